@@ -0,0 +1,141 @@
+package main
+
+import (
+    "convert_cbz/internal/processor"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "os"
+    "runtime"
+    "sync"
+
+    "github.com/jelius-sama/logger"
+)
+
+func runCheck(args []string) {
+    var (
+        threads int
+        verbose bool
+        jsonOut bool
+    )
+
+    fs := flag.NewFlagSet("check", flag.ExitOnError)
+    fs.IntVar(&threads, "threads", runtime.NumCPU(), "Number of archives checked at once")
+    fs.IntVar(&threads, "j", runtime.NumCPU(), "Number of archives checked at once")
+    fs.BoolVar(&verbose, "verbose", false, "List every archive checked, not only the ones with problems")
+    fs.BoolVar(&verbose, "v", false, "List every archive checked, not only the ones with problems")
+    fs.BoolVar(&jsonOut, "json", false, "Print the results of every archive as JSON")
+    fs.Usage = showCheckUsage
+    fs.Parse(args)
+    applyEnv(fs)
+
+    if fs.NArg() == 0 {
+        showCheckUsage()
+        os.Exit(2)
+    }
+    if threads < 1 {
+        threads = runtime.NumCPU()
+    }
+
+    // Directories are searched for archives, files are checked as given
+    var paths []string
+    for _, arg := range fs.Args() {
+        info, err := os.Stat(arg)
+        if err != nil {
+            logger.Error(err.Error())
+            os.Exit(2)
+        }
+        if !info.IsDir() {
+            paths = append(paths, arg)
+            continue
+        }
+        found, err := findArchives(arg)
+        if err != nil {
+            logger.Error(fmt.Sprintf("Failed to search %s: %v", arg, err))
+            os.Exit(2)
+        }
+        paths = append(paths, found...)
+    }
+    if len(paths) == 0 {
+        logger.Warning("No archives found to check")
+        return
+    }
+    if !jsonOut {
+        logger.Info(fmt.Sprintf("Checking %d archives", len(paths)))
+    }
+
+    results := checkAll(paths, threads, func(res processor.CheckResult) {
+        if jsonOut {
+            return
+        }
+        // Printed as they finish, a large collection takes a while
+        switch {
+        case len(res.Problems) > 0:
+            logger.Error(res.Path)
+            for _, p := range res.Problems {
+                if p.Entry != "" {
+                    fmt.Printf("  %-12s %s: %s\n", p.Kind, p.Entry, p.Error)
+                } else {
+                    fmt.Printf("  %-12s %s\n", p.Kind, p.Error)
+                }
+            }
+        case verbose:
+            logger.Okay(fmt.Sprintf("%s: %d pages", res.Path, res.Pages))
+        }
+    })
+
+    pages, unchecked, problems := 0, 0, 0
+    for _, res := range results {
+        pages += res.Pages
+        unchecked += res.Unchecked
+        if len(res.Problems) > 0 {
+            problems++
+        }
+    }
+
+    if jsonOut {
+        enc := json.NewEncoder(os.Stdout)
+        enc.SetIndent("", "  ")
+        enc.Encode(results)
+    } else {
+        if unchecked > 0 {
+            logger.Info(fmt.Sprintf("%d pages are WebP, AVIF, JPEG XL or BMP, only their checksums were checked", unchecked))
+        }
+        if problems > 0 {
+            logger.Warning(fmt.Sprintf("Checked %d archives with %d pages, %d have problems", len(results), pages, problems))
+        } else {
+            logger.Okay(fmt.Sprintf("Checked %d archives with %d pages, all fine", len(results), pages))
+        }
+    }
+    if problems > 0 {
+        os.Exit(1)
+    }
+}
+
+// checkAll checks paths with up to threads at once, calling done from one
+// goroutine at a time as each finishes. Results line up with paths.
+func checkAll(paths []string, threads int, done func(processor.CheckResult)) []processor.CheckResult {
+    results := make([]processor.CheckResult, len(paths))
+
+    var mu sync.Mutex
+    next := make(chan int)
+    var wg sync.WaitGroup
+    for range min(threads, len(paths)) {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := range next {
+                results[i] = processor.CheckArchive(paths[i])
+                mu.Lock()
+                done(results[i])
+                mu.Unlock()
+            }
+        }()
+    }
+    for i := range paths {
+        next <- i
+    }
+    close(next)
+    wg.Wait()
+    return results
+}
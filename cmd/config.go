@@ -0,0 +1,322 @@
+package main
+
+import (
+    "convert_cbz/internal/ignore"
+    "convert_cbz/internal/types"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/jelius-sama/logger"
+)
+
+// configBundle is everything config export carries from one machine to
+// another: the profiles file, the series maps the profiles or the command
+// line name, and the .cbzignore files of source libraries
+type configBundle struct {
+    Version    int                        `json:"version"`
+    Exported   time.Time                  `json:"exported"`
+    Profiles   map[string]json.RawMessage `json:"profiles,omitempty"`
+    SeriesMaps []bundledFile              `json:"series_maps,omitempty"`
+    Ignores    []bundledFile              `json:"ignores,omitempty"`
+}
+
+// bundledFile is a file of a bundle with where it came from. Ignore files
+// have the library they are in as Root and their place in it as Path.
+type bundledFile struct {
+    Root string `json:"root,omitempty"`
+    Path string `json:"path"`
+    Data string `json:"data"`
+}
+
+const configBundleVersion = 1
+
+// runConfig dispatches the subcommands that move the configuration around
+func runConfig(args []string) {
+    if len(args) == 0 {
+        showConfigUsage()
+        os.Exit(2)
+    }
+    switch args[0] {
+    case "export":
+        runConfigExport(args[1:])
+    case "import":
+        runConfigImport(args[1:])
+    case "-help", "--help", "-h":
+        showConfigUsage()
+    default:
+        showConfigUsage()
+        os.Exit(2)
+    }
+}
+
+// runConfigExport writes the bundle to a file or stdout
+func runConfigExport(args []string) {
+    var (
+        file        string
+        seriesMaps  types.StringSliceFlag
+        ignoreRoots types.StringSliceFlag
+    )
+    fs := flag.NewFlagSet("config export", flag.ExitOnError)
+    fs.StringVar(&file, "file", "", "Write the bundle to this file instead of stdout")
+    fs.StringVar(&file, "f", "", "Write the bundle to this file instead of stdout")
+    fs.Var(&seriesMaps, "series-map", "Series map to bundle besides those the profiles use (can be specified multiple times)")
+    fs.Var(&ignoreRoots, "ignore-root", "Library whose .cbzignore files are bundled (can be specified multiple times)")
+    fs.Usage = showConfigUsage
+    fs.Parse(args)
+    applyEnv(fs)
+
+    if fs.NArg() != 0 {
+        showConfigUsage()
+        os.Exit(2)
+    }
+
+    b := configBundle{Version: configBundleVersion, Exported: time.Now()}
+    path, err := profilesPath()
+    if err != nil {
+        logger.Fatal(fmt.Sprintf("Failed to locate profiles: %v", err))
+    }
+    data, err := os.ReadFile(path)
+    if err != nil && !errors.Is(err, os.ErrNotExist) {
+        logger.Fatal(fmt.Sprintf("Failed to read profiles: %v", err))
+    }
+    if err == nil {
+        if err := json.Unmarshal(data, &b.Profiles); err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to read profiles from %s: %v", path, err))
+        }
+        // Relative paths only mean something where the profile was used
+        for name, raw := range b.Profiles {
+            b.Profiles[name] = relocateSeriesMap(raw, func(m string) (string, bool) { return absPath(m), true })
+        }
+        // The series maps the profiles use go along, they are no use without them
+        profiles, _, err := loadProfiles()
+        if err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to read profiles from %s: %v", path, err))
+        }
+        for _, p := range profiles {
+            seriesMaps = append(seriesMaps, p["series-map"]...)
+        }
+    }
+
+    seen := make(map[string]bool)
+    for _, m := range seriesMaps {
+        m = absPath(m)
+        if seen[m] {
+            continue
+        }
+        seen[m] = true
+        data, err := os.ReadFile(m)
+        if err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to read series map: %v", err))
+        }
+        b.SeriesMaps = append(b.SeriesMaps, bundledFile{Path: m, Data: string(data)})
+    }
+    sort.Slice(b.SeriesMaps, func(i, j int) bool { return b.SeriesMaps[i].Path < b.SeriesMaps[j].Path })
+
+    for _, root := range ignoreRoots {
+        root = absPath(root)
+        err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+            if err != nil || d.IsDir() || d.Name() != ignore.FileName {
+                return err
+            }
+            data, err := os.ReadFile(path)
+            if err != nil {
+                return err
+            }
+            b.Ignores = append(b.Ignores, bundledFile{Root: root, Path: filepath.ToSlash(relTo(root, path)), Data: string(data)})
+            return nil
+        })
+        if err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to collect %s files of %s: %v", ignore.FileName, root, err))
+        }
+    }
+
+    out, err := json.MarshalIndent(b, "", "  ")
+    if err != nil {
+        logger.Fatal(err.Error())
+    }
+    out = append(out, '\n')
+    if file == "" {
+        os.Stdout.Write(out)
+        return
+    }
+    if err := os.WriteFile(file, out, 0644); err != nil {
+        logger.Fatal(err.Error())
+    }
+    logger.Okay(fmt.Sprintf("Exported %d profiles, %d series maps and %d %s files to %s", len(b.Profiles), len(b.SeriesMaps), len(b.Ignores), ignore.FileName, file))
+}
+
+// runConfigImport sets the bundle up on this machine
+func runConfigImport(args []string) {
+    var (
+        file      string
+        mapsDir   string
+        dryRun    bool
+        rootMoves types.StringSliceFlag
+    )
+    fs := flag.NewFlagSet("config import", flag.ExitOnError)
+    fs.StringVar(&file, "file", "", "Bundle to import, - reads it from stdin")
+    fs.StringVar(&file, "f", "", "Bundle to import, - reads it from stdin")
+    fs.StringVar(&mapsDir, "series-map-dir", "", "Where series maps are written (default: series-maps in the configuration directory)")
+    fs.Var(&rootMoves, "ignore-root", "Where a library is on this machine, as old=new, for its .cbzignore files (can be specified multiple times)")
+    fs.BoolVar(&dryRun, "dry-run", false, "Show what would be written without writing it")
+    fs.BoolVar(&dryRun, "n", false, "Show what would be written without writing it")
+    fs.Usage = showConfigUsage
+    fs.Parse(args)
+    applyEnv(fs)
+
+    if file == "" && fs.NArg() == 1 {
+        file = fs.Arg(0)
+    } else if fs.NArg() != 0 || file == "" {
+        showConfigUsage()
+        os.Exit(2)
+    }
+
+    var data []byte
+    var err error
+    if file == "-" {
+        data, err = io.ReadAll(os.Stdin)
+    } else {
+        data, err = os.ReadFile(file)
+    }
+    if err != nil {
+        logger.Fatal(fmt.Sprintf("Failed to read bundle: %v", err))
+    }
+    var b configBundle
+    if err := json.Unmarshal(data, &b); err != nil {
+        logger.Fatal(fmt.Sprintf("Invalid bundle: %v", err))
+    }
+    if b.Version != configBundleVersion {
+        logger.Fatal(fmt.Sprintf("Bundle version %d isn't supported, export it again with this version", b.Version))
+    }
+
+    path, err := profilesPath()
+    if err != nil {
+        logger.Fatal(fmt.Sprintf("Failed to locate profiles: %v", err))
+    }
+    if mapsDir == "" {
+        mapsDir = filepath.Join(filepath.Dir(path), "series-maps")
+    }
+    mapsDir = absPath(mapsDir)
+    moves := make(map[string]string)
+    for _, move := range rootMoves {
+        from, to, ok := strings.Cut(move, "=")
+        if !ok || from == "" || to == "" {
+            logger.Fatal(fmt.Sprintf("Bad -ignore-root %q, use old=new", move))
+        }
+        moves[filepath.Clean(from)] = absPath(to)
+    }
+
+    // Series maps first, the profiles are pointed at where they end up
+    write := func(dest string, data []byte) {
+        if dryRun {
+            fmt.Printf("\033[36m>\033[0m %s\n", dest)
+            return
+        }
+        if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+            logger.Fatal(err.Error())
+        }
+        if err := os.WriteFile(dest, data, 0644); err != nil {
+            logger.Fatal(err.Error())
+        }
+    }
+    placed := make(map[string]string)
+    taken := make(map[string]bool)
+    for _, m := range b.SeriesMaps {
+        name := filepath.Base(filepath.FromSlash(m.Path))
+        // Maps of the same name from different folders get numbered
+        base, ext := strings.TrimSuffix(name, filepath.Ext(name)), filepath.Ext(name)
+        for n := 2; taken[name]; n++ {
+            name = fmt.Sprintf("%s %d%s", base, n, ext)
+        }
+        taken[name] = true
+        placed[m.Path] = filepath.Join(mapsDir, name)
+        write(placed[m.Path], []byte(m.Data))
+    }
+
+    if len(b.Profiles) > 0 {
+        current := make(map[string]json.RawMessage)
+        existing, err := os.ReadFile(path)
+        if err != nil && !errors.Is(err, os.ErrNotExist) {
+            logger.Fatal(fmt.Sprintf("Failed to read profiles: %v", err))
+        }
+        if err == nil {
+            if err := json.Unmarshal(existing, &current); err != nil {
+                logger.Fatal(fmt.Sprintf("Failed to read profiles from %s: %v", path, err))
+            }
+        }
+        for name, raw := range b.Profiles {
+            if _, ok := current[name]; ok {
+                logger.Warning(fmt.Sprintf("Replacing profile %s", name))
+            }
+            current[name] = relocateSeriesMap(raw, func(m string) (string, bool) {
+                dest, ok := placed[m]
+                return dest, ok
+            })
+        }
+        out, err := json.MarshalIndent(current, "", "  ")
+        if err != nil {
+            logger.Fatal(err.Error())
+        }
+        write(path, append(out, '\n'))
+    }
+
+    ignores := 0
+    for _, f := range b.Ignores {
+        root, ok := moves[filepath.Clean(f.Root)]
+        if !ok {
+            root = filepath.FromSlash(f.Root)
+        }
+        if info, err := os.Stat(root); err != nil || !info.IsDir() {
+            logger.Warning(fmt.Sprintf("Skipping %s of %s, it isn't on this machine (use -ignore-root %s=<folder>)", f.Path, f.Root, f.Root))
+            continue
+        }
+        dest := filepath.Join(root, filepath.FromSlash(f.Path))
+        if !filepath.IsLocal(filepath.FromSlash(f.Path)) || filepath.Base(dest) != ignore.FileName {
+            logger.Warning(fmt.Sprintf("Skipping %s, not a %s file in its library", f.Path, ignore.FileName))
+            continue
+        }
+        write(dest, []byte(f.Data))
+        ignores++
+    }
+
+    if dryRun {
+        return
+    }
+    logger.Okay(fmt.Sprintf("Imported %d profiles, %d series maps and %d %s files", len(b.Profiles), len(b.SeriesMaps), ignores, ignore.FileName))
+}
+
+// relocateSeriesMap replaces the series-map option of a profile with what
+// move returns for it, when it reports a change
+func relocateSeriesMap(raw json.RawMessage, move func(string) (string, bool)) json.RawMessage {
+    var options map[string]any
+    if err := json.Unmarshal(raw, &options); err != nil {
+        return raw
+    }
+    changed := false
+    for _, key := range []string{"series-map", "-series-map"} {
+        v, ok := options[key].(string)
+        if !ok {
+            continue
+        }
+        if dest, ok := move(v); ok && dest != v {
+            options[key] = dest
+            changed = true
+        }
+    }
+    if !changed {
+        return raw
+    }
+    out, err := json.Marshal(options)
+    if err != nil {
+        return raw
+    }
+    return out
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+    "convert_cbz/internal/types"
+    "flag"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "github.com/jelius-sama/logger"
+)
+
+// envPrefix starts the environment variables options are read from, like
+// CBZ_OUTPUT for -output and CBZ_EXCLUDE_DIR for -exclude-dir
+const envPrefix = "CBZ_"
+
+// applyEnv sets the options of fs that weren't given on the command line
+// from their environment variables, for containers and services where
+// flags mean a wrapper script. The options of a subcommand have its name
+// after the prefix, CBZ_SERVE_LISTEN for serve -listen, since the same option
+// name doesn't always mean the same thing to every command. Options that
+// can be given more than once take a list separated like PATH. Single letter
+// short forms have no variable of their own, their long form's covers them.
+func applyEnv(fs *flag.FlagSet) {
+    prefix := envPrefix
+    if fs != flag.CommandLine {
+        prefix += envName(fs.Name()) + "_"
+    }
+
+    given := make(map[flag.Value]bool)
+    fs.Visit(func(f *flag.Flag) { given[f.Value] = true })
+
+    fs.VisitAll(func(f *flag.Flag) {
+        if len(f.Name) == 1 || given[f.Value] {
+            return
+        }
+        name := prefix + envName(f.Name)
+        value, ok := os.LookupEnv(name)
+        if !ok {
+            return
+        }
+        values := []string{value}
+        if _, list := f.Value.(*types.StringSliceFlag); list {
+            values = filepath.SplitList(value)
+        }
+        for _, v := range values {
+            if err := fs.Set(f.Name, v); err != nil {
+                logger.Fatal(fmt.Sprintf("Bad %s: %v", name, err))
+            }
+        }
+        // Its aliases share the value, they aren't set again
+        given[f.Value] = true
+    })
+}
+
+// envName is how name is spelled in an environment variable
+func envName(name string) string {
+    return strings.ToUpper(strings.NewReplacer("-", "_", " ", "_").Replace(name))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+    "convert_cbz/internal/processor"
+    "flag"
+    "fmt"
+    "os"
+
+    "github.com/jelius-sama/logger"
+)
+
+func runEqual(args []string) {
+    var quiet bool
+
+    fs := flag.NewFlagSet("equal", flag.ExitOnError)
+    fs.BoolVar(&quiet, "quiet", false, "Print nothing, only set the exit status")
+    fs.BoolVar(&quiet, "q", false, "Print nothing, only set the exit status")
+    fs.Usage = showEqualUsage
+    fs.Parse(args)
+    applyEnv(fs)
+
+    if fs.NArg() != 2 {
+        showEqualUsage()
+        os.Exit(2)
+    }
+    a, b := fs.Arg(0), fs.Arg(1)
+
+    pagesA, otherA, err := processor.PageHashes(a)
+    if err != nil {
+        logger.Error(fmt.Sprintf("%s: %v", a, err))
+        os.Exit(2)
+    }
+    pagesB, otherB, err := processor.PageHashes(b)
+    if err != nil {
+        logger.Error(fmt.Sprintf("%s: %v", b, err))
+        os.Exit(2)
+    }
+
+    // Compare as multisets, a page in both archives cancels out one copy
+    count := make(map[string]int)
+    for _, p := range pagesA {
+        count[p.Hash]++
+    }
+    for _, p := range pagesB {
+        count[p.Hash]--
+    }
+
+    onlyA := unmatched(pagesA, count, 1)
+    onlyB := unmatched(pagesB, count, -1)
+
+    if len(onlyA) == 0 && len(onlyB) == 0 {
+        if !quiet {
+            logger.Okay(fmt.Sprintf("Equal: both archives hold the same %d pages", len(pagesA)))
+            if otherA != otherB {
+                logger.Info(fmt.Sprintf("Non-image entries differ (%d vs %d), they are not compared", otherA, otherB))
+            }
+        }
+        return
+    }
+
+    if !quiet {
+        logger.Warning(fmt.Sprintf("Different: %d of %d pages only in %s, %d of %d only in %s",
+            len(onlyA), len(pagesA), a, len(onlyB), len(pagesB), b))
+        for _, name := range onlyA {
+            fmt.Printf("  < %s\n", name)
+        }
+        for _, name := range onlyB {
+            fmt.Printf("  > %s\n", name)
+        }
+    }
+    os.Exit(1)
+}
+
+// unmatched returns the names of pages left over once the other archive's
+// copies are subtracted. sign is which side of count belongs to pages.
+func unmatched(pages []processor.Page, count map[string]int, sign int) []string {
+    left := make(map[string]int)
+    for h, n := range count {
+        if n*sign > 0 {
+            left[h] = n * sign
+        }
+    }
+
+    var names []string
+    for _, p := range pages {
+        if left[p.Hash] > 0 {
+            left[p.Hash]--
+            names = append(names, p.Name)
+        }
+    }
+    return names
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+    "convert_cbz/internal/comicinfo"
+    "convert_cbz/internal/history"
+    "convert_cbz/internal/naming"
+    "convert_cbz/internal/pathnorm"
+    "convert_cbz/internal/processor"
+    "convert_cbz/internal/report"
+    "convert_cbz/internal/types"
+    "convert_cbz/internal/util"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/jelius-sama/logger"
+)
+
+// runExtract is -extract: every archive of inputs is unpacked into a folder
+// under outputDir, named after it or by names, by the same workers a
+// conversion uses. Folders aren't journaled, so there is no rollback and no
+// run history.
+func runExtract(start time.Time, inputs []string, outputDir string, names *naming.Template, titles *comicinfo.TitleParser, series *comicinfo.SeriesMap, collision types.CollisionPolicy, dryRun bool, reportPath string, opts *types.Options) {
+    outputDir = absPath(outputDir)
+    pathnorm.Configure(types.CaseAuto, outputDir)
+
+    workItems := extractItems(inputs, outputDir, names, titles, series, collision)
+    if len(workItems) == 0 {
+        logger.Warning("No archives found to extract")
+        return
+    }
+
+    if dryRun {
+        fmt.Println()
+        for _, item := range workItems {
+            if _, err := os.Stat(item.OutputPath); err == nil && opts.Overwrite != types.OverwriteAlways {
+                fmt.Printf("\033[90m= %s (folder exists)\033[0m\n", item.Archive)
+            } else {
+                fmt.Printf("\033[32m+\033[0m %s \033[90m→\033[0m %s\n", item.Archive, item.OutputPath)
+            }
+        }
+        fmt.Printf("\n%d archives would be extracted\n", len(workItems))
+        return
+    }
+
+    logger.Info(fmt.Sprintf("Extracting %d archives with %d threads", len(workItems), opts.Threads))
+    logger.Info(fmt.Sprintf("Output: %s", outputDir))
+    if err := os.MkdirAll(outputDir, 0755); err != nil {
+        logger.Fatal(fmt.Sprintf("Failed to create output directory: %v", err))
+    }
+
+    // A conversion writing into the same directory would race for the names
+    runID := history.NewRun(start, outputDir, inputs).ID
+    unlock, err := history.Lock(outputDir, runID)
+    if err != nil {
+        logger.Fatal(fmt.Sprintf("Failed to lock output directory: %v", err))
+    }
+    defer unlock()
+
+    ctx, abort := interruptContexts()
+    opts.Extract = true
+    opts.Abort = abort
+    opts.RunID = runID
+    opts.Status = statusRequests()
+
+    stats := &types.ConversionStats{Total: len(workItems)}
+    processor.ProcessConcurrently(ctx, workItems, opts, stats)
+    util.PrintFinalStats(stats, time.Since(start))
+
+    if reportPath != "" {
+        if err := report.Write(reportPath, reportRows(stats)); err != nil {
+            logger.Error(fmt.Sprintf("Failed to write report: %v", err))
+        } else {
+            logger.Info(fmt.Sprintf("Report written to %s", reportPath))
+        }
+    }
+
+    if ctx.Err() != nil {
+        if len(stats.Deferred) > 0 {
+            logger.Warning(fmt.Sprintf("Interrupted, %d archives not extracted", len(stats.Deferred)))
+        }
+        unlock()
+        os.Exit(130)
+    }
+    if stats.ErrorLimit {
+        logger.Warning(fmt.Sprintf("Stopped after %d failed archives, %d archives not extracted", opts.MaxErrors, len(stats.Deferred)))
+        unlock()
+        os.Exit(1)
+    }
+}
+
+// extractItems finds the archives of inputs and plans the folder each is
+// unpacked into. Without names, archives found in a folder keep their path
+// below it in the output and ones given directly go straight into it. With
+// names, the template path of the archive, taken like that of a source
+// folder, is the folder. collision decides what happens to archives wanting
+// a folder another one already has.
+func extractItems(inputs []string, outputDir string, names *naming.Template, titles *comicinfo.TitleParser, series *comicinfo.SeriesMap, collision types.CollisionPolicy) []types.WorkItem {
+    var workItems []types.WorkItem
+    taken := make(map[string]string)
+    for _, in := range inputs {
+        info, err := os.Stat(in)
+        if err != nil {
+            logger.Error(err.Error())
+            continue
+        }
+        archives := []string{in}
+        root := filepath.Dir(absPath(in))
+        if info.IsDir() {
+            if archives, err = findExtractable(in); err != nil {
+                logger.Error(fmt.Sprintf("Failed to search %s: %v", in, err))
+                continue
+            }
+            root = absPath(in)
+        }
+
+        for _, archive := range archives {
+            archive = absPath(archive)
+            rel := relTo(root, archive)
+            out := filepath.Join(outputDir, strings.TrimSuffix(rel, filepath.Ext(rel)))
+            if names != nil {
+                fields := naming.FieldsFor(strings.TrimSuffix(archive, filepath.Ext(archive)), titles, series)
+                path, err := names.Path(outputDir, fields)
+                if err != nil {
+                    logger.Error(err.Error())
+                    continue
+                }
+                out = strings.TrimSuffix(path, ".cbz")
+            }
+
+            // Chapter 1.cbz and Chapter 1.cbr both want the folder Chapter 1
+            if other, ok := taken[pathnorm.Key(out)]; ok {
+                switch collision {
+                case types.CollisionError:
+                    logger.Fatal(fmt.Sprintf("%s and %s would both be extracted to %s", other, archive, out))
+                case types.CollisionSuffix:
+                    out = freeFolder(out, taken)
+                default:
+                    logger.Warning(fmt.Sprintf("%s would be extracted over the folder of %s, skipping it", archive, other))
+                    continue
+                }
+            }
+            taken[pathnorm.Key(out)] = archive
+
+            workItems = append(workItems, types.WorkItem{
+                FolderName: filepath.Base(out),
+                SourcePath: archive,
+                OutputPath: out,
+                Archive:    archive,
+            })
+        }
+    }
+    return workItems
+}
+
+// freeFolder adds " (2)", " (3)", ... to out until no archive has it
+func freeFolder(out string, taken map[string]string) string {
+    for n := 2; ; n++ {
+        candidate := fmt.Sprintf("%s (%d)", out, n)
+        if _, ok := taken[pathnorm.Key(candidate)]; !ok {
+            return candidate
+        }
+    }
+}
+
+// findExtractable is findArchives for -extract and repack, which take .cbr
+// and .pdf files too
+func findExtractable(dir string) ([]string, error) {
+    var archives []string
+    err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        ext := strings.ToLower(filepath.Ext(d.Name()))
+        if !d.IsDir() && (ext == ".cbz" || ext == ".cbr" || ext == ".pdf") && !strings.HasPrefix(d.Name(), ".") {
+            archives = append(archives, path)
+        }
+        return nil
+    })
+    sort.Strings(archives)
+    return archives, err
+}
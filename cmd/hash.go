@@ -0,0 +1,121 @@
+package main
+
+import (
+    "convert_cbz/internal/processor"
+    "convert_cbz/internal/types"
+    "flag"
+    "fmt"
+    "os"
+    "path/filepath"
+    "runtime"
+    "sort"
+    "strings"
+    "sync"
+
+    "github.com/jelius-sama/logger"
+)
+
+func runHash(args []string) {
+    var (
+        threads   int
+        dumbMode  bool
+        recursive bool
+        fpMode    types.FingerprintMode = types.FingerprintContent
+    )
+
+    fs := flag.NewFlagSet("hash", flag.ExitOnError)
+    fs.IntVar(&threads, "threads", runtime.NumCPU(), "Number of paths hashed at once")
+    fs.IntVar(&threads, "j", runtime.NumCPU(), "Number of paths hashed at once")
+    fs.BoolVar(&dumbMode, "dumb", false, "Hash all files of a folder, not only those smart mode would archive")
+    fs.BoolVar(&dumbMode, "d", false, "Hash all files of a folder, not only those smart mode would archive")
+    fs.BoolVar(&recursive, "recursive", false, "Hash every subdirectory and CBZ inside the given directories")
+    fs.BoolVar(&recursive, "r", false, "Hash every subdirectory and CBZ inside the given directories")
+    fs.Var(&fpMode, "fingerprint", "Hash names and contents, or names, sizes and times [content|meta]")
+    fs.Usage = showHashUsage
+    fs.Parse(args)
+    applyEnv(fs)
+
+    if fs.NArg() == 0 {
+        showHashUsage()
+        return
+    }
+    if threads < 1 {
+        threads = runtime.NumCPU()
+    }
+
+    var paths []string
+    if recursive {
+        for _, dir := range fs.Args() {
+            entries, err := os.ReadDir(dir)
+            if err != nil {
+                logger.Error(fmt.Sprintf("Failed to read %s: %v", dir, err))
+                continue
+            }
+            for _, e := range entries {
+                if e.IsDir() || isArchive(e.Name()) {
+                    paths = append(paths, filepath.Join(dir, e.Name()))
+                }
+            }
+        }
+        sort.Strings(paths)
+    } else {
+        paths = fs.Args()
+    }
+
+    hashes, errs := hashAll(paths, threads, func(path string) (string, error) {
+        return hashPath(path, dumbMode, fpMode)
+    })
+
+    // Same layout as sha256sum, so the output is easy to join on
+    failed := false
+    for i, path := range paths {
+        if errs[i] != nil {
+            logger.Error(fmt.Sprintf("%s: %v", path, errs[i]))
+            failed = true
+            continue
+        }
+        fmt.Printf("%s  %s\n", hashes[i], path)
+    }
+    if failed {
+        os.Exit(1)
+    }
+}
+
+// hashAll runs hash over paths with up to threads at once, results line up with paths
+func hashAll(paths []string, threads int, hash func(string) (string, error)) ([]string, []error) {
+    hashes := make([]string, len(paths))
+    errs := make([]error, len(paths))
+
+    next := make(chan int)
+    var wg sync.WaitGroup
+    for range min(threads, max(len(paths), 1)) {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := range next {
+                hashes[i], errs[i] = hash(paths[i])
+            }
+        }()
+    }
+    for i := range paths {
+        next <- i
+    }
+    close(next)
+    wg.Wait()
+    return hashes, errs
+}
+
+func hashPath(path string, dumbMode bool, mode types.FingerprintMode) (string, error) {
+    info, err := os.Stat(path)
+    if err != nil {
+        return "", err
+    }
+    if info.IsDir() {
+        return processor.HashFolder(path, dumbMode, mode)
+    }
+    return processor.HashArchive(path, mode)
+}
+
+func isArchive(name string) bool {
+    return strings.EqualFold(filepath.Ext(name), ".cbz")
+}
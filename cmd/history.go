@@ -0,0 +1,459 @@
+package main
+
+import (
+    "convert_cbz/internal/history"
+    "convert_cbz/internal/pathnorm"
+    "convert_cbz/internal/processor"
+    "convert_cbz/internal/types"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "os"
+    "path/filepath"
+    "runtime"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/jelius-sama/logger"
+)
+
+// runHistory dispatches the subcommands that work on the run history
+func runHistory(args []string) {
+    if len(args) == 0 {
+        showHistoryUsage()
+        os.Exit(2)
+    }
+    switch args[0] {
+    case "export":
+        runHistoryExport(args[1:])
+    case "import":
+        runHistoryImport(args[1:])
+    case "compare":
+        runHistoryCompare(args[1:])
+    case "-help", "--help", "-h":
+        showHistoryUsage()
+    default:
+        showHistoryUsage()
+        os.Exit(2)
+    }
+}
+
+// runHistoryExport dumps the recorded items of every run, for spreadsheets
+// and backup catalogs
+func runHistoryExport(args []string) {
+    var (
+        format string
+        file   string
+        since  string
+        until  string
+        root   string
+    )
+
+    fs := flag.NewFlagSet("history export", flag.ExitOnError)
+    fs.StringVar(&format, "format", "", "Output format [csv|json] (default: from the -file extension, csv otherwise)")
+    fs.StringVar(&file, "file", "", "Write to this file instead of stdout")
+    fs.StringVar(&file, "f", "", "Write to this file instead of stdout")
+    fs.StringVar(&since, "since", "", "Only runs started on or after this date (2006-01-02 or RFC 3339)")
+    fs.StringVar(&until, "until", "", "Only runs started on or before this date (2006-01-02 or RFC 3339)")
+    fs.StringVar(&root, "input-root", "", "Only folders inside this directory")
+    fs.Usage = showHistoryUsage
+    fs.Parse(args)
+    applyEnv(fs)
+
+    if fs.NArg() != 0 {
+        showHistoryUsage()
+        os.Exit(2)
+    }
+
+    if format == "" {
+        format = "csv"
+        if strings.EqualFold(filepath.Ext(file), ".json") {
+            format = "json"
+        }
+    }
+    if format != "csv" && format != "json" {
+        logger.Fatal(fmt.Sprintf("Unknown export format %q, use csv or json", format))
+    }
+
+    var filter history.ExportFilter
+    var err error
+    if filter.Since, err = parseDate(since, false); err != nil {
+        logger.Fatal(fmt.Sprintf("Invalid -since: %v", err))
+    }
+    if filter.Until, err = parseDate(until, true); err != nil {
+        logger.Fatal(fmt.Sprintf("Invalid -until: %v", err))
+    }
+    if root != "" {
+        if filter.Root, err = filepath.Abs(root); err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to resolve %s: %v", root, err))
+        }
+    }
+
+    rows, err := history.Export(filter)
+    if err != nil {
+        logger.Fatal(fmt.Sprintf("Failed to read run history: %v", err))
+    }
+
+    out := os.Stdout
+    if file != "" {
+        f, err := os.Create(file)
+        if err != nil {
+            logger.Fatal(err.Error())
+        }
+        defer f.Close()
+        out = f
+    }
+    if err := history.WriteExport(out, rows, format); err != nil {
+        logger.Fatal(fmt.Sprintf("Failed to write export: %v", err))
+    }
+    if file != "" {
+        logger.Okay(fmt.Sprintf("Exported %d items to %s", len(rows), file))
+    }
+}
+
+// parseDate reads a -since or -until value. A bare date is the start of that
+// day in local time, or with end the start of the next, so the day counts.
+func parseDate(s string, end bool) (time.Time, error) {
+    if s == "" {
+        return time.Time{}, nil
+    }
+    if t, err := time.Parse(time.RFC3339, s); err == nil {
+        return t, nil
+    }
+    t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+    if err != nil {
+        return time.Time{}, fmt.Errorf("%q is neither 2006-01-02 nor RFC 3339", s)
+    }
+    if end {
+        t = t.AddDate(0, 0, 1)
+    }
+    return t, nil
+}
+
+// runHistoryCompare shows how two runs differ, to follow a library growing
+// and catch conversions that started failing
+func runHistoryCompare(args []string) {
+    var jsonOut bool
+
+    fs := flag.NewFlagSet("history compare", flag.ExitOnError)
+    fs.BoolVar(&jsonOut, "json", false, "Print the comparison as JSON")
+    fs.Usage = showHistoryUsage
+    fs.Parse(args)
+    applyEnv(fs)
+
+    if fs.NArg() != 2 {
+        showHistoryUsage()
+        os.Exit(2)
+    }
+    a, err := history.Load(fs.Arg(0))
+    if err != nil {
+        logger.Fatal(err.Error())
+    }
+    b, err := history.Load(fs.Arg(1))
+    if err != nil {
+        logger.Fatal(err.Error())
+    }
+    c := history.Compare(a, b)
+
+    if jsonOut {
+        enc := json.NewEncoder(os.Stdout)
+        enc.SetIndent("", "  ")
+        enc.Encode(c)
+        return
+    }
+
+    fmt.Println()
+    fmt.Printf("  %-14s %24s %24s %12s\n", "", c.A.ID, c.B.ID, "change")
+    row := func(label string, x, y int64, unit func(int64) string) {
+        fmt.Printf("  %-14s %24s %24s %12s\n", label, unit(x), unit(y), delta(y-x, unit))
+    }
+    count := func(n int64) string { return strconv.FormatInt(n, 10) }
+    mb := func(n int64) string { return fmt.Sprintf("%.1f MB", float64(n)/(1<<20)) }
+    row("Folders", int64(c.A.Folders), int64(c.B.Folders), count)
+    for _, status := range []types.ItemStatus{types.StatusConverted, types.StatusSkipped, types.StatusFailed, types.StatusDeferred, types.StatusRenamed, types.StatusImported, types.StatusRetired} {
+        if c.A.Statuses[status] > 0 || c.B.Statuses[status] > 0 {
+            row("  "+string(status), int64(c.A.Statuses[status]), int64(c.B.Statuses[status]), count)
+        }
+    }
+    row("Pages", int64(c.A.Pages), int64(c.B.Pages), count)
+    row("Archive size", c.A.Bytes, c.B.Bytes, mb)
+    row("Source size", c.A.SourceBytes, c.B.SourceBytes, mb)
+    fmt.Printf("  %-14s %24s %24s\n", "Duration", runDuration(c.A), runDuration(c.B))
+    fmt.Println()
+    fmt.Printf("%d folders only in %s, %d only in %s\n", c.Dropped, c.A.ID, c.Added, c.B.ID)
+
+    if len(c.NewFailures) > 0 {
+        fmt.Printf("\n\033[31m%d folders failed that didn't before:\033[0m\n", len(c.NewFailures))
+        for _, it := range c.NewFailures {
+            fmt.Printf("  %s  \033[90m%s\033[0m\n", it.Source, it.Error)
+        }
+    }
+    if len(c.Fixed) > 0 {
+        fmt.Printf("\n\033[32m%d folders that failed went through:\033[0m\n", len(c.Fixed))
+        for _, it := range c.Fixed {
+            fmt.Printf("  %s\n", it.Source)
+        }
+    }
+}
+
+// delta formats a change in a comparison, with its sign
+func delta(d int64, unit func(int64) string) string {
+    switch {
+    case d > 0:
+        return "+" + unit(d)
+    case d < 0:
+        return "-" + unit(-d)
+    }
+    return "="
+}
+
+// runDuration is how long a run took, rounded for display
+func runDuration(s history.Summary) string {
+    if s.Finished.IsZero() {
+        return "unfinished"
+    }
+    return s.Finished.Sub(s.Started).Round(time.Second).String()
+}
+
+// runHistoryImport seeds the history and the sync catalog with a library
+// converted before either existed, matching its archives back to their
+// source folders
+func runHistoryImport(args []string) {
+    start := time.Now()
+    var (
+        outputDir   string
+        catalogPath string
+        match       string
+        threads     int
+        dumbMode    bool
+        recursive   bool
+        dryRun      bool
+        inputPaths  types.StringSliceFlag
+        recInputs   types.StringSliceFlag
+        compression types.CompressionMode = types.CMNone
+        fpMode      types.FingerprintMode = types.FingerprintMeta
+    )
+
+    fs := flag.NewFlagSet("history import", flag.ExitOnError)
+    fs.StringVar(&outputDir, "output", "", "Library holding the archives")
+    fs.StringVar(&outputDir, "o", "", "Library holding the archives")
+    fs.Var(&inputPaths, "input", "Folder the archives were converted from (can be specified multiple times)")
+    fs.Var(&inputPaths, "i", "Folder the archives were converted from (can be specified multiple times)")
+    fs.Var(&recInputs, "input-recursive", "Input whose subdirectories the archives were converted from (can be specified multiple times)")
+    fs.BoolVar(&recursive, "recursive", false, "Every -input holds the source folders, like -recursive when converting")
+    fs.BoolVar(&recursive, "r", false, "Every -input holds the source folders, like -recursive when converting")
+    fs.StringVar(&match, "match", "name", "How archives are matched to folders [name|content]")
+    fs.StringVar(&catalogPath, "catalog", "", "Catalog file to seed (default: the one sync uses for -output)")
+    fs.BoolVar(&dumbMode, "dumb", false, "The library was converted with -dumb")
+    fs.Var(&compression, "compression", "Compression the library was converted with, as sync will use")
+    fs.Var(&fpMode, "fingerprint", "Fingerprint mode sync will use [meta|content]")
+    fs.IntVar(&threads, "threads", runtime.NumCPU(), "Number of folders and archives hashed at once")
+    fs.IntVar(&threads, "j", runtime.NumCPU(), "Number of folders and archives hashed at once")
+    fs.BoolVar(&dryRun, "dry-run", false, "Show the matches without recording them")
+    fs.BoolVar(&dryRun, "n", false, "Show the matches without recording them")
+    fs.Usage = showHistoryUsage
+    fs.Parse(args)
+    applyEnv(fs)
+
+    if outputDir == "" || fs.NArg() != 0 {
+        showHistoryUsage()
+        os.Exit(2)
+    }
+    if match != "name" && match != "content" {
+        logger.Fatal(fmt.Sprintf("Unknown -match %q, use name or content", match))
+    }
+    if threads < 1 {
+        threads = runtime.NumCPU()
+    }
+    outputDir = absPath(outputDir)
+    pathnorm.Configure(types.CaseAuto, outputDir)
+
+    archives, err := findArchives(outputDir)
+    if err != nil {
+        logger.Fatal(fmt.Sprintf("Failed to read library: %v", err))
+    }
+
+    // Archives a run or sync already recorded are left as they are
+    sources, cat := librarySources(outputDir)
+    if catalogPath != "" {
+        if cat, err = history.LoadCatalog(catalogPath, outputDir); err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to read catalog: %v", err))
+        }
+    }
+    var unknown []string
+    for _, a := range archives {
+        if _, ok := sources[pathnorm.Key(a)]; !ok {
+            unknown = append(unknown, a)
+        }
+    }
+    logger.Info(fmt.Sprintf("Found %d archives in %s, %d not recorded yet", len(archives), outputDir, len(unknown)))
+
+    recursiveInputs, directInputs := []string(recInputs), []string(inputPaths)
+    if recursive {
+        recursiveInputs, directInputs = slices.Concat(directInputs, recursiveInputs), nil
+    }
+    var folders []types.WorkItem
+    if len(directInputs) > 0 || len(recursiveInputs) > 0 {
+        if folders, err = collectInputs(directInputs, recursiveInputs, outputDir, dumbMode, false, false, types.LayoutFlat, types.NestedNone, 0, nil); err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to collect folders: %v", err))
+        }
+    }
+
+    var matched map[string]types.WorkItem
+    if match == "content" {
+        matched = matchByContent(unknown, folders, dumbMode, threads)
+    } else {
+        matched = matchByName(unknown, folders)
+    }
+
+    if dryRun {
+        fmt.Println()
+        for _, a := range unknown {
+            if item, ok := matched[a]; ok {
+                fmt.Printf("\033[32m+\033[0m %s  \033[90m← %s\033[0m\n", relTo(outputDir, a), item.SourcePath)
+            } else {
+                fmt.Printf("\033[90m? %s  (no source folder found)\033[0m\n", relTo(outputDir, a))
+            }
+        }
+        fmt.Printf("\n%d archives, %d matched to a source folder\n", len(unknown), len(matched))
+        return
+    }
+
+    run := history.NewRun(start, outputDir, slices.Concat(inputPaths, recInputs))
+    release, err := history.Lock(outputDir, run.ID)
+    if err != nil {
+        logger.Fatal(fmt.Sprintf("Failed to lock output directory: %v", err))
+    }
+    defer release()
+
+    // The catalog holds what folders hash to now, so sync sees them unchanged
+    var pending []string
+    for _, a := range unknown {
+        if item, ok := matched[a]; ok && cat.Lookup(item.SourcePath) == nil {
+            pending = append(pending, a)
+        }
+    }
+    pendingSources := make([]string, len(pending))
+    for i, a := range pending {
+        pendingSources[i] = matched[a].SourcePath
+    }
+    hashes, errs := hashAll(pendingSources, threads, func(dir string) (string, error) {
+        return processor.HashFolder(dir, dumbMode, fpMode)
+    })
+    for i, a := range pending {
+        if errs[i] != nil {
+            logger.Error(fmt.Sprintf("Failed to hash %s, not added to the catalog: %v", pendingSources[i], errs[i]))
+            continue
+        }
+        converted := start
+        if info, err := os.Stat(a); err == nil {
+            converted = info.ModTime()
+        }
+        cat.Put(&history.CatalogEntry{
+            Source:      absPath(pendingSources[i]),
+            Output:      a,
+            Hash:        hashes[i],
+            Dumb:        dumbMode,
+            Compression: compression.String(),
+            FirstSeen:   start,
+            Converted:   converted,
+        })
+    }
+
+    for _, a := range unknown {
+        item, ok := matched[a]
+        if !ok {
+            continue
+        }
+        it := history.Item{Folder: item.FolderName, Source: item.SourcePath, Output: a, Status: types.StatusImported}
+        if info, err := os.Stat(a); err == nil {
+            it.Bytes = info.Size()
+        }
+        it.Fingerprint, _ = processor.ReadFingerprint(a)
+        run.Items = append(run.Items, it)
+    }
+    run.Finished = time.Now()
+
+    if len(run.Items) > 0 {
+        if err := history.Save(run); err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to save run history: %v", err))
+        }
+    }
+    if len(pending) > 0 {
+        if err := cat.Save(); err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to save catalog: %v", err))
+        }
+    }
+
+    logger.Okay(fmt.Sprintf("Imported %d archives with their source folders, %d without one were left out", len(run.Items), len(unknown)-len(run.Items)))
+    if len(run.Items) > 0 {
+        logger.Info(fmt.Sprintf("Run ID: %s", run.ID))
+    }
+}
+
+// matchByName pairs every archive with the folder it is named after. When
+// several folders share the name, the one a run would write to that very
+// path wins, otherwise the archive stays unmatched.
+func matchByName(archives []string, folders []types.WorkItem) map[string]types.WorkItem {
+    byName := make(map[string][]types.WorkItem)
+    for _, f := range folders {
+        byName[f.FolderName] = append(byName[f.FolderName], f)
+    }
+
+    matched := make(map[string]types.WorkItem)
+    for _, a := range archives {
+        candidates := byName[strings.TrimSuffix(filepath.Base(a), filepath.Ext(a))]
+        if len(candidates) > 1 {
+            idx := slices.IndexFunc(candidates, func(f types.WorkItem) bool { return pathnorm.Equal(f.OutputPath, a) })
+            if idx < 0 {
+                logger.Warning(fmt.Sprintf("%d folders are named like %s, use -match content instead", len(candidates), filepath.Base(a)))
+                continue
+            }
+            candidates = candidates[idx : idx+1]
+        }
+        if len(candidates) == 1 {
+            matched[a] = candidates[0]
+        }
+    }
+    return matched
+}
+
+// matchByContent pairs archives with the folders holding exactly their
+// pages, whatever either is called
+func matchByContent(archives []string, folders []types.WorkItem, dumbMode bool, threads int) map[string]types.WorkItem {
+    logger.Info(fmt.Sprintf("Hashing %d archives and %d folders", len(archives), len(folders)))
+    archiveHashes, archiveErrs := hashAll(archives, threads, func(path string) (string, error) {
+        return processor.HashArchive(path, types.FingerprintContent)
+    })
+    sources := make([]string, len(folders))
+    for i, f := range folders {
+        sources[i] = f.SourcePath
+    }
+    folderHashes, folderErrs := hashAll(sources, threads, func(dir string) (string, error) {
+        return processor.HashFolder(dir, dumbMode, types.FingerprintContent)
+    })
+
+    byHash := make(map[string][]types.WorkItem)
+    for i, f := range folders {
+        if folderErrs[i] != nil {
+            logger.Warning(fmt.Sprintf("Failed to hash %s: %v", f.SourcePath, folderErrs[i]))
+            continue
+        }
+        byHash[folderHashes[i]] = append(byHash[folderHashes[i]], f)
+    }
+
+    // Identical folders are each matched once, in order
+    matched := make(map[string]types.WorkItem)
+    for i, a := range archives {
+        if archiveErrs[i] != nil {
+            logger.Warning(fmt.Sprintf("Failed to hash %s: %v", a, archiveErrs[i]))
+            continue
+        }
+        if candidates := byHash[archiveHashes[i]]; len(candidates) > 0 {
+            matched[a], byHash[archiveHashes[i]] = candidates[0], candidates[1:]
+        }
+    }
+    return matched
+}
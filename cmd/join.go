@@ -0,0 +1,229 @@
+package main
+
+import (
+    "convert_cbz/internal/collector"
+    "convert_cbz/internal/comicinfo"
+    "convert_cbz/internal/history"
+    "convert_cbz/internal/pathnorm"
+    "convert_cbz/internal/processor"
+    "convert_cbz/internal/types"
+    "convert_cbz/internal/util"
+    "flag"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/jelius-sama/logger"
+)
+
+// runJoin combines existing archives into one, usually the chapters of a
+// volume. They are unpacked into scratch folders and merged like -merge
+// merges source folders, pages renumbered across all of them.
+func runJoin(args []string) {
+    start := time.Now()
+    var (
+        output      string
+        tempDir     string
+        passwords   string
+        titlePat    string
+        seriesMap   string
+        cover       string
+        dumbMode    bool
+        comicInfo   bool
+        keepReplace bool
+        verify      bool
+        dryRun      bool
+        compression types.CompressionMode = types.CMNone
+        overwrite   types.OverwriteMode   = types.OverwriteSkip
+        mergeLayout types.MergeLayout     = types.MergeFlat
+        mergeOrder  types.MergeOrder      = types.MergeNatural
+        progress    types.ProgressMode    = types.ProgressAuto
+    )
+
+    fs := flag.NewFlagSet("join", flag.ExitOnError)
+    fs.StringVar(&output, "output", "", "Archive the others are combined into, e.g. \"./cbz/Vol. 01.cbz\"")
+    fs.StringVar(&output, "o", "", "Archive the others are combined into, e.g. \"./cbz/Vol. 01.cbz\"")
+    fs.StringVar(&tempDir, "temp-dir", "", "Where archives are unpacked while they are joined (default: the system temp directory)")
+    fs.StringVar(&passwords, "passwords", "", "File with one password per line, tried in turn on encrypted archives")
+    fs.Var(&mergeLayout, "layout", "How the archives are stored [flat|folders], flat numbers the pages across all of them")
+    fs.Var(&mergeOrder, "order", "Order the archives are joined in [natural|input], input keeps the order they were given")
+    fs.BoolVar(&dumbMode, "dumb", false, "Keep every entry instead of filtering like smart mode")
+    fs.BoolVar(&dumbMode, "d", false, "Keep every entry instead of filtering like smart mode")
+    fs.Var(&compression, "compression", "Compression mode to use")
+    fs.Var(&compression, "c", "Compression mode to use")
+    fs.Var(&overwrite, "overwrite", "What to do when the archive already exists [skip|always|if-different]")
+    fs.BoolVar(&keepReplace, "keep-replaced", false, "Keep the archive replaced by -overwrite next to it, so rollback can restore it")
+    fs.BoolVar(&verify, "verify", false, "Read the archive back before it is moved into place")
+    fs.StringVar(&cover, "cover", "", "Glob for the file placed first as the cover, \"none\" keeps name order")
+    fs.BoolVar(&comicInfo, "comicinfo", false, "Fill in the combined ComicInfo.xml from the output name and series folder")
+    fs.StringVar(&titlePat, "title-pattern", "", "Regular expression with number/title/volume/group groups for -comicinfo")
+    fs.StringVar(&seriesMap, "series-map", "", "JSON file with series titles for -comicinfo (implies -comicinfo)")
+    fs.Var(&progress, "progress", "Progress display [auto|bar|plain], auto shows the bar only on a terminal")
+    fs.BoolVar(&dryRun, "dry-run", false, "List the archives that would be joined, in order, without joining them")
+    fs.BoolVar(&dryRun, "n", false, "List the archives that would be joined, in order, without joining them")
+    fs.Usage = showJoinUsage
+    fs.Parse(args)
+    applyEnv(fs)
+
+    if fs.NArg() == 0 || output == "" {
+        showJoinUsage()
+        os.Exit(2)
+    }
+    os.Setenv(types.CKey.String(), compression.String())
+
+    titles, err := comicinfo.NewTitleParser(titlePat)
+    if err != nil {
+        logger.Fatal(err.Error())
+    }
+    var series *comicinfo.SeriesMap
+    if seriesMap != "" {
+        if series, err = comicinfo.LoadSeriesMap(seriesMap); err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to load series map: %v", err))
+        }
+        comicInfo = true
+    }
+    var list []string
+    if passwords != "" {
+        if list, err = processor.LoadPasswords(passwords); err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to load password list: %v", err))
+        }
+    }
+
+    if !strings.EqualFold(filepath.Ext(output), ".cbz") {
+        output += ".cbz"
+    }
+    output = absPath(output)
+    outputDir := filepath.Dir(output)
+    pathnorm.Configure(types.CaseAuto, outputDir)
+
+    archives := joinArchives(fs.Args(), output, mergeOrder)
+    if len(archives) < 2 {
+        logger.Fatal(fmt.Sprintf("Found %d archives to join, at least 2 are needed", len(archives)))
+    }
+
+    if dryRun {
+        fmt.Println()
+        for i, archive := range archives {
+            fmt.Printf("%3d. %s\n", i+1, archive)
+        }
+        fmt.Printf("\n%d archives would be joined into %s\n", len(archives), output)
+        return
+    }
+
+    logger.Info(fmt.Sprintf("Joining %d archives into %s", len(archives), output))
+    if err := os.MkdirAll(outputDir, 0755); err != nil {
+        logger.Fatal(fmt.Sprintf("Failed to create output directory: %v", err))
+    }
+    run := history.NewRun(start, outputDir, fs.Args())
+    unlock, err := history.Lock(outputDir, run.ID)
+    if err != nil {
+        logger.Fatal(fmt.Sprintf("Failed to lock output directory: %v", err))
+    }
+    defer unlock()
+
+    scratch, err := os.MkdirTemp(tempDir, "convert-cbz-join-")
+    if err != nil {
+        unlock()
+        logger.Fatal(fmt.Sprintf("Failed to create scratch directory: %v", err))
+    }
+    defer os.RemoveAll(scratch)
+
+    // Chapters are usually kept in their series folder, like source folders
+    name := strings.TrimSuffix(filepath.Base(output), filepath.Ext(output))
+    item := types.WorkItem{
+        FolderName:   name,
+        SourcePath:   scratch,
+        OutputPath:   output,
+        DumbMode:     dumbMode,
+        Series:       filepath.Base(collector.CommonParent(archives)),
+        PartArchives: archives,
+    }
+    for i, archive := range archives {
+        part := strings.TrimSuffix(filepath.Base(archive), filepath.Ext(archive))
+        item.Parts = append(item.Parts, filepath.Join(scratch, strconv.Itoa(i+1), part))
+    }
+
+    ctx, abort := interruptContexts()
+    journal := history.NewJournal(run.ID, outputDir)
+
+    stats := &types.ConversionStats{Total: 1}
+    processor.ProcessConcurrently(ctx, []types.WorkItem{item}, &types.Options{
+        Threads:      1,
+        Overwrite:    overwrite,
+        Cover:        cover,
+        Sort:         types.SortNatural,
+        Merge:        mergeLayout,
+        Fingerprint:  types.FingerprintMeta,
+        Progress:     progress,
+        Abort:        abort,
+        RunID:        run.ID,
+        Status:       statusRequests(),
+        ComicInfo:    comicInfo,
+        Titles:       titles,
+        Series:       series,
+        Journal:      journal.Record,
+        KeepReplaced: keepReplace,
+        Passwords:    list,
+        Verify:       verify,
+    }, stats)
+    if err := journal.Finish(); err != nil {
+        logger.Warning(fmt.Sprintf("Failed to write journal: %v", err))
+    }
+    util.PrintFinalStats(stats, time.Since(start))
+    if len(journal.Steps) > 0 {
+        logger.Info(fmt.Sprintf("Undo this run with: %s rollback %s", os.Args[0], run.ID))
+    }
+
+    run.Finished = time.Now()
+    run.Record(stats.Results)
+    if err := history.Save(run); err != nil {
+        logger.Warning(fmt.Sprintf("Failed to record run history: %v", err))
+    }
+
+    if ctx.Err() != nil {
+        unlock()
+        os.RemoveAll(scratch)
+        os.Exit(130)
+    }
+    if stats.Errors > 0 {
+        unlock()
+        os.RemoveAll(scratch)
+        os.Exit(1)
+    }
+}
+
+// joinArchives lists the archives of inputs in the order they are joined.
+// Folders are searched for archives, the output itself is never one of them.
+func joinArchives(inputs []string, output string, order types.MergeOrder) []string {
+    var archives []string
+    for _, in := range inputs {
+        info, err := os.Stat(in)
+        if err != nil {
+            logger.Error(err.Error())
+            continue
+        }
+        found := []string{in}
+        if info.IsDir() {
+            if found, err = findArchives(in); err != nil {
+                logger.Error(fmt.Sprintf("Failed to search %s: %v", in, err))
+                continue
+            }
+        }
+        for _, archive := range found {
+            if archive = absPath(archive); pathnorm.Key(archive) != pathnorm.Key(output) {
+                archives = append(archives, archive)
+            }
+        }
+    }
+
+    if order == types.MergeNatural {
+        sort.SliceStable(archives, func(i, j int) bool {
+            return util.NaturalLess(filepath.Base(archives[i]), filepath.Base(archives[j]))
+        })
+    }
+    return archives
+}
@@ -1,14 +1,31 @@
 package main
 
 import (
+    "bufio"
+    "bytes"
+    "convert_cbz/internal/calibre"
+    "convert_cbz/internal/collector"
+    "convert_cbz/internal/comicinfo"
+    "convert_cbz/internal/history"
+    "convert_cbz/internal/naming"
+    "convert_cbz/internal/notify"
+    "convert_cbz/internal/pathnorm"
+    "convert_cbz/internal/plan"
     "convert_cbz/internal/processor"
+    "convert_cbz/internal/report"
     "convert_cbz/internal/types"
     "convert_cbz/internal/util"
+    "encoding/json"
     "flag"
     "fmt"
+    "io"
     "os"
-    "path/filepath"
+    "regexp"
     "runtime"
+    "slices"
+    "sort"
+    "strconv"
+    "strings"
     "time"
 
     "github.com/jelius-sama/logger"
@@ -17,17 +34,150 @@ import (
 const VERSION = "v2.2.1"
 
 func main() {
+    // Subcommands take over the whole argument list
+    if len(os.Args) > 1 {
+        switch os.Args[1] {
+        case "serve":
+            runServe(os.Args[2:])
+            return
+        case "hash":
+            runHash(os.Args[2:])
+            return
+        case "sync":
+            runSync(os.Args[2:])
+            return
+        case "equal":
+            runEqual(os.Args[2:])
+            return
+        case "check":
+            runCheck(os.Args[2:])
+            return
+        case "repack":
+            runRepack(os.Args[2:])
+            return
+        case "join":
+            runJoin(os.Args[2:])
+            return
+        case "rename":
+            runRename(os.Args[2:])
+            return
+        case "migrate":
+            runMigrate(os.Args[2:])
+            return
+        case "rollback":
+            runRollback(os.Args[2:])
+            return
+        case "history":
+            runHistory(os.Args[2:])
+            return
+        case "config":
+            runConfig(os.Args[2:])
+            return
+        }
+    }
+
     start := time.Now()
     // Command line argument parsing
     var (
         outputDir   string
         threads     int
         dumbMode    bool
+        imagesOnly  bool
         recursive   bool
         showHelp    bool
         showVersion bool
+        verbose     bool
+        nameWidth   int
+        noTruncate  bool
+        strict      bool
+        confirmFlag bool
+        dryRun      bool
+        keepReplace bool
+        update      bool
+        verify      bool
+        worm        bool
+        immutable   bool
+        force       bool
+        deleteSrc   bool
+        trashSrc    bool
+        trashDir    string
+        keepLatest  int
+        coldStorage string
+        preHook     string
+        postHook    string
+        discordTok  string
+        discordChan string
+        telegramTok string
+        telegramID  string
+        jsonSummary bool
+        comicInfo   bool
+        cbi         bool
+        titlePat    string
+        specialPat  string
+        seriesMap   string
+        seriesJSON  bool
+        genre       string
+        tags        string
+        ageRating   string
+        reportPath  string
+        cover       string
+        renamePages bool
+        sidecar     bool
+        opf         bool
+        calibreLib  string
+        dedupeLinks bool
+        nameTmpl    string
+        outLayout   string
+        mergeName   string
+        volumesOf   int
+        volumeName  string
+        splitPat    string
+        mirror      bool
+        inPlace     bool
+        extract     bool
+        passwords   string
+        sanitize    bool
+        sanitizeEnt bool
+        cp437       bool
+        rootImages  bool
+        reproduce   bool
+        replaceChar string
+        lowPower    bool
+        prefetch    int
+        excludeWarn float64
+        maxEntries  int
+        minImages   int
+        maxNameLen  int
+        maxDepth    int
+        scanDepth   int
+        maxPages    int
+        maxSize     types.ByteSize
+        maxDuration time.Duration
+        maxErrors   int
+        resumeID    string
+        profileName string
         inputPaths  types.StringSliceFlag
+        recInputs   types.StringSliceFlag
+        inputFiles  types.StringSliceFlag
+        excludeDirs types.StringSliceFlag
+        includeExts types.StringSliceFlag
+        excludeExts types.StringSliceFlag
+        webhooks    types.StringSliceFlag
         compression types.CompressionMode = types.ToCompressionMode(types.CMNone.String())
+        overwrite   types.OverwriteMode   = types.OverwriteSkip
+        fpMode      types.FingerprintMode = types.FingerprintMeta
+        caseMode    types.CaseMode        = types.CaseAuto
+        progress    types.ProgressMode    = types.ProgressAuto
+        sortMode    types.SortMode        = types.SortNatural
+        textFiles   types.TextMode        = types.TextKeep
+        normalize   types.NormMode        = types.NormNone
+        layout      types.LibraryLayout   = types.LayoutFlat
+        nested      types.NestedMode      = types.NestedNone
+        duplicates  types.DuplicatePolicy = types.DuplicatesKeep
+        collision   types.CollisionPolicy = types.CollisionSkip
+        logFormat   types.LogFormat       = types.LogText
+        mergeLayout types.MergeLayout     = types.MergeFolders
+        mergeOrder  types.MergeOrder      = types.MergeNatural
     )
 
     flag.StringVar(&outputDir, "output", "", "Output directory")
@@ -39,24 +189,129 @@ func main() {
 
     flag.BoolVar(&dumbMode, "dumb", false, "Archive all files without filtering")
     flag.BoolVar(&dumbMode, "d", false, "Archive all files without filtering")
+    flag.BoolVar(&imagesOnly, "images-only", false, "Archive nothing but files whose content is an image, reporting the rest")
+    flag.Var(&includeExts, "include-ext", "Extensions smart mode always archives, e.g. xml or .psd, or images, videos or text (comma separated, can be specified multiple times)")
+    flag.Var(&excludeExts, "exclude-ext", "Extensions never archived, in dumb mode too, e.g. mp4,mkv or videos (comma separated, can be specified multiple times)")
+    flag.Var(&excludeDirs, "exclude-dir", "Pattern for subdirectories of a folder that are never archived, or of a recursive input that are never converted, e.g. __MACOSX or raw/** (can be specified multiple times)")
+    flag.BoolVar(&dedupeLinks, "dedupe-links", false, "Store hard-linked duplicates in a folder once, recording the links in a manifest")
+    flag.Var(&textFiles, "text-files", "What becomes of .txt, .nfo and other text files [keep|merge|notes], merge combines them into info.txt")
 
     flag.BoolVar(&recursive, "recursive", false, "Process subdirectories recursively")
     flag.BoolVar(&recursive, "r", false, "Process subdirectories recursively")
+    flag.Var(&layout, "library-layout", "How inputs are organised [flat|series/volume/chapter]")
+    flag.Var(&nested, "nested", "How recursive inputs deeper than one level are converted [none|flatten|merge-volumes|mirror]")
+    flag.IntVar(&scanDepth, "max-depth", 0, "Levels below recursive inputs folders are looked for, deeper ones go into the archive of the folder above (default: 1, no limit with -nested)")
+    flag.Var(&duplicates, "duplicates", "What to do with folders holding the same chapter [keep|ask|larger|newer|suffix]")
+    flag.BoolVar(&rootImages, "root-images", false, "With -recursive, convert inputs that hold images but no subfolders directly")
 
     flag.BoolVar(&showHelp, "help", false, "Show usage information")
     flag.BoolVar(&showHelp, "h", false, "Show usage information")
 
     flag.BoolVar(&showVersion, "version", false, "Show version information")
-    flag.BoolVar(&showVersion, "v", false, "Show version information")
+
+    flag.BoolVar(&verbose, "verbose", false, "Stream every log line as it happens instead of one block per folder")
+    flag.BoolVar(&verbose, "v", false, "Stream every log line as it happens instead of one block per folder")
+
+    flag.BoolVar(&dryRun, "dry-run", false, "Show what would be converted compared to the last run, without converting")
+    flag.BoolVar(&dryRun, "n", false, "Show what would be converted compared to the last run, without converting")
+
+    flag.IntVar(&prefetch, "prefetch", 2, "Number of upcoming folders to scan ahead of the workers (0 disables)")
+
+    flag.BoolVar(&lowPower, "low-power", false, "Reduce concurrency while on battery or when the CPU runs hot")
+
+    flag.DurationVar(&maxDuration, "max-duration", 0, "Stop dispatching new folders after this long, e.g. 2h (0 means no limit)")
+    flag.IntVar(&maxErrors, "max-errors", 0, "Stop dispatching new folders once this many have failed (0 means no limit)")
+    flag.StringVar(&resumeID, "resume", "", "Resume the folders a time-boxed run left behind, by run ID or checkpoint file")
+
+    flag.Float64Var(&excludeWarn, "exclude-threshold", 50, "Flag folders where smart mode excludes more than this percentage of files (0 disables)")
+    flag.IntVar(&maxEntries, "max-entries", 20000, "Fail folders with more files than this before archiving them (0 disables)")
+    flag.IntVar(&minImages, "min-images", 0, "Skip folders with fewer images than this with a warning (0 disables)")
+    flag.BoolVar(&strict, "strict", false, "Fail flagged folders, and ones with fifos, sockets or empty files, instead of only warning")
+    flag.BoolVar(&confirmFlag, "confirm-flagged", false, "Ask before archiving folders smart mode would flag, on a terminal")
+
+    flag.IntVar(&nameWidth, "name-width", util.TruncateWidth, "Display width folder names are truncated to")
+    flag.BoolVar(&noTruncate, "no-truncate", false, "Never truncate folder names in progress and summary output")
 
     flag.Var(&inputPaths, "input", "Input directory/directories (can be specified multiple times)")
     flag.Var(&inputPaths, "i", "Input directory/directories (can be specified multiple times)")
+    flag.Var(&recInputs, "input-recursive", "Input directory whose subdirectories are converted, like -recursive for this input only (can be specified multiple times)")
+    flag.Var(&inputFiles, "input-file", "File listing inputs one per line, # starts a comment (can be specified multiple times)")
 
     flag.Var(&compression, "compression", "Compression mode to use")
     flag.Var(&compression, "c", "Compression mode to use")
 
+    flag.Var(&overwrite, "overwrite", "What to do with existing archives [skip|always|if-different]")
+    flag.BoolVar(&update, "update", false, "Add new files of a folder to its existing archive instead of skipping it, rebuilding it if files changed")
+    flag.BoolVar(&keepReplace, "keep-replaced", false, "Keep archives replaced by -overwrite next to them, so rollback can restore them")
+    flag.BoolVar(&verify, "verify", false, "Read every archive back before it is moved into place, failing folders whose archive is corrupt")
+    flag.BoolVar(&worm, "worm", false, "Make every archive read-only once it has been verified, write once read many")
+    flag.BoolVar(&immutable, "immutable", false, "Also make them immutable where supported, chattr +i or chflags uchg (implies -worm)")
+    flag.BoolVar(&force, "force", false, "Replace or update read-only archives instead of skipping them")
+    flag.BoolVar(&deleteSrc, "delete-source", false, "Delete the files of each folder once its archive has been read back and verified")
+    flag.BoolVar(&trashSrc, "trash-source", false, "Move each folder to the trash once its archive has been read back and verified")
+    flag.StringVar(&trashDir, "trash-dir", "", "Move folders into this quarantine directory instead of the trash (implies -trash-source)")
+    flag.IntVar(&keepLatest, "keep-latest", 0, "After the run, move all but the latest this many chapters of every series out of the output (0 keeps all)")
+    flag.StringVar(&coldStorage, "cold-storage", "", "Where -keep-latest moves older chapters to instead of the trash")
+    flag.StringVar(&preHook, "pre-hook", "", "Shell command run on every folder before its files are read, failing it fails the folder")
+    flag.StringVar(&postHook, "post-hook", "", "Shell command run for every folder once it converted, was skipped or failed")
+    flag.Var(&webhooks, "webhook", "URL every finished folder and the run summary are POSTed to as JSON (can be specified multiple times)")
+    flag.StringVar(&discordTok, "discord-token", "", "Token of the Discord bot that posts failed folders and the run summary")
+    flag.StringVar(&discordChan, "discord-channel", "", "ID of the Discord channel it posts to")
+    flag.StringVar(&telegramTok, "telegram-token", "", "Token of the Telegram bot that posts failed folders and the run summary")
+    flag.StringVar(&telegramID, "telegram-chat", "", "ID of the Telegram chat it posts to, or @name of a public channel")
+    flag.BoolVar(&comicInfo, "comicinfo", false, "Add a ComicInfo.xml with the chapter title and number parsed from the folder name")
+    flag.StringVar(&seriesMap, "series-map", "", "JSON file with series titles in other languages for -comicinfo (implies -comicinfo)")
+    flag.BoolVar(&cbi, "comicbookinfo", false, "Also write the ComicInfo.xml details as ComicBookInfo JSON into the archive comment")
+    flag.BoolVar(&seriesJSON, "series-json", false, "After the run, write a Mylar series.json into every folder holding the archives of one series")
+    flag.StringVar(&genre, "genre", "", "Comma separated genres written to ComicInfo.xml (implies -comicinfo)")
+    flag.StringVar(&tags, "tags", "", "Comma separated tags written to ComicInfo.xml (implies -comicinfo)")
+    flag.StringVar(&ageRating, "age-rating", "", "Age rating written to ComicInfo.xml, e.g. \"Teen\" or \"Mature 17+\" (implies -comicinfo)")
+    flag.StringVar(&titlePat, "title-pattern", "", "Regular expression with number/title/volume/group groups for -comicinfo")
+    flag.StringVar(&specialPat, "special-pattern", "", "Regular expression matching the folder names of specials, for {special}")
+
+    flag.StringVar(&nameTmpl, "name-template", "", "Archive name template, e.g. \"{series}/{series} - c{number:3}< - {title}>\" (default: {folder})")
+    flag.StringVar(&outLayout, "layout", "", "Name archives the way a library server expects [komga|kavita], implies -comicinfo")
+    flag.StringVar(&mergeName, "merge", "", "Combine every folder found into one archive of this name, e.g. \"Vol. 01\"")
+    flag.Var(&mergeLayout, "merge-layout", "How merged folders are stored [folders|flat], flat numbers the pages across all of them")
+    flag.Var(&mergeOrder, "merge-order", "Order folders are merged in [natural|input], input keeps the order they were given")
+    flag.IntVar(&volumesOf, "volumes-of", 0, "Bundle the chapter folders of every series into volumes of this many chapters, one archive each (0 disables)")
+    flag.StringVar(&volumeName, "volume-name", collector.DefaultVolumeName, "Name template of the volumes -volumes-of writes")
+    flag.StringVar(&splitPat, "split-by-pattern", "", "Regular expression whose first or chapter group is the chapter of a file, one archive per chapter, e.g. \"^(c\\d+)_\"")
+    flag.IntVar(&maxPages, "max-pages", 0, "Split folders with more pages than this into \"Name (Part 1).cbz\", \"Name (Part 2).cbz\", ... (0 disables)")
+    flag.Var(&maxSize, "max-size", "Split folders larger than this, e.g. 300MB, into parts like -max-pages (0 disables)")
+    flag.BoolVar(&mirror, "mirror", false, "Recreate the folders of the inputs under the output instead of writing every archive into it")
+    flag.BoolVar(&inPlace, "in-place", false, "Write every archive next to its source folder instead of into -output")
+    flag.BoolVar(&extract, "extract", false, "Unpack the CBZ/CBR files of the inputs back into folders under -output")
+    flag.Var(&collision, "on-collision", "What happens to inputs that would be written to the same output [skip|error|suffix|merge]")
+    flag.StringVar(&passwords, "passwords", "", "File with one password per line, tried in turn on encrypted archives -extract unpacks")
+    flag.BoolVar(&sanitize, "sanitize", false, "Rewrite archive names Windows and exFAT can't store, like ones with : ? | or a trailing dot")
+    flag.BoolVar(&sanitizeEnt, "sanitize-entries", false, "Rewrite entry names inside archives the same way, recorded in a manifest")
+    flag.Var(&normalize, "normalize", "Unicode form of archive and entry names [none|nfc|nfd]")
+    flag.BoolVar(&reproduce, "reproducible", false, "Fixed entry times and permissions, so the same source always gives a byte-identical archive")
+    flag.IntVar(&maxNameLen, "max-entry-length", 0, "Shorten entry names longer than this many bytes, for readers that fail on long paths (0 disables)")
+    flag.IntVar(&maxDepth, "max-entry-depth", 0, "Fold folders nested deeper than this inside archives into one (0 disables)")
+    flag.BoolVar(&cp437, "cp437-fallback", false, "Transliterate non-ASCII entry names to CP437 for readers that ignore the UTF-8 flag")
+    flag.StringVar(&replaceChar, "replace-char", naming.DefaultReplacement, "What -sanitize puts in place of characters it can't keep, empty drops them")
+    flag.StringVar(&cover, "cover", "", "Glob for the file placed first as the cover, \"none\" keeps name order (default: cover* or volume* image)")
+    flag.StringVar(&reportPath, "report", "", "Write a per-folder report to this .csv or .json file")
+    flag.BoolVar(&jsonSummary, "json", false, "Print a JSON document with per-folder results to stdout instead of the summary")
+    flag.Var(&logFormat, "log-format", "Per-folder log lines as [text|json], json streams them to stdout")
+    flag.Var(&sortMode, "sort", "Order of the pages in each archive [natural|lexical]")
+    flag.BoolVar(&sidecar, "sidecar", false, "Write <archive>.cbz.json next to every archive with its pages' names, sizes, dimensions and hashes")
+    flag.BoolVar(&opf, "opf", false, "Write <archive>.opf next to every archive with its title, series, series index and tags for Calibre")
+    flag.StringVar(&calibreLib, "calibre-library", "", "Add every archive converted to this Calibre library with calibredb")
+    flag.BoolVar(&renamePages, "rename-pages", false, "Store pages as 0001.jpg, 0002.jpg, ... in reading order, with a manifest of the original names")
+    flag.Var(&progress, "progress", "Progress display [auto|bar|plain], auto shows the bar only on a terminal")
+    flag.Var(&caseMode, "case", "How paths are compared for duplicates and collisions [auto|sensitive|insensitive]")
+    flag.Var(&fpMode, "fingerprint", "How if-different detects changed sources [meta|content]")
+    flag.StringVar(&profileName, "profile", "", "Named bundle of options, built in or from the profiles file, options given still win")
+
     flag.Usage = showUsage
     flag.Parse()
+    applyEnv(flag.CommandLine)
+    if profileName != "" {
+        applyProfile(flag.CommandLine, profileName)
+    }
 
     // Handle version flag
     if showVersion {
@@ -65,8 +320,36 @@ func main() {
         return
     }
 
+    inputPaths = append(inputPaths, readInputLists(inputFiles)...)
+    inputPaths, recInputs = stdinInputs(inputPaths, recInputs)
+    inputPaths, recInputs = expandGlobs(inputPaths), expandGlobs(recInputs)
+
+    // A checkpoint already knows its inputs and output
+    var checkpoint *history.Checkpoint
+    if resumeID != "" {
+        cp, err := history.LoadCheckpoint(resumeID)
+        if err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to load checkpoint: %v", err))
+        }
+        checkpoint = cp
+        if outputDir == "" {
+            outputDir = cp.OutputDir
+        }
+    }
+
+    // In place there is no output directory, history and the lock live in
+    // the deepest folder every archive is written below
+    if inPlace && checkpoint == nil {
+        if outputDir != "" {
+            logger.Fatal("-in-place and -output can't be combined")
+        }
+        if inputs := slices.Concat(inputPaths, recInputs); len(inputs) > 0 {
+            outputDir = collector.CommonParent(inputs)
+        }
+    }
+
     // Handle help flag or missing required arguments
-    if showHelp || len(inputPaths) == 0 || outputDir == "" {
+    if showHelp || (len(inputPaths) == 0 && len(recInputs) == 0 && checkpoint == nil) || outputDir == "" {
         showUsage()
         return
     }
@@ -76,61 +359,352 @@ func main() {
         threads = runtime.NumCPU()
     }
 
-    // Too much CPU usage might end up triggering aggresive context switching,
-    // which can hurt performance instead of increasing it
-    if compression == types.CMDefault && threads > runtime.NumCPU()*2 {
-        // Limit to 2x CPU cores to prevent resource exhaustion
-        threads = runtime.NumCPU() * 2
-        logger.Info(fmt.Sprintf("Thread count limited to %d (2x CPU cores)", threads))
+    threads = limitThreads(threads, compression)
+
+    os.Setenv(types.CKey.String(), compression.String())
+
+    senders := notifiers(webhooks, discordTok, discordChan, telegramTok, telegramID)
+
+    // Unpacking shares the workers with converting, little else
+    if extract {
+        if inPlace || mirror || mergeName != "" || volumesOf != 0 || splitPat != "" || maxPages != 0 || maxSize != 0 || layout != types.LayoutFlat || nested != types.NestedNone || scanDepth != 0 || checkpoint != nil || keepLatest != 0 || coldStorage != "" || preHook != "" || len(senders) > 0 || outLayout != "" || seriesJSON || opf || calibreLib != "" || cbi {
+            logger.Fatal("-extract can't be combined with -in-place, -mirror, -merge, -volumes-of, -split-by-pattern, -max-pages, -max-size, -library-layout, -nested, -max-depth, -resume, -keep-latest, -cold-storage, -pre-hook, -webhook, -discord-token, -telegram-token, -layout, -series-json, -opf, -calibre-library or -comicbookinfo")
+        }
+        if overwrite == types.OverwriteIfDifferent {
+            logger.Fatal("-extract takes -overwrite skip or always")
+        }
+        if collision == types.CollisionMerge {
+            logger.Fatal("-extract takes -on-collision skip, error or suffix")
+        }
+        // The archive is named like the folder it was made from
+        var names *naming.Template
+        var titles *comicinfo.TitleParser
+        var series *comicinfo.SeriesMap
+        if nameTmpl != "" {
+            var err error
+            if names, err = naming.Parse(nameTmpl); err != nil {
+                logger.Fatal(err.Error())
+            }
+            if titles, err = comicinfo.NewTitleParser(titlePat); err != nil {
+                logger.Fatal(err.Error())
+            }
+            if seriesMap != "" {
+                if series, err = comicinfo.LoadSeriesMap(seriesMap); err != nil {
+                    logger.Fatal(fmt.Sprintf("Failed to load series map: %v", err))
+                }
+            }
+        }
+        var list []string
+        if passwords != "" {
+            var err error
+            if list, err = processor.LoadPasswords(passwords); err != nil {
+                logger.Fatal(fmt.Sprintf("Failed to load password list: %v", err))
+            }
+        }
+        if verbose && progress == types.ProgressAuto {
+            progress = types.ProgressPlain
+        }
+        runExtract(start, slices.Concat(inputPaths, recInputs), outputDir, names, titles, series, collision, dryRun, reportPath, &types.Options{
+            Threads:      threads,
+            MaxErrors:    maxErrors,
+            Overwrite:    overwrite,
+            KeepReplaced: keepReplace,
+            RenamePages:  renamePages,
+            Passwords:    list,
+            PostHook:     postHook,
+            Progress:     progress,
+            Verbose:      verbose,
+        })
+        return
     }
-    if compression == types.CMFast && threads > runtime.NumCPU()*4 {
-        // Limit to 4x CPU cores to prevent resource exhaustion
-        threads = runtime.NumCPU() * 4
-        logger.Info(fmt.Sprintf("Thread count limited to %d (4x CPU cores)", threads))
+    if passwords != "" {
+        logger.Fatal("-passwords only applies to -extract, repack and join")
     }
-    if compression == types.CMSlow && threads > runtime.NumCPU() {
-        // Limit to 1x CPU cores to prevent resource exhaustion
-        threads = runtime.NumCPU()
-        logger.Info(fmt.Sprintf("Thread count limited to %d (1x CPU cores)", threads))
+
+    // A layout is a name template, and the server reads the numbers from ComicInfo.xml
+    nameTmpl = layoutTemplate(outLayout, nameTmpl, "-name-template")
+    if outLayout != "" {
+        comicInfo = true
     }
 
-    os.Setenv(types.CKey.String(), compression.String())
+    titles, err := comicinfo.NewTitleParser(titlePat)
+    if err != nil {
+        logger.Fatal(err.Error())
+    }
+    if err := titles.SetSpecialPattern(specialPat); err != nil {
+        logger.Fatal(err.Error())
+    }
+    for _, pattern := range excludeDirs {
+        if err := util.ValidPattern(pattern); err != nil {
+            logger.Fatal(fmt.Sprintf("Bad -exclude-dir pattern %q: %v", pattern, err))
+        }
+    }
+    include, exclude := extensions(includeExts, excludeExts)
+    if imagesOnly && dumbMode {
+        logger.Fatal("-images-only and -dumb can't be combined")
+    }
+    if maxNameLen < 0 || maxDepth < 0 {
+        logger.Fatal("-max-entry-length and -max-entry-depth can't be negative")
+    }
+    names, err := naming.Parse(nameTmpl)
+    if err != nil {
+        logger.Fatal(err.Error())
+    }
+    // Both decide where archives go
+    if mirror && nameTmpl != "" {
+        logger.Fatal("-mirror and -name-template can't be combined")
+    }
+    if inPlace && (mirror || nameTmpl != "") {
+        logger.Fatal("-in-place can't be combined with -mirror or -name-template")
+    }
+    if nested != types.NestedNone && layout != types.LayoutFlat {
+        logger.Fatal("-nested and -library-layout can't be combined")
+    }
+    if scanDepth < 0 {
+        logger.Fatal("-max-depth can't be negative")
+    }
+    if scanDepth != 0 && layout != types.LayoutFlat {
+        logger.Fatal("-max-depth and -library-layout can't be combined")
+    }
+    if nested == types.NestedMirror && (mirror || nameTmpl != "" || inPlace) {
+        logger.Fatal("-nested mirror can't be combined with -mirror, -in-place or -name-template")
+    }
+    // The parts have no folder of their own to name, mirror, trash or empty
+    if mergeName != "" && (inPlace || mirror || nameTmpl != "" || deleteSrc || trashSrc || trashDir != "") {
+        logger.Fatal("-merge can't be combined with -in-place, -mirror, -name-template, -delete-source or -trash-source")
+    }
+    if keepLatest < 0 {
+        logger.Fatal("-keep-latest can't be negative")
+    }
+    if confirmFlag && strict {
+        logger.Fatal("-confirm-flagged and -strict can't be combined, -strict already fails flagged folders")
+    }
+    if inPlace && (keepLatest != 0 || coldStorage != "") {
+        logger.Fatal("-keep-latest and -cold-storage can't be combined with -in-place")
+    }
+    if inPlace && seriesJSON {
+        logger.Fatal("-series-json and -in-place can't be combined")
+    }
+    var library *calibre.Library
+    if calibreLib != "" {
+        if library, err = calibre.Open(calibreLib); err != nil {
+            logger.Fatal(fmt.Sprintf("Bad -calibre-library: %v", err))
+        }
+    }
+    if collision == types.CollisionMerge && (deleteSrc || trashSrc || trashDir != "") {
+        logger.Fatal("-on-collision merge can't be combined with -delete-source or -trash-source")
+    }
+    var volumeNames *naming.Template
+    if volumesOf != 0 {
+        if volumesOf < 0 {
+            logger.Fatal("-volumes-of can't be negative")
+        }
+        if mergeName != "" || splitPat != "" || inPlace || mirror || nameTmpl != "" || deleteSrc || trashSrc || trashDir != "" {
+            logger.Fatal("-volumes-of can't be combined with -merge, -split-by-pattern, -in-place, -mirror, -name-template, -delete-source or -trash-source")
+        }
+        if volumeNames, err = naming.Parse(volumeName); err != nil {
+            logger.Fatal(err.Error())
+        }
+    }
+    // A chapter's folder holds the others too
+    var split *regexp.Regexp
+    if splitPat != "" {
+        if mergeName != "" || deleteSrc || trashSrc || trashDir != "" {
+            logger.Fatal("-split-by-pattern can't be combined with -merge, -delete-source or -trash-source")
+        }
+        if split, err = util.CompileSplit(splitPat); err != nil {
+            logger.Fatal(err.Error())
+        }
+    }
+    // The first part would take the folder with it
+    if maxPages < 0 {
+        logger.Fatal("-max-pages can't be negative")
+    }
+    if (maxPages > 0 || maxSize > 0) && (deleteSrc || trashSrc || trashDir != "") {
+        logger.Fatal("-max-pages and -max-size can't be combined with -delete-source or -trash-source")
+    }
+    var series *comicinfo.SeriesMap
+    if seriesMap != "" {
+        if series, err = comicinfo.LoadSeriesMap(seriesMap); err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to load series map: %v", err))
+        }
+        comicInfo = true
+    }
+    retain := retention{keep: keepLatest, cold: coldStorage, titles: titles, series: series}
+    if coldStorage != "" && !retain.enabled() {
+        logger.Fatal("-cold-storage needs -keep-latest, or a series map setting \"keep\"")
+    }
+    class := comicinfo.Classification{Genre: comicinfo.SplitList(genre), Tags: comicinfo.SplitList(tags)}
+    if ageRating != "" {
+        if class.AgeRating, err = comicinfo.ParseAgeRating(ageRating); err != nil {
+            logger.Fatal(err.Error())
+        }
+    }
+    if len(class.Genre) > 0 || len(class.Tags) > 0 || class.AgeRating != "" {
+        comicInfo = true
+    }
 
-    // Create output directory if it doesn't exist
-    if err := os.MkdirAll(outputDir, 0755); err != nil {
-        logger.Fatal(fmt.Sprintf("Failed to create output directory: %v", err))
+    // JSON lines own stdout, everything meant for humans moves to stderr
+    jsonOut := os.Stdout
+    if logFormat == types.LogJSON || jsonSummary {
+        os.Stdout = os.Stderr
     }
 
+    // Live log lines and the bar would draw over each other
+    if verbose && progress == types.ProgressAuto {
+        progress = types.ProgressPlain
+    }
+
+    // Full names are more useful than a tidy box when output goes to a file
+    util.TruncateWidth = nameWidth
+    if noTruncate {
+        util.TruncateWidth = 0
+    }
+
+    // Create output directory if it doesn't exist, a dry run must not touch the disk
+    if !dryRun {
+        if err := os.MkdirAll(outputDir, 0755); err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to create output directory: %v", err))
+        }
+    }
+
+    // Duplicate and collision checks depend on how the output filesystem treats case
+    pathnorm.Configure(caseMode, outputDir)
+    collector.ConfigureCollisions(collision)
+
     logger.Info(fmt.Sprintf("Starting CBZ conversion with %d threads", threads))
-    logger.Info(fmt.Sprintf("Output: %s", outputDir))
+    if inPlace {
+        logger.Info(fmt.Sprintf("Output: in place, next to each source folder (history kept in %s)", outputDir))
+    } else {
+        logger.Info(fmt.Sprintf("Output: %s", outputDir))
+    }
 
     if dumbMode {
         logger.Info("Mode: DUMB - archiving all files without filtering")
+    } else if imagesOnly {
+        logger.Info("Mode: IMAGES ONLY - archiving nothing but image files")
     } else {
         logger.Info("Mode: SMART - filtering files intelligently")
     }
 
+    if overwrite != types.OverwriteSkip {
+        logger.Info(fmt.Sprintf("Overwrite: %s - existing archives may be replaced", overwrite))
+    }
+    if update && overwrite != types.OverwriteAlways {
+        logger.Info("Update: new files are added to existing archives")
+    }
+
+    if trashDir != "" {
+        trashSrc = true
+    }
+    switch {
+    case deleteSrc && trashSrc:
+        logger.Fatal("-delete-source and -trash-source can't be combined")
+    case deleteSrc:
+        logger.Warning("Delete source: folders are deleted once their archive is verified")
+    case trashDir != "":
+        logger.Info(fmt.Sprintf("Trash source: folders are moved to %s once their archive is verified", trashDir))
+    case trashSrc:
+        logger.Info("Trash source: folders are moved to the trash once their archive is verified")
+    case verify:
+        logger.Info("Verify: archives are read back before they are moved into place")
+    }
+    if immutable {
+        worm = true
+    }
+    if worm {
+        logger.Info("WORM: archives are made read-only once verified")
+    }
+
+    if lowPower {
+        logger.Info("Mode: LOW-POWER - fewer workers on battery or when running hot")
+    }
+
+    // -recursive makes every -input recursive, -input-recursive marks single ones
+    recursiveInputs, directInputs := []string(recInputs), []string(inputPaths)
     if recursive {
+        recursiveInputs, directInputs = slices.Concat(directInputs, recursiveInputs), nil
+    }
+
+    switch {
+    case layout != types.LayoutFlat:
+        logger.Info(fmt.Sprintf("Mode: LIBRARY - %s, one archive per chapter in a folder per series", layout))
+    case nested == types.NestedMergeVolumes && len(recursiveInputs) > 0:
+        logger.Info("Mode: NESTED - merge-volumes, one archive per folder of chapters at any depth")
+    case nested != types.NestedNone && len(recursiveInputs) > 0 && scanDepth > 0:
+        logger.Info(fmt.Sprintf("Mode: NESTED - %s, one archive per leaf folder, max depth %d", nested, scanDepth))
+    case nested != types.NestedNone && len(recursiveInputs) > 0:
+        logger.Info(fmt.Sprintf("Mode: NESTED - %s, one archive per leaf folder at any depth", nested))
+    case scanDepth > 1 && len(recursiveInputs) > 0:
+        logger.Info(fmt.Sprintf("Mode: RECURSIVE - folders up to %d levels below the inputs", scanDepth))
+    case len(recursiveInputs) > 0 && len(directInputs) > 0:
+        logger.Info(fmt.Sprintf("Mode: MIXED - %d recursive and %d direct inputs", len(recursiveInputs), len(directInputs)))
+    case len(recursiveInputs) > 0:
         logger.Info("Mode: RECURSIVE - processing subdirectories")
-    } else {
+    default:
         logger.Info("Mode: DIRECT - converting specified directories only")
     }
 
     // Collect all work items based on input paths and mode
     var workItems []types.WorkItem
-    var err error
 
-    if recursive {
-        // Recursive mode: scan each input path for subdirectories
-        workItems, err = collectRecursiveWorkItems(inputPaths, outputDir, dumbMode)
+    if checkpoint != nil {
+        // Resume: pick up exactly what the time-boxed run didn't get to
+        logger.Info(fmt.Sprintf("Resuming run %s", checkpoint.RunID))
+        workItems = checkpoint.Items
+        // The checks above see no -merge or -split-by-pattern on a resume,
+        // the items still carry what those did
+        if deleteSrc || trashSrc || trashDir != "" {
+            for _, item := range workItems {
+                if item.SharesSource() {
+                    logger.Fatal(fmt.Sprintf("Run %s merged or split folders, it can't be resumed with -delete-source or -trash-source", checkpoint.RunID))
+                }
+            }
+        }
     } else {
-        // Direct mode: convert specified directories directly
-        workItems, err = collectDirectWorkItems(inputPaths, outputDir, dumbMode)
+        // Mirrored under the common parent, every archive already sits next to its source
+        workItems, err = collectInputs(directInputs, recursiveInputs, outputDir, dumbMode, rootImages, mirror || inPlace, layout, nested, scanDepth, excludeDirs)
     }
 
     if err != nil {
         logger.Fatal(fmt.Sprintf("Failed to collect work items: %v", err))
     }
+    if checkpoint == nil {
+        if split != nil {
+            workItems = collector.Split(workItems, split)
+        }
+        workItems = collector.ResolveDuplicates(workItems, duplicates, titles, series, askDuplicate)
+        if mergeName != "" {
+            workItems = collector.Merge(workItems, outputDir, mergeName, mergeOrder)
+        }
+        if volumesOf > 0 {
+            workItems = collector.Volumes(workItems, outputDir, volumesOf, volumeNames, titles, series)
+        }
+        workItems = collector.ApplyTemplate(workItems, outputDir, names, titles, series)
+        workItems = collector.Sanitize(workItems, outputDir, sanitizer(sanitize, replaceChar))
+        workItems = collector.Normalize(workItems, outputDir, normalize)
+        if inPlace {
+            workItems = collector.InPlace(workItems)
+        }
+        if workItems, err = collector.ResolveCollisions(workItems, collision); err != nil {
+            logger.Fatal(err.Error())
+        }
+        if retain.enabled() {
+            workItems = dropRetired(workItems, outputDir, coldStorage)
+        }
+        if maxPages > 0 || maxSize > 0 {
+            sel := &types.Options{ExcludeDirs: excludeDirs, IncludeExt: include, ExcludeExt: exclude, ImagesOnly: imagesOnly, Roots: slices.Concat(inputPaths, recInputs), Sort: sortMode, Cover: cover}
+            workItems = collector.SplitBySize(workItems, maxPages, int64(maxSize), func(item types.WorkItem) ([]int64, error) {
+                return processor.PageSizes(item, sel)
+            })
+        }
+    }
+
+    // Asked up front, a prompt can't share the terminal with the progress bar
+    if confirmFlag && !dryRun {
+        sel := &types.Options{Threads: threads, ExcludeThreshold: excludeWarn, ExcludeDirs: excludeDirs, IncludeExt: include, ExcludeExt: exclude, ImagesOnly: imagesOnly, TextFiles: textFiles, Roots: slices.Concat(inputPaths, recInputs), Sort: sortMode, Cover: cover}
+        workItems = confirmFlagged(workItems, sel)
+    }
 
     if len(workItems) == 0 {
         logger.Warning("No folders found to process")
@@ -139,110 +713,543 @@ func main() {
 
     logger.Info(fmt.Sprintf("Found %d folders to process", len(workItems)))
 
+    last, err := history.Last(outputDir)
+    if err != nil {
+        logger.Warning(fmt.Sprintf("Failed to read run history: %v", err))
+    }
+
+    if dryRun {
+        fmt.Println()
+        plan.Build(workItems, last, overwrite, update && overwrite != types.OverwriteAlways).Print()
+        return
+    }
+
+    // Simultaneous runs are fine as long as they write into different directories
+    run := history.NewRun(start, outputDir, slices.Concat(inputPaths, recInputs))
+    unlock, err := history.Lock(outputDir, run.ID)
+    if err != nil {
+        logger.Fatal(fmt.Sprintf("Failed to lock output directory: %v", err))
+    }
+
+    // Ctrl+C stops starting new folders, a second one aborts those in progress
+    ctx, abort := interruptContexts()
+
+    // Every archive written is journaled first, so the run can be rolled back
+    journal := history.NewJournal(run.ID, outputDir)
+
     // Process folders concurrently
     stats := &types.ConversionStats{Total: len(workItems)}
-    util.PrintFinalStats(stats, processor.ProcessConcurrently(workItems, threads, stats), time.Since(start))
-}
+    opts := &types.Options{
+        Threads:          threads,
+        ExcludeThreshold: excludeWarn,
+        MaxEntries:       maxEntries,
+        MinImages:        minImages,
+        ExcludeDirs:      excludeDirs,
+        IncludeExt:       include,
+        ExcludeExt:       exclude,
+        ImagesOnly:       imagesOnly,
+        Roots:            slices.Concat(inputPaths, recInputs),
+        Strict:           strict,
+        MaxDuration:      maxDuration,
+        MaxErrors:        maxErrors,
+        LowPower:         lowPower,
+        Overwrite:        overwrite,
+        Update:           update,
+        Cover:            cover,
+        PreHook:          preHook,
+        PostHook:         postHook,
+        Sort:             sortMode,
+        RenamePages:      renamePages,
+        Merge:            mergeLayout,
+        Sidecar:          sidecar,
+        OPF:              opf,
+        ComicBookInfo:    cbi,
+        Calibre:          library,
+        DedupeLinks:      dedupeLinks,
+        TextFiles:        textFiles,
+        EntrySanitizer:   sanitizer(sanitizeEnt, replaceChar),
+        Normalize:        normalize,
+        CP437Fallback:    cp437,
+        MaxEntryLength:   maxNameLen,
+        MaxEntryDepth:    maxDepth,
+        Reproducible:     reproduce,
+        Fingerprint:      fpMode,
+        Prefetch:         prefetch,
+        Abort:            abort,
+        RunID:            run.ID,
+        LogFormat:        logFormat,
+        Progress:         progress,
+        Status:           statusRequests(),
+        Verbose:          verbose,
+        ComicInfo:        comicInfo,
+        Titles:           titles,
+        Series:           series,
+        Classification:   class,
+        Journal:          journal.Record,
+        KeepReplaced:     keepReplace,
+        Verify:           verify,
+        DeleteSource:     deleteSrc,
+        TrashSource:      trashSrc,
+        TrashDir:         trashDir,
+        WORM:             worm,
+        Immutable:        immutable,
+        Force:            force,
+    }
+    if logFormat == types.LogJSON {
+        opts.LogOutput = jsonOut
+    }
+    if logFormat == types.LogJSON || jsonSummary {
+        // A spinner would only garble the stream
+        opts.Quiet = true
+    }
+    notifier := notify.Start(run.ID, senders)
+    if notifier != nil {
+        opts.OnResult = notifier.Item
+    }
+    processor.ProcessConcurrently(ctx, workItems, opts, stats)
 
-// collectRecursiveWorkItems scans input directories for subdirectories (original behavior)
-func collectRecursiveWorkItems(inputPaths []string, outputDir string, dumbMode bool) ([]types.WorkItem, error) {
-    var workItems []types.WorkItem
-    seenPaths := make(map[string]bool) // Prevent duplicates
+    // Only a finished run knows which chapters are the latest
+    var retired []history.Item
+    if retain.enabled() && ctx.Err() == nil {
+        retired = applyRetention(absPath(outputDir), retain, journal, stats.Results)
+    }
+    if seriesJSON && ctx.Err() == nil {
+        writeSeriesFiles(absPath(outputDir), titles, series, journal, stats.Results)
+    }
+    if err := journal.Finish(); err != nil {
+        logger.Warning(fmt.Sprintf("Failed to write journal: %v", err))
+    }
+    if len(journal.Steps) > 0 {
+        logger.Info(fmt.Sprintf("Undo this run with: %s rollback %s", os.Args[0], run.ID))
+    }
 
-    for _, inputPath := range inputPaths {
-        // Validate input directory exists
-        if _, err := os.Stat(inputPath); os.IsNotExist(err) {
-            logger.Warning(fmt.Sprintf("Input directory does not exist, skipping: %s", inputPath))
-            continue
+    if jsonSummary {
+        printSummary(jsonOut, run, stats, start, logFormat == types.LogJSON)
+    } else if logFormat == types.LogJSON {
+        snap := stats.Snapshot()
+        json.NewEncoder(jsonOut).Encode(types.LogRecord{
+            Time:     time.Now(),
+            Level:    "info",
+            Msg:      "Conversion complete",
+            Duration: time.Since(start).Seconds(),
+            Stats:    &snap,
+        })
+    } else {
+        util.PrintFinalStats(stats, time.Since(start))
+    }
+
+    if reportPath != "" {
+        if err := report.Write(reportPath, reportRows(stats)); err != nil {
+            logger.Error(fmt.Sprintf("Failed to write report: %v", err))
+        } else {
+            logger.Info(fmt.Sprintf("Report written to %s", reportPath))
         }
+    }
 
-        // Get subdirectories
-        folders, err := util.GetFolders(inputPath)
-        if err != nil {
-            logger.Warning(fmt.Sprintf("Failed to read directory %s: %v", inputPath, err))
-            continue
+    run.Finished = time.Now()
+    run.Record(stats.Results)
+    run.Items = append(run.Items, retired...)
+    if err := history.Save(run); err != nil {
+        logger.Warning(fmt.Sprintf("Failed to record run history: %v", err))
+    }
+
+    // The old checkpoint is superseded either way, a new one covers anything still left
+    if checkpoint != nil {
+        if err := history.RemoveCheckpoint(checkpoint.RunID); err != nil {
+            logger.Warning(fmt.Sprintf("Failed to remove checkpoint: %v", err))
         }
+    }
+    if len(stats.Deferred) > 0 {
+        cp := &history.Checkpoint{
+            RunID:     run.ID,
+            Created:   time.Now(),
+            OutputDir: run.OutputDir,
+            Items:     stats.Deferred,
+        }
+        if err := history.SaveCheckpoint(cp); err != nil {
+            logger.Error(fmt.Sprintf("Failed to write checkpoint: %v", err))
+        } else {
+            if ctx.Err() != nil {
+                logger.Warning(fmt.Sprintf("Interrupted, %d folders not converted", len(stats.Deferred)))
+            } else if stats.ErrorLimit {
+                logger.Warning(fmt.Sprintf("Stopped after %d failed folders, %d folders not started", maxErrors, len(stats.Deferred)))
+            } else {
+                logger.Warning(fmt.Sprintf("Time budget of %s reached, %d folders not started", maxDuration, len(stats.Deferred)))
+            }
+            logger.Info(fmt.Sprintf("Resume with: %s -resume %s", os.Args[0], run.ID))
+        }
+    }
 
-        logger.Info(fmt.Sprintf("Input: %s (%d subdirectories)", inputPath, len(folders)))
+    notifier.Finish(runSummary(start, run.OutputDir, stats, ctx.Err() != nil))
+    unlock()
 
-        // Create work items for each subdirectory
-        for _, folder := range folders {
-            sourcePath := filepath.Join(inputPath, folder)
+    // Conventional exit status for a run stopped by a signal
+    if ctx.Err() != nil {
+        os.Exit(130)
+    }
+    // Scripts shouldn't take a run that gave up for a finished one
+    if stats.ErrorLimit {
+        os.Exit(1)
+    }
+}
 
-            // Get absolute path to avoid duplicates
-            absPath, err := filepath.Abs(sourcePath)
+// collectInputs gathers the work items of a run: every subdirectory of the
+// recursive inputs, or the folders nested finds in them down to depth
+// levels, and the direct inputs themselves, in one list. Folders found below
+// an input are skipped when they match exclude or a .cbzignore file between
+// the input and them ignores them. A library layout takes every
+// input as a library laid out that way instead, mirror recreates the input
+// folders under the output.
+func collectInputs(direct, recursive []string, outputDir string, dumbMode, rootImages, mirror bool, layout types.LibraryLayout, nested types.NestedMode, depth int, exclude []string) ([]types.WorkItem, error) {
+    // Same-named folders of different inputs only stop colliding once
+    // mirrored, so collect every input on its own first
+    if mirror {
+        var workItems []types.WorkItem
+        for i, in := range slices.Concat(direct, recursive) {
+            var items []types.WorkItem
+            var err error
+            if i < len(direct) {
+                items, err = collectInputs([]string{in}, nil, outputDir, dumbMode, rootImages, false, layout, nested, depth, exclude)
+            } else {
+                items, err = collectInputs(nil, []string{in}, outputDir, dumbMode, rootImages, false, layout, nested, depth, exclude)
+            }
             if err != nil {
-                logger.Warning(fmt.Sprintf("Failed to resolve path %s: %v", sourcePath, err))
-                continue
+                return nil, err
             }
+            workItems = append(workItems, items...)
+        }
+        return collector.Mirror(workItems, slices.Concat(direct, recursive), outputDir), nil
+    }
+
+    if layout == types.LayoutSeriesVolumeChapter {
+        workItems, err := collector.CollectLibrary(slices.Concat(direct, recursive), outputDir, dumbMode)
+        workItems = collector.ExcludeDirs(workItems, slices.Concat(direct, recursive), exclude)
+        return collector.Ignored(workItems, slices.Concat(direct, recursive)), err
+    }
+    if len(recursive) == 0 {
+        return collector.CollectDirect(direct, outputDir, dumbMode)
+    }
 
-            // Skip if we've already seen this path
-            if seenPaths[absPath] {
+    collect := collector.CollectRecursive
+    if nested != types.NestedNone || depth > 1 {
+        // Deeper folders are named like -nested flatten names them
+        mode := nested
+        if mode == types.NestedNone {
+            mode = types.NestedFlatten
+        }
+        collect = func(inputPaths []string, outputDir string, dumbMode bool) ([]types.WorkItem, error) {
+            return collector.CollectNested(inputPaths, outputDir, dumbMode, mode, depth, exclude)
+        }
+    }
+    workItems, err := collect(recursive, outputDir, dumbMode)
+    if err != nil {
+        return nil, err
+    }
+    workItems = collector.Ignored(collector.ExcludeDirs(workItems, recursive, exclude), recursive)
+    // Pointed at a single chapter, there are no subfolders to find
+    direct = append(rootsToConvert(recursive, rootImages), direct...)
+    if len(direct) > 0 {
+        workItems = collector.AddDirect(workItems, direct, outputDir, dumbMode)
+    }
+    return workItems, nil
+}
+
+// rootsToConvert picks the recursive inputs that hold loose images but no
+// subfolders to convert directly: all of them with -root-images, the ones
+// confirmed at a prompt when run from a terminal, none otherwise
+func rootsToConvert(inputPaths []string, convert bool) []string {
+    var paths []string
+    for _, root := range collector.LooseRoots(inputPaths) {
+        switch {
+        case convert:
+        case util.IsTerminal(os.Stdin) && util.IsTerminal(os.Stdout):
+            if !confirm(fmt.Sprintf("%s has no subfolders but %d images, convert it as one folder? [y/N] ", root.Path, root.Images)) {
                 continue
             }
-            seenPaths[absPath] = true
+        default:
+            logger.Warning(fmt.Sprintf("%s has no subfolders but %d images, convert it with -root-images or as a direct -input", root.Path, root.Images))
+            continue
+        }
+        paths = append(paths, root.Path)
+    }
+    return paths
+}
 
-            outputPath := filepath.Join(outputDir, folder+".cbz")
+// confirmFlagged asks about every folder smart mode would flag whether to
+// archive it anyway, and leaves out the ones that aren't confirmed. Away from
+// a terminal nobody can answer, they are archived and flagged as usual.
+func confirmFlagged(workItems []types.WorkItem, sel *types.Options) []types.WorkItem {
+    if !util.IsTerminal(os.Stdin) || !util.IsTerminal(os.Stdout) {
+        logger.Warning("-confirm-flagged needs a terminal, flagged folders are archived and reported as usual")
+        return workItems
+    }
+    flagged := processor.FlaggedItems(workItems, sel)
+    if len(flagged) == 0 {
+        return workItems
+    }
 
-            workItems = append(workItems, types.WorkItem{
-                FolderName: folder,
-                SourcePath: absPath,
-                OutputPath: outputPath,
-                DumbMode:   dumbMode,
-            })
+    var kept []types.WorkItem
+    for i, item := range workItems {
+        if f, ok := flagged[i]; ok {
+            pct := float64(f.Excluded) / float64(f.Scanned) * 100
+            if !confirm(fmt.Sprintf("Smart mode leaves out %d of %d files (%.0f%%) of %s, archive it anyway? [y/N] ", f.Excluded, f.Scanned, pct, item.SourcePath)) {
+                logger.Warning("Skipping " + item.FolderName)
+                continue
+            }
         }
+        kept = append(kept, item)
     }
-
-    return workItems, nil
+    return kept
 }
 
-// collectDirectWorkItems converts specified directories directly
-func collectDirectWorkItems(inputPaths []string, outputDir string, dumbMode bool) ([]types.WorkItem, error) {
-    var workItems []types.WorkItem
-    seenPaths := make(map[string]bool) // Prevent duplicates
+// askDuplicate lists the folders holding the same chapter and lets the user
+// pick the ones to keep. Away from a terminal nobody can answer, so all of
+// them are kept.
+func askDuplicate(chapter string, candidates []collector.Candidate) []int {
+    if !util.IsTerminal(os.Stdin) || !util.IsTerminal(os.Stdout) {
+        return nil
+    }
 
-    for _, inputPath := range inputPaths {
-        // Validate input directory exists
-        inputInfo, err := os.Stat(inputPath)
-        if os.IsNotExist(err) {
-            logger.Warning(fmt.Sprintf("Input path does not exist, skipping: %s", inputPath))
-            continue
+    fmt.Printf("\n%s is in %d folders:\n", chapter, len(candidates))
+    for i, c := range candidates {
+        fmt.Printf("  %d) %s  %d files, %.1f MB, modified %s\n", i+1, c.Item.SourcePath, c.Files, float64(c.Bytes)/(1<<20), c.Modified.Format("2006-01-02 15:04"))
+    }
+
+    reader := bufio.NewReader(os.Stdin)
+    for {
+        fmt.Print("Keep which? [numbers separated by spaces, a for all] ")
+        answer, err := reader.ReadString('\n')
+        answer = strings.ToLower(strings.TrimSpace(answer))
+        if err != nil || answer == "" || answer == "a" || answer == "all" {
+            return nil
         }
 
-        // Ensure it's a directory
-        if !inputInfo.IsDir() {
-            logger.Warning(fmt.Sprintf("Input path is not a directory, skipping: %s", inputPath))
-            continue
+        var keep []int
+        for _, field := range strings.FieldsFunc(answer, func(r rune) bool { return r == ' ' || r == ',' }) {
+            n, err := strconv.Atoi(field)
+            if err != nil || n < 1 || n > len(candidates) {
+                keep = nil
+                break
+            }
+            keep = append(keep, n-1)
         }
+        if keep != nil {
+            return keep
+        }
+        fmt.Printf("Answer with numbers from 1 to %d\n", len(candidates))
+    }
+}
+
+// confirm asks a yes/no question on the terminal, anything but yes is a no
+func confirm(question string) bool {
+    fmt.Print(question)
+    answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+    answer = strings.ToLower(strings.TrimSpace(answer))
+    return answer == "y" || answer == "yes"
+}
 
-        // Get absolute path to avoid duplicates
-        absPath, err := filepath.Abs(inputPath)
+// readInputLists reads the inputs listed in the -input-file files, one path
+// per line. Blank lines and ones starting with # are skipped, and relative
+// paths are taken from the working directory like those of -input.
+func readInputLists(lists []string) []string {
+    var inputs []string
+    for _, list := range lists {
+        data, err := os.ReadFile(list)
         if err != nil {
-            logger.Warning(fmt.Sprintf("Failed to resolve path %s: %v", inputPath, err))
-            continue
+            logger.Fatal(fmt.Sprintf("Failed to read input list: %v", err))
+        }
+        n := 0
+        for _, line := range strings.Split(string(data), "\n") {
+            line = strings.TrimSpace(strings.TrimPrefix(line, "\uFEFF"))
+            if line == "" || strings.HasPrefix(line, "#") {
+                continue
+            }
+            inputs = append(inputs, line)
+            n++
+        }
+        if n == 0 {
+            logger.Warning(fmt.Sprintf("%s lists no inputs", list))
         }
+    }
+    return inputs
+}
+
+// stdinInputs replaces the "-" among the direct or recursive inputs with the
+// paths read from stdin, separated by NULs like find -print0 writes them or
+// else one per line. Stdin can only be read once.
+func stdinInputs(direct, recursive []string) ([]string, []string) {
+    dashes := slices.Contains(direct, "-")
+    if slices.Contains(recursive, "-") {
+        if dashes {
+            logger.Fatal("-input - and -input-recursive - can't be combined, stdin can only be read once")
+        }
+        return direct, replaceDash(recursive)
+    }
+    if dashes {
+        direct = replaceDash(direct)
+    }
+    return direct, recursive
+}
 
-        // Skip if we've already seen this path
-        if seenPaths[absPath] {
-            logger.Warning(fmt.Sprintf("Duplicate path, skipping: %s", inputPath))
+// replaceDash puts the paths read from stdin where the first "-" of inputs is
+func replaceDash(inputs []string) []string {
+    data, err := io.ReadAll(os.Stdin)
+    if err != nil {
+        logger.Fatal(fmt.Sprintf("Failed to read inputs from stdin: %v", err))
+    }
+    var paths []string
+    if bytes.IndexByte(data, 0) >= 0 {
+        // Names may hold newlines and spaces, NUL separated ones are taken as they are
+        for _, p := range strings.Split(string(data), "\x00") {
+            if p != "" {
+                paths = append(paths, p)
+            }
+        }
+    } else {
+        for _, line := range strings.Split(string(data), "\n") {
+            if line = strings.TrimSuffix(line, "\r"); line != "" {
+                paths = append(paths, line)
+            }
+        }
+    }
+    if len(paths) == 0 {
+        logger.Warning("No inputs read from stdin")
+    }
+
+    i := slices.Index(inputs, "-")
+    rest := slices.DeleteFunc(slices.Clone(inputs[i+1:]), func(in string) bool { return in == "-" })
+    return slices.Concat(inputs[:i], paths, rest)
+}
+
+// expandGlobs replaces the inputs with wildcards by the paths they match, for
+// shells like cmd.exe that leave them be. An input that exists as it is
+// written, like "[Group] Series", is never taken for a pattern.
+func expandGlobs(inputs []string) []string {
+    var expanded []string
+    for _, in := range inputs {
+        if _, err := os.Stat(in); err == nil || !util.HasMeta(in) {
+            expanded = append(expanded, in)
             continue
         }
-        seenPaths[absPath] = true
+        matches, err := util.Glob(in)
+        if err != nil {
+            logger.Fatal(fmt.Sprintf("Bad input pattern %q: %v", in, err))
+        }
+        if len(matches) == 0 {
+            logger.Warning(fmt.Sprintf("%s matches nothing", in))
+        }
+        expanded = append(expanded, matches...)
+    }
+    return expanded
+}
+
+// extensions parses -include-ext and -exclude-ext
+func extensions(includeExts, excludeExts []string) ([]string, []string) {
+    include, err := processor.ParseExtensions(includeExts)
+    if err != nil {
+        logger.Fatal(fmt.Sprintf("Bad -include-ext: %v", err))
+    }
+    exclude, err := processor.ParseExtensions(excludeExts)
+    if err != nil {
+        logger.Fatal(fmt.Sprintf("Bad -exclude-ext: %v", err))
+    }
+    return include, exclude
+}
+
+// layoutTemplate returns the name template -layout stands for, or tmpl,
+// given by templateFlag, without a layout
+func layoutTemplate(layout, tmpl, templateFlag string) string {
+    if layout == "" {
+        return tmpl
+    }
+    if tmpl != "" {
+        logger.Fatal(fmt.Sprintf("-layout and %s can't be combined", templateFlag))
+    }
+    tmpl, err := naming.Layout(layout)
+    if err != nil {
+        logger.Fatal(fmt.Sprintf("Bad -layout: %v", err))
+    }
+    return tmpl
+}
 
-        // Generate output filename from directory name
-        folderName := filepath.Base(absPath)
-        outputPath := filepath.Join(outputDir, folderName+".cbz")
+// sanitizer returns the sanitizer for -sanitize and -replace-char, nil when disabled
+func sanitizer(enabled bool, replacement string) *naming.Sanitizer {
+    if !enabled {
+        return nil
+    }
+    s, err := naming.NewSanitizer(replacement)
+    if err != nil {
+        logger.Fatal(err.Error())
+    }
+    return s
+}
 
-        logger.Info(fmt.Sprintf("Input: %s", inputPath))
+// limitThreads caps the worker count according to how CPU heavy the compression mode is
+func limitThreads(threads int, compression types.CompressionMode) int {
+    // Too much CPU usage might end up triggering aggresive context switching,
+    // which can hurt performance instead of increasing it
+    if compression == types.CMDefault && threads > runtime.NumCPU()*2 {
+        // Limit to 2x CPU cores to prevent resource exhaustion
+        threads = runtime.NumCPU() * 2
+        logger.Info(fmt.Sprintf("Thread count limited to %d (2x CPU cores)", threads))
+    }
+    if compression == types.CMFast && threads > runtime.NumCPU()*4 {
+        // Limit to 4x CPU cores to prevent resource exhaustion
+        threads = runtime.NumCPU() * 4
+        logger.Info(fmt.Sprintf("Thread count limited to %d (4x CPU cores)", threads))
+    }
+    if compression == types.CMSlow && threads > runtime.NumCPU() {
+        // Limit to 1x CPU cores to prevent resource exhaustion
+        threads = runtime.NumCPU()
+        logger.Info(fmt.Sprintf("Thread count limited to %d (1x CPU cores)", threads))
+    }
+    return threads
+}
 
-        workItems = append(workItems, types.WorkItem{
-            FolderName: folderName,
-            SourcePath: absPath,
-            OutputPath: outputPath,
-            DumbMode:   dumbMode,
-        })
+// printSummary writes the -json document. Next to JSON log lines it has to be
+// a single line too, otherwise it is indented for people reading along.
+func printSummary(w io.Writer, run *history.Run, stats *types.ConversionStats, start time.Time, compact bool) {
+    stats.Mutex.Lock()
+    summary := types.RunSummary{
+        RunID:     run.ID,
+        Started:   start,
+        Finished:  time.Now(),
+        Duration:  time.Since(start).Seconds(),
+        OutputDir: run.OutputDir,
+        Items:     append([]types.ItemResult{}, stats.Results...),
+        Deferred:  deferredResults(stats.Deferred),
     }
+    stats.Mutex.Unlock()
+    summary.Stats = stats.Snapshot()
 
-    return workItems, nil
+    sort.Slice(summary.Items, func(i, j int) bool { return summary.Items[i].SourcePath < summary.Items[j].SourcePath })
+
+    enc := json.NewEncoder(w)
+    if !compact {
+        enc.SetIndent("", "  ")
+    }
+    if err := enc.Encode(summary); err != nil {
+        logger.Error(fmt.Sprintf("Failed to write summary: %v", err))
+    }
+}
+
+// deferredResults lists items that were never started as results
+func deferredResults(items []types.WorkItem) []types.ItemResult {
+    results := []types.ItemResult{}
+    for _, item := range items {
+        results = append(results, types.ItemResult{
+            FolderName: item.FolderName,
+            SourcePath: item.SourcePath,
+            OutputPath: item.OutputPath,
+            Status:     types.StatusDeferred,
+        })
+    }
+    return results
 }
 
+// reportRows is everything a -report covers, including the folders not started
+func reportRows(stats *types.ConversionStats) []types.ItemResult {
+    stats.Mutex.Lock()
+    defer stats.Mutex.Unlock()
+    return append(append([]types.ItemResult{}, stats.Results...), deferredResults(stats.Deferred)...)
+}
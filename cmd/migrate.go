@@ -0,0 +1,297 @@
+package main
+
+import (
+    "convert_cbz/internal/comicinfo"
+    "convert_cbz/internal/history"
+    "convert_cbz/internal/naming"
+    "convert_cbz/internal/pathnorm"
+    "convert_cbz/internal/processor"
+    "convert_cbz/internal/types"
+    "flag"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "github.com/jelius-sama/logger"
+)
+
+// migration is everything runMigrate changes about one archive
+type migration struct {
+    from, to string
+    source   string
+    rewrite  processor.Rewrite
+    changes  []string
+}
+
+func (m migration) needsRewrite() bool {
+    return m.rewrite.Recompress || m.rewrite.ComicInfo != nil
+}
+
+// runMigrate upgrades an existing output library to the current conventions:
+// names from a template, a ComicInfo.xml in every archive and the entry method
+// of a compression mode. Every change is journaled first and the whole
+// migration is rolled back if one of them fails.
+func runMigrate(args []string) {
+    start := time.Now()
+    var (
+        outputDir   string
+        tmplText    string
+        titlePat    string
+        seriesMap   string
+        rollbackID  string
+        comicInfo   bool
+        dryRun      bool
+        keepBackups bool
+        compression types.CompressionMode = types.CMNone
+    )
+
+    fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+    fs.StringVar(&outputDir, "output", "", "Output library to migrate")
+    fs.StringVar(&outputDir, "o", "", "Output library to migrate")
+    fs.StringVar(&tmplText, "template", "", "Name template to move archives to")
+    fs.StringVar(&tmplText, "t", "", "Name template to move archives to")
+    fs.BoolVar(&comicInfo, "comicinfo", false, "Add a generated ComicInfo.xml to archives without one")
+    fs.StringVar(&titlePat, "title-pattern", "", "Regular expression with number/title/volume/group groups for folder names")
+    fs.StringVar(&seriesMap, "series-map", "", "JSON file with series titles for names and ComicInfo.xml")
+    fs.Var(&compression, "compression", "Store entries the way this compression mode does")
+    fs.Var(&compression, "c", "Store entries the way this compression mode does")
+    fs.BoolVar(&dryRun, "dry-run", false, "Show what would change without changing anything")
+    fs.BoolVar(&dryRun, "n", false, "Show what would change without changing anything")
+    fs.BoolVar(&keepBackups, "keep-backups", false, "Keep the original of every rewritten archive, so -rollback can restore it later")
+    fs.StringVar(&rollbackID, "rollback", "", "Undo the migration with this journal ID")
+    fs.Usage = showMigrateUsage
+    fs.Parse(args)
+    applyEnv(fs)
+
+    if rollbackID != "" {
+        rollbackRun(rollbackID, false)
+        return
+    }
+
+    recompress := false
+    fs.Visit(func(f *flag.Flag) {
+        if f.Name == "compression" || f.Name == "c" {
+            recompress = true
+        }
+    })
+    if outputDir == "" || (tmplText == "" && !comicInfo && !recompress) {
+        showMigrateUsage()
+        return
+    }
+    os.Setenv(types.CKey.String(), compression.String())
+
+    var tmpl *naming.Template
+    if tmplText != "" {
+        var err error
+        if tmpl, err = naming.Parse(tmplText); err != nil {
+            logger.Fatal(err.Error())
+        }
+    }
+    titles, err := comicinfo.NewTitleParser(titlePat)
+    if err != nil {
+        logger.Fatal(err.Error())
+    }
+    var series *comicinfo.SeriesMap
+    if seriesMap != "" {
+        if series, err = comicinfo.LoadSeriesMap(seriesMap); err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to load series map: %v", err))
+        }
+    }
+    metaOpts := &types.Options{ComicInfo: true, Titles: titles, Series: series}
+
+    outputDir = absPath(outputDir)
+    pathnorm.Configure(types.CaseAuto, outputDir)
+
+    run := history.NewRun(start, outputDir, nil)
+    unlock := func() {}
+    if !dryRun {
+        release, err := history.Lock(outputDir, run.ID)
+        if err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to lock output directory: %v", err))
+        }
+        unlock = release
+    }
+    defer unlock()
+
+    sources, cat := librarySources(outputDir)
+    archives, err := findArchives(outputDir)
+    if err != nil {
+        logger.Fatal(fmt.Sprintf("Failed to scan %s: %v", outputDir, err))
+    }
+
+    // Work out every change before making any, a broken archive stops the
+    // migration before it starts
+    var migrations []migration
+    taken := make(map[string]string)
+    for _, archive := range archives {
+        m := migration{from: archive, to: archive, source: sources[pathnorm.Key(archive)]}
+
+        info, err := processor.InspectArchive(archive)
+        if err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to read %s: %v", archive, err))
+        }
+        if recompress && info.WrongMethod {
+            m.rewrite.Recompress = true
+            m.changes = append(m.changes, "recompress")
+        }
+        if comicInfo && !info.HasComicInfo {
+            if m.rewrite.ComicInfo, err = processor.GenerateComicInfo(migrationItem(m), metaOpts); err != nil {
+                logger.Fatal(fmt.Sprintf("Failed to generate %s for %s: %v", comicinfo.FileName, archive, err))
+            }
+            m.changes = append(m.changes, "add "+comicinfo.FileName)
+        }
+        if tmpl != nil {
+            f, err := archiveFields(archive, outputDir, m.source, titles, series)
+            if err == nil {
+                m.to, err = tmpl.Path(outputDir, f)
+            }
+            if err != nil {
+                logger.Fatal(fmt.Sprintf("Can't work out the new name of %s: %v", archive, err))
+            }
+        }
+        if other, ok := taken[pathnorm.Key(m.to)]; ok {
+            logger.Fatal(fmt.Sprintf("%s and %s would both be named %s", other, archive, m.to))
+        }
+        taken[pathnorm.Key(m.to)] = archive
+        if m.to != m.from {
+            if _, err := os.Stat(m.to); err == nil && !pathnorm.Equal(m.from, m.to) {
+                logger.Fatal(fmt.Sprintf("%s would be moved over %s", archive, m.to))
+            }
+            m.changes = append(m.changes, "rename")
+        }
+
+        if len(m.changes) > 0 {
+            migrations = append(migrations, m)
+        }
+    }
+
+    if len(migrations) == 0 {
+        logger.Okay(fmt.Sprintf("All %d archives are up to date", len(archives)))
+        return
+    }
+
+    if dryRun {
+        fmt.Println()
+        for _, m := range migrations {
+            line := fmt.Sprintf("\033[33m~\033[0m %s", relTo(outputDir, m.from))
+            if m.to != m.from {
+                line += " \033[90m→\033[0m " + relTo(outputDir, m.to)
+            }
+            fmt.Println(line + "  \033[90m(" + strings.Join(m.changes, ", ") + ")\033[0m")
+        }
+        fmt.Printf("\n%d of %d archives would be migrated\n", len(migrations), len(archives))
+        return
+    }
+
+    journal := history.NewJournal(run.ID, outputDir)
+    ctx, _ := interruptContexts()
+    logger.Info(fmt.Sprintf("Migrating %d of %d archives, journal %s", len(migrations), len(archives), journal.ID))
+
+    for _, m := range migrations {
+        err := applyMigration(journal, m)
+        if err == nil && ctx.Err() != nil {
+            err = fmt.Errorf("interrupted")
+        }
+        if err != nil {
+            logger.Error(fmt.Sprintf("Migration failed at %s: %v", relTo(outputDir, m.from), err))
+            undoJournal(journal)
+            unlock()
+            os.Exit(1)
+        }
+        logger.Okay(fmt.Sprintf("Migrated: %s (%s)", relTo(outputDir, m.to), strings.Join(m.changes, ", ")))
+    }
+
+    // Everything went through, only now is the library changed for good
+    catalogChanged := false
+    dropped := 0
+    for _, s := range journal.Steps {
+        switch s.Kind {
+        case history.StepMove:
+            if moveCatalogOutput(cat, s.From, s.To) {
+                catalogChanged = true
+            }
+            removeEmptyDirs(filepath.Dir(s.From), outputDir)
+            if s.Source != "" {
+                run.Items = append(run.Items, history.Item{Folder: filepath.Base(s.Source), Source: s.Source, Output: s.To, Status: types.StatusRenamed})
+            }
+        case history.StepRewrite:
+            if keepBackups {
+                break
+            }
+            // The journal must stop pointing rollback at it first
+            if err := journal.DropBackup(s.Backup); err != nil {
+                logger.Warning(fmt.Sprintf("Kept backup %s, failed to write journal: %v", s.Backup, err))
+                break
+            }
+            os.Remove(s.Backup)
+            dropped++
+        }
+    }
+    if catalogChanged {
+        if err := cat.Save(); err != nil {
+            logger.Error(fmt.Sprintf("Failed to write catalog: %v", err))
+        }
+    }
+
+    if err := journal.Finish(); err != nil {
+        logger.Warning(fmt.Sprintf("Failed to write journal: %v", err))
+    }
+    run.Finished = journal.Finished
+    if err := history.Save(run); err != nil {
+        logger.Warning(fmt.Sprintf("Failed to record run history: %v", err))
+    }
+
+    switch {
+    case dropped == 0:
+        logger.Info(fmt.Sprintf("Migrated %d archives, undo with: %s rollback %s", len(migrations), os.Args[0], journal.ID))
+    case dropped < len(journal.Steps):
+        logger.Info(fmt.Sprintf("Migrated %d archives, undo the renames with: %s rollback %s (rewritten archives stay as they are, -keep-backups keeps them undoable)", len(migrations), os.Args[0], journal.ID))
+    default:
+        logger.Info(fmt.Sprintf("Migrated %d archives, their backups were removed (-keep-backups keeps them undoable)", len(migrations)))
+    }
+}
+
+// applyMigration makes the changes of m, journaling each one before it is made
+func applyMigration(journal *history.Journal, m migration) error {
+    if m.needsRewrite() {
+        tmp, err := processor.RewriteArchive(m.from, m.rewrite)
+        if err != nil {
+            return err
+        }
+        backup := types.BackupPath(m.from, journal.ID)
+        if err := journal.Record(history.Step{Kind: history.StepRewrite, From: m.from, Backup: backup, Source: m.source}); err != nil {
+            os.Remove(tmp)
+            return fmt.Errorf("failed to write journal: %w", err)
+        }
+        if err := os.Rename(m.from, backup); err != nil {
+            os.Remove(tmp)
+            return err
+        }
+        if err := os.Rename(tmp, m.from); err != nil {
+            os.Remove(tmp)
+            return err
+        }
+    }
+
+    if m.to != m.from {
+        if err := journal.Record(history.Step{Kind: history.StepMove, From: m.from, To: m.to, Source: m.source}); err != nil {
+            return fmt.Errorf("failed to write journal: %w", err)
+        }
+        if err := moveArchive(m.from, m.to); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// migrationItem is the work item a generated ComicInfo.xml is built from
+func migrationItem(m migration) types.WorkItem {
+    source := m.source
+    if source == "" {
+        name := strings.TrimSuffix(filepath.Base(m.from), filepath.Ext(m.from))
+        source = filepath.Join(filepath.Dir(m.from), name)
+    }
+    return types.WorkItem{FolderName: filepath.Base(source), SourcePath: source, OutputPath: m.from}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+    "convert_cbz/internal/notify"
+    "convert_cbz/internal/types"
+    "fmt"
+    "time"
+
+    "github.com/jelius-sama/logger"
+)
+
+// notifiers returns the senders -webhook and the chat bots ask for, checked
+// before anything is converted so a typo doesn't only show once the run is
+// over
+func notifiers(webhooks []string, discordToken, discordChannel, telegramToken, telegramChat string) []notify.Sender {
+    var senders []notify.Sender
+    for _, raw := range webhooks {
+        w, err := notify.NewWebhook(raw)
+        if err != nil {
+            logger.Fatal(fmt.Sprintf("Bad -webhook: %v", err))
+        }
+        senders = append(senders, w)
+    }
+    if (discordToken == "") != (discordChannel == "") {
+        logger.Fatal("-discord-token and -discord-channel go together")
+    }
+    if discordToken != "" {
+        senders = append(senders, notify.NewDiscord(discordToken, discordChannel))
+    }
+    if (telegramToken == "") != (telegramChat == "") {
+        logger.Fatal("-telegram-token and -telegram-chat go together")
+    }
+    if telegramToken != "" {
+        senders = append(senders, notify.NewTelegram(telegramToken, telegramChat))
+    }
+    return senders
+}
+
+// runSummary is the summary event of a run that started at start
+func runSummary(start time.Time, outputDir string, stats *types.ConversionStats, interrupted bool) notify.Summary {
+    stats.Mutex.Lock()
+    deferred := len(stats.Deferred)
+    stats.Mutex.Unlock()
+    return notify.Summary{
+        Started:     start,
+        Finished:    time.Now(),
+        Duration:    time.Since(start).Seconds(),
+        OutputDir:   outputDir,
+        Stats:       stats.Snapshot(),
+        Deferred:    deferred,
+        Interrupted: interrupted,
+    }
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+
+    "github.com/jelius-sama/logger"
+)
+
+// profile is a named bundle of options, option name to the values it is set
+// to, more than one for options that can be given more than once
+type profile map[string][]string
+
+// builtinProfiles are the profiles there are without a profiles file. One of
+// the same name in the file replaces them.
+var builtinProfiles = map[string]profile{
+    // Kept for good: smallest archives, read back before they replace
+    // anything, with the metadata to find them again
+    "archive": {
+        "compression": {"slow"},
+        "verify":      {"true"},
+        "comicinfo":   {"true"},
+        "sidecar":     {"true"},
+    },
+    // Copied to e-readers and phones: stored pages open fastest on slow
+    // hardware, and names survive FAT and exFAT cards
+    "ereader": {
+        "compression":    {"none"},
+        "sanitize":       {"true"},
+        "normalize":      {"nfc"},
+        "cp437-fallback": {"true"},
+    },
+    // Converted to be read now
+    "fast": {
+        "compression": {"none"},
+    },
+}
+
+// profilesPath is the file user profiles are read from
+func profilesPath() (string, error) {
+    dir, err := os.UserConfigDir()
+    if err != nil {
+        return "", err
+    }
+    return filepath.Join(dir, "convert-cbz", "profiles.json"), nil
+}
+
+// loadProfiles reads the profiles file, a JSON object of profile names to
+// objects of option names and values:
+//
+//  {"kindle": {"compression": "none", "sanitize": true, "exclude-dir": ["extras", "raw"]}}
+//
+// A missing file means no user profiles.
+func loadProfiles() (map[string]profile, string, error) {
+    path, err := profilesPath()
+    if err != nil {
+        return nil, "", err
+    }
+    data, err := os.ReadFile(path)
+    if errors.Is(err, fs.ErrNotExist) {
+        return nil, path, nil
+    }
+    if err != nil {
+        return nil, path, err
+    }
+
+    var raw map[string]map[string]any
+    if err := json.Unmarshal(data, &raw); err != nil {
+        return nil, path, err
+    }
+    profiles := make(map[string]profile, len(raw))
+    for name, options := range raw {
+        p := make(profile, len(options))
+        for option, value := range options {
+            values, err := optionValues(value)
+            if err != nil {
+                return nil, path, fmt.Errorf("profile %s, %s: %w", name, option, err)
+            }
+            p[strings.TrimPrefix(option, "-")] = values
+        }
+        profiles[name] = p
+    }
+    return profiles, path, nil
+}
+
+// optionValues turns a JSON value into what is passed to the option
+func optionValues(value any) ([]string, error) {
+    switch v := value.(type) {
+    case string:
+        return []string{v}, nil
+    case bool:
+        return []string{strconv.FormatBool(v)}, nil
+    case float64:
+        return []string{strconv.FormatFloat(v, 'f', -1, 64)}, nil
+    case []any:
+        var values []string
+        for _, item := range v {
+            if _, ok := item.([]any); ok {
+                return nil, errors.New("lists can't be nested")
+            }
+            more, err := optionValues(item)
+            if err != nil {
+                return nil, err
+            }
+            values = append(values, more...)
+        }
+        return values, nil
+    default:
+        return nil, fmt.Errorf("can't use %v as a value", value)
+    }
+}
+
+// applyProfile sets the options of fs that weren't given on the command line
+// or in the environment from the profile called name. Options a subcommand
+// doesn't take are left out, so one profile serves conversions and sync; the
+// main command takes them all and reports the ones it doesn't know.
+func applyProfile(fs *flag.FlagSet, name string) {
+    profiles, path, err := loadProfiles()
+    if err != nil {
+        logger.Fatal(fmt.Sprintf("Failed to read profiles from %s: %v", path, err))
+    }
+    p, ok := profiles[name]
+    if !ok {
+        p, ok = builtinProfiles[name]
+    }
+    if !ok {
+        var names []string
+        for n := range builtinProfiles {
+            names = append(names, n)
+        }
+        for n := range profiles {
+            if _, dup := builtinProfiles[n]; !dup {
+                names = append(names, n)
+            }
+        }
+        sort.Strings(names)
+        logger.Fatal(fmt.Sprintf("Unknown profile %q, there are: %s", name, strings.Join(names, ", ")))
+    }
+
+    given := make(map[flag.Value]bool)
+    fs.Visit(func(f *flag.Flag) { given[f.Value] = true })
+
+    options := make([]string, 0, len(p))
+    for option := range p {
+        options = append(options, option)
+    }
+    sort.Strings(options)
+    for _, option := range options {
+        f := fs.Lookup(option)
+        if f == nil {
+            if fs == flag.CommandLine {
+                logger.Warning(fmt.Sprintf("Profile %s sets -%s, which doesn't exist, ignoring it", name, option))
+            }
+            continue
+        }
+        if option == "profile" {
+            logger.Fatal(fmt.Sprintf("Profile %s can't set -profile", name))
+        }
+        if given[f.Value] {
+            continue
+        }
+        for _, v := range p[option] {
+            if err := fs.Set(option, v); err != nil {
+                logger.Fatal(fmt.Sprintf("Profile %s: bad -%s: %v", name, option, err))
+            }
+        }
+        given[f.Value] = true
+    }
+}
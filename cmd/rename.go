@@ -0,0 +1,286 @@
+package main
+
+import (
+    "convert_cbz/internal/comicinfo"
+    "convert_cbz/internal/history"
+    "convert_cbz/internal/naming"
+    "convert_cbz/internal/pathnorm"
+    "convert_cbz/internal/processor"
+    "convert_cbz/internal/types"
+    "flag"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/jelius-sama/logger"
+)
+
+// renameMove is one archive rename decided on by runRename
+type renameMove struct {
+    from, to string
+    source   string // Folder the archive was built from, empty when only its metadata is known
+}
+
+// runRename moves the archives of an existing output library to the names a
+// template gives them, so changing the template doesn't orphan old files
+func runRename(args []string) {
+    start := time.Now()
+    var (
+        outputDir string
+        tmplText  string
+        layout    string
+        titlePat  string
+        special   string
+        seriesMap string
+        dryRun    bool
+        sanitize  bool
+        replace   string
+    )
+
+    fs := flag.NewFlagSet("rename", flag.ExitOnError)
+    fs.StringVar(&outputDir, "output", "", "Output library to rename")
+    fs.StringVar(&outputDir, "o", "", "Output library to rename")
+    fs.StringVar(&tmplText, "template", "", "Name template to apply")
+    fs.StringVar(&tmplText, "t", "", "Name template to apply")
+    fs.StringVar(&layout, "layout", "", "Layout to apply instead of a template [komga|kavita]")
+    fs.StringVar(&titlePat, "title-pattern", "", "Regular expression with number/title/volume/group groups for folder names")
+    fs.StringVar(&special, "special-pattern", "", "Regular expression matching the folder names of specials, for {special}")
+    fs.StringVar(&seriesMap, "series-map", "", "JSON file with series titles used for {series}")
+    fs.BoolVar(&sanitize, "sanitize", false, "Rewrite names Windows and exFAT can't store")
+    fs.StringVar(&replace, "replace-char", naming.DefaultReplacement, "What -sanitize puts in place of characters it can't keep, empty drops them")
+    fs.BoolVar(&dryRun, "dry-run", false, "Show the renames without doing them")
+    fs.BoolVar(&dryRun, "n", false, "Show the renames without doing them")
+    fs.Usage = showRenameUsage
+    fs.Parse(args)
+    applyEnv(fs)
+
+    tmplText = layoutTemplate(layout, tmplText, "-template")
+    if outputDir == "" || tmplText == "" {
+        showRenameUsage()
+        return
+    }
+
+    tmpl, err := naming.Parse(tmplText)
+    if err != nil {
+        logger.Fatal(err.Error())
+    }
+    safe := sanitizer(sanitize, replace)
+    titles, err := comicinfo.NewTitleParser(titlePat)
+    if err != nil {
+        logger.Fatal(err.Error())
+    }
+    if err := titles.SetSpecialPattern(special); err != nil {
+        logger.Fatal(err.Error())
+    }
+    var series *comicinfo.SeriesMap
+    if seriesMap != "" {
+        if series, err = comicinfo.LoadSeriesMap(seriesMap); err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to load series map: %v", err))
+        }
+    }
+
+    outputDir = absPath(outputDir)
+    pathnorm.Configure(types.CaseAuto, outputDir)
+
+    run := history.NewRun(start, outputDir, nil)
+    unlock := func() {}
+    if !dryRun {
+        release, err := history.Lock(outputDir, run.ID)
+        if err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to lock output directory: %v", err))
+        }
+        unlock = release
+    }
+    defer unlock()
+
+    sources, cat := librarySources(outputDir)
+    archives, err := findArchives(outputDir)
+    if err != nil {
+        logger.Fatal(fmt.Sprintf("Failed to scan %s: %v", outputDir, err))
+    }
+
+    var moves []renameMove
+    taken := make(map[string]string) // Target key → archive that gets it
+    for _, archive := range archives {
+        source := sources[pathnorm.Key(archive)]
+        f, err := archiveFields(archive, outputDir, source, titles, series)
+        if err != nil {
+            logger.Warning(fmt.Sprintf("Can't work out the name of %s, skipping: %v", archive, err))
+            continue
+        }
+        to, err := tmpl.Path(outputDir, f)
+        if err != nil {
+            logger.Warning(fmt.Sprintf("%v, skipping: %s", err, archive))
+            continue
+        }
+        to = safe.Under(outputDir, to)
+        if other, ok := taken[pathnorm.Key(to)]; ok {
+            logger.Warning(fmt.Sprintf("%s would get the same name as %s, skipping: %s", archive, other, to))
+            continue
+        }
+        taken[pathnorm.Key(to)] = archive
+        if to != archive {
+            moves = append(moves, renameMove{from: archive, to: to, source: source})
+        }
+    }
+
+    if len(moves) == 0 {
+        logger.Okay(fmt.Sprintf("All %d archives already follow the template", len(archives)))
+        return
+    }
+
+    if dryRun {
+        fmt.Println()
+        for _, m := range moves {
+            fmt.Printf("\033[36m>\033[0m %s \033[90m→\033[0m %s\n", relTo(outputDir, m.from), relTo(outputDir, m.to))
+        }
+        fmt.Printf("\n%d of %d archives would be renamed\n", len(moves), len(archives))
+        return
+    }
+
+    renamed, failed := 0, 0
+    catalogChanged := false
+    for _, m := range moves {
+        if err := moveArchive(m.from, m.to); err != nil {
+            logger.Error(fmt.Sprintf("Failed to move %s: %v", m.from, err))
+            failed++
+            continue
+        }
+        renamed++
+        logger.Okay(fmt.Sprintf("Renamed: %s → %s", relTo(outputDir, m.from), relTo(outputDir, m.to)))
+        removeEmptyDirs(filepath.Dir(m.from), outputDir)
+
+        if moveCatalogOutput(cat, m.from, m.to) {
+            catalogChanged = true
+        }
+        if m.source != "" {
+            run.Items = append(run.Items, history.Item{
+                Folder: filepath.Base(m.source),
+                Source: m.source,
+                Output: m.to,
+                Status: types.StatusRenamed,
+            })
+        }
+    }
+
+    if catalogChanged {
+        if err := cat.Save(); err != nil {
+            logger.Error(fmt.Sprintf("Failed to write catalog: %v", err))
+        }
+    }
+
+    // Recorded like a run, so the next rename and the history know the new names
+    run.Finished = time.Now()
+    sort.Slice(run.Items, func(i, j int) bool { return run.Items[i].Source < run.Items[j].Source })
+    if err := history.Save(run); err != nil {
+        logger.Warning(fmt.Sprintf("Failed to record run history: %v", err))
+    }
+
+    logger.Info(fmt.Sprintf("Renamed %d archives, %d failed, %d already named right", renamed, failed, len(archives)-len(moves)))
+    if failed > 0 {
+        unlock()
+        os.Exit(1)
+    }
+}
+
+// librarySources maps the archives of outputDir to their source folders. The
+// history and the sync catalog know where archives came from; archives they
+// don't know about fall back to their own ComicInfo.xml.
+func librarySources(outputDir string) (map[string]string, *history.Catalog) {
+    sources, err := history.Sources(outputDir)
+    if err != nil {
+        logger.Warning(fmt.Sprintf("Failed to read run history: %v", err))
+        sources = map[string]string{}
+    }
+    catalogPath, err := history.CatalogPath(outputDir)
+    if err != nil {
+        logger.Fatal(fmt.Sprintf("Failed to locate catalog: %v", err))
+    }
+    cat, err := history.LoadCatalog(catalogPath, outputDir)
+    if err != nil {
+        logger.Fatal(fmt.Sprintf("Failed to read catalog: %v", err))
+    }
+    for _, e := range cat.Entries {
+        sources[pathnorm.Key(e.Output)] = e.Source
+    }
+    return sources, cat
+}
+
+// moveCatalogOutput points catalog entries for the archive at from to to
+func moveCatalogOutput(cat *history.Catalog, from, to string) bool {
+    changed := false
+    for _, e := range cat.Entries {
+        if pathnorm.Equal(e.Output, from) {
+            e.Output = to
+            changed = true
+        }
+    }
+    return changed
+}
+
+// archiveFields recovers the template fields of an archive: from its source
+// folder when that is known, else from its ComicInfo.xml and its current name
+func archiveFields(archive, outputDir, source string, titles *comicinfo.TitleParser, series *comicinfo.SeriesMap) (naming.Fields, error) {
+    if source != "" {
+        return naming.FieldsFor(source, titles, series), nil
+    }
+
+    name := strings.TrimSuffix(filepath.Base(archive), filepath.Ext(archive))
+    parent := ""
+    if dir := filepath.Dir(archive); !pathnorm.Equal(dir, outputDir) {
+        parent = filepath.Base(dir)
+    }
+    f := naming.FieldsFor(filepath.Join(parent, name), titles, series)
+    if parent == "" {
+        f.Series = ""
+    }
+
+    ci, err := processor.ReadComicInfo(archive)
+    if err != nil {
+        return naming.Fields{}, err
+    }
+    if ci != nil {
+        f.Title, f.Number, f.Volume, f.Group = ci.Title, ci.Number, ci.Volume, ci.ScanInformation
+        if ci.Series != "" {
+            f.Series = ci.Series
+        }
+    }
+    return f, nil
+}
+
+// findArchives lists the .cbz files under dir, leaving out temp files of
+// archives being written
+func findArchives(dir string) ([]string, error) {
+    var archives []string
+    err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        if !d.IsDir() && strings.EqualFold(filepath.Ext(d.Name()), ".cbz") && !strings.HasPrefix(d.Name(), ".") {
+            archives = append(archives, path)
+        }
+        return nil
+    })
+    sort.Strings(archives)
+    return archives, err
+}
+
+// removeEmptyDirs removes dir and its parents up to root while they are empty
+func removeEmptyDirs(dir, root string) {
+    for !pathnorm.Equal(dir, root) && strings.HasPrefix(dir, root) {
+        if info, err := os.Lstat(dir); err != nil || !info.IsDir() || os.Remove(dir) != nil {
+            return
+        }
+        dir = filepath.Dir(dir)
+    }
+}
+
+func relTo(root, path string) string {
+    if rel, err := filepath.Rel(root, path); err == nil {
+        return rel
+    }
+    return path
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+    "convert_cbz/internal/collector"
+    "convert_cbz/internal/comicinfo"
+    "convert_cbz/internal/history"
+    "convert_cbz/internal/pathnorm"
+    "convert_cbz/internal/processor"
+    "convert_cbz/internal/report"
+    "convert_cbz/internal/types"
+    "convert_cbz/internal/util"
+    "flag"
+    "fmt"
+    "os"
+    "path/filepath"
+    "runtime"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/jelius-sama/logger"
+)
+
+// runRepack converts existing archives again: each one is unpacked into a
+// scratch folder and goes through the same filtering, ordering, renaming and
+// metadata as a source folder would. CBR and PDF files come out as CBZs.
+func runRepack(args []string) {
+    start := time.Now()
+    var (
+        outputDir   string
+        tempDir     string
+        passwords   string
+        titlePat    string
+        seriesMap   string
+        cover       string
+        reportPath  string
+        excludeDirs types.StringSliceFlag
+        includeExts types.StringSliceFlag
+        excludeExts types.StringSliceFlag
+        threads     int
+        maxNameLen  int
+        maxDepth    int
+        inPlace     bool
+        dumbMode    bool
+        imagesOnly  bool
+        comicInfo   bool
+        cbi         bool
+        renamePages bool
+        sanitizeEnt bool
+        keepReplace bool
+        verify      bool
+        dryRun      bool
+        compression types.CompressionMode = types.CMNone
+        overwrite   types.OverwriteMode   = types.OverwriteSkip
+        sortMode    types.SortMode        = types.SortNatural
+        textFiles   types.TextMode        = types.TextKeep
+        progress    types.ProgressMode    = types.ProgressAuto
+    )
+
+    fs := flag.NewFlagSet("repack", flag.ExitOnError)
+    fs.StringVar(&outputDir, "output", "", "Directory the repacked archives are written to")
+    fs.StringVar(&outputDir, "o", "", "Directory the repacked archives are written to")
+    fs.BoolVar(&inPlace, "in-place", false, "Replace every archive with its repacked version")
+    fs.StringVar(&tempDir, "temp-dir", "", "Where archives are unpacked while they are repacked (default: the system temp directory)")
+    fs.StringVar(&passwords, "passwords", "", "File with one password per line, tried in turn on encrypted archives")
+    fs.IntVar(&threads, "threads", runtime.NumCPU(), "Number of concurrent threads")
+    fs.IntVar(&threads, "j", runtime.NumCPU(), "Number of concurrent threads")
+    fs.BoolVar(&dumbMode, "dumb", false, "Keep every entry instead of filtering like smart mode")
+    fs.BoolVar(&dumbMode, "d", false, "Keep every entry instead of filtering like smart mode")
+    fs.BoolVar(&imagesOnly, "images-only", false, "Keep nothing but entries whose content is an image, reporting the rest")
+    fs.Var(&includeExts, "include-ext", "Extensions smart mode always keeps, e.g. xml, or images, videos or text (comma separated, can be specified multiple times)")
+    fs.Var(&excludeExts, "exclude-ext", "Extensions dropped from the archives, e.g. mp4,mkv or videos (comma separated, can be specified multiple times)")
+    fs.Var(&excludeDirs, "exclude-dir", "Pattern for folders inside the archives that are dropped, e.g. raw/** (can be specified multiple times)")
+    fs.Var(&compression, "compression", "Compression mode to use")
+    fs.Var(&compression, "c", "Compression mode to use")
+    fs.Var(&overwrite, "overwrite", "What to do with archives already in the output [skip|always|if-different]")
+    fs.BoolVar(&keepReplace, "keep-replaced", false, "Keep replaced archives next to them, so rollback can restore them")
+    fs.BoolVar(&verify, "verify", false, "Read every archive back before it is moved into place")
+    fs.Var(&sortMode, "sort", "Order of the pages in each archive [natural|lexical]")
+    fs.StringVar(&cover, "cover", "", "Glob for the file placed first as the cover, \"none\" keeps name order")
+    fs.BoolVar(&renamePages, "rename-pages", false, "Store pages as 0001.jpg, 0002.jpg, ... in reading order, with a manifest of the original names")
+    fs.BoolVar(&sanitizeEnt, "sanitize-entries", false, "Rewrite entry names Windows can't extract")
+    fs.Var(&textFiles, "text-files", "What becomes of .txt, .nfo and other text files [keep|merge|notes]")
+    fs.IntVar(&maxNameLen, "max-entry-length", 0, "Shorten entry names longer than this many bytes (0 disables)")
+    fs.IntVar(&maxDepth, "max-entry-depth", 0, "Fold folders nested deeper than this into one (0 disables)")
+    fs.BoolVar(&comicInfo, "comicinfo", false, "Add a ComicInfo.xml parsed from the archive name to archives without one")
+    fs.BoolVar(&cbi, "comicbookinfo", false, "Also write the ComicInfo.xml details as ComicBookInfo JSON into the archive comment")
+    fs.StringVar(&titlePat, "title-pattern", "", "Regular expression with number/title/volume/group groups for -comicinfo")
+    fs.StringVar(&seriesMap, "series-map", "", "JSON file with series titles for -comicinfo (implies -comicinfo)")
+    fs.StringVar(&reportPath, "report", "", "Write a per-archive report to this .csv or .json file")
+    fs.Var(&progress, "progress", "Progress display [auto|bar|plain], auto shows the bar only on a terminal")
+    fs.BoolVar(&dryRun, "dry-run", false, "List the archives that would be repacked, without repacking")
+    fs.BoolVar(&dryRun, "n", false, "List the archives that would be repacked, without repacking")
+    fs.Usage = showRepackUsage
+    fs.Parse(args)
+    applyEnv(fs)
+
+    if fs.NArg() == 0 || (outputDir == "") == !inPlace {
+        if inPlace && outputDir != "" {
+            logger.Error("-in-place and -output can't be combined")
+        }
+        showRepackUsage()
+        os.Exit(2)
+    }
+    if threads < 1 {
+        threads = runtime.NumCPU()
+    }
+    threads = limitThreads(threads, compression)
+    os.Setenv(types.CKey.String(), compression.String())
+
+    for _, pattern := range excludeDirs {
+        if err := util.ValidPattern(pattern); err != nil {
+            logger.Fatal(fmt.Sprintf("Bad -exclude-dir pattern %q: %v", pattern, err))
+        }
+    }
+    include, exclude := extensions(includeExts, excludeExts)
+    if imagesOnly && dumbMode {
+        logger.Fatal("-images-only and -dumb can't be combined")
+    }
+    if maxNameLen < 0 || maxDepth < 0 {
+        logger.Fatal("-max-entry-length and -max-entry-depth can't be negative")
+    }
+    titles, err := comicinfo.NewTitleParser(titlePat)
+    if err != nil {
+        logger.Fatal(err.Error())
+    }
+    var series *comicinfo.SeriesMap
+    if seriesMap != "" {
+        if series, err = comicinfo.LoadSeriesMap(seriesMap); err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to load series map: %v", err))
+        }
+        comicInfo = true
+    }
+    var list []string
+    if passwords != "" {
+        if list, err = processor.LoadPasswords(passwords); err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to load password list: %v", err))
+        }
+    }
+
+    // Replacing the only copy of an archive, the new one has to read back
+    if inPlace {
+        outputDir = collector.CommonParent(fs.Args())
+        overwrite = types.OverwriteAlways
+        verify = true
+    }
+    outputDir = absPath(outputDir)
+    pathnorm.Configure(types.CaseAuto, outputDir)
+
+    workItems := repackItems(fs.Args(), outputDir, inPlace, dumbMode)
+    if len(workItems) == 0 {
+        logger.Warning("No archives found to repack")
+        return
+    }
+
+    if dryRun {
+        fmt.Println()
+        for _, item := range workItems {
+            if item.OutputPath == item.Archive {
+                fmt.Printf("\033[33m~\033[0m %s\n", item.Archive)
+            } else {
+                fmt.Printf("\033[32m+\033[0m %s \033[90m→\033[0m %s\n", item.Archive, item.OutputPath)
+            }
+        }
+        fmt.Printf("\n%d archives would be repacked\n", len(workItems))
+        return
+    }
+
+    logger.Info(fmt.Sprintf("Repacking %d archives with %d threads", len(workItems), threads))
+    if inPlace {
+        logger.Info("In place: every archive is replaced once its repacked version is verified")
+    } else {
+        logger.Info(fmt.Sprintf("Output: %s", outputDir))
+    }
+
+    if err := os.MkdirAll(outputDir, 0755); err != nil {
+        logger.Fatal(fmt.Sprintf("Failed to create output directory: %v", err))
+    }
+    run := history.NewRun(start, outputDir, fs.Args())
+    unlock, err := history.Lock(outputDir, run.ID)
+    if err != nil {
+        logger.Fatal(fmt.Sprintf("Failed to lock output directory: %v", err))
+    }
+    defer unlock()
+
+    scratch, err := os.MkdirTemp(tempDir, "convert-cbz-repack-")
+    if err != nil {
+        unlock()
+        logger.Fatal(fmt.Sprintf("Failed to create scratch directory: %v", err))
+    }
+    defer os.RemoveAll(scratch)
+    for i := range workItems {
+        workItems[i].SourcePath = filepath.Join(scratch, workItems[i].SourcePath)
+    }
+
+    ctx, abort := interruptContexts()
+    journal := history.NewJournal(run.ID, outputDir)
+
+    stats := &types.ConversionStats{Total: len(workItems)}
+    processor.ProcessConcurrently(ctx, workItems, &types.Options{
+        Threads:        threads,
+        MaxEntries:     20000,
+        ExcludeDirs:    excludeDirs,
+        IncludeExt:     include,
+        ExcludeExt:     exclude,
+        ImagesOnly:     imagesOnly,
+        Overwrite:      overwrite,
+        Cover:          cover,
+        Sort:           sortMode,
+        RenamePages:    renamePages,
+        TextFiles:      textFiles,
+        EntrySanitizer: sanitizer(sanitizeEnt, "_"),
+        MaxEntryLength: maxNameLen,
+        MaxEntryDepth:  maxDepth,
+        Fingerprint:    types.FingerprintMeta,
+        Prefetch:       2,
+        Progress:       progress,
+        Abort:          abort,
+        RunID:          run.ID,
+        Status:         statusRequests(),
+        ComicInfo:      comicInfo,
+        ComicBookInfo:  cbi,
+        Titles:         titles,
+        Series:         series,
+        Journal:        journal.Record,
+        KeepReplaced:   keepReplace,
+        Passwords:      list,
+        Verify:         verify,
+    }, stats)
+    if err := journal.Finish(); err != nil {
+        logger.Warning(fmt.Sprintf("Failed to write journal: %v", err))
+    }
+    util.PrintFinalStats(stats, time.Since(start))
+    if len(journal.Steps) > 0 {
+        logger.Info(fmt.Sprintf("Undo this run with: %s rollback %s", os.Args[0], run.ID))
+    }
+
+    if reportPath != "" {
+        if err := report.Write(reportPath, reportRows(stats)); err != nil {
+            logger.Error(fmt.Sprintf("Failed to write report: %v", err))
+        } else {
+            logger.Info(fmt.Sprintf("Report written to %s", reportPath))
+        }
+    }
+
+    run.Finished = time.Now()
+    run.Record(stats.Results)
+    if err := history.Save(run); err != nil {
+        logger.Warning(fmt.Sprintf("Failed to record run history: %v", err))
+    }
+
+    if ctx.Err() != nil {
+        unlock()
+        os.RemoveAll(scratch)
+        os.Exit(130)
+    }
+}
+
+// repackItems finds the archives of inputs and plans where each is written,
+// and unpacked to relative to the scratch directory. Archives found in a
+// folder keep their path below it in the output, ones given directly go
+// straight into it.
+func repackItems(inputs []string, outputDir string, inPlace, dumbMode bool) []types.WorkItem {
+    var workItems []types.WorkItem
+    taken := make(map[string]string)
+    for _, in := range inputs {
+        info, err := os.Stat(in)
+        if err != nil {
+            logger.Error(err.Error())
+            continue
+        }
+        // Archives are made absolute, the root they are relative to too
+        archives := []string{in}
+        root := filepath.Dir(absPath(in))
+        if info.IsDir() {
+            if archives, err = findExtractable(in); err != nil {
+                logger.Error(fmt.Sprintf("Failed to search %s: %v", in, err))
+                continue
+            }
+            root = absPath(in)
+        }
+
+        for _, archive := range archives {
+            archive = absPath(archive)
+            out := archive
+            if !inPlace {
+                out = filepath.Join(outputDir, relTo(root, archive))
+            }
+            // A .cbr or .pdf becomes a .cbz, next to it in place
+            if ext := filepath.Ext(out); !strings.EqualFold(ext, ".cbz") {
+                out = strings.TrimSuffix(out, ext) + ".cbz"
+            }
+            if other, ok := taken[pathnorm.Key(out)]; ok {
+                logger.Warning(fmt.Sprintf("%s would be written over the archive of %s, skipping it", archive, other))
+                continue
+            }
+            taken[pathnorm.Key(out)] = archive
+
+            // The scratch folder has the names a source folder would, the
+            // series and title are parsed from them
+            name := strings.TrimSuffix(filepath.Base(archive), filepath.Ext(archive))
+            source := filepath.Join(strconv.Itoa(len(workItems)), filepath.Base(filepath.Dir(archive)), name)
+            workItems = append(workItems, types.WorkItem{
+                FolderName: name,
+                SourcePath: source,
+                OutputPath: out,
+                DumbMode:   dumbMode,
+                Archive:    archive,
+            })
+        }
+    }
+    return workItems
+}
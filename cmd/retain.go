@@ -0,0 +1,234 @@
+package main
+
+import (
+    "convert_cbz/internal/comicinfo"
+    "convert_cbz/internal/history"
+    "convert_cbz/internal/pathnorm"
+    "convert_cbz/internal/processor"
+    "convert_cbz/internal/trash"
+    "convert_cbz/internal/types"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+
+    "github.com/jelius-sama/logger"
+)
+
+// retention is what -keep-latest and -cold-storage ask for
+type retention struct {
+    keep   int    // Latest chapters kept of every series, zero keeps them all
+    cold   string // Where older chapters go, the trash when empty
+    titles *comicinfo.TitleParser
+    series *comicinfo.SeriesMap // Its "keep" replaces keep for a series
+}
+
+// enabled reports whether any series has chapters to retire
+func (r retention) enabled() bool {
+    if r.keep > 0 {
+        return true
+    }
+    if r.series != nil {
+        for _, info := range r.series.Series {
+            if info.Keep > 0 {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// keepFor is the number of chapters of series kept, zero for all of them
+func (r retention) keepFor(series string) int {
+    if info, ok := r.series.Lookup(series); ok && info.Keep != 0 {
+        return max(info.Keep, 0)
+    }
+    return r.keep
+}
+
+// chapterArchive is an archive of outputDir with the chapter it holds
+type chapterArchive struct {
+    path   string
+    source string
+    volume float64
+    number float64
+}
+
+// applyRetention moves all but the latest chapters of every series in
+// outputDir out of it, journaled so rolling back the run brings them back.
+// Series and chapters are recovered like rename does, archives without a
+// series or chapter number are left alone. results are those of the run,
+// which isn't in the history yet. It returns the history items of the
+// archives moved.
+func applyRetention(outputDir string, r retention, journal *history.Journal, results []types.ItemResult) []history.Item {
+    sources, cat := librarySources(outputDir)
+    for _, res := range results {
+        if res.Status == types.StatusConverted || res.Status == types.StatusSkipped {
+            sources[pathnorm.Key(res.OutputPath)] = res.SourcePath
+        }
+    }
+    archives, err := findArchives(outputDir)
+    if err != nil {
+        logger.Error(fmt.Sprintf("Retention: failed to scan %s: %v", outputDir, err))
+        return nil
+    }
+    if r.cold != "" && strings.HasPrefix(absPath(r.cold)+string(filepath.Separator), absPath(outputDir)+string(filepath.Separator)) {
+        logger.Error("Retention: -cold-storage can't be inside the output directory")
+        return nil
+    }
+
+    // Series in the order their first archive was found
+    var order []string
+    names := make(map[string]string)
+    groups := make(map[string][]chapterArchive)
+    for _, archive := range archives {
+        source := sources[pathnorm.Key(archive)]
+        f, err := archiveFields(archive, outputDir, source, r.titles, r.series)
+        if err != nil {
+            logger.Warning(fmt.Sprintf("Retention: can't tell the chapter of %s, keeping it: %v", archive, err))
+            continue
+        }
+        number, ok := chapterNumber(f.Number)
+        if f.Series == "" || !ok {
+            continue
+        }
+        volume, _ := chapterNumber(f.Volume)
+        key := strings.ToLower(f.Series)
+        if _, ok := groups[key]; !ok {
+            order = append(order, key)
+            names[key] = f.Series
+        }
+        groups[key] = append(groups[key], chapterArchive{path: archive, source: source, volume: volume, number: number})
+    }
+
+    var items []history.Item
+    catalogChanged := false
+    for _, key := range order {
+        keep, chapters := r.keepFor(names[key]), groups[key]
+        if keep == 0 || len(chapters) <= keep {
+            continue
+        }
+        sort.SliceStable(chapters, func(i, j int) bool {
+            if chapters[i].volume != chapters[j].volume {
+                return chapters[i].volume < chapters[j].volume
+            }
+            return chapters[i].number < chapters[j].number
+        })
+
+        retired := 0
+        for _, c := range chapters[:len(chapters)-keep] {
+            dest, err := retire(c, outputDir, r.cold, journal)
+            if err != nil {
+                logger.Error(fmt.Sprintf("Retention: failed to move %s: %v", relTo(outputDir, c.path), err))
+                continue
+            }
+            retired++
+            removeEmptyDirs(filepath.Dir(c.path), outputDir)
+            if dest != "" && moveCatalogOutput(cat, c.path, dest) {
+                catalogChanged = true
+            }
+            folder := filepath.Base(c.source)
+            if c.source == "" {
+                folder = strings.TrimSuffix(filepath.Base(c.path), filepath.Ext(c.path))
+            }
+            items = append(items, history.Item{Folder: folder, Source: c.source, Output: dest, Status: types.StatusRetired})
+        }
+        if retired > 0 {
+            logger.Info(fmt.Sprintf("Retention: kept the latest %d chapters of %s, moved %d older ones out", keep, names[key], retired))
+        }
+    }
+
+    if catalogChanged {
+        if err := cat.Save(); err != nil {
+            logger.Error(fmt.Sprintf("Failed to write catalog: %v", err))
+        }
+    }
+    return items
+}
+
+// dropRetired leaves out the items a run before retired, by the history or
+// because their archive is in cold storage, so they aren't converted again
+// only to be retired once more
+func dropRetired(workItems []types.WorkItem, outputDir, cold string) []types.WorkItem {
+    retired, err := history.Retired(outputDir)
+    if err != nil {
+        logger.Warning(fmt.Sprintf("Failed to read run history: %v", err))
+    }
+    kept := workItems[:0]
+    for _, item := range workItems {
+        if retired[pathnorm.Key(item.SourcePath)] {
+            continue
+        }
+        if cold != "" {
+            if _, err := os.Stat(filepath.Join(cold, relTo(outputDir, item.OutputPath))); err == nil {
+                continue
+            }
+        }
+        kept = append(kept, item)
+    }
+    if skipped := len(workItems) - len(kept); skipped > 0 {
+        logger.Info(fmt.Sprintf("Skipped %d folders retired by an earlier run", skipped))
+    }
+    return kept
+}
+
+// retire moves the archive of c to the same place below cold it had below
+// outputDir, or to the trash without cold, and returns where it went
+func retire(c chapterArchive, outputDir, cold string, journal *history.Journal) (string, error) {
+    if cold == "" {
+        dest, err := trash.Move(c.path)
+        if err != nil {
+            return "", err
+        }
+        for _, companion := range types.Companions(c.path) {
+            if _, err := os.Stat(companion); err != nil {
+                continue
+            }
+            if _, err := trash.Move(companion); err != nil {
+                logger.Warning(fmt.Sprintf("Failed to move %s to the trash: %v", companion, err))
+            }
+        }
+        // The Recycle Bin doesn't say where, there is nothing to roll back to
+        if dest != "" {
+            if err := journal.Record(history.Step{Kind: history.StepMove, From: c.path, To: dest, Source: c.source}); err != nil {
+                return dest, fmt.Errorf("moved, but failed to write journal: %w", err)
+            }
+        }
+        return dest, nil
+    }
+
+    dest := filepath.Join(cold, relTo(outputDir, c.path))
+    if _, err := os.Stat(dest); err == nil {
+        return "", fmt.Errorf("%s already exists", dest)
+    }
+    if err := journal.Record(history.Step{Kind: history.StepMove, From: c.path, To: dest, Source: c.source}); err != nil {
+        return "", fmt.Errorf("failed to write journal: %w", err)
+    }
+    if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+        return "", err
+    }
+    if err := processor.MoveFile(c.path, dest); err != nil {
+        return "", err
+    }
+    moved := types.Companions(dest)
+    for i, companion := range types.Companions(c.path) {
+        if _, err := os.Stat(companion); err != nil {
+            continue
+        }
+        if err := processor.MoveFile(companion, moved[i]); err != nil {
+            logger.Warning(fmt.Sprintf("Failed to move %s: %v", companion, err))
+        }
+    }
+    return dest, nil
+}
+
+// chapterNumber reads a chapter or volume number like "012" or "10.5"
+func chapterNumber(s string) (float64, bool) {
+    if s == "" {
+        return 0, false
+    }
+    n, err := strconv.ParseFloat(s, 64)
+    return n, err == nil
+}
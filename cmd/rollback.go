@@ -0,0 +1,164 @@
+package main
+
+import (
+    "convert_cbz/internal/history"
+    "convert_cbz/internal/pathnorm"
+    "convert_cbz/internal/types"
+    "flag"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "github.com/jelius-sama/logger"
+)
+
+// runRollback undoes what a run, sync, server job or migration changed in its
+// output directory, as far as its journal allows
+func runRollback(args []string) {
+    var dryRun bool
+
+    fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+    fs.BoolVar(&dryRun, "dry-run", false, "Show what would be undone without undoing it")
+    fs.BoolVar(&dryRun, "n", false, "Show what would be undone without undoing it")
+    fs.Usage = showRollbackUsage
+    fs.Parse(args)
+    applyEnv(fs)
+
+    if fs.NArg() != 1 {
+        showRollbackUsage()
+        os.Exit(2)
+    }
+    rollbackRun(fs.Arg(0), dryRun)
+}
+
+// rollbackRun undoes the journal of run id and brings the catalog and the
+// history in line with the restored names
+func rollbackRun(id string, dryRun bool) {
+    journal, err := history.LoadJournal(id)
+    if err != nil {
+        logger.Fatal(err.Error())
+    }
+    if !journal.RolledBack.IsZero() {
+        logger.Fatal(fmt.Sprintf("Run %s was already rolled back on %s", id, journal.RolledBack.Format("2006-01-02 15:04")))
+    }
+
+    // Archives a later run changed again would lose that run's changes
+    pathnorm.Configure(types.CaseAuto, journal.OutputDir)
+    conflicts, err := journal.Conflicts()
+    if err != nil {
+        logger.Warning(fmt.Sprintf("Failed to check later runs: %v", err))
+    }
+    if len(conflicts) > 0 {
+        logger.Fatal(fmt.Sprintf("Later runs changed the same archives, roll these back first: %s", strings.Join(conflicts, ", ")))
+    }
+
+    if dryRun {
+        printUndo(journal)
+        return
+    }
+
+    unlock, err := history.Lock(journal.OutputDir, "rollback-"+id)
+    if err != nil {
+        logger.Fatal(fmt.Sprintf("Failed to lock output directory: %v", err))
+    }
+    defer unlock()
+
+    ok := undoJournal(journal)
+
+    // Names go back too, so history and catalog have to follow
+    _, cat := librarySources(journal.OutputDir)
+    run := history.NewRun(time.Now(), journal.OutputDir, nil)
+    catalogChanged := false
+    for _, s := range journal.Steps {
+        switch s.Kind {
+        case history.StepMove:
+            if moveCatalogOutput(cat, s.To, s.From) {
+                catalogChanged = true
+            }
+            if s.Source != "" {
+                run.Items = append(run.Items, history.Item{Folder: filepath.Base(s.Source), Source: s.Source, Output: s.From, Status: types.StatusRenamed})
+            }
+        case history.StepCreate, history.StepRewrite:
+            // Gone or back to what it was, either way the next sync has to convert it again
+            if e := cat.Lookup(s.Source); s.Source != "" && e != nil && pathnorm.Equal(e.Output, s.From) {
+                cat.Remove(s.Source)
+                catalogChanged = true
+            }
+        }
+    }
+    if catalogChanged {
+        if err := cat.Save(); err != nil {
+            logger.Error(fmt.Sprintf("Failed to write catalog: %v", err))
+        }
+    }
+    if len(run.Items) > 0 {
+        run.Finished = time.Now()
+        if err := history.Save(run); err != nil {
+            logger.Warning(fmt.Sprintf("Failed to record run history: %v", err))
+        }
+    }
+
+    if !ok {
+        unlock()
+        os.Exit(1)
+    }
+}
+
+// undoJournal reverts every step of journal and reports whether all of them could be
+func undoJournal(journal *history.Journal) bool {
+    logger.Warning(fmt.Sprintf("Rolling back %d changes", len(journal.Steps)))
+    errs := journal.Undo()
+    for _, err := range errs {
+        logger.Error(err.Error())
+    }
+    for _, s := range journal.Steps {
+        switch s.Kind {
+        case history.StepMove:
+            removeEmptyDirs(filepath.Dir(s.To), journal.OutputDir)
+        case history.StepCreate:
+            removeEmptyDirs(filepath.Dir(s.From), journal.OutputDir)
+        }
+    }
+
+    if len(errs) > 0 {
+        logger.Error(fmt.Sprintf("Rollback incomplete, %d of %d changes could not be undone", len(errs), len(journal.Steps)))
+        return false
+    }
+    logger.Okay(fmt.Sprintf("Rolled back %d changes in %s", len(journal.Steps), journal.OutputDir))
+    return true
+}
+
+// printUndo lists what undoing journal would do, newest change first
+func printUndo(journal *history.Journal) {
+    fmt.Printf("\033[90mRun %s into %s, started %s\033[0m\n\n", journal.ID, journal.OutputDir, journal.Started.Format("2006-01-02 15:04"))
+    lost := 0
+    kept := journal.Kept()
+    for i := len(journal.Steps) - 1; i >= 0; i-- {
+        s := journal.Steps[i]
+        rel := relTo(journal.OutputDir, s.From)
+        switch {
+        case s.Kind == history.StepDeleteSource:
+            lost++
+            fmt.Printf("\033[90m! %s  (source deleted, not kept)\033[0m\n", s.From)
+        case s.Kind == history.StepTrashSource && s.To == "":
+            lost++
+            fmt.Printf("\033[90m! %s  (source in the Recycle Bin, restore it from there)\033[0m\n", s.From)
+        case s.Kind == history.StepTrashSource:
+            fmt.Printf("\033[36m<\033[0m %s \033[90m← %s (source back from the trash)\033[0m\n", s.From, s.To)
+        case (s.Kind == history.StepCreate || s.Kind == history.StepRewrite) && kept[pathnorm.Key(s.From)]:
+            fmt.Printf("\033[90m= %s  (%s, kept, its source was deleted)\033[0m\n", rel, s.Kind)
+        case s.Kind == history.StepCreate:
+            fmt.Printf("\033[31m-\033[0m %s  \033[90m(created, will be removed)\033[0m\n", rel)
+        case s.Kind == history.StepMove:
+            fmt.Printf("\033[36m<\033[0m %s \033[90m←\033[0m %s\n", rel, relTo(journal.OutputDir, s.To))
+        case s.Backup != "":
+            fmt.Printf("\033[33m~\033[0m %s  \033[90m(%s, original restored)\033[0m\n", rel, s.Kind)
+        default:
+            lost++
+            fmt.Printf("\033[90m! %s  (%s, original not kept, stays as it is)\033[0m\n", rel, s.Kind)
+        }
+    }
+    fmt.Printf("\n%d changes, %d can't be undone\n", len(journal.Steps), lost)
+}
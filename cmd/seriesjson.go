@@ -0,0 +1,135 @@
+package main
+
+import (
+    "convert_cbz/internal/comicinfo"
+    "convert_cbz/internal/history"
+    "convert_cbz/internal/pathnorm"
+    "convert_cbz/internal/processor"
+    "convert_cbz/internal/types"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "github.com/jelius-sama/logger"
+)
+
+// writeSeriesFiles writes a series.json into every folder of outputDir the
+// run put archives in, when they all belong to the same series. Series are
+// recovered like rename does, with the series a generated ComicInfo.xml
+// names first since it knows the library layout. A series.json from Mylar is
+// left alone, one written before is brought up to date.
+func writeSeriesFiles(outputDir string, titles *comicinfo.TitleParser, series *comicinfo.SeriesMap, journal *history.Journal, results []types.ItemResult) {
+    sources, _ := librarySources(outputDir)
+    var dirs []string
+    seen := make(map[string]bool)
+    for _, res := range results {
+        if res.Status != types.StatusConverted && res.Status != types.StatusSkipped {
+            continue
+        }
+        sources[pathnorm.Key(res.OutputPath)] = res.SourcePath
+        dir := filepath.Dir(res.OutputPath)
+        if !seen[pathnorm.Key(dir)] {
+            seen[pathnorm.Key(dir)] = true
+            dirs = append(dirs, dir)
+        }
+    }
+
+    written := 0
+    for _, dir := range dirs {
+        name, count, err := folderSeries(dir, outputDir, sources, titles, series)
+        if err != nil {
+            logger.Warning(fmt.Sprintf("No %s for %s: %v", comicinfo.SeriesFileName, relTo(outputDir, dir), err))
+            continue
+        }
+        info, _ := series.Lookup(name)
+        ok, err := writeSeriesFile(dir, comicinfo.NewMylarSeries(name, info, count), journal)
+        if err != nil {
+            logger.Error(fmt.Sprintf("Failed to write %s: %v", filepath.Join(relTo(outputDir, dir), comicinfo.SeriesFileName), err))
+            continue
+        }
+        if ok {
+            written++
+        }
+    }
+    if written > 0 {
+        logger.Info(fmt.Sprintf("Wrote %s for %d series", comicinfo.SeriesFileName, written))
+    }
+}
+
+// folderSeries returns the one series the archives directly in dir belong to,
+// and how many there are
+func folderSeries(dir, outputDir string, sources map[string]string, titles *comicinfo.TitleParser, series *comicinfo.SeriesMap) (string, int, error) {
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        return "", 0, err
+    }
+    var name string
+    count := 0
+    for _, e := range entries {
+        if e.IsDir() || !strings.EqualFold(filepath.Ext(e.Name()), ".cbz") || strings.HasPrefix(e.Name(), ".") {
+            continue
+        }
+        archive := filepath.Join(dir, e.Name())
+        f, err := archiveFields(archive, outputDir, sources[pathnorm.Key(archive)], titles, series)
+        if err != nil {
+            return "", 0, err
+        }
+        if ci, err := processor.ReadComicInfo(archive); err == nil && ci != nil && ci.Series != "" {
+            f.Series = ci.Series
+        }
+        switch {
+        case f.Series == "":
+            return "", 0, fmt.Errorf("can't tell the series of %s", e.Name())
+        case name == "":
+            name = f.Series
+        case !strings.EqualFold(name, f.Series):
+            return "", 0, fmt.Errorf("it holds both %s and %s", name, f.Series)
+        }
+        count++
+    }
+    if count == 0 {
+        return "", 0, errors.New("it holds no archives")
+    }
+    return name, count, nil
+}
+
+// writeSeriesFile puts s into dir, journaling a new file so rolling back the
+// run removes it again. It reports whether it did, a series.json from Mylar
+// isn't replaced.
+func writeSeriesFile(dir string, s comicinfo.MylarSeries, journal *history.Journal) (bool, error) {
+    dest := filepath.Join(dir, comicinfo.SeriesFileName)
+    if _, err := os.Stat(dest); err == nil {
+        if mylar, err := comicinfo.IsMylarSeries(dest); err != nil || mylar {
+            return false, err
+        }
+    } else if err := journal.Record(history.Step{Kind: history.StepCreate, From: dest}); err != nil {
+        return false, fmt.Errorf("failed to write journal: %w", err)
+    }
+
+    data, err := s.Marshal()
+    if err != nil {
+        return false, err
+    }
+    tmp, err := os.CreateTemp(dir, "."+comicinfo.SeriesFileName+".*.tmp")
+    if err != nil {
+        return false, err
+    }
+    _, err = tmp.Write(data)
+    if err == nil {
+        // CreateTemp makes files only the owner can read
+        err = tmp.Chmod(0644)
+    }
+    if closeErr := tmp.Close(); err == nil {
+        err = closeErr
+    }
+    if err == nil {
+        err = os.Rename(tmp.Name(), dest)
+    }
+    if err != nil {
+        os.Remove(tmp.Name())
+        return false, err
+    }
+    return true, nil
+}
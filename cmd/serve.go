@@ -0,0 +1,129 @@
+package main
+
+import (
+    "convert_cbz/internal/history"
+    "convert_cbz/internal/pathnorm"
+    "convert_cbz/internal/server"
+    "convert_cbz/internal/types"
+    "flag"
+    "fmt"
+    "net"
+    "os"
+    "runtime"
+    "time"
+
+    "github.com/jelius-sama/logger"
+)
+
+// runServe runs the conversion server with its web dashboard until it fails
+func runServe(args []string) {
+    var (
+        listen      string
+        outputDir   string
+        threads     int
+        strict      bool
+        excludeWarn float64
+        maxEntries  int
+        minImages   int
+        cover       string
+        compression types.CompressionMode = types.CMNone
+        verifyEvery time.Duration
+        verifyRate  types.ByteSize = 16 << 20
+        token       string
+        allowRoots  types.StringSliceFlag
+    )
+
+    fs := flag.NewFlagSet("serve", flag.ExitOnError)
+    fs.StringVar(&listen, "listen", "127.0.0.1:8080", "Address to serve the dashboard and API on")
+    fs.StringVar(&listen, "l", "127.0.0.1:8080", "Address to serve the dashboard and API on")
+    fs.StringVar(&token, "token", "", "Token API requests have to send as \"Authorization: Bearer <token>\"")
+    fs.Var(&allowRoots, "allow-root", "Folder jobs may take their inputs from (can be specified multiple times)")
+    fs.StringVar(&outputDir, "output", "", "Output directory")
+    fs.StringVar(&outputDir, "o", "", "Output directory")
+    fs.IntVar(&threads, "threads", runtime.NumCPU(), "Number of concurrent threads per job")
+    fs.IntVar(&threads, "j", runtime.NumCPU(), "Number of concurrent threads per job")
+    fs.Float64Var(&excludeWarn, "exclude-threshold", 50, "Flag folders where smart mode excludes more than this percentage of files (0 disables)")
+    fs.IntVar(&maxEntries, "max-entries", 20000, "Fail folders with more files than this before archiving them (0 disables)")
+    fs.IntVar(&minImages, "min-images", 0, "Skip folders with fewer images than this with a warning (0 disables)")
+    fs.BoolVar(&strict, "strict", false, "Fail flagged folders, and ones with fifos, sockets or empty files, instead of only warning")
+    fs.StringVar(&cover, "cover", "", "Glob for the file placed first as the cover, jobs can override it")
+    fs.Var(&compression, "compression", "Compression mode to use")
+    fs.Var(&compression, "c", "Compression mode to use")
+    fs.DurationVar(&verifyEvery, "verify-every", 0, "Read every archive in the output directory back this often to find damaged ones (0 disables)")
+    fs.Var(&verifyRate, "verify-rate", "Bytes a second the integrity watch reads at most")
+    fs.Usage = showServeUsage
+    fs.Parse(args)
+    applyEnv(fs)
+
+    if outputDir == "" {
+        showServeUsage()
+        return
+    }
+
+    for _, root := range allowRoots {
+        if info, err := os.Stat(root); err != nil || !info.IsDir() {
+            logger.Fatal(fmt.Sprintf("-allow-root %s is not a folder", root))
+        }
+    }
+    if token == "" && !loopback(listen) {
+        logger.Warning(fmt.Sprintf("Anyone who can reach %s can queue jobs, set -token to require one", listen))
+    }
+
+    if verifyEvery < 0 {
+        logger.Fatal("-verify-every can't be negative")
+    }
+
+    if threads < 1 {
+        threads = runtime.NumCPU()
+    }
+    threads = limitThreads(threads, compression)
+    os.Setenv(types.CKey.String(), compression.String())
+
+    if err := os.MkdirAll(outputDir, 0755); err != nil {
+        logger.Fatal(fmt.Sprintf("Failed to create output directory: %v", err))
+    }
+
+    pathnorm.Configure(types.CaseAuto, outputDir)
+
+    // The server owns the output directory for as long as it runs
+    unlock, err := history.Lock(outputDir, "serve")
+    if err != nil {
+        logger.Fatal(fmt.Sprintf("Failed to lock output directory: %v", err))
+    }
+
+    logger.Info(fmt.Sprintf("Output: %s", outputDir))
+    srv := server.New(outputDir, types.Options{
+        Threads:          threads,
+        ExcludeThreshold: excludeWarn,
+        MaxEntries:       maxEntries,
+        MinImages:        minImages,
+        Strict:           strict,
+        Cover:            cover,
+        Prefetch:         2,
+    })
+    srv.Token = token
+    srv.AllowRoots = allowRoots
+    srv.VerifyEvery = verifyEvery
+    srv.VerifyRate = int64(verifyRate)
+    if verifyEvery > 0 {
+        logger.Info(fmt.Sprintf("Integrity watch: every %s at up to %s/s", verifyEvery, verifyRate.String()))
+    }
+    err = srv.Run(listen)
+    unlock()
+    if err != nil {
+        logger.Fatal(fmt.Sprintf("Server stopped: %v", err))
+    }
+}
+
+// loopback reports whether addr only listens on this machine
+func loopback(addr string) bool {
+    host, _, err := net.SplitHostPort(addr)
+    if err != nil {
+        return false
+    }
+    if host == "localhost" {
+        return true
+    }
+    ip := net.ParseIP(host)
+    return ip != nil && ip.IsLoopback()
+}
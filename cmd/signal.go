@@ -0,0 +1,57 @@
+package main
+
+import (
+    "context"
+    "os"
+    "os/signal"
+    "syscall"
+
+    "github.com/jelius-sama/logger"
+)
+
+// interruptContexts returns stop, cancelled by the first SIGINT or SIGTERM,
+// and abort, cancelled by the second. A third signal is left to the default
+// handler and kills the process.
+func interruptContexts() (stop context.Context, abort context.Context) {
+    stop, stopCancel := context.WithCancel(context.Background())
+    abort, abortCancel := context.WithCancel(context.Background())
+
+    sigs := make(chan os.Signal, 1)
+    signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+    go func() {
+        <-sigs
+        logger.Warning("Interrupted, finishing folders in progress (press Ctrl+C again to abort them)")
+        stopCancel()
+
+        <-sigs
+        logger.Warning("Aborting folders in progress, partial archives are removed")
+        signal.Stop(sigs)
+        abortCancel()
+    }()
+
+    return stop, abort
+}
+
+// statusRequests fires every time the process gets one of statusSignals,
+// SIGUSR1 where there is one. It is nil where there are none.
+func statusRequests() <-chan struct{} {
+    if len(statusSignals) == 0 {
+        return nil
+    }
+
+    sigs := make(chan os.Signal, 1)
+    signal.Notify(sigs, statusSignals...)
+
+    requests := make(chan struct{}, 1)
+    go func() {
+        for range sigs {
+            // A report already pending covers this one too
+            select {
+            case requests <- struct{}{}:
+            default:
+            }
+        }
+    }()
+    return requests
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// statusSignals is empty, Windows and Plan 9 have no signal to spare for a
+// status report
+var statusSignals []os.Signal
@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import (
+    "os"
+    "syscall"
+)
+
+// statusSignals ask a running conversion for a status report
+var statusSignals = []os.Signal{syscall.SIGUSR1}
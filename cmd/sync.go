@@ -0,0 +1,430 @@
+package main
+
+import (
+    "convert_cbz/internal/collector"
+    "convert_cbz/internal/comicinfo"
+    "convert_cbz/internal/history"
+    "convert_cbz/internal/naming"
+    "convert_cbz/internal/notify"
+    "convert_cbz/internal/pathnorm"
+    "convert_cbz/internal/plan"
+    "convert_cbz/internal/processor"
+    "convert_cbz/internal/types"
+    "convert_cbz/internal/util"
+    "flag"
+    "fmt"
+    "os"
+    "path/filepath"
+    "runtime"
+    "slices"
+    "time"
+
+    "github.com/jelius-sama/logger"
+)
+
+func runSync(args []string) {
+    start := time.Now()
+    var (
+        outputDir   string
+        catalogPath string
+        threads     int
+        dumbMode    bool
+        recursive   bool
+        rootImages  bool
+        dryRun      bool
+        prune       bool
+        keepReplace bool
+        verify      bool
+        excludeWarn float64
+        maxEntries  int
+        minImages   int
+        scanDepth   int
+        nameTmpl    string
+        outLayout   string
+        titlePat    string
+        special     string
+        seriesMap   string
+        mirror      bool
+        sanitize    bool
+        replaceChar string
+        profileName string
+        preHook     string
+        postHook    string
+        discordTok  string
+        discordChan string
+        telegramTok string
+        telegramID  string
+        inputPaths  types.StringSliceFlag
+        recInputs   types.StringSliceFlag
+        inputFiles  types.StringSliceFlag
+        webhooks    types.StringSliceFlag
+        compression types.CompressionMode = types.CMNone
+        fpMode      types.FingerprintMode = types.FingerprintMeta
+        normalize   types.NormMode        = types.NormNone
+        layout      types.LibraryLayout   = types.LayoutFlat
+        nested      types.NestedMode      = types.NestedNone
+    )
+
+    fs := flag.NewFlagSet("sync", flag.ExitOnError)
+    fs.Var(&inputPaths, "input", "Input directory/directories (can be specified multiple times)")
+    fs.Var(&inputPaths, "i", "Input directory/directories (can be specified multiple times)")
+    fs.Var(&recInputs, "input-recursive", "Input directory whose subdirectories are synced, like -recursive for this input only (can be specified multiple times)")
+    fs.Var(&inputFiles, "input-file", "File listing inputs one per line, # starts a comment (can be specified multiple times)")
+    fs.StringVar(&outputDir, "output", "", "Output directory")
+    fs.StringVar(&outputDir, "o", "", "Output directory")
+    fs.StringVar(&catalogPath, "catalog", "", "Catalog file (default: one per output directory in the state directory)")
+    fs.IntVar(&threads, "threads", runtime.NumCPU(), "Number of concurrent threads")
+    fs.IntVar(&threads, "j", runtime.NumCPU(), "Number of concurrent threads")
+    fs.BoolVar(&dumbMode, "dumb", false, "Archive all files without filtering")
+    fs.BoolVar(&dumbMode, "d", false, "Archive all files without filtering")
+    fs.BoolVar(&recursive, "recursive", false, "Process subdirectories recursively")
+    fs.BoolVar(&recursive, "r", false, "Process subdirectories recursively")
+    fs.Var(&layout, "library-layout", "How inputs are organised [flat|series/volume/chapter]")
+    fs.Var(&nested, "nested", "How recursive inputs deeper than one level are converted [none|flatten|merge-volumes|mirror]")
+    fs.IntVar(&scanDepth, "max-depth", 0, "Levels below recursive inputs folders are looked for, deeper ones go into the archive of the folder above (default: 1, no limit with -nested)")
+    fs.BoolVar(&rootImages, "root-images", false, "With -recursive, convert inputs that hold images but no subfolders directly")
+    fs.BoolVar(&dryRun, "dry-run", false, "Show what the sync would do without doing it")
+    fs.BoolVar(&dryRun, "n", false, "Show what the sync would do without doing it")
+    fs.BoolVar(&prune, "prune", false, "Delete the archives of folders that no longer exist")
+    fs.BoolVar(&keepReplace, "keep-replaced", false, "Keep rebuilt and pruned archives, so rollback can restore them")
+    fs.BoolVar(&verify, "verify", false, "Read every archive back before it is moved into place, failing folders whose archive is corrupt")
+    fs.Float64Var(&excludeWarn, "exclude-threshold", 50, "Flag folders where smart mode excludes more than this percentage of files (0 disables)")
+    fs.IntVar(&maxEntries, "max-entries", 20000, "Fail folders with more files than this before archiving them (0 disables)")
+    fs.IntVar(&minImages, "min-images", 0, "Skip folders with fewer images than this with a warning (0 disables)")
+    fs.Var(&compression, "compression", "Compression mode to use")
+    fs.Var(&compression, "c", "Compression mode to use")
+    fs.Var(&fpMode, "fingerprint", "How changed folders are detected [meta|content]")
+    fs.StringVar(&nameTmpl, "name-template", "", "Archive name template (default: {folder})")
+    fs.StringVar(&outLayout, "layout", "", "Name archives the way a library server expects [komga|kavita]")
+    fs.StringVar(&titlePat, "title-pattern", "", "Regular expression with number/title/volume/group groups for folder names")
+    fs.StringVar(&special, "special-pattern", "", "Regular expression matching the folder names of specials, for {special}")
+    fs.StringVar(&seriesMap, "series-map", "", "JSON file with series titles used for {series}")
+    fs.BoolVar(&mirror, "mirror", false, "Recreate the folders of the inputs under the output instead of writing every archive into it")
+    fs.BoolVar(&sanitize, "sanitize", false, "Rewrite archive names Windows and exFAT can't store")
+    fs.Var(&normalize, "normalize", "Unicode form of archive and entry names [none|nfc|nfd]")
+    fs.StringVar(&replaceChar, "replace-char", naming.DefaultReplacement, "What -sanitize puts in place of characters it can't keep, empty drops them")
+    fs.StringVar(&preHook, "pre-hook", "", "Shell command run on every folder before its files are read, failing it fails the folder")
+    fs.StringVar(&postHook, "post-hook", "", "Shell command run for every folder once it converted or failed")
+    fs.Var(&webhooks, "webhook", "URL every finished folder and the sync summary are POSTed to as JSON (can be specified multiple times)")
+    fs.StringVar(&discordTok, "discord-token", "", "Token of the Discord bot that posts failed folders and the sync summary")
+    fs.StringVar(&discordChan, "discord-channel", "", "ID of the Discord channel it posts to")
+    fs.StringVar(&telegramTok, "telegram-token", "", "Token of the Telegram bot that posts failed folders and the sync summary")
+    fs.StringVar(&telegramID, "telegram-chat", "", "ID of the Telegram chat it posts to, or @name of a public channel")
+    fs.StringVar(&profileName, "profile", "", "Named bundle of options, built in or from the profiles file, options given still win")
+    fs.Usage = showSyncUsage
+    fs.Parse(args)
+    applyEnv(fs)
+    if profileName != "" {
+        applyProfile(fs, profileName)
+    }
+    inputPaths = append(inputPaths, readInputLists(inputFiles)...)
+    inputPaths, recInputs = stdinInputs(inputPaths, recInputs)
+    inputPaths, recInputs = expandGlobs(inputPaths), expandGlobs(recInputs)
+
+    if (len(inputPaths) == 0 && len(recInputs) == 0) || outputDir == "" {
+        showSyncUsage()
+        return
+    }
+
+    if threads < 1 {
+        threads = runtime.NumCPU()
+    }
+    threads = limitThreads(threads, compression)
+    os.Setenv(types.CKey.String(), compression.String())
+    senders := notifiers(webhooks, discordTok, discordChan, telegramTok, telegramID)
+
+    nameTmpl = layoutTemplate(outLayout, nameTmpl, "-name-template")
+    names, err := naming.Parse(nameTmpl)
+    if err != nil {
+        logger.Fatal(err.Error())
+    }
+    // The same as the conversion named them with, or every archive looks renamed
+    titles, err := comicinfo.NewTitleParser(titlePat)
+    if err != nil {
+        logger.Fatal(err.Error())
+    }
+    if err := titles.SetSpecialPattern(special); err != nil {
+        logger.Fatal(err.Error())
+    }
+    var series *comicinfo.SeriesMap
+    if seriesMap != "" {
+        if series, err = comicinfo.LoadSeriesMap(seriesMap); err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to load series map: %v", err))
+        }
+    }
+    // Both decide where archives go
+    if mirror && nameTmpl != "" {
+        logger.Fatal("-mirror and -name-template can't be combined")
+    }
+    if nested != types.NestedNone && layout != types.LayoutFlat {
+        logger.Fatal("-nested and -library-layout can't be combined")
+    }
+    if scanDepth < 0 {
+        logger.Fatal("-max-depth can't be negative")
+    }
+    if scanDepth != 0 && layout != types.LayoutFlat {
+        logger.Fatal("-max-depth and -library-layout can't be combined")
+    }
+    if nested == types.NestedMirror && (mirror || nameTmpl != "") {
+        logger.Fatal("-nested mirror can't be combined with -mirror or -name-template")
+    }
+
+    if !dryRun {
+        if err := os.MkdirAll(outputDir, 0755); err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to create output directory: %v", err))
+        }
+    }
+    pathnorm.Configure(types.CaseAuto, outputDir)
+
+    if catalogPath == "" {
+        path, err := history.CatalogPath(outputDir)
+        if err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to locate catalog: %v", err))
+        }
+        catalogPath = path
+    }
+
+    run := history.NewRun(start, outputDir, slices.Concat(inputPaths, recInputs))
+    unlock := func() {}
+    if !dryRun {
+        release, err := history.Lock(outputDir, run.ID)
+        if err != nil {
+            logger.Fatal(fmt.Sprintf("Failed to lock output directory: %v", err))
+        }
+        unlock = release
+    }
+
+    cat, err := history.LoadCatalog(catalogPath, outputDir)
+    if err != nil {
+        unlock()
+        logger.Fatal(fmt.Sprintf("Failed to read catalog: %v", err))
+    }
+
+    recursiveInputs, directInputs := []string(recInputs), []string(inputPaths)
+    if recursive {
+        recursiveInputs, directInputs = slices.Concat(directInputs, recursiveInputs), nil
+    }
+    workItems, err := collectInputs(directInputs, recursiveInputs, outputDir, dumbMode, rootImages, mirror, layout, nested, scanDepth, nil)
+    if err != nil {
+        unlock()
+        logger.Fatal(fmt.Sprintf("Failed to collect work items: %v", err))
+    }
+    workItems = collector.ApplyTemplate(workItems, outputDir, names, titles, series)
+    workItems = collector.Sanitize(workItems, outputDir, sanitizer(sanitize, replaceChar))
+    workItems = collector.Normalize(workItems, outputDir, normalize)
+
+    // Hash every folder, the catalog knows what they hashed to when last converted
+    logger.Info(fmt.Sprintf("Hashing %d folders", len(workItems)))
+    sources := make([]string, len(workItems))
+    for i, item := range workItems {
+        sources[i] = item.SourcePath
+    }
+    hashes, errs := hashAll(sources, threads, func(dir string) (string, error) {
+        return processor.HashFolder(dir, dumbMode, fpMode)
+    })
+
+    // A folder that can't be hashed is left alone, its catalog entry included
+    var hashedItems []types.WorkItem
+    var hashed []string
+    for i, item := range workItems {
+        if errs[i] != nil {
+            logger.Error(fmt.Sprintf("Failed to hash %s: %v", item.SourcePath, errs[i]))
+            continue
+        }
+        hashedItems = append(hashedItems, item)
+        hashed = append(hashed, hashes[i])
+    }
+
+    settings := plan.SyncSettings{Dumb: dumbMode, Compression: compression.String()}
+    p := plan.BuildSync(hashedItems, hashed, cat, settings)
+
+    if dryRun {
+        fmt.Println()
+        p.Print()
+        return
+    }
+
+    logger.Info(fmt.Sprintf("Sync: %d new, %d changed, %d renamed, %d unchanged, %d deleted",
+        p.Count(plan.ActionNew), p.Count(plan.ActionReconvert), p.Count(plan.ActionRenamed),
+        p.Count(plan.ActionUnchanged), p.Count(plan.ActionRemoved)))
+
+    // Every change to the output is journaled first, so the sync can be rolled back
+    journal := history.NewJournal(run.ID, outputDir)
+
+    now := time.Now()
+    for _, e := range p.Entries {
+        switch e.Action {
+        case plan.ActionRenamed:
+            step := history.Step{Kind: history.StepMove, From: e.Previous.Output, To: e.OutputPath, Source: e.SourcePath}
+            if err := journal.Record(step); err != nil {
+                logger.Error(fmt.Sprintf("Failed to write journal, not moving %s: %v", e.Previous.Output, err))
+                continue
+            }
+            if err := moveArchive(e.Previous.Output, e.OutputPath); err != nil {
+                logger.Error(fmt.Sprintf("Failed to move %s: %v", e.Previous.Output, err))
+                continue
+            }
+            logger.Okay(fmt.Sprintf("Renamed: %s → %s", filepath.Base(e.Previous.Output), filepath.Base(e.OutputPath)))
+            removeEmptyDirs(filepath.Dir(e.Previous.Output), absPath(outputDir))
+            moved := *e.Previous
+            moved.Source = absPath(e.SourcePath)
+            moved.Output = absPath(e.OutputPath)
+            cat.Remove(e.Previous.Source)
+            cat.Put(&moved)
+
+        case plan.ActionRemoved:
+            if !prune {
+                logger.Warning(fmt.Sprintf("Source deleted, keeping archive: %s (use -prune to remove it)", e.OutputPath))
+                continue
+            }
+            if err := pruneArchive(journal, e, keepReplace); err != nil {
+                logger.Error(fmt.Sprintf("Failed to remove %s: %v", e.OutputPath, err))
+                continue
+            }
+            logger.Okay(fmt.Sprintf("Pruned: %s", filepath.Base(e.OutputPath)))
+            cat.Remove(e.SourcePath)
+        }
+    }
+
+    stats := &types.ConversionStats{}
+    ctx, abort := interruptContexts()
+    notifier := notify.Start(run.ID, senders)
+    var onResult func(types.ItemResult)
+    if notifier != nil {
+        onResult = notifier.Item
+    }
+    if pending := p.Pending(dumbMode); len(pending) > 0 {
+        stats.Total = len(pending)
+        processor.ProcessConcurrently(ctx, pending, &types.Options{
+            Threads:          threads,
+            ExcludeThreshold: excludeWarn,
+            MaxEntries:       maxEntries,
+            MinImages:        minImages,
+            Overwrite:        types.OverwriteAlways,
+            Fingerprint:      fpMode,
+            Prefetch:         2,
+            Status:           statusRequests(),
+            Abort:            abort,
+            RunID:            run.ID,
+            Normalize:        normalize,
+            Journal:          journal.Record,
+            KeepReplaced:     keepReplace,
+            Verify:           verify,
+            PreHook:          preHook,
+            PostHook:         postHook,
+            OnResult:         onResult,
+        }, stats)
+        util.PrintFinalStats(stats, time.Since(start))
+    } else {
+        logger.Okay("Nothing to convert, every folder is up to date")
+    }
+
+    // Only folders that converted fine are recorded, failures are retried next sync
+    for _, res := range stats.Results {
+        e := p.ForSource(res.SourcePath)
+        if res.Status != types.StatusConverted || e == nil {
+            continue
+        }
+        entry := &history.CatalogEntry{
+            Source:      absPath(res.SourcePath),
+            Output:      absPath(res.OutputPath),
+            Hash:        e.Hash,
+            Dumb:        settings.Dumb,
+            Compression: settings.Compression,
+            FirstSeen:   now,
+            Converted:   now,
+        }
+        if e.Previous != nil {
+            entry.FirstSeen = e.Previous.FirstSeen
+            cat.Remove(e.Previous.Source)
+        }
+        cat.Put(entry)
+    }
+
+    if err := cat.Save(); err != nil {
+        logger.Error(fmt.Sprintf("Failed to write catalog: %v", err))
+    }
+
+    if err := journal.Finish(); err != nil {
+        logger.Warning(fmt.Sprintf("Failed to write journal: %v", err))
+    }
+    if len(journal.Steps) > 0 {
+        logger.Info(fmt.Sprintf("Undo this run with: %s rollback %s", os.Args[0], run.ID))
+    }
+
+    run.Finished = time.Now()
+    run.Record(stats.Results)
+    if err := history.Save(run); err != nil {
+        logger.Warning(fmt.Sprintf("Failed to record run history: %v", err))
+    }
+    notifier.Finish(runSummary(start, run.OutputDir, stats, ctx.Err() != nil))
+    unlock()
+
+    if ctx.Err() != nil {
+        os.Exit(130)
+    }
+}
+
+// pruneArchive removes the archive of a deleted folder, or with keep only
+// moves it aside so a rollback can bring it back
+func pruneArchive(journal *history.Journal, e plan.Entry, keep bool) error {
+    if _, err := os.Stat(e.OutputPath); os.IsNotExist(err) {
+        return nil
+    }
+    step := history.Step{Kind: history.StepDelete, From: e.OutputPath, Source: e.SourcePath}
+    if keep {
+        step.Backup = types.BackupPath(e.OutputPath, journal.ID)
+    }
+    if err := journal.Record(step); err != nil {
+        return fmt.Errorf("failed to write journal: %w", err)
+    }
+    if keep {
+        // The sidecar and OPF stay, they're there again once the archive is restored
+        return os.Rename(e.OutputPath, step.Backup)
+    }
+    if err := os.Remove(e.OutputPath); err != nil {
+        return err
+    }
+    for _, companion := range types.Companions(e.OutputPath) {
+        if err := os.Remove(companion); err != nil && !os.IsNotExist(err) {
+            logger.Warning(fmt.Sprintf("Failed to remove %s: %v", companion, err))
+        }
+    }
+    return nil
+}
+
+// moveArchive moves a renamed folder's archive to its new name, never over
+// another archive, and its sidecar and OPF with it. The folders of the new
+// name are created as needed.
+func moveArchive(from, to string) error {
+    if from == to {
+        return nil
+    }
+    // A case-only rename on a case-insensitive filesystem finds from itself at to
+    if _, err := os.Stat(to); err == nil && !pathnorm.Equal(from, to) {
+        return fmt.Errorf("%s already exists", to)
+    }
+    if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+        return err
+    }
+    if err := os.Rename(from, to); err != nil {
+        return err
+    }
+    moved := types.Companions(to)
+    for i, companion := range types.Companions(from) {
+        if _, err := os.Stat(companion); err != nil {
+            continue
+        }
+        if err := os.Rename(companion, moved[i]); err != nil {
+            logger.Warning(fmt.Sprintf("Failed to move %s: %v", companion, err))
+        }
+    }
+    return nil
+}
+
+func absPath(path string) string {
+    if abs, err := filepath.Abs(path); err == nil {
+        return abs
+    }
+    return path
+}
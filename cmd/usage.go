@@ -10,18 +10,129 @@ func showUsage() {
     fmt.Println()
     fmt.Println("USAGE:")
     fmt.Printf("  %s -input <dir> [-input <dir>...] -output <folder> [options]\n", os.Args[0])
+    fmt.Printf("  %s serve -output <folder> [options]\n", os.Args[0])
     fmt.Println()
     fmt.Println("REQUIRED:")
-    fmt.Println("  -input,  -i  string    Input directory (can be specified multiple times)")
-    fmt.Println("  -output, -o  string    Output directory for CBZ files")
+    fmt.Println("  -input,  -i  string    Input directory or quoted pattern like './mangas/One Piece*/' (can be")
+    fmt.Println("                         specified multiple times, - reads them from stdin)")
+    fmt.Println("  -output, -o  string    Output directory for CBZ files (not needed with -in-place)")
     fmt.Println()
     fmt.Println("OPTIONS:")
+    fmt.Println("  -input-recursive string      Input whose subdirectories are converted, next to or instead of -input")
+    fmt.Println("  -input-file      string      File listing inputs one per line, # comments, taken like -input")
     fmt.Println("  -recursive,   -r             Process subdirectories recursively (default: false)")
+    fmt.Println("  -library-layout  string      How inputs are organised: [flat|series/volume/chapter] (default: flat)")
+    fmt.Println("  -nested          string      Recursive inputs at any depth: [none|flatten|merge-volumes|mirror] (default: none)")
+    fmt.Println("  -max-depth       int         Levels below recursive inputs folders are looked for (default: 1, no limit with -nested)")
+    fmt.Println("  -root-images                 With -recursive, convert inputs with images but no subfolders directly")
+    fmt.Println("  -duplicates      string      Folders holding the same chapter: [keep|ask|larger|newer|suffix] (default: keep)")
+    fmt.Println("  -on-collision    string      Inputs wanting the same output: [skip|error|suffix|merge] (default: skip)")
     fmt.Println("  -compression, -c string      Compression mode to use. [none|default|fast|slow] (default: none)")
     fmt.Println("  -threads,     -j int         Number of concurrent threads (default: 4)")
+    fmt.Println("  -overwrite       string      Existing archives: [skip|always|if-different] (default: skip)")
+    fmt.Println("  -update                      Add new files to existing archives instead of skipping them")
+    fmt.Println("  -keep-replaced               Keep archives replaced by -overwrite, so rollback can restore them")
+    fmt.Println("  -verify                      Read every archive back before moving it into place, fail corrupt ones")
+    fmt.Println("  -worm                        Make every archive read-only once verified, write once read many")
+    fmt.Println("  -immutable                   Also make them immutable where supported (implies -worm)")
+    fmt.Println("  -force                       Replace or update read-only archives instead of skipping them")
+    fmt.Println("  -delete-source               Delete each source folder once its archive is verified")
+    fmt.Println("  -trash-source                Move each source folder to the trash once its archive is verified")
+    fmt.Println("  -trash-dir       string      Move them into this quarantine directory instead (implies -trash-source)")
+    fmt.Println("  -keep-latest     int         After the run, move all but the latest N chapters of every series out (default: 0, all kept)")
+    fmt.Println("  -cold-storage    string      Where -keep-latest moves older chapters, mirroring the output (default: the trash)")
+    fmt.Println("  -pre-hook        string      Shell command run on every folder before its files are read, e.g. an optimizer")
+    fmt.Println("  -post-hook       string      Shell command run for every folder once it converted, was skipped or failed")
+    fmt.Println("  -webhook         string      URL every finished folder and the run summary are POSTed to as JSON")
+    fmt.Println("                               (can be specified multiple times)")
+    fmt.Println("  -discord-token   string      Discord bot that posts failed folders and the run summary,")
+    fmt.Println("  -discord-channel string      to this channel ID")
+    fmt.Println("  -telegram-token  string      Telegram bot that posts failed folders and the run summary,")
+    fmt.Println("  -telegram-chat   string      to this chat ID or @channel")
+    fmt.Println("  -comicinfo                   Add a ComicInfo.xml with the title and number parsed from the folder name")
+    fmt.Println("  -comicbookinfo               Also write the ComicInfo.xml details as ComicBookInfo JSON into the archive comment")
+    fmt.Println("  -series-map      string      JSON file with series titles in other languages (implies -comicinfo)")
+    fmt.Println("  -series-json                 Write a Mylar series.json into every folder holding one series")
+    fmt.Println("  -genre           string      Comma separated genres for ComicInfo.xml (implies -comicinfo)")
+    fmt.Println("  -tags            string      Comma separated tags for ComicInfo.xml (implies -comicinfo)")
+    fmt.Println("  -age-rating      string      ComicInfo.xml age rating, e.g. Teen or \"Mature 17+\" (implies -comicinfo)")
+    fmt.Println("  -title-pattern   string      Regex with number/title/volume/group groups for -comicinfo")
+    fmt.Println("  -special-pattern string      Regex matching the folder names of specials, for {special}")
+    fmt.Println("  -name-template   string      Archive name template, see rename -help (default: {folder})")
+    fmt.Println("  -layout          string      Name archives the way a library server expects: [komga|kavita], implies -comicinfo")
+    fmt.Println("  -mirror                      Recreate the input folders under the output instead of one flat folder")
+    fmt.Println("  -in-place                    Write every archive next to its source folder instead of into -output")
+    fmt.Println("  -merge           string      Combine every folder found into one archive of this name")
+    fmt.Println("  -merge-layout    string      How merged folders are stored: [folders|flat] (default: folders)")
+    fmt.Println("  -merge-order     string      Order folders are merged in: [natural|input] (default: natural)")
+    fmt.Println("  -volumes-of      int         Bundle every N chapter folders of a series into one volume archive")
+    fmt.Println("  -volume-name     string      Name template of those volumes (default: \"{series} v{volume:2}\")")
+    fmt.Println("  -split-by-pattern string     Regex whose first or chapter group picks the chapter of each file,")
+    fmt.Println("                               one archive per chapter, e.g. \"^(c\\d+)_\" for c001_p001.jpg")
+    fmt.Println("  -max-pages       int         Split folders with more pages into \"Name (Part 1).cbz\", ... (default: 0, no limit)")
+    fmt.Println("  -max-size        string      Split folders larger than this, e.g. 300MB, into parts (default: no limit)")
+    fmt.Println("  -extract                     Unpack the CBZ/CBR files of the inputs back into folders under -output")
+    fmt.Println("  -passwords       string      File of passwords tried in turn on encrypted archives, one per line")
+    fmt.Println("  -sanitize                    Rewrite archive names Windows and exFAT can't store (: ? | trailing dots, ...)")
+    fmt.Println("  -sanitize-entries            Rewrite entry names inside archives the same way, recorded in a manifest")
+    fmt.Println("  -replace-char    string      Stands in for characters -sanitize removes, empty drops them (default: _)")
+    fmt.Println("  -normalize       string      Unicode form of archive and entry names: [none|nfc|nfd] (default: none)")
+    fmt.Println("  -max-entry-length int        Shorten entry names longer than this many bytes (default: 0, no limit)")
+    fmt.Println("  -max-entry-depth int         Fold folders nested deeper than this inside archives (default: 0, no limit)")
+    fmt.Println("  -cp437-fallback              Transliterate non-ASCII entry names to CP437 for readers that ignore UTF-8")
+    fmt.Println("  -sort            string      Page order in archives: [natural|lexical] (default: natural, page2 before page10)")
+    fmt.Println("  -rename-pages                Store pages as 0001.jpg, 0002.jpg, ... with a manifest of the original names")
+    fmt.Println("  -reproducible                Fixed entry times and permissions, the same source gives identical bytes")
+    fmt.Println("  -sidecar                     Write <archive>.cbz.json with every page's name, size, dimensions and hash")
+    fmt.Println("  -opf                         Write <archive>.opf with its title, series, series index and tags for Calibre")
+    fmt.Println("  -calibre-library string      Add every archive converted to this Calibre library with calibredb")
+    fmt.Println("  -text-files      string      Text files in folders: [keep|merge|notes] (default: keep, merge makes one info.txt,")
+    fmt.Println("                               notes moves them into the ComicInfo.xml Notes)")
+    fmt.Println("  -cover           string      Glob for the file placed first as the cover, none keeps name order")
+    fmt.Println("                               (default: the first cover* or volume* image)")
+    fmt.Println("  -report          string      Write a per-folder report to this .csv or .json file")
+    fmt.Println("  -json                        Print a JSON document with per-folder results instead of the summary")
+    fmt.Println("  -log-format      string      Per-folder log lines: [text|json] (default: text, json streams to stdout)")
+    fmt.Println("  -progress        string      Progress display: [auto|bar|plain] (default: auto, bar only on a terminal)")
+    fmt.Println("  -case            string      Path comparison: [auto|sensitive|insensitive] (default: auto, probes output)")
+    fmt.Println("  -fingerprint     string      How if-different spots changed sources: [meta|content] (default: meta)")
+    fmt.Println("  -profile         string      Bundle of options: archive, ereader, fast or one from profiles.json")
     fmt.Println("  -dumb,        -d             Archive all files without filtering (default: false)")
+    fmt.Println("  -images-only                 Archive nothing but files whose content is an image, reporting the rest")
+    fmt.Println("  -include-ext     string      Extensions smart mode always archives, e.g. xml,psd or images|videos|text (can be repeated)")
+    fmt.Println("  -exclude-ext     string      Extensions never archived, in dumb mode too, e.g. mp4,mkv or videos (can be repeated)")
+    fmt.Println("  -exclude-dir     string      Subfolders never archived or converted, e.g. __MACOSX or *_raw (can be repeated)")
+    fmt.Println("  -dedupe-links                Store hard-linked duplicates once, the links go in a manifest")
+    fmt.Println("  -dry-run,     -n             Show what would change since the last run, without converting")
+    fmt.Println("  -prefetch        int         Upcoming folders scanned ahead of the workers, 0 disables (default: 2)")
+    fmt.Println("  -low-power                   Reduce concurrency on battery or when the CPU runs hot (default: false)")
+    fmt.Println("  -max-duration    duration    Stop dispatching new folders after this long, e.g. 2h (default: no limit)")
+    fmt.Println("  -max-errors      int         Stop dispatching new folders once this many failed (default: no limit)")
+    fmt.Println("  -resume          string      Resume what a time-boxed run left behind, by run ID or checkpoint file")
+    fmt.Println("  -exclude-threshold float     Flag folders where smart mode excludes more than this % of files (default: 50)")
+    fmt.Println("  -max-entries     int         Fail folders with more files than this before archiving (default: 20000, 0 disables)")
+    fmt.Println("  -min-images      int         Skip folders with fewer images than this, with a warning (default: 0, off)")
+    fmt.Println("  -strict                      Fail flagged folders and ones with fifos, sockets or empty files (default: false)")
+    fmt.Println("  -confirm-flagged             Ask before archiving folders smart mode would flag (default: false)")
+    fmt.Println("  -name-width      int         Display width folder names are truncated to (default: 32)")
+    fmt.Println("  -no-truncate                 Never truncate names, useful when redirecting output to a file")
     fmt.Println("  -help,        -h             Show this help message")
-    fmt.Println("  -version,     -v             Show version information")
+    fmt.Println("  -verbose,     -v             Stream log lines as they happen instead of one block per folder")
+    fmt.Println("  -version                     Show version information")
+    fmt.Println()
+    fmt.Println("SUBCOMMANDS:")
+    fmt.Println("  serve                        Run a conversion server with a web dashboard (see serve -help)")
+    fmt.Println("  hash                         Print the content hash of folders and archives (see hash -help)")
+    fmt.Println("  sync                         Convert only new and changed folders, tracked in a catalog (see sync -help)")
+    fmt.Println("  equal                        Check whether two archives hold the same pages (see equal -help)")
+    fmt.Println("  check                        Find broken archives and pages in an existing collection (see check -help)")
+    fmt.Println("  repack                       Clean up existing archives and PDFs like freshly converted folders (see repack -help)")
+    fmt.Println("  join                         Combine existing archives into one, e.g. the chapters of a volume (see join -help)")
+    fmt.Println("  rename                       Move an existing library to a new name template (see rename -help)")
+    fmt.Println("  migrate                      Upgrade an existing library to new conventions (see migrate -help)")
+    fmt.Println("  rollback                     Undo what a run changed in its output directory (see rollback -help)")
+    fmt.Println("  history                      Export, import and compare the recorded runs (see history -help)")
+    fmt.Println("  config                       Move profiles, series maps and .cbzignore files between machines (see config -help)")
     fmt.Println()
     fmt.Println("EXAMPLES:")
     fmt.Println("  1. Recursive Mode:")
@@ -46,6 +157,8 @@ func showUsage() {
     fmt.Println("    Scans input directories and converts each subdirectory into a CBZ")
     fmt.Println("    Example: ./mangas/ contains [manga1/, manga2/, manga3/]")
     fmt.Println("             → Creates manga1.cbz, manga2.cbz, manga3.cbz")
+    fmt.Println("    An input with images but no subdirectories is offered for direct")
+    fmt.Println("    conversion, -root-images converts it without asking")
     fmt.Println()
     fmt.Println("  DIRECT (default):")
     fmt.Println("    Converts the specified directories directly into CBZ files")
@@ -61,5 +174,386 @@ func showUsage() {
     fmt.Println()
     fmt.Println("  DUMB (-dumb|-d):")
     fmt.Println("    Archives everything without any filtering")
+    fmt.Println()
+    fmt.Println("  MERGE (-merge NAME):")
+    fmt.Println("    Every folder found goes into one archive, e.g. the chapters of a volume")
+    fmt.Println("    Example: -r -input \"./manga1/Vol. 01\" -merge \"Vol. 01\"")
+    fmt.Println("             → Creates Vol. 01.cbz with a folder per chapter, or all pages")
+    fmt.Println("               numbered 0001, 0002, ... with -merge-layout flat")
+    fmt.Println()
+    fmt.Println("  EXTRACT (-extract):")
+    fmt.Println("    The reverse: every archive given or found below an input is unpacked")
+    fmt.Println("    into a folder of the same name, e.g. ./cbz/manga1.cbz → ./out/manga1/,")
+    fmt.Println("    or one -name-template places like \"{series}/{chapter:3}/\"; generated")
+    fmt.Println("    entries are left out and renamed pages get their original names back")
+    fmt.Println("    Takes -threads, -overwrite skip|always, -keep-replaced, -passwords, -rename-pages,")
+    fmt.Println("    -on-collision skip|error|suffix, -max-errors, -report and -dry-run")
+    fmt.Println()
+    fmt.Println("STATUS:")
+    fmt.Println("  Press Enter below the progress bar, or send SIGUSR1 (kill -USR1 <pid>),")
+    fmt.Println("  to print the folders done and left, throughput, ETA and what each worker")
+    fmt.Println("  is writing")
+    fmt.Println()
+    fmt.Println("ENVIRONMENT:")
+    fmt.Println("  Every option not given on the command line is read from CBZ_ and its name,")
+    fmt.Println("  e.g. CBZ_OUTPUT=/cbz or CBZ_EXCLUDE_DIR=__MACOSX:raw; subcommands add theirs,")
+    fmt.Println("  e.g. CBZ_SERVE_LISTEN. Repeatable options take a list separated like PATH")
+}
+
+func showServeUsage() {
+    fmt.Println("CBZ Converter - Conversion server with web dashboard")
+    fmt.Println()
+    fmt.Println("USAGE:")
+    fmt.Printf("  %s serve -output <folder> [options]\n", os.Args[0])
+    fmt.Println()
+    fmt.Println("REQUIRED:")
+    fmt.Println("  -output, -o  string          Output directory for CBZ files of every job")
+    fmt.Println()
+    fmt.Println("OPTIONS:")
+    fmt.Println("  -listen,      -l string      Address to listen on, e.g. :8080 for every interface (default: 127.0.0.1:8080)")
+    fmt.Println("  -token           string      Token API requests have to send as \"Authorization: Bearer <token>\"")
+    fmt.Println("  -allow-root      string      Folder jobs may take their inputs from (can be repeated, default: any)")
+    fmt.Println("  -compression, -c string      Compression mode to use. [none|default|fast|slow] (default: none)")
+    fmt.Println("  -threads,     -j int         Number of concurrent threads per job (default: CPU count)")
+    fmt.Println("  -exclude-threshold float     Flag folders where smart mode excludes more than this % of files (default: 50)")
+    fmt.Println("  -max-entries     int         Fail folders with more files than this before archiving (default: 20000, 0 disables)")
+    fmt.Println("  -min-images      int         Skip folders with fewer images than this, with a warning (default: 0, off)")
+    fmt.Println("  -strict                      Fail flagged folders and ones with fifos, sockets or empty files (default: false)")
+    fmt.Println("  -cover           string      Glob for the file placed first as the cover (default: cover* or volume* image)")
+    fmt.Println("  -verify-every    duration    Read every archive in the output back this often, e.g. 24h (default: 0, off)")
+    fmt.Println("  -verify-rate     size        Most the integrity watch reads a second (default: 16MB)")
+    fmt.Println()
+    fmt.Println("Jobs run one at a time in submission order. Open the listen address in a")
+    fmt.Println("browser to queue jobs and watch their progress, or use the JSON API:")
+    fmt.Println("  POST /api/jobs       {\"inputs\": [\"/srv/mangas\"], \"recursive\": true, \"dumb\": false, \"cover\": \"\"}")
+    fmt.Println("  GET  /api/jobs       List jobs, newest first")
+    fmt.Println("  GET  /api/jobs/{id}  Show a single job")
+    fmt.Println("  GET  /api/stats      Totals across all jobs")
+    fmt.Println("  GET  /api/integrity  Progress of the integrity watch and the damaged archives it found")
+    fmt.Println("Jobs are posted as application/json. With -token the dashboard is opened as")
+    fmt.Println("http://<host>:8080/#token=<token>. The last 200 finished jobs are kept.")
+}
+
+func showHashUsage() {
+    fmt.Println("CBZ Converter - Hash folders and archives the way the converter fingerprints them")
+    fmt.Println()
+    fmt.Println("USAGE:")
+    fmt.Printf("  %s hash [options] <folder|archive.cbz>...\n", os.Args[0])
+    fmt.Println()
+    fmt.Println("OPTIONS:")
+    fmt.Println("  -recursive,  -r              Hash every subdirectory and CBZ inside the given directories")
+    fmt.Println("  -dumb,       -d              Hash all files of a folder, not only those smart mode would archive")
+    fmt.Println("  -threads,    -j int          Number of paths hashed at once (default: CPU count)")
+    fmt.Println("  -fingerprint string          What is hashed: [content|meta] (default: content)")
+    fmt.Println()
+    fmt.Println("A folder and the archive converted from it hash the same in content mode,")
+    fmt.Println("and that hash is what -fingerprint content stores in the archive comment.")
+    fmt.Println("Meta hashes cover names, sizes and modification times and only work on folders.")
+    fmt.Println("Output follows sha256sum: one \"<hash>  <path>\" line per path.")
+}
+
+func showSyncUsage() {
+    fmt.Println("CBZ Converter - Keep an output directory in sync with a library")
+    fmt.Println()
+    fmt.Println("USAGE:")
+    fmt.Printf("  %s sync -input <folder> -output <folder> [options]\n", os.Args[0])
+    fmt.Println()
+    fmt.Println("REQUIRED:")
+    fmt.Println("  -input,  -i  string          Input directory (can be specified multiple times)")
+    fmt.Println("  -output, -o  string          Output directory for CBZ files")
+    fmt.Println()
+    fmt.Println("OPTIONS:")
+    fmt.Println("  -input-recursive string      Input whose subdirectories are synced, next to or instead of -input")
+    fmt.Println("  -input-file      string      File listing inputs one per line, # comments, taken like -input")
+    fmt.Println("  -recursive,   -r             Sync every subdirectory of the inputs")
+    fmt.Println("  -library-layout  string      How inputs are organised: [flat|series/volume/chapter] (default: flat)")
+    fmt.Println("  -nested          string      Recursive inputs at any depth: [none|flatten|merge-volumes|mirror] (default: none)")
+    fmt.Println("  -max-depth       int         Levels below recursive inputs folders are looked for (default: 1, no limit with -nested)")
+    fmt.Println("  -root-images                 With -recursive, sync inputs with images but no subfolders directly")
+    fmt.Println("  -dumb,        -d             Archive all files without filtering")
+    fmt.Println("  -dry-run,     -n             Show what the sync would do without doing it")
+    fmt.Println("  -prune                       Delete the archives of folders that no longer exist")
+    fmt.Println("  -keep-replaced               Keep rebuilt and pruned archives, so rollback can restore them")
+    fmt.Println("  -verify                      Read every archive back before moving it into place, fail corrupt ones")
+    fmt.Println("  -catalog      string         Catalog file (default: one per output directory)")
+    fmt.Println("  -fingerprint  string         How changed folders are detected: [meta|content] (default: meta)")
+    fmt.Println("  -name-template string        Archive name template (default: {folder})")
+    fmt.Println("  -layout       string         Name archives the way a library server expects: [komga|kavita]")
+    fmt.Println("  -title-pattern   string      Regex with number/title/volume/group groups for folder names")
+    fmt.Println("  -special-pattern string      Regex matching the folder names of specials, for {special}")
+    fmt.Println("  -series-map      string      JSON file with series titles used for {series}")
+    fmt.Println("  -mirror                      Recreate the input folders under the output instead of one flat folder")
+    fmt.Println("  -sanitize                    Rewrite archive names Windows and exFAT can't store")
+    fmt.Println("  -replace-char string         Stands in for characters -sanitize removes (default: _)")
+    fmt.Println("  -pre-hook     string         Shell command run on every folder before its files are read")
+    fmt.Println("  -post-hook    string         Shell command run for every folder once it converted or failed")
+    fmt.Println("  -webhook      string         URL every finished folder and the summary are POSTed to as JSON")
+    fmt.Println("  -discord-token, -discord-channel  Discord bot and channel failures and the summary are posted to")
+    fmt.Println("  -telegram-token, -telegram-chat   Telegram bot and chat failures and the summary are posted to")
+    fmt.Println("  -profile      string         Bundle of options, those sync doesn't take are left out")
+    fmt.Println("  -normalize    string         Unicode form of archive and entry names: [none|nfc|nfd] (default: none)")
+    fmt.Println("  -compression, -c string      Compression mode to use. [none|default|fast|slow] (default: none)")
+    fmt.Println("  -threads,     -j int         Number of concurrent threads (default: CPU count)")
+    fmt.Println("  -exclude-threshold float     Flag folders where smart mode excludes more than this % of files (default: 50)")
+    fmt.Println("  -max-entries     int         Fail folders with more files than this before archiving (default: 20000, 0 disables)")
+    fmt.Println("  -min-images      int         Skip folders with fewer images than this, with a warning (default: 0, off)")
+    fmt.Println()
+    fmt.Println("The catalog records every converted folder with its hash, archive, options")
+    fmt.Println("and timestamps. A sync converts new folders, rebuilds changed ones, moves the")
+    fmt.Println("archive of a renamed folder instead of rebuilding it, and reports deleted ones.")
+}
+
+func showEqualUsage() {
+    fmt.Println("CBZ Converter - Compare the pages of two archives")
+    fmt.Println()
+    fmt.Println("USAGE:")
+    fmt.Printf("  %s equal [options] <a.cbz> <b.cbz>\n", os.Args[0])
+    fmt.Println()
+    fmt.Println("OPTIONS:")
+    fmt.Println("  -quiet, -q                   Print nothing, only set the exit status")
+    fmt.Println()
+    fmt.Println("Archives are equal when they hold the same images, compared by content:")
+    fmt.Println("entry names, order and compression don't matter, duplicates are counted.")
+    fmt.Println("Non-image entries are ignored. Exit status is 0 when equal, 1 when")
+    fmt.Println("different and 2 when an archive can't be read.")
+}
+
+func showCheckUsage() {
+    fmt.Println("CBZ Converter - Find broken archives and pages in an existing collection")
+    fmt.Println()
+    fmt.Println("USAGE:")
+    fmt.Printf("  %s check [options] <folder|archive.cbz>...\n", os.Args[0])
+    fmt.Println()
+    fmt.Println("OPTIONS:")
+    fmt.Println("  -threads, -j  int            Number of archives checked at once (default: number of CPUs)")
+    fmt.Println("  -verbose, -v                 List every archive checked, not only the ones with problems")
+    fmt.Println("  -json                        Print the results of every archive as JSON")
+    fmt.Println()
+    fmt.Println("Folders are searched recursively for .cbz files. Every entry is read back so")
+    fmt.Println("its checksum is verified, and every JPEG, PNG and GIF page decoded. Archives")
+    fmt.Println("that don't open, corrupt entries, archives without a single image and pages")
+    fmt.Println("that don't decode are reported. Nothing is written. Exit status is 0 when")
+    fmt.Println("every archive is fine, 1 when some have problems and 2 on bad arguments.")
+}
+
+func showRepackUsage() {
+    fmt.Println("CBZ Converter - Clean up existing archives like freshly converted folders")
+    fmt.Println()
+    fmt.Println("USAGE:")
+    fmt.Printf("  %s repack -output <folder> [options] <folder|archive.cbz|.cbr|.pdf>...\n", os.Args[0])
+    fmt.Printf("  %s repack -in-place [options] <folder|archive.cbz|.cbr|.pdf>...\n", os.Args[0])
+    fmt.Println()
+    fmt.Println("REQUIRED, one of:")
+    fmt.Println("  -output, -o  string          Directory the repacked archives are written to")
+    fmt.Println("  -in-place                    Replace every archive with its repacked version, once verified")
+    fmt.Println()
+    fmt.Println("OPTIONS:")
+    fmt.Println("  -threads, -j     int         Number of concurrent threads (default: number of CPUs)")
+    fmt.Println("  -temp-dir        string      Where archives are unpacked while repacked (default: system temp)")
+    fmt.Println("  -passwords       string      File of passwords tried in turn on encrypted archives, one per line")
+    fmt.Println("  -dumb,    -d                 Keep every entry instead of filtering like smart mode")
+    fmt.Println("  -images-only                 Keep nothing but entries whose content is an image")
+    fmt.Println("  -include-ext     string      Extensions smart mode always keeps, e.g. xml or images|videos|text (can be repeated)")
+    fmt.Println("  -exclude-ext     string      Extensions dropped from the archives, e.g. mp4,mkv or videos (can be repeated)")
+    fmt.Println("  -exclude-dir     string      Folders inside the archives to drop, e.g. raw/** (can be repeated)")
+    fmt.Println("  -compression, -c string      Compression mode to use. [none|default|fast|slow] (default: none)")
+    fmt.Println("  -overwrite       string      Archives already in the output: [skip|always|if-different] (default: skip)")
+    fmt.Println("  -keep-replaced               Keep replaced archives, so rollback can restore them")
+    fmt.Println("  -verify                      Read every archive back before moving it into place")
+    fmt.Println("  -sort            string      Order of the pages: [natural|lexical] (default: natural)")
+    fmt.Println("  -cover           string      Glob for the file placed first as the cover, none keeps name order")
+    fmt.Println("  -rename-pages                Store pages as 0001.jpg, 0002.jpg, ... in reading order")
+    fmt.Println("  -sanitize-entries            Rewrite entry names Windows can't extract")
+    fmt.Println("  -text-files      string      Text files in the archives: [keep|merge|notes] (default: keep)")
+    fmt.Println("  -max-entry-length int        Shorten entry names longer than this many bytes (default: 0, no limit)")
+    fmt.Println("  -max-entry-depth int         Fold folders nested deeper than this (default: 0, no limit)")
+    fmt.Println("  -comicinfo                   Add a ComicInfo.xml parsed from the archive name if it has none")
+    fmt.Println("  -comicbookinfo               Also write ComicBookInfo JSON into the archive comment")
+    fmt.Println("  -title-pattern   string      Regular expression with number/title/volume/group groups")
+    fmt.Println("  -series-map      string      JSON file with series titles (implies -comicinfo)")
+    fmt.Println("  -report          string      Write a per-archive report to this .csv or .json file")
+    fmt.Println("  -progress        string      Progress display: [auto|bar|plain] (default: auto)")
+    fmt.Println("  -dry-run, -n                 List the archives that would be repacked")
+    fmt.Println()
+    fmt.Println("Folders are searched recursively for .cbz files. Each archive is unpacked")
+    fmt.Println("into a scratch folder, dropping folders that wrap every entry and __MACOSX,")
+    fmt.Println("and converted like a source folder: system files and junk are filtered out,")
+    fmt.Println("pages sorted and the cover placed first. The scratch folder is removed again.")
+}
+
+func showJoinUsage() {
+    fmt.Println("CBZ Converter - Combine existing archives into one")
+    fmt.Println()
+    fmt.Println("USAGE:")
+    fmt.Printf("  %s join -output <archive.cbz> [options] <folder|archive.cbz>...\n", os.Args[0])
+    fmt.Println()
+    fmt.Println("REQUIRED:")
+    fmt.Println("  -output, -o  string          Archive the others are combined into")
+    fmt.Println()
+    fmt.Println("OPTIONS:")
+    fmt.Println("  -layout          string      How the archives are stored: [flat|folders] (default: flat)")
+    fmt.Println("  -order           string      Order they are joined in: [natural|input] (default: natural)")
+    fmt.Println("  -temp-dir        string      Where archives are unpacked while joined (default: system temp)")
+    fmt.Println("  -passwords       string      File of passwords tried in turn on encrypted archives, one per line")
+    fmt.Println("  -dumb,    -d                 Keep every entry instead of filtering like smart mode")
+    fmt.Println("  -compression, -c string      Compression mode to use. [none|default|fast|slow] (default: none)")
+    fmt.Println("  -overwrite       string      If the archive exists: [skip|always|if-different] (default: skip)")
+    fmt.Println("  -keep-replaced               Keep the replaced archive, so rollback can restore it")
+    fmt.Println("  -verify                      Read the archive back before moving it into place")
+    fmt.Println("  -cover           string      Glob for the file placed first as the cover, none keeps name order")
+    fmt.Println("  -comicinfo                   Fill in the combined ComicInfo.xml from the output name and series folder")
+    fmt.Println("  -title-pattern   string      Regular expression with number/title/volume/group groups")
+    fmt.Println("  -series-map      string      JSON file with series titles (implies -comicinfo)")
+    fmt.Println("  -progress        string      Progress display: [auto|bar|plain] (default: auto)")
+    fmt.Println("  -dry-run, -n                 List the archives that would be joined, in order")
+    fmt.Println()
+    fmt.Println("Folders are searched recursively for .cbz files, which are joined in natural")
+    fmt.Println("order of their names. Pages are numbered 0001, 0002, ... across all of them")
+    fmt.Println("with a manifest of the originals, and their ComicInfo.xml files combined into")
+    fmt.Println("one that keeps what they agree on.")
 }
 
+func showRenameUsage() {
+    fmt.Println("CBZ Converter - Rename an existing library to a new name template")
+    fmt.Println()
+    fmt.Println("USAGE:")
+    fmt.Printf("  %s rename -output <folder> -template <template> [options]\n", os.Args[0])
+    fmt.Printf("  %s rename -output <folder> -layout <layout> [options]\n", os.Args[0])
+    fmt.Println()
+    fmt.Println("REQUIRED:")
+    fmt.Println("  -output,   -o  string        Output library holding the archives")
+    fmt.Println("  -template, -t  string        Name template to apply")
+    fmt.Println("  -layout        string        Or the layout of a library server to apply: [komga|kavita]")
+    fmt.Println()
+    fmt.Println("OPTIONS:")
+    fmt.Println("  -dry-run,  -n                Show the renames without doing them")
+    fmt.Println("  -title-pattern   string      Regex with number/title/volume/group groups for folder names")
+    fmt.Println("  -special-pattern string      Regex matching the folder names of specials, for {special}")
+    fmt.Println("  -series-map      string      JSON file with series titles used for {series}")
+    fmt.Println("  -sanitize                    Rewrite names Windows and exFAT can't store")
+    fmt.Println("  -replace-char    string      Stands in for characters -sanitize removes (default: _)")
+    fmt.Println()
+    fmt.Println("TEMPLATES:")
+    fmt.Println("  {folder} {series} {number} {chapter} {volume} {title} {group}   Values of the archive")
+    fmt.Println("  {special}                    Folder name of a special (Omake, Extras, ...), empty otherwise")
+    fmt.Println("  {number:3}                   Number padded with zeros to 3 digits (also {volume:N})")
+    fmt.Println("  <...>                        Left out when a value inside it is empty")
+    fmt.Println("  /                            Starts a subdirectory")
+    fmt.Println("  |                            Separates alternatives, the first whose values outside <...>")
+    fmt.Println("                               are all there is used")
+    fmt.Println()
+    fmt.Println("  Example: \"{series}/{series} - c{number:3}< - {title}>\"")
+    fmt.Println()
+    fmt.Println("The values come from the folder each archive was converted from, as")
+    fmt.Println("recorded in the run history and the sync catalog; archives neither knows")
+    fmt.Println("use their ComicInfo.xml and current name. The catalog is updated to the")
+    fmt.Println("new names. Convert with the same -name-template or -layout afterwards.")
+}
+
+func showMigrateUsage() {
+    fmt.Println("CBZ Converter - Upgrade an existing library to new conventions")
+    fmt.Println()
+    fmt.Println("USAGE:")
+    fmt.Printf("  %s migrate -output <folder> [changes] [options]\n", os.Args[0])
+    fmt.Printf("  %s migrate -rollback <journal id>\n", os.Args[0])
+    fmt.Println()
+    fmt.Println("CHANGES (at least one):")
+    fmt.Println("  -template,    -t string      Move archives to the names of this template (see rename -help)")
+    fmt.Println("  -comicinfo                   Add a generated ComicInfo.xml to archives without one")
+    fmt.Println("  -compression, -c string      Store entries the way this mode does: [none|default|fast|slow]")
+    fmt.Println()
+    fmt.Println("OPTIONS:")
+    fmt.Println("  -dry-run,     -n             Show what would change without changing anything")
+    fmt.Println("  -title-pattern   string      Regex with number/title/volume/group groups for folder names")
+    fmt.Println("  -series-map      string      JSON file with series titles for names and ComicInfo.xml")
+    fmt.Println("  -keep-backups                Keep the original of every rewritten archive for rollback")
+    fmt.Println("  -rollback        string      Undo the migration with this journal ID")
+    fmt.Println()
+    fmt.Println("Every change is written to a journal in the state directory before it is")
+    fmt.Println("made. If one fails, or the migration is interrupted, everything done so")
+    fmt.Println("far is undone. A finished migration can be undone with rollback; rewritten")
+    fmt.Println("archives only if it ran with -keep-backups.")
+}
+
+func showRollbackUsage() {
+    fmt.Println("CBZ Converter - Undo what a run changed in its output directory")
+    fmt.Println()
+    fmt.Println("USAGE:")
+    fmt.Printf("  %s rollback [options] <run id>\n", os.Args[0])
+    fmt.Println()
+    fmt.Println("OPTIONS:")
+    fmt.Println("  -dry-run,     -n             Show what would be undone without undoing it")
+    fmt.Println()
+    fmt.Println("Runs, syncs, server jobs and migrations journal every archive they create,")
+    fmt.Println("replace, move or delete before doing so. Rolling back removes the archives")
+    fmt.Println("a run created and moves renamed ones back. Archives it replaced or deleted")
+    fmt.Println("come back only if their originals were kept (-keep-replaced, -keep-backups).")
+    fmt.Println("Source folders are only touched by -delete-source, their archives are kept,")
+    fmt.Println("and -trash-source, they come back from the trash where the OS allows.")
+}
+
+func showHistoryUsage() {
+    fmt.Println("CBZ Converter - Work with the recorded runs")
+    fmt.Println()
+    fmt.Println("USAGE:")
+    fmt.Printf("  %s history export [options]\n", os.Args[0])
+    fmt.Printf("  %s history import -output <library> [-input <dir>...] [options]\n", os.Args[0])
+    fmt.Printf("  %s history compare [-json] <run-a> <run-b>\n", os.Args[0])
+    fmt.Println()
+    fmt.Println("EXPORT OPTIONS:")
+    fmt.Println("  -file,  -f   string          Write to this file instead of stdout")
+    fmt.Println("  -format      string          csv or json (default: from the -file extension, csv otherwise)")
+    fmt.Println("  -since       string          Only runs started on or after this date (2006-01-02 or RFC 3339)")
+    fmt.Println("  -until       string          Only runs started on or before this date")
+    fmt.Println("  -input-root  string          Only folders inside this directory")
+    fmt.Println()
+    fmt.Println("IMPORT OPTIONS:")
+    fmt.Println("  -output,  -o string          Library holding the archives")
+    fmt.Println("  -input,   -i string          Folder the archives were converted from (can be repeated)")
+    fmt.Println("  -input-recursive string      Input whose subdirectories the archives came from")
+    fmt.Println("  -recursive, -r               Every -input holds the source folders")
+    fmt.Println("  -match       string          Match archives to folders by name or content (default: name)")
+    fmt.Println("  -dumb, -compression, -fingerprint  What later syncs will use, recorded in the catalog")
+    fmt.Println("  -catalog     string          Catalog file to seed (default: the one sync uses)")
+    fmt.Println("  -dry-run, -n                 Show the matches without recording them")
+    fmt.Println()
+    fmt.Println("Export writes every folder of every run, sync and server job with its run,")
+    fmt.Println("source, archive and result, and for converted folders the pages, sizes and")
+    fmt.Println("source fingerprint. Runs recorded by older versions have no sizes.")
+    fmt.Println()
+    fmt.Println("Import records the archives of a library converted before the history")
+    fmt.Println("existed, as one run, and adds them to the sync catalog, so -dry-run, rename")
+    fmt.Println("and sync know their sources. Archives already recorded are left alone.")
+    fmt.Println()
+    fmt.Println("Compare shows the folders, results, pages and sizes of two runs side by side,")
+    fmt.Println("and lists the folders that failed in the second run but not in the first and")
+    fmt.Println("those that failed in the first and went through in the second. Folders are")
+    fmt.Println("matched by their source path. -json prints the same as a JSON document.")
+}
+
+func showConfigUsage() {
+    fmt.Println("CBZ Converter - Move the configuration to another machine")
+    fmt.Println()
+    fmt.Println("USAGE:")
+    fmt.Printf("  %s config export [-file <bundle.json>] [options]\n", os.Args[0])
+    fmt.Printf("  %s config import [options] <bundle.json>\n", os.Args[0])
+    fmt.Println()
+    fmt.Println("EXPORT OPTIONS:")
+    fmt.Println("  -file,  -f   string          Write the bundle to this file instead of stdout")
+    fmt.Println("  -series-map  string          Series map to bundle besides those the profiles use (can be repeated)")
+    fmt.Println("  -ignore-root string          Library whose .cbzignore files are bundled (can be repeated)")
+    fmt.Println()
+    fmt.Println("IMPORT OPTIONS:")
+    fmt.Println("  -file,  -f   string          Bundle to import, - reads it from stdin")
+    fmt.Println("  -series-map-dir string       Where series maps go (default: series-maps in the configuration directory)")
+    fmt.Println("  -ignore-root string          Where a library is on this machine, as old=new (can be repeated)")
+    fmt.Println("  -dry-run, -n                 Show what would be written without writing it")
+    fmt.Println()
+    fmt.Println("A bundle is one JSON file holding the profiles file, the series maps the")
+    fmt.Println("profiles use and those given with -series-map, and the .cbzignore files of")
+    fmt.Println("the libraries given with -ignore-root. Import adds the profiles to the")
+    fmt.Println("profiles file, replacing those of the same name, and points them at where")
+    fmt.Println("their series maps were written. .cbzignore files go back into their library,")
+    fmt.Println("at its old path unless -ignore-root says where it is now. Password lists")
+    fmt.Println("aren't bundled.")
+}
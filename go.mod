@@ -3,3 +3,5 @@ module convert_cbz
 go 1.24.5
 
 require github.com/jelius-sama/logger v1.0.2
+
+require golang.org/x/text v0.28.0
@@ -0,0 +1,98 @@
+package calibre
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "runtime"
+    "strings"
+    "sync"
+)
+
+// outputLimit is how much of what a failing calibredb printed ends up in its error
+const outputLimit = 1024
+
+// installed are where the Calibre installers put calibredb when it isn't on the PATH
+var installed = map[string][]string{
+    "darwin":  {"/Applications/calibre.app/Contents/MacOS/calibredb"},
+    "windows": {`C:\Program Files\Calibre2\calibredb.exe`, `C:\Program Files (x86)\Calibre2\calibredb.exe`},
+}
+
+// Library is a Calibre library books are added to with calibredb
+type Library struct {
+    Path      string // Library folder, or the URL of a content server
+    calibredb string
+
+    // calibredb takes the library for itself, only one may run at a time
+    mu sync.Mutex
+}
+
+// Open checks that path is a Calibre library, or a content server URL like
+// http://localhost:8080#books, and finds calibredb to add books to it with
+func Open(path string) (*Library, error) {
+    if !strings.Contains(path, "://") {
+        abs, err := filepath.Abs(path)
+        if err != nil {
+            return nil, err
+        }
+        if _, err := os.Stat(filepath.Join(abs, "metadata.db")); err != nil {
+            return nil, fmt.Errorf("%s isn't a Calibre library, it has no metadata.db", path)
+        }
+        path = abs
+    }
+    calibredb, err := find()
+    if err != nil {
+        return nil, err
+    }
+    return &Library{Path: path, calibredb: calibredb}, nil
+}
+
+func find() (string, error) {
+    if path, err := exec.LookPath("calibredb"); err == nil {
+        return path, nil
+    }
+    for _, path := range installed[runtime.GOOS] {
+        if _, err := os.Stat(path); err == nil {
+            return path, nil
+        }
+    }
+    return "", errors.New("calibredb not found, install Calibre or put calibredb on the PATH")
+}
+
+// Add adds the archive at path as b. A book with the same title and authors
+// already there gets the archive instead of a copy, so an archive that was
+// rebuilt replaces the old one.
+func (l *Library) Add(path string, b Book) error {
+    args := []string{"add", "--with-library", l.Path, "--automerge", "overwrite", "--title", b.Title}
+    if b.Series != "" {
+        args = append(args, "--series", b.Series)
+        if b.SeriesIndex != "" {
+            args = append(args, "--series-index", b.SeriesIndex)
+        }
+    }
+    if len(b.Tags) > 0 {
+        args = append(args, "--tags", strings.Join(b.Tags, ","))
+    }
+    args = append(args, path)
+
+    l.mu.Lock()
+    out, err := exec.Command(l.calibredb, args...).CombinedOutput()
+    l.mu.Unlock()
+    if err != nil {
+        msg := strings.TrimSpace(string(out))
+        if len(msg) > outputLimit {
+            msg = "..." + msg[len(msg)-outputLimit:]
+        }
+        if msg != "" {
+            return fmt.Errorf("calibredb: %w: %s", err, msg)
+        }
+        return fmt.Errorf("calibredb: %w", err)
+    }
+    return nil
+}
+
+func (l *Library) String() string {
+    return l.Path
+}
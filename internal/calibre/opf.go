@@ -0,0 +1,108 @@
+package calibre
+
+import (
+    "convert_cbz/internal/comicinfo"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/xml"
+)
+
+// Book is what Calibre is told about an archive
+type Book struct {
+    ID          string // UUID, the same for every rebuild of the archive
+    Title       string
+    Series      string
+    SeriesIndex string // Chapter number, or the volume for a whole volume
+    Publisher   string
+    Tags        []string
+}
+
+// BookOf describes the archive called title, whose ComicInfo.xml is ci and
+// whose source files have the fingerprint fp
+func BookOf(ci *comicinfo.ComicInfo, title, fp string) Book {
+    b := Book{
+        ID:          uuidOf(fp + "\x00" + title),
+        Title:       title,
+        Series:      ci.Series,
+        SeriesIndex: ci.Number,
+        Publisher:   ci.Publisher,
+    }
+    if b.SeriesIndex == "" {
+        b.SeriesIndex = ci.Volume
+    }
+    // Calibre has tags only, genres are tags too
+    for _, list := range []string{ci.Genre, ci.Tags} {
+        b.Tags = append(b.Tags, comicinfo.SplitList(list)...)
+    }
+    return b
+}
+
+// uuidOf makes a version 5 style UUID out of s
+func uuidOf(s string) string {
+    sum := sha256.Sum256([]byte(s))
+    sum[6] = sum[6]&0x0f | 0x50
+    sum[8] = sum[8]&0x3f | 0x80
+    h := hex.EncodeToString(sum[:16])
+    return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+// The OPF 2.0 package Calibre writes as metadata.opf, with its calibre:
+// meta elements for the series
+type opfPackage struct {
+    XMLName  xml.Name    `xml:"package"`
+    XMLNS    string      `xml:"xmlns,attr"`
+    UniqueID string      `xml:"unique-identifier,attr"`
+    Version  string      `xml:"version,attr"`
+    Metadata opfMetadata `xml:"metadata"`
+    Guide    struct{}    `xml:"guide"`
+}
+
+type opfMetadata struct {
+    XMLNSDC    string        `xml:"xmlns:dc,attr"`
+    XMLNSOPF   string        `xml:"xmlns:opf,attr"`
+    Identifier opfIdentifier `xml:"dc:identifier"`
+    Title      string        `xml:"dc:title"`
+    Publisher  string        `xml:"dc:publisher,omitempty"`
+    Subjects   []string      `xml:"dc:subject"`
+    Meta       []opfMeta     `xml:"meta"`
+}
+
+type opfIdentifier struct {
+    ID     string `xml:"id,attr"`
+    Scheme string `xml:"opf:scheme,attr"`
+    Value  string `xml:",chardata"`
+}
+
+type opfMeta struct {
+    Name    string `xml:"name,attr"`
+    Content string `xml:"content,attr"`
+}
+
+// OPF renders b the way Calibre writes metadata.opf
+func (b Book) OPF() ([]byte, error) {
+    pkg := opfPackage{
+        XMLNS:    "http://www.idpf.org/2007/opf",
+        UniqueID: "uuid_id",
+        Version:  "2.0",
+        Metadata: opfMetadata{
+            XMLNSDC:    "http://purl.org/dc/elements/1.1/",
+            XMLNSOPF:   "http://www.idpf.org/2007/opf",
+            Identifier: opfIdentifier{ID: "uuid_id", Scheme: "uuid", Value: b.ID},
+            Title:      b.Title,
+            Publisher:  b.Publisher,
+            Subjects:   b.Tags,
+        },
+    }
+    if b.Series != "" {
+        pkg.Metadata.Meta = append(pkg.Metadata.Meta, opfMeta{Name: "calibre:series", Content: b.Series})
+        if b.SeriesIndex != "" {
+            pkg.Metadata.Meta = append(pkg.Metadata.Meta, opfMeta{Name: "calibre:series_index", Content: b.SeriesIndex})
+        }
+    }
+
+    data, err := xml.MarshalIndent(pkg, "", "  ")
+    if err != nil {
+        return nil, err
+    }
+    return append([]byte(xml.Header), append(data, '\n')...), nil
+}
@@ -0,0 +1,151 @@
+package collector
+
+import (
+    "convert_cbz/internal/pathnorm"
+    "convert_cbz/internal/types"
+    "convert_cbz/internal/util"
+    "fmt"
+    "os"
+    "path/filepath"
+
+    "github.com/jelius-sama/logger"
+)
+
+// Notice receives what collecting has to say about the inputs, with warning
+// set for the ones skipped. A nil Notice keeps quiet.
+type Notice func(warning bool, msg string)
+
+// Log is the Notice that logs to the terminal
+func Log(warning bool, msg string) {
+    if warning {
+        logger.Warning(msg)
+    } else {
+        logger.Info(msg)
+    }
+}
+
+func (n Notice) info(msg string) {
+    if n != nil {
+        n(false, msg)
+    }
+}
+
+func (n Notice) warn(msg string) {
+    if n != nil {
+        n(true, msg)
+    }
+}
+
+// CollectRecursive scans input directories for subdirectories (original behavior)
+func CollectRecursive(inputPaths []string, outputDir string, dumbMode bool) ([]types.WorkItem, error) {
+    return CollectRecursiveNotify(inputPaths, outputDir, dumbMode, Log)
+}
+
+// CollectRecursiveNotify is CollectRecursive telling notice instead of the terminal
+func CollectRecursiveNotify(inputPaths []string, outputDir string, dumbMode bool, notice Notice) ([]types.WorkItem, error) {
+    var workItems []types.WorkItem
+    seenPaths := make(map[string]bool) // Prevent duplicates
+
+    for _, inputPath := range inputPaths {
+        // Validate input directory exists
+        if _, err := os.Stat(inputPath); os.IsNotExist(err) {
+            notice.warn(fmt.Sprintf("Input directory does not exist, skipping: %s", inputPath))
+            continue
+        }
+
+        // Get subdirectories
+        folders, err := util.GetFolders(inputPath)
+        if err != nil {
+            notice.warn(fmt.Sprintf("Failed to read directory %s: %v", inputPath, err))
+            continue
+        }
+
+        notice.info(fmt.Sprintf("Input: %s (%d subdirectories)", inputPath, len(folders)))
+
+        // Create work items for each subdirectory
+        for _, folder := range folders {
+            sourcePath := filepath.Join(inputPath, folder)
+
+            // Get absolute path to avoid duplicates
+            absPath, err := filepath.Abs(sourcePath)
+            if err != nil {
+                notice.warn(fmt.Sprintf("Failed to resolve path %s: %v", sourcePath, err))
+                continue
+            }
+
+            // Skip if we've already seen this path
+            if seenPaths[absPath] {
+                continue
+            }
+            seenPaths[absPath] = true
+
+            outputPath := filepath.Join(outputDir, folder+".cbz")
+
+            workItems = append(workItems, types.WorkItem{
+                FolderName: folder,
+                SourcePath: absPath,
+                OutputPath: outputPath,
+                DumbMode:   dumbMode,
+            })
+        }
+    }
+
+    return dropCollisions(workItems, notice), nil
+}
+
+// CollectDirect converts specified directories directly
+func CollectDirect(inputPaths []string, outputDir string, dumbMode bool) ([]types.WorkItem, error) {
+    return CollectDirectNotify(inputPaths, outputDir, dumbMode, Log)
+}
+
+// CollectDirectNotify is CollectDirect telling notice instead of the terminal
+func CollectDirectNotify(inputPaths []string, outputDir string, dumbMode bool, notice Notice) ([]types.WorkItem, error) {
+    var workItems []types.WorkItem
+    seenPaths := make(map[string]bool) // Prevent duplicates
+
+    for _, inputPath := range inputPaths {
+        // Validate input directory exists
+        inputInfo, err := os.Stat(inputPath)
+        if os.IsNotExist(err) {
+            notice.warn(fmt.Sprintf("Input path does not exist, skipping: %s", inputPath))
+            continue
+        }
+
+        // Ensure it's a directory
+        if !inputInfo.IsDir() {
+            notice.warn(fmt.Sprintf("Input path is not a directory, skipping: %s", inputPath))
+            continue
+        }
+
+        // Get absolute path to avoid duplicates
+        absPath, err := filepath.Abs(inputPath)
+        if err != nil {
+            notice.warn(fmt.Sprintf("Failed to resolve path %s: %v", inputPath, err))
+            continue
+        }
+
+        // Skip if we've already seen this path, "Chapter 1" and "chapter 1" are the
+        // same folder on case-insensitive filesystems
+        if seenPaths[pathnorm.Key(absPath)] {
+            notice.warn(fmt.Sprintf("Duplicate path, skipping: %s", inputPath))
+            continue
+        }
+        seenPaths[pathnorm.Key(absPath)] = true
+
+        // Generate output filename from directory name
+        folderName := filepath.Base(absPath)
+        outputPath := filepath.Join(outputDir, folderName+".cbz")
+
+        notice.info(fmt.Sprintf("Input: %s", inputPath))
+
+        workItems = append(workItems, types.WorkItem{
+            FolderName: folderName,
+            SourcePath: absPath,
+            OutputPath: outputPath,
+            DumbMode:   dumbMode,
+        })
+    }
+
+    return dropCollisions(workItems, notice), nil
+}
+
@@ -0,0 +1,162 @@
+package collector
+
+import (
+    "convert_cbz/internal/pathnorm"
+    "convert_cbz/internal/types"
+    "fmt"
+    "path/filepath"
+    "strings"
+
+    "github.com/jelius-sama/logger"
+)
+
+// collisionPolicy is what dropOutputCollisions does, set by ConfigureCollisions
+var collisionPolicy = types.CollisionSkip
+
+// ConfigureCollisions makes the collection steps keep items whose outputs
+// collide for ResolveCollisions to deal with once every item is named, unless
+// policy is CollisionSkip
+func ConfigureCollisions(policy types.CollisionPolicy) {
+    collisionPolicy = policy
+}
+
+// dropOutputCollisions removes items whose output is the same file as an
+// earlier item's. On case-insensitive filesystems that includes names that
+// only differ by case, like "Chapter 1" and "chapter 1".
+func dropOutputCollisions(workItems []types.WorkItem) []types.WorkItem {
+    return dropCollisions(workItems, Log)
+}
+
+// dropCollisions is dropOutputCollisions telling notice what it skips
+func dropCollisions(workItems []types.WorkItem, notice Notice) []types.WorkItem {
+    if collisionPolicy != types.CollisionSkip {
+        return workItems
+    }
+    owners := make(map[string]types.WorkItem)
+    kept := workItems[:0]
+
+    for _, item := range workItems {
+        key := pathnorm.Key(item.OutputPath)
+        if first, ok := owners[key]; ok {
+            notice.warn(fmt.Sprintf("Output %s collides with %s (from %s), skipping: %s",
+                item.OutputPath, first.OutputPath, first.SourcePath, item.SourcePath))
+            continue
+        }
+        owners[key] = item
+        kept = append(kept, item)
+    }
+    return kept
+}
+
+// ResolveCollisions deals with items whose output is the same file as an
+// earlier item's the way policy says: CollisionSkip keeps the first one,
+// CollisionError fails naming every collision, CollisionSuffix adds the name
+// of the source's parent folder to the others, or a counter if that is taken
+// too, and CollisionMerge archives the folders together under the output.
+func ResolveCollisions(workItems []types.WorkItem, policy types.CollisionPolicy) ([]types.WorkItem, error) {
+    // Groups in the order their first item was found
+    var keys []string
+    groups := make(map[string][]int)
+    taken := make(map[string]bool)
+    for i, item := range workItems {
+        key := pathnorm.Key(item.OutputPath)
+        if _, ok := groups[key]; !ok {
+            keys = append(keys, key)
+        }
+        groups[key] = append(groups[key], i)
+        taken[key] = true
+    }
+
+    var clashes []string
+    drop := make(map[int]bool)
+    for _, key := range keys {
+        group := groups[key]
+        if len(group) < 2 {
+            continue
+        }
+        first := workItems[group[0]]
+        sources := make([]string, len(group))
+        for n, i := range group {
+            sources[n] = itemSource(workItems[i])
+        }
+
+        switch {
+        case policy == types.CollisionError:
+            clashes = append(clashes, fmt.Sprintf("%s from %s", first.OutputPath, strings.Join(sources, ", ")))
+        case policy == types.CollisionSuffix:
+            for n, i := range group[1:] {
+                out := freeOutput(workItems[i], n+2, taken)
+                taken[pathnorm.Key(out)] = true
+                logger.Warning(fmt.Sprintf("Output %s collides with %s, writing %s instead: %s",
+                    workItems[i].OutputPath, sources[0], filepath.Base(out), sources[n+1]))
+                workItems[i].OutputPath = out
+            }
+        case policy == types.CollisionMerge && mergeable(workItems, group):
+            items := make([]types.WorkItem, len(group))
+            for n, i := range group {
+                items[n] = workItems[i]
+                drop[i] = n > 0
+            }
+            workItems[group[0]] = mergeItems(items, first.FolderName, first.OutputPath)
+            logger.Info(fmt.Sprintf("Output %s collides, merging %d folders into it: %s",
+                first.OutputPath, len(group), strings.Join(sources, ", ")))
+        default:
+            if policy == types.CollisionMerge {
+                logger.Warning(fmt.Sprintf("Output %s collides, but only whole folders can be merged", first.OutputPath))
+            }
+            for n, i := range group[1:] {
+                logger.Warning(fmt.Sprintf("Output %s collides with %s, skipping: %s", first.OutputPath, sources[0], sources[n+1]))
+                drop[i] = true
+            }
+        }
+    }
+    if len(clashes) > 0 {
+        return nil, fmt.Errorf("inputs collide on %d outputs, -on-collision suffix or merge resolves them:\n  %s", len(clashes), strings.Join(clashes, "\n  "))
+    }
+
+    kept := workItems[:0]
+    for i, item := range workItems {
+        if !drop[i] {
+            kept = append(kept, item)
+        }
+    }
+    return kept, nil
+}
+
+// mergeable reports whether the items of group are whole folders, which
+// mergeItems can combine
+func mergeable(workItems []types.WorkItem, group []int) bool {
+    for _, i := range group {
+        item := workItems[i]
+        if item.Archive != "" || len(item.Parts) > 0 || len(item.PartArchives) > 0 || item.SplitPattern != "" {
+            return false
+        }
+    }
+    return true
+}
+
+// itemSource is what the user gave for item, the archive for repacked ones
+func itemSource(item types.WorkItem) string {
+    if item.Archive != "" {
+        return item.Archive
+    }
+    return item.SourcePath
+}
+
+// freeOutput is the output of item with the name of the folder its source is
+// in added, like "Chapter 1 (Scans B).cbz", or failing that " (n)", " (n+1)",
+// ... whichever isn't taken first
+func freeOutput(item types.WorkItem, n int, taken map[string]bool) string {
+    ext := filepath.Ext(item.OutputPath)
+    stem := strings.TrimSuffix(item.OutputPath, ext)
+    if parent := filepath.Base(filepath.Dir(itemSource(item))); parent != "." && parent != string(filepath.Separator) {
+        if out := fmt.Sprintf("%s (%s)%s", stem, parent, ext); !taken[pathnorm.Key(out)] {
+            return out
+        }
+    }
+    for ; ; n++ {
+        if out := fmt.Sprintf("%s (%d)%s", stem, n, ext); !taken[pathnorm.Key(out)] {
+            return out
+        }
+    }
+}
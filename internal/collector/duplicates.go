@@ -0,0 +1,162 @@
+package collector
+
+import (
+    "convert_cbz/internal/comicinfo"
+    "convert_cbz/internal/naming"
+    "convert_cbz/internal/types"
+    "fmt"
+    "io/fs"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "github.com/jelius-sama/logger"
+)
+
+// Candidate is one of the folders holding the same chapter
+type Candidate struct {
+    Item     types.WorkItem
+    Files    int
+    Bytes    int64
+    Modified time.Time // Newest file in the folder
+}
+
+// Chooser picks the candidates to keep for a chapter, by index. Returning
+// none keeps them all.
+type Chooser func(chapter string, candidates []Candidate) []int
+
+// ResolveDuplicates finds folders that hold the same chapter, parsed from
+// their names like -comicinfo does, and keeps the ones policy says. ask is
+// only used by DuplicatesAsk.
+func ResolveDuplicates(workItems []types.WorkItem, policy types.DuplicatePolicy, titles *comicinfo.TitleParser, series *comicinfo.SeriesMap, ask Chooser) []types.WorkItem {
+    if policy == types.DuplicatesKeep {
+        return workItems
+    }
+
+    // Groups in the order their first folder was found
+    var keys []string
+    groups := make(map[string][]int)
+    labels := make(map[string]string)
+    for i, item := range workItems {
+        f := naming.FieldsForItem(item.SourcePath, item.Series, item.Volume, titles, series)
+        if f.Number == "" {
+            continue
+        }
+        key := strings.Join([]string{strings.ToLower(f.Series), trimZeros(f.Volume), trimZeros(f.Number)}, "\x00")
+        if _, ok := groups[key]; !ok {
+            keys = append(keys, key)
+            labels[key] = chapterLabel(f)
+        }
+        groups[key] = append(groups[key], i)
+    }
+
+    drop := make(map[int]bool)
+    for _, key := range keys {
+        group := groups[key]
+        if len(group) < 2 {
+            continue
+        }
+
+        if policy == types.DuplicatesSuffix {
+            for n, i := range group[1:] {
+                workItems[i].NameSuffix = fmt.Sprintf(" (%d)", n+2)
+            }
+            logger.Warning(fmt.Sprintf("%s is in %d folders, keeping them all", labels[key], len(group)))
+            continue
+        }
+
+        candidates := make([]Candidate, len(group))
+        for n, i := range group {
+            candidates[n] = candidate(workItems[i])
+        }
+        var keep []int
+        switch policy {
+        case types.DuplicatesLarger:
+            keep = []int{best(candidates, func(a, b Candidate) bool { return a.Bytes > b.Bytes })}
+        case types.DuplicatesNewer:
+            keep = []int{best(candidates, func(a, b Candidate) bool { return a.Modified.After(b.Modified) })}
+        case types.DuplicatesAsk:
+            if ask != nil {
+                keep = ask(labels[key], candidates)
+            }
+        }
+        if len(keep) == 0 {
+            logger.Warning(fmt.Sprintf("%s is in %d folders, keeping them all", labels[key], len(group)))
+            continue
+        }
+
+        kept := make(map[int]bool)
+        for _, n := range keep {
+            kept[n] = true
+        }
+        var names []string
+        for n, i := range group {
+            if kept[n] {
+                names = append(names, workItems[i].FolderName)
+            } else {
+                drop[i] = true
+            }
+        }
+        logger.Warning(fmt.Sprintf("%s is in %d folders, keeping %s", labels[key], len(group), strings.Join(names, ", ")))
+    }
+
+    kept := workItems[:0]
+    for i, item := range workItems {
+        if !drop[i] {
+            kept = append(kept, item)
+        }
+    }
+    return kept
+}
+
+// best returns the index of the first candidate no other one beats
+func best(candidates []Candidate, better func(a, b Candidate) bool) int {
+    winner := 0
+    for i := 1; i < len(candidates); i++ {
+        if better(candidates[i], candidates[winner]) {
+            winner = i
+        }
+    }
+    return winner
+}
+
+func candidate(item types.WorkItem) Candidate {
+    c := Candidate{Item: item}
+    filepath.WalkDir(item.SourcePath, func(path string, d fs.DirEntry, err error) error {
+        if err != nil || d.IsDir() {
+            return nil
+        }
+        info, err := d.Info()
+        if err != nil {
+            return nil
+        }
+        c.Files++
+        c.Bytes += info.Size()
+        if info.ModTime().After(c.Modified) {
+            c.Modified = info.ModTime()
+        }
+        return nil
+    })
+    return c
+}
+
+// chapterLabel names a chapter in log lines and prompts, "Berserk v2 #12"
+func chapterLabel(f naming.Fields) string {
+    label := f.Series
+    if f.Volume != "" {
+        label += " v" + f.Volume
+    }
+    return strings.TrimSpace(label + " #" + f.Number)
+}
+
+// trimZeros makes "012" and "12" the same number
+func trimZeros(n string) string {
+    if n == "" {
+        return ""
+    }
+    t := strings.TrimLeft(n, "0")
+    if t == "" || t[0] == '.' {
+        t = "0" + t
+    }
+    return t
+}
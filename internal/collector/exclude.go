@@ -0,0 +1,85 @@
+package collector
+
+import (
+    "convert_cbz/internal/ignore"
+    "convert_cbz/internal/types"
+    "convert_cbz/internal/util"
+    "fmt"
+    "path/filepath"
+    "strings"
+
+    "github.com/jelius-sama/logger"
+)
+
+// ExcludeDirs drops the work items whose folder, or a folder it is in, below
+// one of roots matches one of patterns like -exclude-dir matches subfolders of
+// a source folder. Items outside every root are kept.
+func ExcludeDirs(workItems []types.WorkItem, roots, patterns []string) []types.WorkItem {
+    if len(patterns) == 0 {
+        return workItems
+    }
+    var kept []types.WorkItem
+    for _, item := range workItems {
+        if rel, ok := belowRoot(item.SourcePath, roots); ok && excludedDir(rel, patterns) {
+            continue
+        }
+        kept = append(kept, item)
+    }
+    if skipped := len(workItems) - len(kept); skipped > 0 {
+        logger.Info(fmt.Sprintf("Skipped %d folders matching -exclude-dir", skipped))
+    }
+    return kept
+}
+
+// Ignored drops the work items whose folder the .cbzignore files between
+// the one of roots it is in and the folder itself ignore
+func Ignored(workItems []types.WorkItem, roots []string) []types.WorkItem {
+    var kept []types.WorkItem
+    for _, item := range workItems {
+        rules, err := ignore.ForFolder(item.SourcePath, roots)
+        if err != nil {
+            logger.Warning(fmt.Sprintf("Failed to read %s for %s: %v", ignore.FileName, item.SourcePath, err))
+        } else if rules.Match(item.SourcePath, true) {
+            continue
+        }
+        kept = append(kept, item)
+    }
+    if skipped := len(workItems) - len(kept); skipped > 0 {
+        logger.Info(fmt.Sprintf("Skipped %d folders ignored by %s", skipped, ignore.FileName))
+    }
+    return kept
+}
+
+// belowRoot is the slash separated path of dir below the first of roots it
+// is in
+func belowRoot(dir string, roots []string) (string, bool) {
+    for _, root := range roots {
+        abs, err := filepath.Abs(root)
+        if err != nil {
+            continue
+        }
+        rel, err := filepath.Rel(abs, dir)
+        if err == nil && rel != "." && filepath.IsLocal(rel) {
+            return filepath.ToSlash(rel), true
+        }
+    }
+    return "", false
+}
+
+// excludedDir reports whether rel or one of the folders it is in matches one
+// of patterns
+func excludedDir(rel string, patterns []string) bool {
+    if len(patterns) == 0 {
+        return false
+    }
+    segments := strings.Split(rel, "/")
+    for i := range segments {
+        prefix := strings.Join(segments[:i+1], "/")
+        for _, pattern := range patterns {
+            if util.MatchPath(pattern, prefix) {
+                return true
+            }
+        }
+    }
+    return false
+}
@@ -0,0 +1,91 @@
+package collector
+
+import (
+    "convert_cbz/internal/comicinfo"
+    "convert_cbz/internal/pathnorm"
+    "convert_cbz/internal/types"
+    "convert_cbz/internal/util"
+    "fmt"
+    "os"
+    "path/filepath"
+
+    "github.com/jelius-sama/logger"
+)
+
+// CollectLibrary scans inputs laid out as Library/Series/Volume/Chapter and
+// returns one work item per chapter, written to <output>/<series>/<chapter>.cbz
+// with the series and volume set. A volume folder without chapter folders is
+// converted as a whole.
+func CollectLibrary(inputPaths []string, outputDir string, dumbMode bool) ([]types.WorkItem, error) {
+    var workItems []types.WorkItem
+    seenPaths := make(map[string]bool)
+
+    add := func(sourcePath, series, volume string) {
+        absPath, err := filepath.Abs(sourcePath)
+        if err != nil {
+            logger.Warning(fmt.Sprintf("Failed to resolve path %s: %v", sourcePath, err))
+            return
+        }
+        if seenPaths[pathnorm.Key(absPath)] {
+            return
+        }
+        seenPaths[pathnorm.Key(absPath)] = true
+
+        folder := filepath.Base(absPath)
+        workItems = append(workItems, types.WorkItem{
+            FolderName: folder,
+            SourcePath: absPath,
+            OutputPath: filepath.Join(outputDir, series, folder+".cbz"),
+            DumbMode:   dumbMode,
+            Series:     series,
+            Volume:     comicinfo.ParseVolume(volume),
+        })
+    }
+
+    for _, inputPath := range inputPaths {
+        if _, err := os.Stat(inputPath); os.IsNotExist(err) {
+            logger.Warning(fmt.Sprintf("Input directory does not exist, skipping: %s", inputPath))
+            continue
+        }
+
+        seriesFolders, err := util.GetFolders(inputPath)
+        if err != nil {
+            logger.Warning(fmt.Sprintf("Failed to read directory %s: %v", inputPath, err))
+            continue
+        }
+
+        chapters := 0
+        for _, series := range seriesFolders {
+            seriesPath := filepath.Join(inputPath, series)
+            volumes, err := util.GetFolders(seriesPath)
+            if err != nil {
+                logger.Warning(fmt.Sprintf("Failed to read directory %s: %v", seriesPath, err))
+                continue
+            }
+
+            for _, volume := range volumes {
+                volumePath := filepath.Join(seriesPath, volume)
+                folders, err := util.GetFolders(volumePath)
+                if err != nil {
+                    logger.Warning(fmt.Sprintf("Failed to read directory %s: %v", volumePath, err))
+                    continue
+                }
+
+                // Some volumes were never split into chapters
+                if len(folders) == 0 {
+                    add(volumePath, series, volume)
+                    chapters++
+                    continue
+                }
+                for _, chapter := range folders {
+                    add(filepath.Join(volumePath, chapter), series, volume)
+                    chapters++
+                }
+            }
+        }
+
+        logger.Info(fmt.Sprintf("Input: %s (%d series, %d chapters)", inputPath, len(seriesFolders), chapters))
+    }
+
+    return dropOutputCollisions(workItems), nil
+}
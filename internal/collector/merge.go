@@ -0,0 +1,61 @@
+package collector
+
+import (
+    "convert_cbz/internal/types"
+    "convert_cbz/internal/util"
+    "fmt"
+    "path/filepath"
+    "sort"
+    "strings"
+
+    "github.com/jelius-sama/logger"
+)
+
+// Merge combines workItems into one item whose archive is name in outputDir,
+// holding the folders of all of them in order
+func Merge(workItems []types.WorkItem, outputDir, name string, order types.MergeOrder) []types.WorkItem {
+    if len(workItems) == 0 {
+        return nil
+    }
+    if order == types.MergeNatural {
+        sort.SliceStable(workItems, func(i, j int) bool {
+            return util.NaturalLess(filepath.Base(workItems[i].SourcePath), filepath.Base(workItems[j].SourcePath))
+        })
+    }
+
+    name = strings.TrimSuffix(name, filepath.Ext(name))
+    merged := mergeItems(workItems, name, filepath.Join(outputDir, name+".cbz"))
+    logger.Info(fmt.Sprintf("Merging %d folders into %s", len(merged.Parts), filepath.Base(merged.OutputPath)))
+    return []types.WorkItem{merged}
+}
+
+// mergeItems is the item combining the folders of workItems, in the order
+// given, into outputPath. The series and volume are kept when every folder
+// agrees on them.
+func mergeItems(workItems []types.WorkItem, name, outputPath string) types.WorkItem {
+    merged := types.WorkItem{
+        FolderName: name,
+        OutputPath: outputPath,
+        DumbMode:   workItems[0].DumbMode,
+        Series:     workItems[0].Series,
+        Volume:     workItems[0].Volume,
+    }
+    parents := make(map[string]bool)
+    for _, item := range workItems {
+        merged.Parts = append(merged.Parts, item.SourcePath)
+        parents[filepath.Dir(item.SourcePath)] = true
+        if item.Series != merged.Series {
+            merged.Series = ""
+        }
+        if item.Volume != merged.Volume {
+            merged.Volume = ""
+        }
+    }
+    merged.SourcePath = CommonParent(merged.Parts)
+
+    // Chapters of one series folder, the series is still that folder
+    if merged.Series == "" && len(parents) == 1 {
+        merged.Series = filepath.Base(merged.SourcePath)
+    }
+    return merged
+}
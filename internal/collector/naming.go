@@ -0,0 +1,121 @@
+package collector
+
+import (
+    "convert_cbz/internal/comicinfo"
+    "convert_cbz/internal/naming"
+    "convert_cbz/internal/pathnorm"
+    "convert_cbz/internal/types"
+    "convert_cbz/internal/util"
+    "fmt"
+    "path/filepath"
+    "strings"
+
+    "github.com/jelius-sama/logger"
+)
+
+// ApplyTemplate names the outputs of workItems with tmpl instead of after
+// their folder. Items the template gives no usable name are skipped.
+func ApplyTemplate(workItems []types.WorkItem, outputDir string, tmpl *naming.Template, titles *comicinfo.TitleParser, series *comicinfo.SeriesMap) []types.WorkItem {
+    if tmpl == nil || tmpl.String() == naming.Default {
+        return workItems
+    }
+
+    named := workItems[:0]
+    used := make(map[string]bool)
+    for _, item := range workItems {
+        out, err := tmpl.Path(outputDir, naming.FieldsForItem(item.SourcePath, item.Series, item.Volume, titles, series))
+        if err != nil {
+            logger.Warning(fmt.Sprintf("%v, skipping: %s", err, item.SourcePath))
+            continue
+        }
+        // A chapter kept twice gets its number instead of colliding
+        if item.NameSuffix != "" && used[pathnorm.Key(out)] {
+            out = strings.TrimSuffix(out, filepath.Ext(out)) + item.NameSuffix + filepath.Ext(out)
+        }
+        used[pathnorm.Key(out)] = true
+        item.OutputPath = out
+        named = append(named, item)
+    }
+    return dropOutputCollisions(named)
+}
+
+// Normalize puts the output names of workItems below outputDir into the
+// Unicode form of mode. Names that end up the same keep only their first item.
+func Normalize(workItems []types.WorkItem, outputDir string, mode types.NormMode) []types.WorkItem {
+    if mode == types.NormNone {
+        return workItems
+    }
+
+    for i, item := range workItems {
+        workItems[i].OutputPath = util.NormalizeUnder(outputDir, item.OutputPath, mode)
+    }
+    return dropOutputCollisions(workItems)
+}
+
+// Sanitize rewrites the output names of workItems below outputDir that
+// Windows and exFAT can't store, with s. Names that end up the same keep
+// only their first item.
+func Sanitize(workItems []types.WorkItem, outputDir string, s *naming.Sanitizer) []types.WorkItem {
+    if s == nil {
+        return workItems
+    }
+
+    for i, item := range workItems {
+        workItems[i].OutputPath = s.Under(outputDir, item.OutputPath)
+    }
+    return dropOutputCollisions(workItems)
+}
+
+// Mirror places the output of every item at the path of its source relative
+// to the deepest folder all of inputPaths are in, so same-named folders from
+// different inputs no longer meet in one flat directory:
+//
+//	-input /srv/a/mangas -input /srv/b/mangas
+//	/srv/a/mangas/Chapter 01 → <output>/a/mangas/Chapter 01.cbz
+func Mirror(workItems []types.WorkItem, inputPaths []string, outputDir string) []types.WorkItem {
+    base := CommonParent(inputPaths)
+    for i, item := range workItems {
+        rel, err := filepath.Rel(base, item.SourcePath)
+        if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+            // Only a source outside every input gets here, keep its flat name
+            continue
+        }
+        workItems[i].OutputPath = filepath.Join(outputDir, rel+".cbz")
+    }
+    return dropOutputCollisions(workItems)
+}
+
+// InPlace moves the output of every item next to its source folder, keeping
+// the name it was given. Items are collected and mirrored under the common
+// parent of the inputs first, so sanitizing never renames a source folder.
+func InPlace(workItems []types.WorkItem) []types.WorkItem {
+    for i, item := range workItems {
+        workItems[i].OutputPath = filepath.Join(filepath.Dir(item.SourcePath), filepath.Base(item.OutputPath))
+    }
+    return dropOutputCollisions(workItems)
+}
+
+// CommonParent is the deepest folder that holds every one of paths
+func CommonParent(paths []string) string {
+    var common []string
+    for i, p := range paths {
+        abs, err := filepath.Abs(p)
+        if err != nil {
+            abs = p
+        }
+        parts := strings.Split(filepath.Dir(abs), string(filepath.Separator))
+        if i == 0 {
+            common = parts
+            continue
+        }
+        n := 0
+        for n < len(common) && n < len(parts) && common[n] == parts[n] {
+            n++
+        }
+        common = common[:n]
+    }
+    if len(common) == 1 && common[0] == "" {
+        return string(filepath.Separator)
+    }
+    return strings.Join(common, string(filepath.Separator))
+}
@@ -0,0 +1,140 @@
+package collector
+
+import (
+    "convert_cbz/internal/comicinfo"
+    "convert_cbz/internal/pathnorm"
+    "convert_cbz/internal/types"
+    "convert_cbz/internal/util"
+    "fmt"
+    "os"
+    "path"
+    "path/filepath"
+    "strings"
+
+    "github.com/jelius-sama/logger"
+)
+
+// CollectNested scans input directories as deep as their folders go, where
+// CollectRecursive stops at the first level. Leaf folders, the ones without
+// subfolders, are the chapters: flatten writes each to <output>/<path below
+// the input joined by " - ">.cbz, mirror to the same folders below the
+// output, and merge-volumes converts every folder holding only chapters as
+// one archive, named like flatten names them. Three levels down the folders
+// above a chapter are taken as its series and volume. maxDepth stops the
+// walk that many levels below the input, the folders there are converted
+// whole like leaves, zero goes as deep as they go. Folders matching exclude
+// aren't walked and don't count as subfolders.
+func CollectNested(inputPaths []string, outputDir string, dumbMode bool, mode types.NestedMode, maxDepth int, exclude []string) ([]types.WorkItem, error) {
+    var workItems []types.WorkItem
+    seenPaths := make(map[string]bool)
+
+    for _, inputPath := range inputPaths {
+        if _, err := os.Stat(inputPath); os.IsNotExist(err) {
+            logger.Warning(fmt.Sprintf("Input directory does not exist, skipping: %s", inputPath))
+            continue
+        }
+
+        found := 0
+        add := func(sourcePath string, rel []string, leaf bool) {
+            absPath, err := filepath.Abs(sourcePath)
+            if err != nil {
+                logger.Warning(fmt.Sprintf("Failed to resolve path %s: %v", sourcePath, err))
+                return
+            }
+            if seenPaths[pathnorm.Key(absPath)] {
+                return
+            }
+            seenPaths[pathnorm.Key(absPath)] = true
+
+            item := types.WorkItem{
+                FolderName: rel[len(rel)-1],
+                SourcePath: absPath,
+                OutputPath: filepath.Join(outputDir, strings.Join(rel, " - ")+".cbz"),
+                DumbMode:   dumbMode,
+            }
+            if mode == types.NestedMirror {
+                item.OutputPath = filepath.Join(outputDir, filepath.Join(rel...)+".cbz")
+            }
+            switch {
+            case leaf && len(rel) >= 3:
+                item.Series, item.Volume = rel[len(rel)-3], comicinfo.ParseVolume(rel[len(rel)-2])
+            case !leaf && len(rel) >= 2:
+                item.Series, item.Volume = rel[len(rel)-2], comicinfo.ParseVolume(rel[len(rel)-1])
+            }
+            workItems = append(workItems, item)
+            found++
+        }
+
+        var walk func(dir string, rel []string) error
+        walk = func(dir string, rel []string) error {
+            folders, err := nestedFolders(dir, rel, exclude)
+            if err != nil {
+                return err
+            }
+            if len(folders) == 0 {
+                add(dir, rel, true)
+                return nil
+            }
+            if mode == types.NestedMergeVolumes && allLeaves(dir, rel, folders, exclude) {
+                add(dir, rel, false)
+                return nil
+            }
+            if maxDepth > 0 && len(rel) >= maxDepth {
+                add(dir, rel, true)
+                return nil
+            }
+            for _, folder := range folders {
+                if err := walk(filepath.Join(dir, folder), append(rel[:len(rel):len(rel)], folder)); err != nil {
+                    logger.Warning(fmt.Sprintf("Failed to read directory %s: %v", filepath.Join(dir, folder), err))
+                }
+            }
+            return nil
+        }
+
+        folders, err := nestedFolders(inputPath, nil, exclude)
+        if err != nil {
+            logger.Warning(fmt.Sprintf("Failed to read directory %s: %v", inputPath, err))
+            continue
+        }
+        for _, folder := range folders {
+            if err := walk(filepath.Join(inputPath, folder), []string{folder}); err != nil {
+                logger.Warning(fmt.Sprintf("Failed to read directory %s: %v", filepath.Join(inputPath, folder), err))
+            }
+        }
+        if maxDepth > 0 {
+            logger.Info(fmt.Sprintf("Input: %s (%d folders, nested %s, max depth %d)", inputPath, found, mode, maxDepth))
+        } else {
+            logger.Info(fmt.Sprintf("Input: %s (%d folders, nested %s)", inputPath, found, mode))
+        }
+    }
+
+    return dropOutputCollisions(workItems), nil
+}
+
+// nestedFolders lists the subfolders of dir, at rel below the input, that
+// can hold chapters, leaving out hidden ones, the __MACOSX folders zip tools
+// leave behind and the ones matching exclude
+func nestedFolders(dir string, rel, exclude []string) ([]string, error) {
+    folders, err := util.GetFolders(dir)
+    if err != nil {
+        return nil, err
+    }
+    kept := folders[:0]
+    for _, f := range folders {
+        if !strings.HasPrefix(f, ".") && f != "__MACOSX" && !excludedDir(path.Join(append(rel[:len(rel):len(rel)], f)...), exclude) {
+            kept = append(kept, f)
+        }
+    }
+    return kept, nil
+}
+
+// allLeaves reports whether none of the folders in dir has subfolders
+func allLeaves(dir string, rel, folders, exclude []string) bool {
+    for _, f := range folders {
+        sub, err := nestedFolders(filepath.Join(dir, f), append(rel[:len(rel):len(rel)], f), exclude)
+        if err != nil || len(sub) > 0 {
+            return false
+        }
+    }
+    return true
+}
@@ -0,0 +1,63 @@
+package collector
+
+import (
+    "convert_cbz/internal/types"
+    "fmt"
+    "path/filepath"
+    "strings"
+
+    "github.com/jelius-sama/logger"
+)
+
+// SplitBySize splits every item whose pages go over maxPages or maxBytes
+// into archives holding at most that many, "Name (Part 1).cbz", "Name (Part
+// 2).cbz" and so on, filled in page order. sizes lists the pages of an item
+// with what each takes up in the archive. Zero disables either limit.
+func SplitBySize(workItems []types.WorkItem, maxPages int, maxBytes int64, sizes func(types.WorkItem) ([]int64, error)) []types.WorkItem {
+    var split []types.WorkItem
+    for _, item := range workItems {
+        pages, err := sizes(item)
+        if err != nil {
+            // The conversion fails it with the same error
+            split = append(split, item)
+            continue
+        }
+        ranges := partRanges(pages, maxPages, maxBytes)
+        if len(ranges) < 2 {
+            split = append(split, item)
+            continue
+        }
+
+        ext := filepath.Ext(item.OutputPath)
+        for i, r := range ranges {
+            suffix := fmt.Sprintf(" (Part %d)", i+1)
+            p := item
+            p.FolderName += suffix
+            p.OutputPath = strings.TrimSuffix(item.OutputPath, ext) + suffix + ext
+            p.PageRange = r
+            split = append(split, p)
+
+            if r[1]-r[0] == 1 && maxBytes > 0 && pages[r[0]] > maxBytes {
+                logger.Warning(fmt.Sprintf("A page of %s is larger than -max-size on its own, part %d goes over it", item.FolderName, i+1))
+            }
+        }
+        logger.Info(fmt.Sprintf("Split %s into %d parts", item.FolderName, len(ranges)))
+    }
+    return dropOutputCollisions(split)
+}
+
+// partRanges cuts pages into runs of at most maxPages pages and maxBytes
+// bytes, a page larger than maxBytes gets a part of its own
+func partRanges(pages []int64, maxPages int, maxBytes int64) [][2]int {
+    var ranges [][2]int
+    first, bytes := 0, int64(0)
+    for i, size := range pages {
+        full := maxPages > 0 && i-first >= maxPages || maxBytes > 0 && i > first && bytes+size > maxBytes
+        if full {
+            ranges = append(ranges, [2]int{first, i})
+            first, bytes = i, 0
+        }
+        bytes += size
+    }
+    return append(ranges, [2]int{first, len(pages)})
+}
@@ -0,0 +1,67 @@
+package collector
+
+import (
+    "convert_cbz/internal/types"
+    "io"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// LooseRoot is a recursive input with no subfolders but images of its own,
+// most likely a single chapter pointed at with -recursive
+type LooseRoot struct {
+    Path   string
+    Images int
+}
+
+// LooseRoots returns the inputs that recursive mode would find nothing to
+// convert in, although they hold images directly
+func LooseRoots(inputPaths []string) []LooseRoot {
+    var roots []LooseRoot
+    for _, inputPath := range inputPaths {
+        entries, err := os.ReadDir(inputPath)
+        if err != nil {
+            continue
+        }
+
+        images := 0
+        for _, e := range entries {
+            if e.IsDir() {
+                images = 0
+                break
+            }
+            if e.Type().IsRegular() && isImage(filepath.Join(inputPath, e.Name())) {
+                images++
+            }
+        }
+        if images > 0 {
+            roots = append(roots, LooseRoot{Path: inputPath, Images: images})
+        }
+    }
+    return roots
+}
+
+// AddDirect converts inputPaths directly, like CollectDirect does, next to
+// the work items recursive mode found
+func AddDirect(workItems []types.WorkItem, inputPaths []string, outputDir string, dumbMode bool) []types.WorkItem {
+    direct, _ := CollectDirect(inputPaths, outputDir, dumbMode)
+    return dropOutputCollisions(append(workItems, direct...))
+}
+
+// isImage sniffs the head of a file, like smart mode does
+func isImage(path string) bool {
+    f, err := os.Open(path)
+    if err != nil {
+        return false
+    }
+    defer f.Close()
+
+    head := make([]byte, 512)
+    n, err := io.ReadFull(f, head)
+    if err != nil && err != io.ErrUnexpectedEOF {
+        return false
+    }
+    return strings.HasPrefix(http.DetectContentType(head[:n]), "image/")
+}
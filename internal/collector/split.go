@@ -0,0 +1,81 @@
+package collector
+
+import (
+    "convert_cbz/internal/types"
+    "convert_cbz/internal/util"
+    "fmt"
+    "io/fs"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strings"
+
+    "github.com/jelius-sama/logger"
+)
+
+// Split turns every folder holding the files of several chapters into one
+// item per chapter, by what pattern captures from the file names: c001_p001.jpg
+// and c001_p002.jpg go into c001.cbz, in a folder named like the archive of
+// the whole folder would be. The folder is the series. Folders where nothing
+// matches are converted as they are.
+func Split(workItems []types.WorkItem, pattern *regexp.Regexp) []types.WorkItem {
+    var split []types.WorkItem
+    for _, item := range workItems {
+        chapters, unmatched, err := splitKeys(item.SourcePath, pattern)
+        if err != nil {
+            logger.Warning(fmt.Sprintf("Failed to read %s for -split-by-pattern, converting it whole: %v", item.SourcePath, err))
+            split = append(split, item)
+            continue
+        }
+        if len(chapters) == 0 {
+            logger.Warning(fmt.Sprintf("No file of %s matches -split-by-pattern, converting it whole", item.FolderName))
+            split = append(split, item)
+            continue
+        }
+        if unmatched > 0 {
+            logger.Warning(fmt.Sprintf("%d files of %s match no chapter and are left out", unmatched, item.FolderName))
+        }
+
+        dir := strings.TrimSuffix(item.OutputPath, filepath.Ext(item.OutputPath))
+        for _, chapter := range chapters {
+            c := item
+            c.FolderName = chapter
+            c.OutputPath = filepath.Join(dir, chapter+".cbz")
+            c.SplitPattern = pattern.String()
+            c.Chapter = chapter
+            if c.Series == "" {
+                c.Series = item.FolderName
+            }
+            split = append(split, c)
+        }
+        logger.Info(fmt.Sprintf("Split %s into %d chapters", item.FolderName, len(chapters)))
+    }
+    return dropOutputCollisions(split)
+}
+
+// splitKeys lists the chapters pattern finds among the files below dir, in
+// natural order, and counts the files it finds none in
+func splitKeys(dir string, pattern *regexp.Regexp) ([]string, int, error) {
+    seen := make(map[string]bool)
+    var chapters []string
+    unmatched := 0
+    err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        if d.IsDir() {
+            return nil
+        }
+        key := util.SplitKey(pattern, d.Name())
+        switch {
+        case key == "":
+            unmatched++
+        case !seen[key]:
+            seen[key] = true
+            chapters = append(chapters, key)
+        }
+        return nil
+    })
+    sort.SliceStable(chapters, func(i, j int) bool { return util.NaturalLess(chapters[i], chapters[j]) })
+    return chapters, unmatched, err
+}
@@ -0,0 +1,61 @@
+package collector
+
+import (
+    "convert_cbz/internal/comicinfo"
+    "convert_cbz/internal/naming"
+    "convert_cbz/internal/types"
+    "convert_cbz/internal/util"
+    "fmt"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+
+    "github.com/jelius-sama/logger"
+)
+
+// DefaultVolumeName names the archives of -volumes-of
+const DefaultVolumeName = "{series} v{volume:2}"
+
+// Volumes bundles the chapter folders of every series into volumes of size
+// chapters each, in natural order, the last one taking what is left. The
+// chapters of a series are the folders in the same directory. Every volume
+// is merged like -merge merges folders, into the archive tmpl names from the
+// series, the volume's number and its first chapter.
+func Volumes(workItems []types.WorkItem, outputDir string, size int, tmpl *naming.Template, titles *comicinfo.TitleParser, series *comicinfo.SeriesMap) []types.WorkItem {
+    groups := make(map[string][]types.WorkItem)
+    var dirs []string
+    for _, item := range workItems {
+        dir := filepath.Dir(item.SourcePath)
+        if _, ok := groups[dir]; !ok {
+            dirs = append(dirs, dir)
+        }
+        groups[dir] = append(groups[dir], item)
+    }
+
+    var volumes []types.WorkItem
+    for _, dir := range dirs {
+        chapters := groups[dir]
+        sort.SliceStable(chapters, func(i, j int) bool {
+            return util.NaturalLess(filepath.Base(chapters[i].SourcePath), filepath.Base(chapters[j].SourcePath))
+        })
+
+        count := 0
+        for first := 0; first < len(chapters); first += size {
+            chunk := chapters[first:min(first+size, len(chapters))]
+            volume := strconv.Itoa(first/size + 1)
+            f := naming.FieldsForItem(chunk[0].SourcePath, chunk[0].Series, volume, titles, series)
+            out, err := tmpl.Path(outputDir, f)
+            if err != nil {
+                logger.Warning(fmt.Sprintf("%v, skipping volume %s of %s", err, volume, dir))
+                continue
+            }
+            v := mergeItems(chunk, strings.TrimSuffix(filepath.Base(out), filepath.Ext(out)), out)
+            v.Volume = volume
+            volumes = append(volumes, v)
+            count++
+        }
+        logger.Info(fmt.Sprintf("Bundling %d chapters of %s into %d volumes", len(chapters), filepath.Base(dir), count))
+    }
+    return dropOutputCollisions(volumes)
+}
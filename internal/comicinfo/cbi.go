@@ -0,0 +1,36 @@
+package comicinfo
+
+import "strconv"
+
+// ComicBookInfoKey is the key the ComicBookInfo 1.0 fields are under in the
+// JSON object readers of the ComicBookLover lineage expect as the zip comment
+const ComicBookInfoKey = "ComicBookInfo/1.0"
+
+// ComicBookInfo is the subset of ComicBookInfo 1.0 that ComicInfo.xml has
+// the values for
+type ComicBookInfo struct {
+    Series         string   `json:"series,omitempty"`
+    Title          string   `json:"title,omitempty"`
+    Publisher      string   `json:"publisher,omitempty"`
+    Issue          string   `json:"issue,omitempty"`
+    NumberOfIssues int      `json:"numberOfIssues,omitempty"`
+    Volume         int      `json:"volume,omitempty"`
+    Genre          string   `json:"genre,omitempty"`
+    Tags           []string `json:"tags,omitempty"`
+}
+
+// ToComicBookInfo carries the fields of ci over. The format only has whole
+// volume numbers, "2.5" is left out.
+func (ci *ComicInfo) ToComicBookInfo() ComicBookInfo {
+    cbi := ComicBookInfo{
+        Series:    ci.Series,
+        Title:     ci.Title,
+        Publisher: ci.Publisher,
+        Issue:     ci.Number,
+        Genre:     ci.Genre,
+        Tags:      SplitList(ci.Tags),
+    }
+    cbi.NumberOfIssues, _ = strconv.Atoi(ci.Count)
+    cbi.Volume, _ = strconv.Atoi(ci.Volume)
+    return cbi
+}
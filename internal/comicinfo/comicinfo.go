@@ -0,0 +1,144 @@
+package comicinfo
+
+import (
+    "encoding/xml"
+    "fmt"
+    "slices"
+    "strings"
+)
+
+// FileName is where readers look for the metadata, at the root of the archive
+const FileName = "ComicInfo.xml"
+
+// ComicInfo is the subset of the ComicInfo schema (v2.0, plus Tags and
+// LocalizedSeries from v2.1) the converter fills in. Field order follows the schema, some
+// readers are picky about it.
+//
+// The schema has no publishing status, PublishingStatus is the extension
+// Tachiyomi and Mihon write and read back, in their namespace.
+type ComicInfo struct {
+    XMLName         xml.Name `xml:"ComicInfo"`
+    XMLNSXSI        string   `xml:"xmlns:xsi,attr"`
+    XMLNSXSD        string   `xml:"xmlns:xsd,attr"`
+    Title           string   `xml:"Title,omitempty"`
+    Series          string   `xml:"Series,omitempty"`
+    Number          string   `xml:"Number,omitempty"`
+    Count           string   `xml:"Count,omitempty"`
+    Volume          string   `xml:"Volume,omitempty"`
+    AlternateSeries string   `xml:"AlternateSeries,omitempty"`
+    Notes           string   `xml:"Notes,omitempty"`
+    Publisher       string   `xml:"Publisher,omitempty"`
+    Genre           string   `xml:"Genre,omitempty"`
+    Tags            string   `xml:"Tags,omitempty"`
+    ScanInformation string   `xml:"ScanInformation,omitempty"`
+    AgeRating       string   `xml:"AgeRating,omitempty"`
+    LocalizedSeries string   `xml:"LocalizedSeries,omitempty"`
+
+    PublishingStatus string `xml:"http://www.w3.org/2001/XMLSchema PublishingStatusTachiyomi,omitempty"`
+}
+
+// Marshal renders the document with its XML declaration
+func (ci *ComicInfo) Marshal() ([]byte, error) {
+    ci.XMLNSXSI = "http://www.w3.org/2001/XMLSchema-instance"
+    ci.XMLNSXSD = "http://www.w3.org/2001/XMLSchema"
+
+    data, err := xml.MarshalIndent(ci, "", "  ")
+    if err != nil {
+        return nil, err
+    }
+    return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+// Unmarshal reads a ComicInfo.xml document, fields outside the subset are ignored
+func Unmarshal(data []byte) (*ComicInfo, error) {
+    var ci ComicInfo
+    if err := xml.Unmarshal(data, &ci); err != nil {
+        return nil, fmt.Errorf("invalid %s: %w", FileName, err)
+    }
+    return &ci, nil
+}
+
+// Combine merges the ComicInfo of the chapters going into one archive: the
+// fields all of them agree on are kept, the ones they differ on dropped, and
+// genres and tags are the union of theirs. The chapter number belongs to a
+// single chapter, so it is only kept when there is just one. Nil entries are
+// skipped, nil is returned when there are none.
+func Combine(infos []*ComicInfo) *ComicInfo {
+    var merged *ComicInfo
+    chapters := 0
+    var genre, tags []string
+    for _, ci := range infos {
+        if ci == nil {
+            continue
+        }
+        chapters++
+        genre = union(genre, SplitList(ci.Genre))
+        tags = union(tags, SplitList(ci.Tags))
+        if merged == nil {
+            copied := *ci
+            merged = &copied
+            continue
+        }
+        for _, f := range []struct{ a, b *string }{
+            {&merged.Title, &ci.Title},
+            {&merged.Series, &ci.Series},
+            {&merged.Number, &ci.Number},
+            {&merged.Count, &ci.Count},
+            {&merged.Volume, &ci.Volume},
+            {&merged.AlternateSeries, &ci.AlternateSeries},
+            {&merged.Notes, &ci.Notes},
+            {&merged.Publisher, &ci.Publisher},
+            {&merged.ScanInformation, &ci.ScanInformation},
+            {&merged.AgeRating, &ci.AgeRating},
+            {&merged.LocalizedSeries, &ci.LocalizedSeries},
+            {&merged.PublishingStatus, &ci.PublishingStatus},
+        } {
+            if *f.a != *f.b {
+                *f.a = ""
+            }
+        }
+    }
+    if merged == nil {
+        return nil
+    }
+    if chapters > 1 {
+        merged.Number = ""
+    }
+    merged.Genre = strings.Join(genre, ", ")
+    merged.Tags = strings.Join(tags, ", ")
+    return merged
+}
+
+// Fill sets the fields of ci that are empty to the ones of other
+func (ci *ComicInfo) Fill(other *ComicInfo) {
+    for _, f := range []struct{ a, b *string }{
+        {&ci.Title, &other.Title},
+        {&ci.Series, &other.Series},
+        {&ci.Number, &other.Number},
+        {&ci.Count, &other.Count},
+        {&ci.Volume, &other.Volume},
+        {&ci.AlternateSeries, &other.AlternateSeries},
+        {&ci.Notes, &other.Notes},
+        {&ci.Publisher, &other.Publisher},
+        {&ci.Genre, &other.Genre},
+        {&ci.Tags, &other.Tags},
+        {&ci.ScanInformation, &other.ScanInformation},
+        {&ci.AgeRating, &other.AgeRating},
+        {&ci.LocalizedSeries, &other.LocalizedSeries},
+        {&ci.PublishingStatus, &other.PublishingStatus},
+    } {
+        if *f.a == "" {
+            *f.a = *f.b
+        }
+    }
+}
+
+// union appends the items of add that list doesn't hold yet, ignoring case
+func union(list, add []string) []string {
+    for _, item := range add {
+        if !slices.ContainsFunc(list, func(s string) bool { return strings.EqualFold(s, item) }) {
+            list = append(list, item)
+        }
+    }
+    return list
+}
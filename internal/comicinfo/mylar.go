@@ -0,0 +1,91 @@
+package comicinfo
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+)
+
+// SeriesFileName is where Mylar keeps the details of a series, in the series
+// folder, and where Komga reads them from
+const SeriesFileName = "series.json"
+
+// MylarSeries is a series.json in the format Mylar writes, schema 1.0.2.
+// Every key is written, Komga refuses the file when one is missing.
+type MylarSeries struct {
+    Metadata MylarMetadata `json:"metadata"`
+}
+
+// MylarMetadata is the series itself. Fields the converter knows nothing
+// about are null, ComicID is the ComicVine id Mylar fills in and 0 here.
+type MylarMetadata struct {
+    Type                 string  `json:"type"` // Always comicSeries
+    Publisher            string  `json:"publisher"`
+    Imprint              *string `json:"imprint"`
+    Name                 string  `json:"name"`
+    ComicID              int     `json:"comicid"`
+    Year                 int     `json:"year"`
+    DescriptionText      *string `json:"description_text"`
+    DescriptionFormatted *string `json:"description_formatted"`
+    Volume               *int    `json:"volume"`
+    BookType             string  `json:"booktype"`
+    AgeRating            *string `json:"age_rating"`
+    ComicImage           string  `json:"ComicImage"`
+    TotalIssues          int     `json:"total_issues"`
+    PublicationRun       string  `json:"publication_run"`
+    Status               string  `json:"status"` // Continuing or Ended
+}
+
+// NewMylarSeries describes the series name with the details of its series map
+// entry, total archives when the entry doesn't say how many there are
+func NewMylarSeries(name string, info SeriesInfo, total int) MylarSeries {
+    if info.Total > 0 {
+        total = info.Total
+    }
+    // Mylar only knows whether more is coming
+    status := "Continuing"
+    if info.Status == "Completed" || info.Status == "Cancelled" {
+        status = "Ended"
+    }
+    return MylarSeries{Metadata: MylarMetadata{
+        Type:        "comicSeries",
+        Publisher:   info.Publisher,
+        Name:        name,
+        BookType:    "Print",
+        TotalIssues: total,
+        Status:      status,
+    }}
+}
+
+// IsMylarSeries reports whether the series.json at path was written by Mylar,
+// which always has a ComicVine id, rather than generated without one
+func IsMylarSeries(path string) (bool, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return false, err
+    }
+    var s struct {
+        Metadata struct {
+            ComicID any `json:"comicid"`
+        } `json:"metadata"`
+    }
+    if err := json.Unmarshal(data, &s); err != nil {
+        return false, fmt.Errorf("invalid %s: %w", SeriesFileName, err)
+    }
+    switch id := s.Metadata.ComicID.(type) {
+    case float64:
+        return id != 0, nil
+    case string:
+        return id != "" && id != "0", nil
+    }
+    return false, nil
+}
+
+// Marshal renders the file
+func (s MylarSeries) Marshal() ([]byte, error) {
+    data, err := json.MarshalIndent(s, "", "  ")
+    if err != nil {
+        return nil, err
+    }
+    return append(data, '\n'), nil
+}
@@ -0,0 +1,76 @@
+package comicinfo
+
+import (
+    "fmt"
+    "strings"
+)
+
+// AgeRatings are the values the schema allows for AgeRating
+var AgeRatings = []string{
+    "Unknown",
+    "Adults Only 18+",
+    "Early Childhood",
+    "Everyone",
+    "Everyone 10+",
+    "G",
+    "Kids to Adults",
+    "M",
+    "MA15+",
+    "Mature 17+",
+    "PG",
+    "R18+",
+    "Rating Pending",
+    "Teen",
+    "X18+",
+}
+
+// Classification is the genre, tags and age rating stamped into every
+// archive of a run or a series
+type Classification struct {
+    Genre     []string `json:"genre,omitempty"`
+    Tags      []string `json:"tags,omitempty"`
+    AgeRating string   `json:"age_rating,omitempty"`
+}
+
+// ParseAgeRating returns the schema spelling of rating, matched ignoring case
+func ParseAgeRating(rating string) (string, error) {
+    for _, r := range AgeRatings {
+        if strings.EqualFold(r, strings.TrimSpace(rating)) {
+            return r, nil
+        }
+    }
+    return "", fmt.Errorf("unknown age rating %q, valid ratings: %s", rating, strings.Join(AgeRatings, ", "))
+}
+
+// SplitList splits a comma separated flag value, dropping empty items
+func SplitList(s string) []string {
+    var items []string
+    for _, item := range strings.Split(s, ",") {
+        if item = strings.TrimSpace(item); item != "" {
+            items = append(items, item)
+        }
+    }
+    return items
+}
+
+// Merge returns c with the fields set in override replacing its own
+func (c Classification) Merge(override Classification) Classification {
+    if len(override.Genre) > 0 {
+        c.Genre = override.Genre
+    }
+    if len(override.Tags) > 0 {
+        c.Tags = override.Tags
+    }
+    if override.AgeRating != "" {
+        c.AgeRating = override.AgeRating
+    }
+    return c
+}
+
+// Apply fills the genre, tags and age rating of ci. Lists are comma separated
+// in the schema.
+func (c Classification) Apply(ci *ComicInfo) {
+    ci.Genre = strings.Join(c.Genre, ", ")
+    ci.Tags = strings.Join(c.Tags, ", ")
+    ci.AgeRating = c.AgeRating
+}
@@ -0,0 +1,127 @@
+package comicinfo
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+)
+
+// SeriesInfo is what the series map knows about one series
+type SeriesInfo struct {
+    Title     string   `json:"title"`     // Written to Series
+    Localized string   `json:"localized"` // Title in the original language, written to LocalizedSeries
+    Alternate []string `json:"alternate"` // Other known titles, written to AlternateSeries
+    Status    string   `json:"status"`    // Publishing status, one of SeriesStatuses
+    Publisher string   `json:"publisher"` // Written to Publisher
+    Total     int      `json:"total"`     // Chapters the series has in all, written to Count
+    Keep      int      `json:"keep"`      // Replaces -keep-latest for this series, negative keeps every chapter
+
+    // Replaces the run's -genre, -tags and -age-rating for this series
+    Classification
+}
+
+// SeriesStatuses are the publishing statuses a series can be marked with,
+// spelled the way Tachiyomi and Mihon write them
+var SeriesStatuses = []string{"Ongoing", "Completed", "On hiatus", "Cancelled"}
+
+// ParseSeriesStatus returns the canonical spelling of status, matched ignoring case
+func ParseSeriesStatus(status string) (string, error) {
+    for _, s := range SeriesStatuses {
+        if strings.EqualFold(s, strings.TrimSpace(status)) {
+            return s, nil
+        }
+    }
+    return "", fmt.Errorf("unknown series status %q, valid statuses: %s", status, strings.Join(SeriesStatuses, ", "))
+}
+
+// SeriesMap maps series folder names to their titles. The file looks like:
+//
+//	{
+//	  "series": {
+//	    "Shingeki no Kyojin": {
+//	      "title": "Attack on Titan",
+//	      "localized": "進撃の巨人",
+//	      "alternate": ["Shingeki no Kyojin"],
+//	      "genre": ["Action", "Dark Fantasy"],
+//	      "age_rating": "Mature 17+",
+//	      "status": "completed",
+//	      "publisher": "Kodansha",
+//	      "total": 139
+//	    }
+//	  }
+//	}
+type SeriesMap struct {
+    Series map[string]SeriesInfo `json:"series"`
+}
+
+// LoadSeriesMap reads a series map file
+func LoadSeriesMap(path string) (*SeriesMap, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    var m SeriesMap
+    if err := json.Unmarshal(data, &m); err != nil {
+        return nil, fmt.Errorf("invalid series map %s: %w", path, err)
+    }
+    for key, info := range m.Series {
+        if info.AgeRating != "" {
+            if info.AgeRating, err = ParseAgeRating(info.AgeRating); err != nil {
+                return nil, fmt.Errorf("invalid series map %s: %s: %w", path, key, err)
+            }
+        }
+        if info.Status != "" {
+            if info.Status, err = ParseSeriesStatus(info.Status); err != nil {
+                return nil, fmt.Errorf("invalid series map %s: %s: %w", path, key, err)
+            }
+        }
+        m.Series[key] = info
+    }
+    return &m, nil
+}
+
+// Lookup finds the series a folder belongs to. Folder names match a key, or
+// any of the titles listed for it, ignoring case.
+func (m *SeriesMap) Lookup(folderName string) (SeriesInfo, bool) {
+    if m == nil {
+        return SeriesInfo{}, false
+    }
+    if info, ok := m.Series[folderName]; ok {
+        return info, true
+    }
+    for key, info := range m.Series {
+        names := append([]string{key, info.Title, info.Localized}, info.Alternate...)
+        for _, name := range names {
+            if name != "" && strings.EqualFold(name, folderName) {
+                return info, true
+            }
+        }
+    }
+    return SeriesInfo{}, false
+}
+
+// Apply fills the series fields of ci from info. The folder name stands in
+// for a missing title.
+func (info SeriesInfo) Apply(ci *ComicInfo, folderName string) {
+    ci.Series = info.Title
+    if ci.Series == "" {
+        ci.Series = folderName
+    }
+    ci.LocalizedSeries = info.Localized
+    ci.PublishingStatus = info.Status
+    ci.Publisher = info.Publisher
+    if info.Total > 0 {
+        ci.Count = strconv.Itoa(info.Total)
+    }
+
+    // AlternateSeries is a single field, keep every variant readers could match on
+    var alternates []string
+    for _, a := range info.Alternate {
+        if a != "" && a != ci.Series {
+            alternates = append(alternates, a)
+        }
+    }
+    ci.AlternateSeries = strings.Join(alternates, "; ")
+}
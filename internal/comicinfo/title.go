@@ -0,0 +1,126 @@
+package comicinfo
+
+import (
+    "fmt"
+    "regexp"
+    "strings"
+)
+
+// DefaultTitlePattern understands the usual chapter folder names, like
+// "c045 - The Long Night [Group]", "[Group] Chapter 12.5: Dawn" or
+// "Vol.02 Ch.010 - Return"
+const DefaultTitlePattern = `(?i)^\s*(?:\[[^\]]*\]\s*)*(?:(?:vol(?:ume)?|v)\.?\s*(?P<volume>\d+)\s*)?(?:c|ch|chap|chapter|ep|episode|#)?\.?\s*(?P<number>\d+(?:\.\d+)?)(?:\s*[-–—:.]\s*|\s+|$)(?P<title>.*?)\s*(?:\[(?P<group>[^\]]*)\]\s*)*$`
+
+// DefaultSpecialPattern marks the folders that aren't part of the numbered
+// run, like "Omake", "Side Story - Guts" or "Extras 2"
+const DefaultSpecialPattern = `(?i)(?:^|[^a-z])(?:specials?|omake|extras?|bonus|side[ -]?stor(?:y|ies)|one[ -]?shots?|sp\d+)(?:[^a-z]|$)`
+
+var bracketTags = regexp.MustCompile(`\s*\[[^\]]*\]\s*`)
+
+// Chapter is what a folder name says about the chapter inside
+type Chapter struct {
+    Title   string
+    Number  string
+    Volume  string
+    Group   string
+    Special bool // The name matches the special pattern
+}
+
+// TitleParser pulls chapter details out of folder names with a regular
+// expression. The named groups number, title, volume and group are used,
+// any of them may be missing from the pattern.
+type TitleParser struct {
+    re      *regexp.Regexp
+    special *regexp.Regexp
+}
+
+// NewTitleParser compiles pattern, an empty pattern means DefaultTitlePattern
+func NewTitleParser(pattern string) (*TitleParser, error) {
+    if pattern == "" {
+        pattern = DefaultTitlePattern
+    }
+    re, err := regexp.Compile(pattern)
+    if err != nil {
+        return nil, fmt.Errorf("invalid title pattern: %w", err)
+    }
+    return &TitleParser{re: re, special: regexp.MustCompile(DefaultSpecialPattern)}, nil
+}
+
+// SetSpecialPattern replaces the pattern that marks specials, an empty
+// pattern keeps DefaultSpecialPattern
+func (p *TitleParser) SetSpecialPattern(pattern string) error {
+    if pattern == "" {
+        return nil
+    }
+    re, err := regexp.Compile(pattern)
+    if err != nil {
+        return fmt.Errorf("invalid special pattern: %w", err)
+    }
+    p.special = re
+    return nil
+}
+
+// Parse splits a folder name into its chapter details. A name the pattern
+// doesn't match becomes the title as a whole, minus any [group] tags.
+func (p *TitleParser) Parse(folderName string) Chapter {
+    special := p.special.MatchString(folderName)
+    m := p.re.FindStringSubmatch(folderName)
+    if m == nil {
+        return Chapter{Title: StripTags(folderName), Special: special}
+    }
+
+    group := func(name string) string {
+        if i := p.re.SubexpIndex(name); i >= 0 {
+            return strings.TrimSpace(m[i])
+        }
+        return ""
+    }
+    return Chapter{
+        Title:   group("title"),
+        Number:  trimZeros(group("number")),
+        Volume:  trimZeros(group("volume")),
+        Group:   group("group"),
+        Special: special,
+    }
+}
+
+var (
+    volumeMarked = regexp.MustCompile(`(?i)(?:^|[^a-z])(?:vol(?:ume)?|v|tome|t)\.?\s*(\d+(?:\.\d+)?)`)
+    firstNumber  = regexp.MustCompile(`\d+(?:\.\d+)?`)
+)
+
+// ParseVolume returns the volume number of a volume folder name, like
+// "Volume 02", "Vol.3 - The Return" or "第2巻", or "" if it has none
+func ParseVolume(folderName string) string {
+    name := bracketTags.ReplaceAllString(folderName, " ")
+    if m := volumeMarked.FindStringSubmatch(name); m != nil {
+        return trimZeros(m[1])
+    }
+    return trimZeros(firstNumber.FindString(name))
+}
+
+// MarkedVolume is ParseVolume for names that only count as a volume when
+// they say so, like "Volume 3" but not "Extras 2", "" otherwise
+func MarkedVolume(folderName string) string {
+    if m := volumeMarked.FindStringSubmatch(bracketTags.ReplaceAllString(folderName, " ")); m != nil {
+        return trimZeros(m[1])
+    }
+    return ""
+}
+
+// StripTags removes the [group] tags from a folder name
+func StripTags(folderName string) string {
+    return strings.TrimSpace(bracketTags.ReplaceAllString(folderName, " "))
+}
+
+// trimZeros turns "045" into "45" the way readers sort numbers, but keeps "0"
+func trimZeros(n string) string {
+    trimmed := strings.TrimLeft(n, "0")
+    if trimmed == "" || trimmed[0] == '.' {
+        if n == "" {
+            return ""
+        }
+        return "0" + trimmed
+    }
+    return trimmed
+}
@@ -0,0 +1,136 @@
+package history
+
+import (
+    "convert_cbz/internal/pathnorm"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "time"
+)
+
+// CatalogEntry tracks one converted folder across syncs
+type CatalogEntry struct {
+    Source      string    `json:"source"`
+    Output      string    `json:"output"`
+    Hash        string    `json:"hash"`
+    Dumb        bool      `json:"dumb"`
+    Compression string    `json:"compression"`
+    FirstSeen   time.Time `json:"first_seen"`
+    Converted   time.Time `json:"converted"`
+}
+
+// Catalog is every folder synced into one output directory, stored as one
+// JSON file. It is read whole and written back whole, a library of tens of
+// thousands of folders is a few megabytes, so a database would only add a
+// cgo dependency the converter otherwise does without.
+type Catalog struct {
+    OutputDir string          `json:"output_dir"`
+    Entries   []*CatalogEntry `json:"entries"`
+
+    path  string
+    index map[string]int // pathnorm.Key of each source to its place in Entries
+}
+
+// CatalogPath returns where the catalog for outputDir lives by default. Each
+// output directory gets its own file, so syncs into different outputs never
+// write the same one.
+func CatalogPath(outputDir string) (string, error) {
+    dir, err := Dir()
+    if err != nil {
+        return "", err
+    }
+    if abs, err := filepath.Abs(outputDir); err == nil {
+        outputDir = abs
+    }
+    sum := sha256.Sum256([]byte(outputDir))
+    return filepath.Join(dir, "catalogs", hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// LoadCatalog reads the catalog at path, a missing file is an empty catalog
+func LoadCatalog(path, outputDir string) (*Catalog, error) {
+    if abs, err := filepath.Abs(outputDir); err == nil {
+        outputDir = abs
+    }
+    c := &Catalog{OutputDir: outputDir, path: path}
+
+    data, err := os.ReadFile(path)
+    if err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return c, nil
+        }
+        return nil, err
+    }
+    if err := json.Unmarshal(data, c); err != nil {
+        return nil, fmt.Errorf("corrupt catalog %s: %w", path, err)
+    }
+
+    // An older catalog may hold a source twice, the later entry is the one kept
+    entries := c.Entries
+    c.Entries = nil
+    for _, e := range entries {
+        c.Put(e)
+    }
+    return c, nil
+}
+
+// Lookup returns the entry for source, or nil
+func (c *Catalog) Lookup(source string) *CatalogEntry {
+    if i, ok := c.index[pathnorm.Key(source)]; ok {
+        return c.Entries[i]
+    }
+    return nil
+}
+
+// Put adds e, replacing the entry with the same source
+func (c *Catalog) Put(e *CatalogEntry) {
+    if c.index == nil {
+        c.index = make(map[string]int)
+    }
+    key := pathnorm.Key(e.Source)
+    if i, ok := c.index[key]; ok {
+        c.Entries[i] = e
+        return
+    }
+    c.index[key] = len(c.Entries)
+    c.Entries = append(c.Entries, e)
+}
+
+// Remove drops the entry for source, if there is one. The last entry takes
+// its place, Save sorts them anyway.
+func (c *Catalog) Remove(source string) {
+    key := pathnorm.Key(source)
+    i, ok := c.index[key]
+    if !ok {
+        return
+    }
+    last := len(c.Entries) - 1
+    if i != last {
+        c.Entries[i] = c.Entries[last]
+        c.index[pathnorm.Key(c.Entries[i].Source)] = i
+    }
+    c.Entries[last] = nil
+    c.Entries = c.Entries[:last]
+    delete(c.index, key)
+}
+
+// Save writes the catalog back to where it was loaded from
+func (c *Catalog) Save() error {
+    if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+        return err
+    }
+    sort.Slice(c.Entries, func(i, j int) bool { return c.Entries[i].Source < c.Entries[j].Source })
+    for i, e := range c.Entries {
+        c.index[pathnorm.Key(e.Source)] = i
+    }
+
+    data, err := json.MarshalIndent(c, "", "  ")
+    if err != nil {
+        return err
+    }
+    return writeFileAtomic(c.path, data)
+}
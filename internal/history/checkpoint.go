@@ -0,0 +1,81 @@
+package history
+
+import (
+    "convert_cbz/internal/types"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+// Checkpoint holds the work items a run didn't get to, so a later run can pick them up
+type Checkpoint struct {
+    RunID     string           `json:"run_id"`
+    Created   time.Time        `json:"created"`
+    OutputDir string           `json:"output_dir"`
+    Items     []types.WorkItem `json:"items"`
+}
+
+func checkpointPath(id string) (string, error) {
+    dir, err := Dir()
+    if err != nil {
+        return "", err
+    }
+    return filepath.Join(dir, "checkpoints", id+".json"), nil
+}
+
+// SaveCheckpoint writes cp under the state directory, keyed by its run ID
+func SaveCheckpoint(cp *Checkpoint) error {
+    path, err := checkpointPath(cp.RunID)
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return err
+    }
+
+    data, err := json.MarshalIndent(cp, "", "  ")
+    if err != nil {
+        return err
+    }
+
+    return writeFileAtomic(path, data)
+}
+
+// LoadCheckpoint reads a checkpoint by run ID, or from a path to a checkpoint file
+func LoadCheckpoint(idOrPath string) (*Checkpoint, error) {
+    path := idOrPath
+    if _, err := os.Stat(path); err != nil {
+        if path, err = checkpointPath(idOrPath); err != nil {
+            return nil, err
+        }
+    }
+
+    data, err := os.ReadFile(path)
+    if err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return nil, fmt.Errorf("no checkpoint for %s", idOrPath)
+        }
+        return nil, err
+    }
+
+    var cp Checkpoint
+    if err := json.Unmarshal(data, &cp); err != nil {
+        return nil, fmt.Errorf("corrupt checkpoint %s: %w", idOrPath, err)
+    }
+    return &cp, nil
+}
+
+// RemoveCheckpoint deletes the checkpoint for a run once it has been fully resumed
+func RemoveCheckpoint(id string) error {
+    path, err := checkpointPath(id)
+    if err != nil {
+        return err
+    }
+    if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+        return err
+    }
+    return nil
+}
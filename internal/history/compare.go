@@ -0,0 +1,88 @@
+package history
+
+import (
+    "convert_cbz/internal/pathnorm"
+    "convert_cbz/internal/types"
+    "time"
+)
+
+// Summary is what a run added up to
+type Summary struct {
+    ID          string                   `json:"id"`
+    Started     time.Time                `json:"started"`
+    Finished    time.Time                `json:"finished"`
+    OutputDir   string                   `json:"output_dir"`
+    Folders     int                      `json:"folders"`
+    Statuses    map[types.ItemStatus]int `json:"statuses"`
+    Pages       int                      `json:"pages"`
+    Bytes       int64                    `json:"bytes"`        // Size of the archives written
+    SourceBytes int64                    `json:"source_bytes"` // Size of the files archived
+}
+
+// Summarize adds up the items of run
+func Summarize(run *Run) Summary {
+    s := Summary{
+        ID:        run.ID,
+        Started:   run.Started,
+        Finished:  run.Finished,
+        OutputDir: run.OutputDir,
+        Folders:   len(run.Items),
+        Statuses:  make(map[types.ItemStatus]int),
+    }
+    for _, it := range run.Items {
+        s.Statuses[it.Status]++
+        s.Pages += it.Pages
+        s.Bytes += it.Bytes
+        s.SourceBytes += it.SourceBytes
+    }
+    return s
+}
+
+// Comparison is how a later run differs from an earlier one
+type Comparison struct {
+    A Summary `json:"a"`
+    B Summary `json:"b"`
+
+    // Folders are matched by their source path
+    NewFailures []Item `json:"new_failures"` // Failed in B but not in A
+    Fixed       []Item `json:"fixed"`        // Failed in A, converted or skipped in B
+    Added       int    `json:"added"`        // Folders only B went through
+    Dropped     int    `json:"dropped"`      // Folders only A went through
+}
+
+// Compare sums up both runs and lists the folders whose result changed
+// between them
+func Compare(a, b *Run) Comparison {
+    c := Comparison{
+        A:           Summarize(a),
+        B:           Summarize(b),
+        NewFailures: []Item{},
+        Fixed:       []Item{},
+    }
+
+    before := make(map[string]Item, len(a.Items))
+    for _, it := range a.Items {
+        before[pathnorm.Key(it.Source)] = it
+    }
+    seen := make(map[string]bool, len(b.Items))
+    for _, it := range b.Items {
+        key := pathnorm.Key(it.Source)
+        seen[key] = true
+        prev, ok := before[key]
+        if !ok {
+            c.Added++
+        }
+        switch {
+        case it.Status == types.StatusFailed && prev.Status != types.StatusFailed:
+            c.NewFailures = append(c.NewFailures, it)
+        case ok && prev.Status == types.StatusFailed && (it.Status == types.StatusConverted || it.Status == types.StatusSkipped):
+            c.Fixed = append(c.Fixed, it)
+        }
+    }
+    for key := range before {
+        if !seen[key] {
+            c.Dropped++
+        }
+    }
+    return c
+}
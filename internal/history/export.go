@@ -0,0 +1,107 @@
+package history
+
+import (
+    "convert_cbz/internal/pathnorm"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "io"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// ExportRow is one recorded item together with the run it belongs to
+type ExportRow struct {
+    RunID     string    `json:"run_id"`
+    Started   time.Time `json:"started"`
+    Finished  time.Time `json:"finished"`
+    OutputDir string    `json:"output_dir"`
+    Item
+}
+
+// ExportFilter picks the items to export, zero fields match everything
+type ExportFilter struct {
+    Since time.Time // Runs started at or after
+    Until time.Time // Runs started before
+    Root  string    // Items whose source is inside this folder
+}
+
+func (f ExportFilter) run(r *Run) bool {
+    if !f.Since.IsZero() && r.Started.Before(f.Since) {
+        return false
+    }
+    return f.Until.IsZero() || r.Started.Before(f.Until)
+}
+
+func (f ExportFilter) item(it Item) bool {
+    if f.Root == "" {
+        return true
+    }
+    root := pathnorm.Key(f.Root)
+    source := pathnorm.Key(it.Source)
+    return source == root || strings.HasPrefix(source, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// Export returns every recorded item f matches, oldest run first. Runs whose
+// record can't be read are skipped.
+func Export(f ExportFilter) ([]ExportRow, error) {
+    ids, err := List()
+    if err != nil {
+        return nil, err
+    }
+
+    var rows []ExportRow
+    for _, id := range ids {
+        run, err := Load(id)
+        if err != nil || !f.run(run) {
+            continue
+        }
+        for _, it := range run.Items {
+            if f.item(it) {
+                rows = append(rows, ExportRow{RunID: run.ID, Started: run.Started, Finished: run.Finished, OutputDir: run.OutputDir, Item: it})
+            }
+        }
+    }
+    return rows, nil
+}
+
+// WriteExport renders rows to w as "csv" or "json"
+func WriteExport(w io.Writer, rows []ExportRow, format string) error {
+    switch format {
+    case "json":
+        if rows == nil {
+            rows = []ExportRow{}
+        }
+        enc := json.NewEncoder(w)
+        enc.SetIndent("", "  ")
+        return enc.Encode(rows)
+    case "csv":
+        cw := csv.NewWriter(w)
+        cw.Write([]string{"run_id", "started", "finished", "output_dir", "folder", "source", "output", "status", "pages", "bytes", "source_bytes", "fingerprint", "password", "series_status", "error"})
+        for _, r := range rows {
+            cw.Write([]string{
+                r.RunID,
+                r.Started.Format(time.RFC3339),
+                r.Finished.Format(time.RFC3339),
+                r.OutputDir,
+                r.Folder,
+                r.Source,
+                r.Output,
+                string(r.Status),
+                strconv.Itoa(r.Pages),
+                strconv.FormatInt(r.Bytes, 10),
+                strconv.FormatInt(r.SourceBytes, 10),
+                r.Fingerprint,
+                strconv.Itoa(r.Password),
+                r.SeriesStatus,
+                r.Error,
+            })
+        }
+        cw.Flush()
+        return cw.Error()
+    default:
+        return fmt.Errorf("unknown export format %q, use csv or json", format)
+    }
+}
@@ -0,0 +1,33 @@
+package history
+
+import (
+    "os"
+    "path/filepath"
+)
+
+// writeFileAtomic writes data to a uniquely named temp file next to path and
+// renames it into place, so a crash never leaves a truncated record and two
+// runs writing at once never share a temp file
+func writeFileAtomic(path string, data []byte) error {
+    f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+    if err != nil {
+        return err
+    }
+    tmp := f.Name()
+
+    _, err = f.Write(data)
+    if err == nil {
+        // CreateTemp makes files only the owner can read
+        err = f.Chmod(0644)
+    }
+    if closeErr := f.Close(); err == nil {
+        err = closeErr
+    }
+    if err == nil {
+        err = os.Rename(tmp, path)
+    }
+    if err != nil {
+        os.Remove(tmp)
+    }
+    return err
+}
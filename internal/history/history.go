@@ -0,0 +1,273 @@
+package history
+
+import (
+    "convert_cbz/internal/pathnorm"
+    "convert_cbz/internal/types"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "runtime"
+    "sort"
+    "strings"
+    "time"
+)
+
+// Run is the record of a single conversion run, stored as one JSON file
+type Run struct {
+    ID        string    `json:"id"`
+    Started   time.Time `json:"started"`
+    Finished  time.Time `json:"finished"`
+    OutputDir string    `json:"output_dir"`
+    Inputs    []string  `json:"inputs"`
+    Items     []Item    `json:"items"`
+}
+
+// Item is the recorded outcome of one folder in a run
+type Item struct {
+    Folder string           `json:"folder"`
+    Source string           `json:"source"`
+    Output string           `json:"output"`
+    Status types.ItemStatus `json:"status"`
+    Error  string           `json:"error,omitempty"`
+
+    // What was written, left out for items that weren't converted and runs
+    // recorded before they were kept
+    Pages       int    `json:"pages,omitempty"`
+    Bytes       int64  `json:"bytes,omitempty"`        // Size of the archive
+    SourceBytes int64  `json:"source_bytes,omitempty"` // Size of the files archived
+    Fingerprint string `json:"fingerprint,omitempty"`  // Of the source files, as in the archive comment
+    Password    int    `json:"password,omitempty"`     // Number in the password list of the one that opened the archive
+
+    SeriesStatus string `json:"series_status,omitempty"` // Publishing status from the series map
+}
+
+// Dir returns the directory that holds the tool's persistent state
+func Dir() (string, error) {
+    if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+        return filepath.Join(dir, "convert-cbz"), nil
+    }
+
+    // Windows has no ~/.local, LocalAppData is the closest equivalent
+    if runtime.GOOS == "windows" {
+        dir, err := os.UserCacheDir()
+        if err != nil {
+            return "", err
+        }
+        return filepath.Join(dir, "convert-cbz"), nil
+    }
+
+    home, err := os.UserHomeDir()
+    if err != nil {
+        return "", err
+    }
+    return filepath.Join(home, ".local", "state", "convert-cbz"), nil
+}
+
+func runsDir() (string, error) {
+    dir, err := Dir()
+    if err != nil {
+        return "", err
+    }
+    return filepath.Join(dir, "runs"), nil
+}
+
+// NewID returns a run ID: the start time, so IDs sort chronologically, and a
+// random suffix so runs started in the same second don't share one
+func NewID(start time.Time) string {
+    var b [3]byte
+    rand.Read(b[:])
+    return start.Format("20060102-150405") + "-" + hex.EncodeToString(b[:])
+}
+
+// NewRun starts a run record for outputDir
+func NewRun(start time.Time, outputDir string, inputs []string) *Run {
+    if abs, err := filepath.Abs(outputDir); err == nil {
+        outputDir = abs
+    }
+    return &Run{
+        ID:        NewID(start),
+        Started:   start,
+        OutputDir: outputDir,
+        Inputs:    inputs,
+    }
+}
+
+// Record copies the per-item results of a finished run into it
+func (r *Run) Record(results []types.ItemResult) {
+    for _, res := range results {
+        r.Items = append(r.Items, Item{
+            Folder: res.FolderName,
+            Source: res.SourcePath,
+            Output: res.OutputPath,
+            Status: res.Status,
+            Error:  res.Error,
+
+            Pages:       res.Pages,
+            Bytes:       res.Bytes,
+            SourceBytes: res.SourceBytes,
+            Fingerprint: res.Fingerprint,
+            Password:    res.Password,
+
+            SeriesStatus: res.SeriesStatus,
+        })
+    }
+    sort.Slice(r.Items, func(i, j int) bool { return r.Items[i].Source < r.Items[j].Source })
+}
+
+// Save writes the run record to the state directory
+func Save(run *Run) error {
+    dir, err := runsDir()
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return err
+    }
+
+    data, err := json.MarshalIndent(run, "", "  ")
+    if err != nil {
+        return err
+    }
+
+    return writeFileAtomic(filepath.Join(dir, run.ID+".json"), data)
+}
+
+// Load reads the run with the given ID
+func Load(id string) (*Run, error) {
+    dir, err := runsDir()
+    if err != nil {
+        return nil, err
+    }
+
+    data, err := os.ReadFile(filepath.Join(dir, id+".json"))
+    if err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return nil, fmt.Errorf("no run with id %s", id)
+        }
+        return nil, err
+    }
+
+    var run Run
+    if err := json.Unmarshal(data, &run); err != nil {
+        return nil, fmt.Errorf("corrupt run record %s: %w", id, err)
+    }
+    return &run, nil
+}
+
+// List returns the IDs of all recorded runs, oldest first
+func List() ([]string, error) {
+    dir, err := runsDir()
+    if err != nil {
+        return nil, err
+    }
+
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return nil, nil
+        }
+        return nil, err
+    }
+
+    var ids []string
+    for _, e := range entries {
+        if id, ok := strings.CutSuffix(e.Name(), ".json"); ok && !e.IsDir() {
+            ids = append(ids, id)
+        }
+    }
+
+    // IDs start with a timestamp so they sort chronologically
+    sort.Strings(ids)
+    return ids, nil
+}
+
+// Last returns the most recent run that wrote into outputDir, or nil if there is none
+func Last(outputDir string) (*Run, error) {
+    if abs, err := filepath.Abs(outputDir); err == nil {
+        outputDir = abs
+    }
+
+    ids, err := List()
+    if err != nil {
+        return nil, err
+    }
+
+    for i := len(ids) - 1; i >= 0; i-- {
+        run, err := Load(ids[i])
+        if err != nil {
+            continue
+        }
+        if run.OutputDir == outputDir {
+            return run, nil
+        }
+    }
+    return nil, nil
+}
+
+// Sources maps the archives recorded for outputDir to the folders they were
+// built from, keyed by pathnorm.Key of the archive. Later runs win.
+func Sources(outputDir string) (map[string]string, error) {
+    if abs, err := filepath.Abs(outputDir); err == nil {
+        outputDir = abs
+    }
+
+    ids, err := List()
+    if err != nil {
+        return nil, err
+    }
+
+    sources := make(map[string]string)
+    for _, id := range ids {
+        run, err := Load(id)
+        if err != nil || run.OutputDir != outputDir {
+            continue
+        }
+        for _, it := range run.Items {
+            if it.Source != "" && it.Output != "" {
+                sources[pathnorm.Key(it.Output)] = it.Source
+            }
+        }
+    }
+    return sources, nil
+}
+
+// Retired returns the source folders whose archives a run into outputDir
+// retired, keyed by pathnorm.Key of the folder. Runs that were rolled back
+// don't count, and a later conversion of the folder takes it off again.
+func Retired(outputDir string) (map[string]bool, error) {
+    if abs, err := filepath.Abs(outputDir); err == nil {
+        outputDir = abs
+    }
+
+    ids, err := List()
+    if err != nil {
+        return nil, err
+    }
+
+    retired := make(map[string]bool)
+    for _, id := range ids {
+        run, err := Load(id)
+        if err != nil || run.OutputDir != outputDir {
+            continue
+        }
+        if j, err := LoadJournal(id); err == nil && !j.RolledBack.IsZero() {
+            continue
+        }
+        for _, it := range run.Items {
+            if it.Source == "" {
+                continue
+            }
+            switch it.Status {
+            case types.StatusRetired:
+                retired[pathnorm.Key(it.Source)] = true
+            case types.StatusConverted:
+                delete(retired, pathnorm.Key(it.Source))
+            }
+        }
+    }
+    return retired, nil
+}
@@ -0,0 +1,346 @@
+package history
+
+import (
+    "bufio"
+    "convert_cbz/internal/pathnorm"
+    "convert_cbz/internal/trash"
+    "convert_cbz/internal/types"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+type (
+    Step     = types.JournalStep
+    StepKind = types.StepKind
+)
+
+const (
+    StepCreate  = types.StepCreate
+    StepRewrite = types.StepRewrite
+    StepMove    = types.StepMove
+    StepDelete  = types.StepDelete
+
+    StepDeleteSource = types.StepDeleteSource
+    StepTrashSource  = types.StepTrashSource
+)
+
+// Journal records the changes a run or migration made to an output library,
+// in order, so they can be undone even after a crash. It is stored as JSON
+// lines and only ever appended to, every step is on disk before it is made.
+type Journal struct {
+    ID         string
+    OutputDir  string
+    Started    time.Time
+    Finished   time.Time
+    RolledBack time.Time
+    Steps      []Step
+
+    mu      sync.Mutex
+    created bool // Header line written
+}
+
+// journalLine is one line of the journal file, only some fields are set on each
+type journalLine struct {
+    ID         string    `json:"id,omitempty"`
+    OutputDir  string    `json:"output_dir,omitempty"`
+    Started    time.Time `json:"started,omitzero"`
+    Finished   time.Time `json:"finished,omitzero"`
+    RolledBack time.Time `json:"rolled_back,omitzero"`
+    Step       *Step     `json:"step,omitempty"`
+    Dropped    string    `json:"dropped,omitempty"` // Backup of an earlier step that was removed
+}
+
+func journalPath(id string) (string, error) {
+    dir, err := Dir()
+    if err != nil {
+        return "", err
+    }
+    return filepath.Join(dir, "journals", id+".jsonl"), nil
+}
+
+// NewJournal starts a journal for outputDir. Nothing is written until the
+// first step is recorded, runs that change nothing leave no journal behind.
+func NewJournal(id, outputDir string) *Journal {
+    if abs, err := filepath.Abs(outputDir); err == nil {
+        outputDir = abs
+    }
+    return &Journal{ID: id, OutputDir: outputDir, Started: time.Now()}
+}
+
+// Record appends a step to the journal file. It is safe for concurrent use.
+func (j *Journal) Record(s Step) error {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    if err := j.append(journalLine{Step: &s}); err != nil {
+        return err
+    }
+    j.Steps = append(j.Steps, s)
+    return nil
+}
+
+// Finish marks the journal as belonging to a run that completed
+func (j *Journal) Finish() error {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    j.Finished = time.Now()
+    if !j.created {
+        return nil
+    }
+    return j.append(journalLine{Finished: j.Finished})
+}
+
+// DropBackup records that backup, kept by an earlier step, is about to be
+// removed. The step then can't be undone, and rollback says so instead of
+// looking for the backup.
+func (j *Journal) DropBackup(backup string) error {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    if err := j.append(journalLine{Dropped: backup}); err != nil {
+        return err
+    }
+    j.dropBackup(backup)
+    return nil
+}
+
+func (j *Journal) dropBackup(backup string) {
+    for i := range j.Steps {
+        if j.Steps[i].Backup == backup {
+            j.Steps[i].Backup = ""
+        }
+    }
+}
+
+// markRolledBack records that the steps were undone
+func (j *Journal) markRolledBack() error {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    j.RolledBack = time.Now()
+    return j.append(journalLine{RolledBack: j.RolledBack})
+}
+
+// append must be called with j.mu held
+func (j *Journal) append(line journalLine) error {
+    path, err := journalPath(j.ID)
+    if err != nil {
+        return err
+    }
+
+    var data []byte
+    if !j.created {
+        if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+            return err
+        }
+        header, _ := json.Marshal(journalLine{ID: j.ID, OutputDir: j.OutputDir, Started: j.Started})
+        data = append(header, '\n')
+    }
+    body, err := json.Marshal(line)
+    if err != nil {
+        return err
+    }
+    data = append(append(data, body...), '\n')
+
+    f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        return err
+    }
+    _, err = f.Write(data)
+    if err == nil {
+        // The step has to survive a crash right after it, that's the point
+        err = f.Sync()
+    }
+    if closeErr := f.Close(); err == nil {
+        err = closeErr
+    }
+    if err == nil {
+        j.created = true
+    }
+    return err
+}
+
+// LoadJournal reads the journal with the given ID
+func LoadJournal(id string) (*Journal, error) {
+    path, err := journalPath(id)
+    if err != nil {
+        return nil, err
+    }
+
+    f, err := os.Open(path)
+    if err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return nil, fmt.Errorf("no journal for %s, it either changed nothing or didn't exist", id)
+        }
+        return nil, err
+    }
+    defer f.Close()
+
+    j := &Journal{created: true}
+    scanner := bufio.NewScanner(f)
+    scanner.Buffer(make([]byte, 64*1024), 1<<20)
+    for scanner.Scan() {
+        var line journalLine
+        if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+            // A crash mid-write can only cut off the last line
+            break
+        }
+        switch {
+        case line.Step != nil:
+            j.Steps = append(j.Steps, *line.Step)
+        case line.Dropped != "":
+            j.dropBackup(line.Dropped)
+        case line.ID != "":
+            j.ID, j.OutputDir, j.Started = line.ID, line.OutputDir, line.Started
+        case !line.Finished.IsZero():
+            j.Finished = line.Finished
+        case !line.RolledBack.IsZero():
+            j.RolledBack = line.RolledBack
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, fmt.Errorf("corrupt journal %s: %w", id, err)
+    }
+    if j.ID == "" {
+        return nil, fmt.Errorf("corrupt journal %s: no header", id)
+    }
+    return j, nil
+}
+
+// Conflicts returns the IDs of later journals into the same output directory,
+// not rolled back yet, that changed one of the archives j did. Undoing j first
+// would throw away what they did.
+func (j *Journal) Conflicts() ([]string, error) {
+    dir, err := Dir()
+    if err != nil {
+        return nil, err
+    }
+    entries, err := os.ReadDir(filepath.Join(dir, "journals"))
+    if err != nil {
+        return nil, err
+    }
+
+    touched := make(map[string]bool)
+    for _, s := range j.Steps {
+        touched[pathnorm.Key(s.From)] = true
+        if s.To != "" {
+            touched[pathnorm.Key(s.To)] = true
+        }
+    }
+
+    var ids []string
+    for _, e := range entries {
+        id, ok := strings.CutSuffix(e.Name(), ".jsonl")
+        if !ok || id == j.ID {
+            continue
+        }
+        other, err := LoadJournal(id)
+        if err != nil || other.OutputDir != j.OutputDir || !other.Started.After(j.Started) || !other.RolledBack.IsZero() {
+            continue
+        }
+        for _, s := range other.Steps {
+            if touched[pathnorm.Key(s.From)] || (s.To != "" && touched[pathnorm.Key(s.To)]) {
+                ids = append(ids, id)
+                break
+            }
+        }
+    }
+    sort.Strings(ids)
+    return ids, nil
+}
+
+// Undo reverts the steps of j, newest first, marks the journal rolled back and
+// returns the errors of the steps it couldn't revert. Steps that are already
+// undone are skipped, so an interrupted undo can be run again.
+func (j *Journal) Undo() []error {
+    kept := j.Kept()
+    var errs []error
+    for i := len(j.Steps) - 1; i >= 0; i-- {
+        s := j.Steps[i]
+        if (s.Kind == StepCreate || s.Kind == StepRewrite) && kept[pathnorm.Key(s.From)] {
+            continue
+        }
+        if err := undoStep(s); err != nil {
+            errs = append(errs, err)
+        }
+    }
+    if err := j.markRolledBack(); err != nil {
+        errs = append(errs, fmt.Errorf("failed to write journal: %w", err))
+    }
+    return errs
+}
+
+// Kept returns the archives undoing j leaves alone, keyed by pathnorm.Key:
+// once its source folder was deleted, or recycled where it can't be brought
+// back from, an archive is the only copy left
+func (j *Journal) Kept() map[string]bool {
+    kept := make(map[string]bool)
+    for _, s := range j.Steps {
+        if s.Kind == StepDeleteSource || (s.Kind == StepTrashSource && s.To == "") {
+            kept[pathnorm.Key(s.To)] = true
+        }
+    }
+    return kept
+}
+
+func undoStep(s Step) error {
+    switch s.Kind {
+    case StepCreate:
+        if err := os.Remove(s.From); err != nil && !errors.Is(err, os.ErrNotExist) {
+            return fmt.Errorf("can't remove %s: %w", s.From, err)
+        }
+    case StepMove:
+        if _, err := os.Stat(s.To); err != nil {
+            if _, fromErr := os.Stat(s.From); fromErr == nil {
+                return nil
+            }
+            return fmt.Errorf("can't move %s back: %w", s.To, err)
+        }
+        if err := os.MkdirAll(filepath.Dir(s.From), 0755); err != nil {
+            return err
+        }
+        if err := os.Rename(s.To, s.From); err != nil {
+            return fmt.Errorf("can't move %s back: %w", s.To, err)
+        }
+        // The sidecar and OPF went along, if there are any
+        back := types.Companions(s.From)
+        for i, companion := range types.Companions(s.To) {
+            if _, err := os.Stat(companion); err != nil {
+                continue
+            }
+            if err := os.Rename(companion, back[i]); err != nil {
+                return fmt.Errorf("can't move %s back: %w", companion, err)
+            }
+        }
+    case StepDeleteSource:
+        return fmt.Errorf("source folder %s was deleted, keeping its archive %s", s.From, s.To)
+    case StepTrashSource:
+        if s.To == "" {
+            return fmt.Errorf("source folder %s is in the Recycle Bin, restore it from there", s.From)
+        }
+        if _, err := os.Stat(s.To); err != nil {
+            if _, fromErr := os.Stat(s.From); fromErr == nil {
+                return nil
+            }
+            return fmt.Errorf("can't bring %s back from the trash: %w", s.From, err)
+        }
+        if err := trash.Restore(s.To, s.From); err != nil {
+            return fmt.Errorf("can't bring %s back from the trash: %w", s.From, err)
+        }
+    case StepRewrite, StepDelete:
+        if s.Backup == "" {
+            return fmt.Errorf("can't restore %s, the original wasn't kept", s.From)
+        }
+        if _, err := os.Stat(s.Backup); err != nil {
+            return fmt.Errorf("can't restore %s, its backup is gone: %w", s.From, err)
+        }
+        if err := os.Rename(s.Backup, s.From); err != nil {
+            return fmt.Errorf("can't restore %s: %w", s.From, err)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,68 @@
+package history
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+)
+
+// LockName starts the names of the files that mark an output directory as in
+// use, one per run: LockName.<pid>.<run id>
+const LockName = ".convert-cbz.lock"
+
+// Lock gives runID exclusive use of outputDir until release is called. Runs
+// into different output directories never contend.
+//
+// Every run puts down a lock file of its own and then looks for the others,
+// keeping its own only when no run still alive has one. Two runs starting at
+// once may both back off but never both go ahead, and a lock left behind by a
+// process that is gone is simply removed, nobody has to take it over.
+func Lock(outputDir, runID string) (release func(), err error) {
+    name := fmt.Sprintf("%s.%d.%s", LockName, os.Getpid(), runID)
+    path := filepath.Join(outputDir, name)
+
+    f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+    if err != nil {
+        return nil, err
+    }
+    if err := f.Close(); err != nil {
+        os.Remove(path)
+        return nil, err
+    }
+
+    entries, err := os.ReadDir(outputDir)
+    if err != nil {
+        os.Remove(path)
+        return nil, err
+    }
+    for _, entry := range entries {
+        pid, owner, ok := parseLock(entry.Name())
+        if !ok || entry.Name() == name {
+            continue
+        }
+        if processAlive(pid) {
+            os.Remove(path)
+            return nil, fmt.Errorf("%s is in use by run %s (pid %d), remove %s if that run is gone", outputDir, owner, pid, entry.Name())
+        }
+
+        // Stale, whoever held it is gone
+        os.Remove(filepath.Join(outputDir, entry.Name()))
+    }
+    return func() { os.Remove(path) }, nil
+}
+
+// parseLock reads the pid and run ID out of the name of a lock file
+func parseLock(name string) (pid int, runID string, ok bool) {
+    rest, found := strings.CutPrefix(name, LockName+".")
+    if !found {
+        return 0, "", false
+    }
+    pidText, runID, _ := strings.Cut(rest, ".")
+    pid, err := strconv.Atoi(pidText)
+    if err != nil || pid <= 0 {
+        return 0, "", false
+    }
+    return pid, runID, true
+}
@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package history
+
+// processAlive can't tell here, so a lock is only given up when its file is
+// removed by hand
+func processAlive(pid int) bool {
+    return true
+}
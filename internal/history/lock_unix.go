@@ -0,0 +1,14 @@
+//go:build unix
+
+package history
+
+import (
+    "errors"
+    "syscall"
+)
+
+func processAlive(pid int) bool {
+    // Signal 0 only checks the process exists, EPERM means it does but isn't ours
+    err := syscall.Kill(pid, 0)
+    return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package history
+
+import "os"
+
+func processAlive(pid int) bool {
+    // FindProcess opens a handle on Windows and fails if there is no such process
+    p, err := os.FindProcess(pid)
+    if err != nil {
+        return false
+    }
+    p.Release()
+    return true
+}
@@ -0,0 +1,130 @@
+package ignore
+
+import (
+    "convert_cbz/internal/util"
+    "errors"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// FileName is the ignore file read from a folder, it applies to everything
+// below the folder and is never archived itself
+const FileName = ".cbzignore"
+
+// rule is one line of an ignore file
+type rule struct {
+    base    string // Folder holding the ignore file
+    pattern string
+    negate  bool // A "!" line, brings back what an earlier line ignored
+    dirOnly bool // A line ending in "/", matches folders only
+}
+
+// Rules are the lines of the ignore files read so far, in the order read.
+// The zero value ignores nothing.
+type Rules struct {
+    rules []rule
+}
+
+// ForFolder reads the ignore files from the first of roots folder is in down
+// to the parent of folder. Those of folder and below are left for Load as
+// they are walked.
+func ForFolder(folder string, roots []string) (*Rules, error) {
+    r := &Rules{}
+    for _, root := range roots {
+        abs, err := filepath.Abs(root)
+        if err != nil {
+            continue
+        }
+        rel, err := filepath.Rel(abs, folder)
+        if err != nil || !filepath.IsLocal(rel) {
+            continue
+        }
+        if rel == "." {
+            break
+        }
+        dir := abs
+        if err := r.Load(dir); err != nil {
+            return nil, err
+        }
+        if parent := filepath.Dir(rel); parent != "." {
+            for _, seg := range strings.Split(parent, string(filepath.Separator)) {
+                dir = filepath.Join(dir, seg)
+                if err := r.Load(dir); err != nil {
+                    return nil, err
+                }
+            }
+        }
+        break
+    }
+    return r, nil
+}
+
+// Load adds the lines of the ignore file in dir, if it has one. They follow
+// gitignore syntax: blank lines and ones starting with # are skipped, ! in
+// front brings back what an earlier line ignored, a trailing / matches
+// folders only, and a pattern with a slash other than at its end is taken
+// relative to dir, one without matches a name at any depth below it.
+// Patterns are matched like -exclude-dir patterns, ignoring case.
+func (r *Rules) Load(dir string) error {
+    data, err := os.ReadFile(filepath.Join(dir, FileName))
+    if errors.Is(err, fs.ErrNotExist) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    for _, line := range strings.Split(string(data), "\n") {
+        line = strings.TrimSuffix(line, "\r")
+        if !strings.HasSuffix(line, `\ `) {
+            line = strings.TrimRight(line, " \t")
+        }
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+
+        ru := rule{base: dir}
+        if strings.HasPrefix(line, "!") {
+            ru.negate, line = true, line[1:]
+        } else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+            line = line[1:]
+        }
+        if strings.HasSuffix(line, "/") {
+            ru.dirOnly, line = true, strings.TrimRight(line, "/")
+        }
+        line = strings.ReplaceAll(line, `\ `, " ")
+        if line == "" {
+            continue
+        }
+        // util.MatchPath anchors patterns with a slash, and only those
+        if strings.Contains(line, "/") && !strings.HasPrefix(line, "/") {
+            line = "/" + line
+        }
+        ru.pattern = line
+        r.rules = append(r.rules, ru)
+    }
+    return nil
+}
+
+// Match reports whether path, a folder if dir is set, is ignored: the last
+// line that matches it decides
+func (r *Rules) Match(path string, dir bool) bool {
+    if r == nil {
+        return false
+    }
+    ignored := false
+    for _, ru := range r.rules {
+        if ru.dirOnly && !dir {
+            continue
+        }
+        rel, err := filepath.Rel(ru.base, path)
+        if err != nil || rel == "." || !filepath.IsLocal(rel) {
+            continue
+        }
+        if util.MatchPath(ru.pattern, filepath.ToSlash(rel)) {
+            ignored = !ru.negate
+        }
+    }
+    return ignored
+}
@@ -0,0 +1,32 @@
+package naming
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// layouts are the templates a layout name stands for, named after the
+// library servers whose scanners expect them
+var layouts = map[string]string{
+    // Komga takes every folder for a series and sorts its books by name, so
+    // each is named after the series with zero padded numbers. Archives
+    // without either number keep their folder name.
+    "komga": "{series}/{series} Vol {volume:2} Ch {number:3}|{series}/{series} Vol {volume:2}|{series}/{series} Ch {number:3}|{series}/{folder}",
+    // Kavita parses "Vol.X Ch.Y" out of the file name and takes what is in a
+    // Specials folder for specials, as are the archives it couldn't number.
+    "kavita": "{series}/Specials/{series} - {special}|{series}/{series} Vol.{volume:2} Ch.{number:3}|{series}/{series} Vol.{volume:2}|{series}/{series} Ch.{number:3}|{series}/Specials/{series} - {folder}",
+}
+
+// Layout returns the template of the layout called name
+func Layout(name string) (string, error) {
+    if tmpl, ok := layouts[strings.ToLower(name)]; ok {
+        return tmpl, nil
+    }
+    var names []string
+    for n := range layouts {
+        names = append(names, n)
+    }
+    sort.Strings(names)
+    return "", fmt.Errorf("unknown layout %q, there are: %s", name, strings.Join(names, ", "))
+}
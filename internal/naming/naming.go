@@ -0,0 +1,272 @@
+package naming
+
+import (
+    "convert_cbz/internal/comicinfo"
+    "fmt"
+    "path"
+    "path/filepath"
+    "strconv"
+    "strings"
+)
+
+// Fields are the values a template can use for one archive
+type Fields struct {
+    Folder  string // Source folder name
+    Series  string // Series map title, or the folder the source folder is in
+    Number  string
+    Volume  string
+    Title   string
+    Group   string
+    Special string // Folder name without [tags] when it is a special, "" otherwise
+}
+
+// FieldsFor works out the fields of a source folder from its path. The folder
+// doesn't have to exist, only the names are used. Nil titles parses with the
+// default pattern.
+func FieldsFor(sourcePath string, titles *comicinfo.TitleParser, series *comicinfo.SeriesMap) Fields {
+    if titles == nil {
+        titles, _ = comicinfo.NewTitleParser("")
+    }
+    folder := filepath.Base(sourcePath)
+    ch := titles.Parse(folder)
+    f := Fields{
+        Folder: folder,
+        Series: filepath.Base(filepath.Dir(sourcePath)),
+        Number: ch.Number,
+        Volume: ch.Volume,
+        Title:  ch.Title,
+        Group:  ch.Group,
+    }
+    // A whole volume in one folder, "Volume 3", has no chapter number
+    if f.Number == "" && f.Volume == "" {
+        f.Volume = comicinfo.MarkedVolume(folder)
+    }
+    if ch.Special {
+        f.Special = comicinfo.StripTags(folder)
+    }
+    if info, ok := series.Lookup(f.Series); ok && info.Title != "" {
+        f.Series = info.Title
+    }
+    return f
+}
+
+// FieldsForItem is FieldsFor with the series folder and volume a library
+// layout found, either may be empty to keep what the path says
+func FieldsForItem(sourcePath, seriesFolder, volume string, titles *comicinfo.TitleParser, series *comicinfo.SeriesMap) Fields {
+    f := FieldsFor(sourcePath, titles, series)
+    if seriesFolder != "" {
+        f.Series = seriesFolder
+        if info, ok := series.Lookup(seriesFolder); ok && info.Title != "" {
+            f.Series = info.Title
+        }
+    }
+    if volume != "" {
+        f.Volume = volume
+    }
+    return f
+}
+
+// Template turns fields into an archive path relative to the output
+// directory. Placeholders are {folder}, {series}, {number}, {volume}, {title},
+// {group} and {special}, with {chapter} another name for {number}; {number:3} pads
+// numbers with zeros to 3 digits. Text in
+// <angle brackets> is left out when a placeholder inside it is empty, and /
+// starts a subdirectory:
+//
+//	{series}/{series} - c{number:3}< - {title}>
+//
+// Alternatives separated by | are tried in turn, the first whose
+// placeholders outside angle brackets all have a value names the archive,
+// the last one always does:
+//
+//	{series}/{series} c{number:3}|{series}/{folder}
+type Template struct {
+    raw  string
+    alts [][]part
+}
+
+type part struct {
+    text     string
+    field    string
+    pad      int
+    optional []part // Set for an <optional> section
+}
+
+// Default names archives after their folder, the way conversions always have
+const Default = "{folder}"
+
+// Parse checks and compiles a template
+func Parse(s string) (*Template, error) {
+    if strings.TrimSpace(s) == "" {
+        s = Default
+    }
+    t := &Template{raw: s}
+    for _, alt := range strings.Split(s, "|") {
+        parts, rest, err := parseParts(alt, false)
+        if err != nil {
+            return nil, fmt.Errorf("invalid name template %q: %w", s, err)
+        }
+        if rest != "" {
+            return nil, fmt.Errorf("invalid name template %q: unmatched >", s)
+        }
+        if strings.TrimSpace(alt) == "" {
+            return nil, fmt.Errorf("invalid name template %q: empty alternative", s)
+        }
+        t.alts = append(t.alts, parts)
+    }
+    return t, nil
+}
+
+func parseParts(s string, inOptional bool) ([]part, string, error) {
+    var parts []part
+    for s != "" {
+        switch s[0] {
+        case '{':
+            end := strings.IndexByte(s, '}')
+            if end < 0 {
+                return nil, "", fmt.Errorf("unclosed {")
+            }
+            p, err := parseField(s[1:end])
+            if err != nil {
+                return nil, "", err
+            }
+            parts = append(parts, p)
+            s = s[end+1:]
+        case '<':
+            if inOptional {
+                return nil, "", fmt.Errorf("optional sections can't be nested")
+            }
+            inner, rest, err := parseParts(s[1:], true)
+            if err != nil {
+                return nil, "", err
+            }
+            if !strings.HasPrefix(rest, ">") {
+                return nil, "", fmt.Errorf("unclosed <")
+            }
+            parts = append(parts, part{optional: inner})
+            s = rest[1:]
+        case '>':
+            if !inOptional {
+                return nil, s, nil
+            }
+            return parts, s, nil
+        default:
+            end := strings.IndexAny(s, "{<>")
+            if end < 0 {
+                end = len(s)
+            }
+            parts = append(parts, part{text: s[:end]})
+            s = s[end:]
+        }
+    }
+    return parts, "", nil
+}
+
+func parseField(spec string) (part, error) {
+    name, padding, hasPad := strings.Cut(spec, ":")
+    switch name {
+    case "folder", "series", "number", "chapter", "volume", "title", "group", "special":
+    default:
+        return part{}, fmt.Errorf("unknown placeholder {%s}", spec)
+    }
+    p := part{field: name}
+    if hasPad {
+        n, err := strconv.Atoi(padding)
+        if err != nil || n < 1 || (name != "number" && name != "chapter" && name != "volume") {
+            return part{}, fmt.Errorf("invalid padding in {%s}, only number, chapter and volume take a width", spec)
+        }
+        p.pad = n
+    }
+    return p, nil
+}
+
+func (t *Template) String() string {
+    return t.raw
+}
+
+// Render returns the archive path for f, relative and slash separated,
+// including the .cbz extension
+func (t *Template) Render(f Fields) (string, error) {
+    var name string
+    for _, parts := range t.alts {
+        var complete bool
+        if name, complete = render(parts, f); complete {
+            break
+        }
+    }
+    name = path.Clean(strings.TrimSpace(name))
+
+    var segments []string
+    for _, seg := range strings.Split(name, "/") {
+        if seg = strings.TrimSpace(seg); seg != "" {
+            segments = append(segments, seg)
+        }
+    }
+    if len(segments) == 0 || segments[0] == ".." {
+        return "", fmt.Errorf("template %q gives no usable name for %s", t.raw, f.Folder)
+    }
+    return strings.Join(segments, "/") + ".cbz", nil
+}
+
+// Path is Render joined onto outputDir
+func (t *Template) Path(outputDir string, f Fields) (string, error) {
+    rel, err := t.Render(f)
+    if err != nil {
+        return "", err
+    }
+    return filepath.Join(outputDir, filepath.FromSlash(rel)), nil
+}
+
+// render reports whether every placeholder it filled in had a value
+func render(parts []part, f Fields) (string, bool) {
+    var sb strings.Builder
+    complete := true
+    for _, p := range parts {
+        switch {
+        case p.optional != nil:
+            if s, ok := render(p.optional, f); ok {
+                sb.WriteString(s)
+            }
+        case p.field != "":
+            v := value(p, f)
+            if v == "" {
+                complete = false
+            }
+            sb.WriteString(v)
+        default:
+            sb.WriteString(p.text)
+        }
+    }
+    return sb.String(), complete
+}
+
+func value(p part, f Fields) string {
+    var v string
+    switch p.field {
+    case "folder":
+        v = f.Folder
+    case "series":
+        v = f.Series
+    case "number", "chapter":
+        v = f.Number
+    case "volume":
+        v = f.Volume
+    case "title":
+        v = f.Title
+    case "group":
+        v = f.Group
+    case "special":
+        v = f.Special
+    }
+    if p.pad > 0 && v != "" {
+        whole, frac, hasFrac := strings.Cut(v, ".")
+        if len(whole) < p.pad {
+            whole = strings.Repeat("0", p.pad-len(whole)) + whole
+        }
+        if v = whole; hasFrac {
+            v += "." + frac
+        }
+    }
+    // A value never adds directories of its own
+    return strings.NewReplacer("/", "-", "\\", "-").Replace(v)
+}
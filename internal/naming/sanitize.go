@@ -0,0 +1,105 @@
+package naming
+
+import (
+    "fmt"
+    "path/filepath"
+    "strings"
+)
+
+// DefaultReplacement stands in for the characters a sanitizer removes
+const DefaultReplacement = "_"
+
+// Characters Windows and exFAT refuse in file names, besides control characters
+const invalidChars = `<>:"/\|?*`
+
+// Device names Windows reserves whatever the extension, CON.cbz included
+var reservedNames = map[string]bool{
+    "CON": true, "PRN": true, "AUX": true, "NUL": true,
+    "COM0": true, "COM1": true, "COM2": true, "COM3": true, "COM4": true,
+    "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+    "LPT0": true, "LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true,
+    "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// Sanitizer rewrites names so they can be copied to Windows and exFAT drives.
+// A nil Sanitizer leaves every name as it is.
+type Sanitizer struct {
+    replacement string
+}
+
+// NewSanitizer returns a sanitizer that puts replacement in place of every
+// character it can't keep, an empty replacement drops them
+func NewSanitizer(replacement string) (*Sanitizer, error) {
+    for _, r := range replacement {
+        if r < 0x20 || strings.ContainsRune(invalidChars, r) {
+            return nil, fmt.Errorf("replacement %q has characters that aren't allowed in file names", replacement)
+        }
+    }
+    return &Sanitizer{replacement: replacement}, nil
+}
+
+// Name sanitizes a single path component
+func (s *Sanitizer) Name(name string) string {
+    if s == nil {
+        return name
+    }
+
+    var sb strings.Builder
+    for _, r := range name {
+        if r < 0x20 || strings.ContainsRune(invalidChars, r) {
+            sb.WriteString(s.replacement)
+        } else {
+            sb.WriteRune(r)
+        }
+    }
+    name = sb.String()
+
+    // Windows drops trailing dots and spaces, two names would end up as one
+    trimmed := strings.TrimRight(name, ". ")
+    if trimmed != name {
+        name = trimmed + s.replacement
+        if s.replacement == "" {
+            name = trimmed
+        }
+    }
+    if name == "" {
+        return DefaultReplacement
+    }
+
+    stem, ext, _ := strings.Cut(name, ".")
+    if reservedNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+        suffix := s.replacement
+        if suffix == "" {
+            suffix = DefaultReplacement
+        }
+        name = stem + suffix
+        if ext != "" {
+            name += "." + ext
+        }
+    }
+    return name
+}
+
+// Path sanitizes every component of a slash separated path
+func (s *Sanitizer) Path(rel string) string {
+    if s == nil {
+        return rel
+    }
+    segments := strings.Split(rel, "/")
+    for i, seg := range segments {
+        segments[i] = s.Name(seg)
+    }
+    return strings.Join(segments, "/")
+}
+
+// Under sanitizes the part of path below dir, dir itself is left alone
+func (s *Sanitizer) Under(dir, path string) string {
+    rel, err := filepath.Rel(dir, path)
+    if s == nil || err != nil {
+        return path
+    }
+    if safe := s.Path(filepath.ToSlash(rel)); safe != filepath.ToSlash(rel) {
+        return filepath.Join(dir, filepath.FromSlash(safe))
+    }
+    return path
+}
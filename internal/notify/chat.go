@@ -0,0 +1,67 @@
+package notify
+
+import (
+    "convert_cbz/internal/types"
+    "convert_cbz/internal/util"
+    "fmt"
+    "strings"
+    "time"
+)
+
+// maxFailureMessages is how many failed folders a chat is told about one by
+// one, the summary counts the rest, so a run failing everything doesn't
+// flood the channel
+const maxFailureMessages = 10
+
+// chat turns events into the short messages chat notifiers post: one per
+// failed folder and one once the run is over
+type chat struct {
+    failures int
+}
+
+// message is what to post for e, false when nothing is
+func (c *chat) message(e Event) (string, bool) {
+    switch {
+    case e.Summary != nil:
+        return summaryMessage(e.RunID, e.Summary), true
+    case e.Item != nil && e.Item.Status == types.StatusFailed:
+        c.failures++
+        if c.failures > maxFailureMessages {
+            return "", false
+        }
+        msg := fmt.Sprintf("✗ %s failed: %s\n%s", e.Item.FolderName, e.Item.Error, e.Item.SourcePath)
+        if c.failures == maxFailureMessages {
+            msg += fmt.Sprintf("\nFurther failures are only counted in the summary of run %s", e.RunID)
+        }
+        return msg, true
+    }
+    return "", false
+}
+
+func summaryMessage(runID string, s *Summary) string {
+    var b strings.Builder
+    switch {
+    case s.Interrupted:
+        fmt.Fprintf(&b, "⚠ Run %s interrupted", runID)
+    case s.Stats.Errors > 0:
+        fmt.Fprintf(&b, "⚠ Run %s finished with errors", runID)
+    default:
+        fmt.Fprintf(&b, "✓ Run %s finished", runID)
+    }
+    fmt.Fprintf(&b, " in %s\n", util.FmtDuration(time.Duration(s.Duration*float64(time.Second))))
+    fmt.Fprintf(&b, "%d converted, %d skipped, %d failed of %d", s.Stats.Success, s.Stats.Skipped, s.Stats.Errors, s.Stats.Total)
+    if s.Deferred > 0 {
+        fmt.Fprintf(&b, ", %d left for -resume", s.Deferred)
+    }
+    fmt.Fprintf(&b, "\n%s", s.OutputDir)
+    return b.String()
+}
+
+// truncate shortens msg to at most limit characters
+func truncate(msg string, limit int) string {
+    runes := []rune(msg)
+    if len(runes) <= limit {
+        return msg
+    }
+    return string(runes[:limit-1]) + "…"
+}
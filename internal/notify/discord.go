@@ -0,0 +1,38 @@
+package notify
+
+import "net/http"
+
+// discordAPI is where Discord's bot API is
+const discordAPI = "https://discord.com/api/v10"
+
+// discordLimit is the longest message Discord takes
+const discordLimit = 2000
+
+// Discord posts failures and the summary to a channel as a bot
+type Discord struct {
+    Token   string
+    Channel string
+    chat
+}
+
+// NewDiscord returns a Discord posting to channel with the bot token
+func NewDiscord(token, channel string) *Discord {
+    return &Discord{Token: token, Channel: channel}
+}
+
+func (d *Discord) Send(e Event) error {
+    msg, ok := d.message(e)
+    if !ok {
+        return nil
+    }
+    header := http.Header{"Authorization": {"Bot " + d.Token}}
+    return postJSON(discordAPI+"/channels/"+d.Channel+"/messages", header, map[string]any{
+        "content": truncate(msg, discordLimit),
+        // Folder names are never mentions
+        "allowed_mentions": map[string][]string{"parse": {}},
+    })
+}
+
+func (d *Discord) String() string {
+    return "Discord channel " + d.Channel
+}
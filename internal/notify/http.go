@@ -0,0 +1,76 @@
+package notify
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "io"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+)
+
+// requestTimeout bounds a single delivery attempt
+const requestTimeout = 10 * time.Second
+
+// attempts is how often a delivery is tried before it is given up
+const attempts = 3
+
+// errorBodyLimit is how much of a refusal's body ends up in the error,
+// where services like Telegram say what was wrong
+const errorBodyLimit = 512
+
+var client = &http.Client{Timeout: requestTimeout}
+
+// postJSON posts v as JSON to target with header, trying again a little
+// later when the receiver is down, rate limits or answers with a server error
+func postJSON(target string, header http.Header, v any) error {
+    body, err := json.Marshal(v)
+    if err != nil {
+        return err
+    }
+
+    for attempt := 1; ; attempt++ {
+        retry, err := post(target, header, body)
+        if err == nil || !retry || attempt == attempts {
+            return err
+        }
+        time.Sleep(time.Duration(attempt) * time.Second)
+    }
+}
+
+// post makes one attempt, reporting whether a failure is worth another
+func post(target string, header http.Header, body []byte) (bool, error) {
+    req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+    if err != nil {
+        return false, err
+    }
+    for name, values := range header {
+        req.Header[name] = values
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("User-Agent", "convert-cbz")
+
+    resp, err := client.Do(req)
+    if err != nil {
+        // Without the URL, which may hold a token
+        var urlErr *url.Error
+        if errors.As(err, &urlErr) {
+            err = urlErr.Err
+        }
+        return true, err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+        io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+        return false, nil
+    }
+    retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+    answer, _ := io.ReadAll(io.LimitReader(resp.Body, errorBodyLimit))
+    if msg := strings.TrimSpace(string(answer)); msg != "" {
+        return retry, errors.New(resp.Status + ": " + msg)
+    }
+    return retry, errors.New(resp.Status)
+}
@@ -0,0 +1,123 @@
+package notify
+
+import (
+    "convert_cbz/internal/types"
+    "fmt"
+    "time"
+
+    "github.com/jelius-sama/logger"
+)
+
+// Kinds of Event
+const (
+    EventItem    = "item"    // A folder converted, was skipped or failed
+    EventSummary = "summary" // The run is over
+)
+
+// queueSize is how many events may wait for a slow receiver before the
+// workers wait for it too
+const queueSize = 1024
+
+// drainTimeout is how long Finish waits for the events still queued
+const drainTimeout = 30 * time.Second
+
+// maxFailures is how many events in a row a sender may fail to deliver
+// before the rest of the run isn't sent to it, so a receiver that is down
+// doesn't slow every folder
+const maxFailures = 3
+
+// Event is one notification, about an item or about the whole run
+type Event struct {
+    Event   string            `json:"event"` // EventItem or EventSummary
+    Time    time.Time         `json:"time"`
+    RunID   string            `json:"run_id"`
+    Item    *types.ItemResult `json:"item,omitempty"`
+    Summary *Summary          `json:"summary,omitempty"`
+}
+
+// Summary is what a run did, the items were sent one by one already
+type Summary struct {
+    Started     time.Time           `json:"started"`
+    Finished    time.Time           `json:"finished"`
+    Duration    float64             `json:"duration"` // Seconds
+    OutputDir   string              `json:"output_dir"`
+    Stats       types.StatsSnapshot `json:"stats"`
+    Deferred    int                 `json:"deferred"` // Folders left for -resume
+    Interrupted bool                `json:"interrupted"`
+}
+
+// Sender delivers events somewhere
+type Sender interface {
+    Send(e Event) error
+    String() string // Where to, for the log
+}
+
+// Dispatcher hands the events of a run to every sender in the order they
+// happened, from a goroutine of its own so a slow receiver doesn't hold up
+// the workers. A nil Dispatcher drops them.
+type Dispatcher struct {
+    runID   string
+    senders []Sender
+    queue   chan Event
+    done    chan struct{}
+}
+
+// Start returns a Dispatcher sending to senders, nil without any
+func Start(runID string, senders []Sender) *Dispatcher {
+    if len(senders) == 0 {
+        return nil
+    }
+    d := &Dispatcher{
+        runID:   runID,
+        senders: senders,
+        queue:   make(chan Event, queueSize),
+        done:    make(chan struct{}),
+    }
+    go d.run()
+    return d
+}
+
+func (d *Dispatcher) run() {
+    defer close(d.done)
+    failures := make([]int, len(d.senders))
+    for e := range d.queue {
+        for i, s := range d.senders {
+            if failures[i] >= maxFailures {
+                continue
+            }
+            err := s.Send(e)
+            if err == nil {
+                failures[i] = 0
+                continue
+            }
+            failures[i]++
+            logger.Warning(fmt.Sprintf("Failed to notify %s: %v", s, err))
+            if failures[i] == maxFailures {
+                logger.Warning(fmt.Sprintf("Not notifying %s for the rest of the run", s))
+            }
+        }
+    }
+}
+
+// Item queues the result of an item, safe to call from the workers
+func (d *Dispatcher) Item(res types.ItemResult) {
+    if d == nil {
+        return
+    }
+    d.queue <- Event{Event: EventItem, Time: time.Now(), RunID: d.runID, Item: &res}
+}
+
+// Finish queues the summary and waits for everything queued to be sent,
+// giving up on what is left after drainTimeout
+func (d *Dispatcher) Finish(summary Summary) {
+    if d == nil {
+        return
+    }
+    d.queue <- Event{Event: EventSummary, Time: time.Now(), RunID: d.runID, Summary: &summary}
+    close(d.queue)
+    select {
+    case <-d.done:
+    case <-time.After(drainTimeout):
+        logger.Warning("Gave up waiting for notifications to be delivered")
+    }
+}
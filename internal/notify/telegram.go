@@ -0,0 +1,35 @@
+package notify
+
+// telegramAPI is where Telegram's bot API is
+const telegramAPI = "https://api.telegram.org"
+
+// telegramLimit is the longest message Telegram takes
+const telegramLimit = 4096
+
+// Telegram posts failures and the summary to a chat as a bot
+type Telegram struct {
+    Token string
+    Chat  string // Chat ID, or @name of a public channel
+    chat
+}
+
+// NewTelegram returns a Telegram posting to chat with the bot token
+func NewTelegram(token, chat string) *Telegram {
+    return &Telegram{Token: token, Chat: chat}
+}
+
+func (t *Telegram) Send(e Event) error {
+    msg, ok := t.message(e)
+    if !ok {
+        return nil
+    }
+    return postJSON(telegramAPI+"/bot"+t.Token+"/sendMessage", nil, map[string]any{
+        "chat_id":                  t.Chat,
+        "text":                     truncate(msg, telegramLimit),
+        "disable_web_page_preview": true,
+    })
+}
+
+func (t *Telegram) String() string {
+    return "Telegram chat " + t.Chat
+}
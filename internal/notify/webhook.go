@@ -0,0 +1,37 @@
+package notify
+
+import (
+    "fmt"
+    "net/url"
+)
+
+// Webhook POSTs every event as JSON to URL
+type Webhook struct {
+    URL string
+}
+
+// NewWebhook checks rawURL is an http or https URL and returns a Webhook for it
+func NewWebhook(rawURL string) (*Webhook, error) {
+    u, err := url.Parse(rawURL)
+    if err != nil {
+        return nil, err
+    }
+    if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return nil, fmt.Errorf("%q isn't an http or https URL", rawURL)
+    }
+    return &Webhook{URL: rawURL}, nil
+}
+
+// Send posts e as it is
+func (w *Webhook) Send(e Event) error {
+    return postJSON(w.URL, nil, e)
+}
+
+// String is the URL without its credentials or query, which may hold a token
+func (w *Webhook) String() string {
+    u, err := url.Parse(w.URL)
+    if err != nil {
+        return "webhook"
+    }
+    return u.Scheme + "://" + u.Host + u.Path
+}
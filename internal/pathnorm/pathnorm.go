@@ -0,0 +1,76 @@
+package pathnorm
+
+import (
+    "convert_cbz/internal/types"
+    "os"
+    "path/filepath"
+    "runtime"
+    "strings"
+)
+
+// Windows and macOS default to case-insensitive filesystems, until told otherwise
+var caseInsensitive = runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+
+// Configure sets how paths are compared. In auto mode the filesystem holding
+// probeDir is tested by creating a mixed-case file and looking it up in lower case.
+func Configure(mode types.CaseMode, probeDir string) {
+    switch mode {
+    case types.CaseSensitive:
+        caseInsensitive = false
+    case types.CaseInsensitive:
+        caseInsensitive = true
+    default:
+        if detected, ok := probe(probeDir); ok {
+            caseInsensitive = detected
+        }
+    }
+}
+
+// CaseInsensitive reports whether paths are compared ignoring case
+func CaseInsensitive() bool {
+    return caseInsensitive
+}
+
+// Key returns a normalized form of path for use as a map key: absolute,
+// cleaned, and lower-cased on case-insensitive filesystems
+func Key(path string) string {
+    if abs, err := filepath.Abs(path); err == nil {
+        path = abs
+    }
+    path = filepath.Clean(path)
+    if caseInsensitive {
+        path = strings.ToLower(path)
+    }
+    return path
+}
+
+// Equal reports whether a and b name the same file under the current rules
+func Equal(a, b string) bool {
+    return Key(a) == Key(b)
+}
+
+func probe(dir string) (insensitive bool, ok bool) {
+    // The output directory may not exist yet (dry run), test its closest existing parent
+    for {
+        if info, err := os.Stat(dir); err == nil && info.IsDir() {
+            break
+        }
+        parent := filepath.Dir(dir)
+        if parent == dir {
+            return false, false
+        }
+        dir = parent
+    }
+
+    f, err := os.CreateTemp(dir, ".CaseProbe-*")
+    if err != nil {
+        return false, false
+    }
+    name := f.Name()
+    f.Close()
+    defer os.Remove(name)
+
+    lower := filepath.Join(filepath.Dir(name), strings.ToLower(filepath.Base(name)))
+    _, err = os.Stat(lower)
+    return err == nil, true
+}
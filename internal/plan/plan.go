@@ -0,0 +1,159 @@
+package plan
+
+import (
+    "convert_cbz/internal/history"
+    "convert_cbz/internal/pathnorm"
+    "convert_cbz/internal/types"
+    "convert_cbz/internal/util"
+    "fmt"
+    "os"
+    "path/filepath"
+)
+
+// Action is what a run would do with a folder
+type Action string
+
+const (
+    ActionNew       Action = "new"        // Never seen before, will be converted
+    ActionReconvert Action = "re-convert" // Seen before but the archive has to be built again
+    ActionUnchanged Action = "unchanged"  // Archive already there, will be skipped
+    ActionRemoved   Action = "removed"    // In the last run but no longer among the inputs
+)
+
+// Entry is a single line of the plan
+type Entry struct {
+    Action     Action
+    FolderName string
+    SourcePath string
+    OutputPath string
+    Reason     string
+
+    // Sync plans only
+    Hash     string                // Fingerprint of the source as it is now
+    Previous *history.CatalogEntry // What the catalog knew about the source
+}
+
+// Plan is what a run would do, compared against the previous run into the same output
+type Plan struct {
+    Entries []Entry
+    Last    *history.Run
+    Title   string // Replaces the comparison line, when set
+
+    bySource map[string]int // pathnorm.Key of each source to its entry, built by ForSource
+}
+
+// Build works out the plan for workItems against the last run (which may be
+// nil). update is -update, adding new files to existing archives.
+func Build(workItems []types.WorkItem, last *history.Run, overwrite types.OverwriteMode, update bool) *Plan {
+    p := &Plan{Last: last}
+
+    previous := make(map[string]history.Item)
+    if last != nil {
+        for _, it := range last.Items {
+            previous[pathnorm.Key(it.Source)] = it
+        }
+    }
+
+    seen := make(map[string]bool)
+    for _, item := range workItems {
+        seen[pathnorm.Key(item.SourcePath)] = true
+        e := Entry{
+            FolderName: item.FolderName,
+            SourcePath: item.SourcePath,
+            OutputPath: item.OutputPath,
+        }
+
+        prev, known := previous[pathnorm.Key(item.SourcePath)]
+        _, statErr := os.Stat(item.OutputPath)
+        exists := statErr == nil
+
+        switch {
+        case exists && overwrite == types.OverwriteAlways:
+            e.Action = ActionReconvert
+            e.Reason = "overwrite always"
+        case exists && update:
+            e.Action = ActionUnchanged
+            e.Reason = "archive exists, new files are added to it"
+        case exists && overwrite == types.OverwriteIfDifferent:
+            e.Action = ActionUnchanged
+            e.Reason = "archive exists, rebuilt if the source changed"
+        case exists:
+            e.Action = ActionUnchanged
+            e.Reason = "archive exists"
+        case !known:
+            e.Action = ActionNew
+        case prev.Status == types.StatusFailed:
+            e.Action = ActionReconvert
+            e.Reason = "failed last run: " + prev.Error
+        default:
+            e.Action = ActionReconvert
+            e.Reason = "archive missing"
+        }
+        p.Entries = append(p.Entries, e)
+    }
+
+    if last != nil {
+        for _, it := range last.Items {
+            if seen[pathnorm.Key(it.Source)] {
+                continue
+            }
+            p.Entries = append(p.Entries, Entry{
+                Action:     ActionRemoved,
+                FolderName: it.Folder,
+                SourcePath: it.Source,
+                OutputPath: it.Output,
+                Reason:     "last run: " + string(it.Status),
+            })
+        }
+    }
+
+    return p
+}
+
+// Count returns how many entries have the given action
+func (p *Plan) Count(a Action) int {
+    n := 0
+    for _, e := range p.Entries {
+        if e.Action == a {
+            n++
+        }
+    }
+    return n
+}
+
+// Print writes the plan in a terraform-plan-like layout
+func (p *Plan) Print() {
+    if p.Title != "" {
+        fmt.Printf("\033[90m%s\033[0m\n\n", p.Title)
+    } else if p.Last != nil {
+        fmt.Printf("\033[90mComparing against run %s (%s)\033[0m\n\n", p.Last.ID, p.Last.Started.Format("2006-01-02 15:04"))
+    } else {
+        fmt.Print("\033[90mNo previous run into this output directory, everything is new\033[0m\n\n")
+    }
+
+    for _, e := range p.Entries {
+        sign, color := "=", "\033[90m"
+        switch e.Action {
+        case ActionNew:
+            sign, color = "+", "\033[32m"
+        case ActionReconvert:
+            sign, color = "~", "\033[33m"
+        case ActionRemoved:
+            sign, color = "-", "\033[31m"
+        case ActionRenamed:
+            sign, color = ">", "\033[36m"
+        }
+
+        line := fmt.Sprintf("%s%s %s\033[0m  %-10s → %s", color, sign, util.PadRight(util.TruncateName(e.FolderName), util.TruncateWidth), e.Action, filepath.Base(e.OutputPath))
+        if e.Reason != "" {
+            line += "  \033[90m(" + e.Reason + ")\033[0m"
+        }
+        fmt.Println(line)
+    }
+
+    summary := fmt.Sprintf("\nPlan: %d new, %d re-convert, %d unchanged", p.Count(ActionNew), p.Count(ActionReconvert), p.Count(ActionUnchanged))
+    if n := p.Count(ActionRenamed); n > 0 {
+        summary += fmt.Sprintf(", %d renamed", n)
+    }
+    fmt.Printf("%s, %d removed\n", summary, p.Count(ActionRemoved))
+}
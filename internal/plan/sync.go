@@ -0,0 +1,131 @@
+package plan
+
+import (
+    "convert_cbz/internal/history"
+    "convert_cbz/internal/pathnorm"
+    "convert_cbz/internal/types"
+    "os"
+    "path/filepath"
+)
+
+// ActionRenamed means the folder moved, its archive is moved along instead of rebuilt
+const ActionRenamed Action = "renamed"
+
+// SyncSettings are the options a sync converts with, entries built with
+// different ones are converted again
+type SyncSettings struct {
+    Dumb        bool
+    Compression string
+}
+
+// BuildSync compares workItems, with hashes[i] the fingerprint of
+// workItems[i], against the catalog. Entries whose source is gone and that no
+// folder was renamed from come out as removed.
+func BuildSync(workItems []types.WorkItem, hashes []string, cat *history.Catalog, settings SyncSettings) *Plan {
+    p := &Plan{Title: "Comparing against the catalog of " + cat.OutputDir}
+
+    // Folders that vanished are rename candidates, matched by hash
+    gone := make(map[string][]*history.CatalogEntry)
+    for _, e := range cat.Entries {
+        if _, err := os.Stat(e.Source); err != nil {
+            gone[e.Hash] = append(gone[e.Hash], e)
+        }
+    }
+
+    for i, item := range workItems {
+        e := Entry{
+            FolderName: item.FolderName,
+            SourcePath: item.SourcePath,
+            OutputPath: item.OutputPath,
+            Hash:       hashes[i],
+        }
+
+        prev := cat.Lookup(item.SourcePath)
+        if prev == nil {
+            if candidates := gone[hashes[i]]; len(candidates) > 0 {
+                prev, gone[hashes[i]] = candidates[0], candidates[1:]
+                e.Previous = prev
+                e.Action = ActionRenamed
+                e.Reason = "was " + filepath.Base(prev.Source)
+                if _, err := os.Stat(prev.Output); err != nil {
+                    e.Action = ActionReconvert
+                    e.Reason = "renamed, archive missing"
+                }
+                p.Entries = append(p.Entries, e)
+                continue
+            }
+        }
+        e.Previous = prev
+
+        _, statErr := os.Stat(item.OutputPath)
+        switch {
+        case prev == nil:
+            e.Action = ActionNew
+        case statErr != nil:
+            e.Action = ActionReconvert
+            e.Reason = "archive missing"
+        case prev.Hash != hashes[i]:
+            e.Action = ActionReconvert
+            e.Reason = "source changed"
+        case prev.Dumb != settings.Dumb || prev.Compression != settings.Compression:
+            e.Action = ActionReconvert
+            e.Reason = "options changed"
+        default:
+            e.Action = ActionUnchanged
+        }
+        p.Entries = append(p.Entries, e)
+    }
+
+    // Whatever is left was deleted, listed in catalog order to keep the output stable
+    left := make(map[*history.CatalogEntry]bool)
+    for _, candidates := range gone {
+        for _, prev := range candidates {
+            left[prev] = true
+        }
+    }
+    for _, prev := range cat.Entries {
+        if left[prev] {
+            p.Entries = append(p.Entries, Entry{
+                Action:     ActionRemoved,
+                FolderName: filepath.Base(prev.Source),
+                SourcePath: prev.Source,
+                OutputPath: prev.Output,
+                Reason:     "source deleted",
+                Previous:   prev,
+            })
+        }
+    }
+
+    return p
+}
+
+// Pending returns the work items the plan has to convert
+func (p *Plan) Pending(dumbMode bool) []types.WorkItem {
+    var items []types.WorkItem
+    for _, e := range p.Entries {
+        if e.Action == ActionNew || e.Action == ActionReconvert {
+            items = append(items, types.WorkItem{
+                FolderName: e.FolderName,
+                SourcePath: e.SourcePath,
+                OutputPath: e.OutputPath,
+                DumbMode:   dumbMode,
+            })
+        }
+    }
+    return items
+}
+
+// ForSource returns the plan entry for a source path, or nil. The plan must
+// not change once it was asked.
+func (p *Plan) ForSource(source string) *Entry {
+    if p.bySource == nil {
+        p.bySource = make(map[string]int, len(p.Entries))
+        for i := len(p.Entries) - 1; i >= 0; i-- {
+            p.bySource[pathnorm.Key(p.Entries[i].SourcePath)] = i
+        }
+    }
+    if i, ok := p.bySource[pathnorm.Key(source)]; ok {
+        return &p.Entries[i]
+    }
+    return nil
+}
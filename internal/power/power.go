@@ -0,0 +1,13 @@
+package power
+
+// Status is what the OS tells us about the machine's power and thermal state.
+// Fields the platform can't report stay at their zero value.
+type Status struct {
+    OnBattery   bool
+    Temperature float64 // Hottest thermal zone in °C, zero when unknown
+}
+
+// Read returns the current power status
+func Read() Status {
+    return read()
+}
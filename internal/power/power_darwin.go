@@ -0,0 +1,17 @@
+//go:build darwin
+
+package power
+
+import (
+    "os/exec"
+    "strings"
+)
+
+// macOS doesn't expose temperatures without private APIs, only the power source
+func read() Status {
+    out, err := exec.Command("pmset", "-g", "batt").Output()
+    if err != nil {
+        return Status{}
+    }
+    return Status{OnBattery: strings.Contains(string(out), "'Battery Power'")}
+}
@@ -0,0 +1,58 @@
+//go:build linux
+
+package power
+
+import (
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+)
+
+func read() Status {
+    return Status{
+        OnBattery:   onBattery(),
+        Temperature: maxTemperature(),
+    }
+}
+
+func readTrimmed(path string) string {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return ""
+    }
+    return strings.TrimSpace(string(data))
+}
+
+// onBattery reports true when no mains adapter is online and a battery is discharging
+func onBattery() bool {
+    supplies, _ := filepath.Glob("/sys/class/power_supply/*")
+    discharging := false
+    for _, dir := range supplies {
+        switch readTrimmed(filepath.Join(dir, "type")) {
+        case "Mains", "USB":
+            if readTrimmed(filepath.Join(dir, "online")) == "1" {
+                return false
+            }
+        case "Battery":
+            if readTrimmed(filepath.Join(dir, "status")) == "Discharging" {
+                discharging = true
+            }
+        }
+    }
+    return discharging
+}
+
+// maxTemperature returns the hottest thermal zone, sysfs reports millidegrees
+func maxTemperature() float64 {
+    zones, _ := filepath.Glob("/sys/class/thermal/thermal_zone*/temp")
+    hottest := 0.0
+    for _, zone := range zones {
+        milli, err := strconv.Atoi(readTrimmed(zone))
+        if err != nil {
+            continue
+        }
+        hottest = max(hottest, float64(milli)/1000)
+    }
+    return hottest
+}
@@ -0,0 +1,63 @@
+package processor
+
+import (
+    "convert_cbz/internal/calibre"
+    "convert_cbz/internal/types"
+    "path/filepath"
+    "strings"
+)
+
+// calibreBook is what Calibre is told about item's archive, from the
+// ComicInfo.xml it holds or else the one -comicinfo would generate. The title
+// is the archive's name, chapter titles alone don't tell books apart.
+func calibreBook(item types.WorkItem, opts *types.Options, fp string) (calibre.Book, error) {
+    ci, err := ReadComicInfo(item.OutputPath)
+    if err != nil {
+        return calibre.Book{}, err
+    }
+    if ci == nil {
+        if ci, err = comicInfoOf(item, opts); err != nil {
+            return calibre.Book{}, err
+        }
+    }
+    if ci.Series == "" {
+        ci.Series, _, _ = lookupSeries(item, opts)
+    }
+    title := strings.TrimSuffix(filepath.Base(item.OutputPath), filepath.Ext(item.OutputPath))
+    return calibre.BookOf(ci, title, fp), nil
+}
+
+// writeOPF writes the Calibre metadata of item's archive next to it
+func writeOPF(opts *types.Options, item types.WorkItem, b calibre.Book) error {
+    data, err := b.OPF()
+    if err != nil {
+        return err
+    }
+    return writeBeside(opts, item, types.OPFPath(item.OutputPath), data)
+}
+
+// addToCalibre writes the OPF and adds the archive to the Calibre library,
+// failing either is only worth a warning
+func addToCalibre(workerID int, item types.WorkItem, opts *types.Options, fp string, log *itemLogger) {
+    b, err := calibreBook(item, opts, fp)
+    if err != nil {
+        r := itemLog(workerID, item, "warn", "Could not read metadata for Calibre")
+        r.Error = err.Error()
+        log.write(r)
+        return
+    }
+    if opts.OPF {
+        if err := writeOPF(opts, item, b); err != nil {
+            r := itemLog(workerID, item, "warn", "Could not write OPF")
+            r.Error = err.Error()
+            log.write(r)
+        }
+    }
+    if opts.Calibre != nil {
+        if err := opts.Calibre.Add(item.OutputPath, b); err != nil {
+            r := itemLog(workerID, item, "warn", "Could not add to Calibre")
+            r.Error = err.Error()
+            log.write(r)
+        }
+    }
+}
@@ -0,0 +1,102 @@
+package processor
+
+import (
+    "convert_cbz/internal/comicinfo"
+    "convert_cbz/internal/types"
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// ownKey holds the converter's own comment, the fingerprint and generated
+// entries, when the archive comment is a ComicBookInfo object
+const ownKey = "x-convert-cbz"
+
+// cbiComment is the archive comment -comicbookinfo writes, the JSON object
+// ComicBookInfo readers parse the whole comment as
+type cbiComment struct {
+    AppID        string                  `json:"appID"`
+    LastModified string                  `json:"lastModified,omitempty"`
+    Info         comicinfo.ComicBookInfo `json:"ComicBookInfo/1.0"`
+    Own          string                  `json:"x-convert-cbz"`
+}
+
+// withComicBookInfo wraps own, the comment archiveComment made, in the
+// ComicBookInfo of item. The details are those of the ComicInfo.xml going
+// into the archive, or the one -comicinfo would generate without one.
+func withComicBookInfo(own string, item types.WorkItem, files []string, extras []extraEntry, opts *types.Options) (string, error) {
+    ci, err := archiveComicInfo(item, files, extras, opts)
+    if err != nil {
+        return "", err
+    }
+    if ci.Series == "" {
+        ci.Series, _, _ = lookupSeries(item, opts)
+    }
+
+    c := cbiComment{AppID: "convert-cbz", Info: ci.ToComicBookInfo(), Own: own}
+    // A reproducible archive can't hold the time it was made
+    if !opts.Reproducible {
+        c.LastModified = time.Now().Format("2006-01-02 15:04:05")
+    }
+    data, err := json.Marshal(c)
+    if err != nil {
+        return "", err
+    }
+    return string(data), nil
+}
+
+// archiveComicInfo is the ComicInfo.xml item's archive gets: generated, or
+// the one at the root of the folder
+func archiveComicInfo(item types.WorkItem, files []string, extras []extraEntry, opts *types.Options) (*comicinfo.ComicInfo, error) {
+    for _, e := range extras {
+        if e.name == comicinfo.FileName {
+            return comicinfo.Unmarshal(e.data)
+        }
+    }
+    for _, f := range files {
+        if filepath.Dir(f) == filepath.Clean(item.SourcePath) && strings.EqualFold(filepath.Base(f), comicinfo.FileName) {
+            data, err := os.ReadFile(f)
+            if err != nil {
+                return nil, err
+            }
+            return comicinfo.Unmarshal(data)
+        }
+    }
+    return comicInfoOf(item, opts)
+}
+
+// ownComment returns the converter's part of an archive comment, which is
+// the whole comment unless it is a JSON object like a ComicBookInfo one
+func ownComment(comment string) string {
+    if !strings.HasPrefix(strings.TrimSpace(comment), "{") {
+        return comment
+    }
+    var c struct {
+        Own string `json:"x-convert-cbz"`
+    }
+    if err := json.Unmarshal([]byte(comment), &c); err != nil {
+        return comment
+    }
+    return c.Own
+}
+
+// withOwnComment puts own back into comment where ownComment found it. A
+// JSON object some other tool wrote as the comment, like its ComicBookInfo,
+// keeps its fields and gets own as one more.
+func withOwnComment(comment, own string) string {
+    if !strings.HasPrefix(strings.TrimSpace(comment), "{") {
+        return own
+    }
+    var fields map[string]any
+    if err := json.Unmarshal([]byte(comment), &fields); err != nil {
+        return own
+    }
+    fields[ownKey] = own
+    data, err := json.Marshal(fields)
+    if err != nil {
+        return own
+    }
+    return string(data)
+}
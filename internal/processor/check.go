@@ -0,0 +1,198 @@
+package processor
+
+import (
+    "archive/zip"
+    "bytes"
+    "errors"
+    "fmt"
+    "image"
+    "io"
+    "net/http"
+    "path/filepath"
+    "strings"
+)
+
+// CheckKind is what is wrong with an archive or one of its entries
+type CheckKind string
+
+const (
+    CheckBroken      CheckKind = "broken"      // Not a ZIP that can be opened
+    CheckCorrupt     CheckKind = "corrupt"     // Entry fails its CRC or can't be decompressed
+    CheckNoImages    CheckKind = "no-images"   // Not a single page in the archive
+    CheckUndecodable CheckKind = "undecodable" // Page whose image data doesn't decode
+)
+
+// CheckProblem is one thing wrong with an archive, Entry is empty for the
+// archive as a whole
+type CheckProblem struct {
+    Kind  CheckKind `json:"kind"`
+    Entry string    `json:"entry,omitempty"`
+    Error string    `json:"error,omitempty"`
+}
+
+// CheckResult is what CheckArchive found in one archive
+type CheckResult struct {
+    Path      string         `json:"path"`
+    Entries   int            `json:"entries"`
+    Pages     int            `json:"pages"`
+    Unchecked int            `json:"unchecked"` // Pages in formats that can't be decoded here, only their CRC was checked
+    Problems  []CheckProblem `json:"problems,omitempty"`
+}
+
+// decodable are the formats the standard library decodes, pages in the
+// others are only recognised
+var decodable = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true}
+
+// sniffable are the page extensions whose data is always recognised by content
+var sniffable = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".bmp": true}
+
+// CheckArchive reads every entry of the CBZ at path back, so archive/zip
+// checks its CRC, and decodes every page. Nothing is written.
+func CheckArchive(path string) CheckResult {
+    res := CheckResult{Path: path}
+    reader, err := zip.OpenReader(path)
+    if err != nil {
+        res.Problems = append(res.Problems, CheckProblem{Kind: CheckBroken, Error: err.Error()})
+        return res
+    }
+    defer reader.Close()
+
+    for _, f := range reader.File {
+        if f.FileInfo().IsDir() {
+            continue
+        }
+        res.Entries++
+        if p := checkEntry(f, &res); p != nil {
+            p.Entry = storedName(f)
+            res.Problems = append(res.Problems, *p)
+        }
+    }
+    if res.Pages == 0 && res.Entries > 0 {
+        res.Problems = append(res.Problems, CheckProblem{Kind: CheckNoImages, Error: "no image entries"})
+    }
+    if res.Entries == 0 {
+        res.Problems = append(res.Problems, CheckProblem{Kind: CheckNoImages, Error: "archive is empty"})
+    }
+    return res
+}
+
+// checkEntry reads one entry to the end, decoding it on the way if it is a
+// page, and counts it in res
+func checkEntry(f *zip.File, res *CheckResult) (problem *CheckProblem) {
+    // A decoder that panics on a page has found a page that doesn't decode
+    defer func() {
+        if err := recovered(recover()); err != nil {
+            problem = &CheckProblem{Kind: CheckUndecodable, Error: err.Error()}
+        }
+    }()
+
+    rc, err := f.Open()
+    if err != nil {
+        return &CheckProblem{Kind: CheckCorrupt, Error: err.Error()}
+    }
+    defer rc.Close()
+    src := &readErr{r: rc}
+
+    // Recognised by content like smart mode does, or by name so a page
+    // that lost its data still counts as one
+    head := make([]byte, 512)
+    n, _ := io.ReadFull(src, head)
+    head = head[:n]
+    kind := http.DetectContentType(head)
+    ext := strings.ToLower(filepath.Ext(f.Name))
+    sniffed := strings.HasPrefix(kind, "image/")
+
+    var decodeErr error
+    switch {
+    case decodable[kind]:
+        res.Pages++
+        _, _, decodeErr = image.Decode(io.MultiReader(bytes.NewReader(head), src))
+    case kind == "image/webp":
+        res.Pages++
+        res.Unchecked++
+        if _, _, ok := webpSize(head); !ok {
+            decodeErr = errors.New("unreadable WebP header")
+        }
+    case sniffed:
+        res.Pages++
+        res.Unchecked++
+    case sniffable[ext]:
+        // Named like a page, but the data isn't recognisable as one
+        res.Pages++
+        decodeErr = fmt.Errorf("not %s image data", strings.TrimPrefix(ext, "."))
+    case imageExtensions[ext]:
+        // AVIF and JPEG XL aren't recognised by content
+        res.Pages++
+        res.Unchecked++
+    }
+
+    // The CRC is only compared once the entry is read to its end
+    io.Copy(io.Discard, src)
+    if src.err != nil {
+        return &CheckProblem{Kind: CheckCorrupt, Error: src.err.Error()}
+    }
+    if decodeErr != nil {
+        return &CheckProblem{Kind: CheckUndecodable, Error: decodeErr.Error()}
+    }
+    return nil
+}
+
+// readErr remembers the first error of r other than EOF, so a broken entry
+// isn't mistaken for an image that doesn't decode
+type readErr struct {
+    r   io.Reader
+    err error
+}
+
+func (e *readErr) Read(p []byte) (int, error) {
+    n, err := e.r.Read(p)
+    if err != nil && err != io.EOF && e.err == nil {
+        e.err = err
+    }
+    return n, err
+}
+
+// RecheckArchive reads every entry of the CBZ at path back so archive/zip
+// compares it with the CRC-32 it was stored with, the cheap way to find an
+// archive that rotted on disk since it was written. pace is called with the
+// size of every chunk read, to throttle the reads or stop them by failing.
+func RecheckArchive(path string, pace func(n int) error) error {
+    reader, err := zip.OpenReader(path)
+    if err != nil {
+        return err
+    }
+    defer reader.Close()
+
+    buf := make([]byte, 256<<10)
+    for _, f := range reader.File {
+        if f.FileInfo().IsDir() {
+            continue
+        }
+        rc, err := f.Open()
+        if err != nil {
+            return fmt.Errorf("%s: %w", storedName(f), err)
+        }
+        _, err = io.CopyBuffer(io.Discard, &pacedReader{r: rc, pace: pace}, buf)
+        rc.Close()
+        if err != nil {
+            return fmt.Errorf("%s: %w", storedName(f), err)
+        }
+    }
+    return nil
+}
+
+// pacedReader hands what it read from r to pace before returning it
+type pacedReader struct {
+    r    io.Reader
+    pace func(n int) error
+}
+
+func (p *pacedReader) Read(b []byte) (int, error) {
+    n, err := p.r.Read(b)
+    if n > 0 {
+        if perr := p.pace(n); perr != nil {
+            return n, perr
+        }
+    }
+    return n, err
+}
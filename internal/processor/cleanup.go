@@ -0,0 +1,81 @@
+package processor
+
+import (
+    "convert_cbz/internal/trash"
+    "convert_cbz/internal/types"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// deleteSource removes the files that went into the archive of item, then
+// the folders they leave empty. Files the archive doesn't hold, like the ones
+// smart mode excluded, are left where they are and returned.
+func deleteSource(opts *types.Options, item types.WorkItem, files []string) ([]string, error) {
+    if opts.Journal != nil {
+        step := types.JournalStep{Kind: types.StepDeleteSource, From: item.SourcePath, To: item.OutputPath, Source: item.SourcePath}
+        if err := opts.Journal(step); err != nil {
+            return nil, fmt.Errorf("failed to write journal: %w", err)
+        }
+    }
+
+    for _, f := range files {
+        if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+            return nil, err
+        }
+    }
+
+    // Deepest folders first, so their parents can go once they are empty
+    var dirs, kept []string
+    err := filepath.WalkDir(item.SourcePath, func(path string, d fs.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        if d.IsDir() {
+            dirs = append(dirs, path)
+        } else if rel, err := filepath.Rel(item.SourcePath, path); err == nil {
+            kept = append(kept, filepath.ToSlash(rel))
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    sort.Slice(dirs, func(i, j int) bool {
+        return strings.Count(dirs[i], string(filepath.Separator)) > strings.Count(dirs[j], string(filepath.Separator))
+    })
+    for _, dir := range dirs {
+        // Folders that still hold something fail, which is what we want
+        os.Remove(dir)
+    }
+    sort.Strings(kept)
+    return kept, nil
+}
+
+// trashSource moves the folder of item to the trash, or into opts.TrashDir,
+// as a whole: nothing is lost, so the files the archive doesn't hold go too.
+// The step is journaled once the move is done, only then is the name in the
+// trash known.
+func trashSource(opts *types.Options, item types.WorkItem) (string, error) {
+    var dest string
+    var err error
+    if opts.TrashDir != "" {
+        dest, err = trash.MoveTo(item.SourcePath, opts.TrashDir)
+    } else {
+        dest, err = trash.Move(item.SourcePath)
+    }
+    if err != nil {
+        return "", err
+    }
+
+    if opts.Journal != nil {
+        step := types.JournalStep{Kind: types.StepTrashSource, From: item.SourcePath, To: dest, Source: item.SourcePath}
+        if err := opts.Journal(step); err != nil {
+            return dest, fmt.Errorf("moved, but failed to write journal: %w", err)
+        }
+    }
+    return dest, nil
+}
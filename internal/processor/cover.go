@@ -0,0 +1,66 @@
+package processor
+
+import (
+    "path"
+    "path/filepath"
+    "strings"
+)
+
+// CoverNone as the cover pattern keeps the files in name order
+const CoverNone = "none"
+
+// coverPatterns are the names that mark a file as the cover, checked in order
+var coverPatterns = []string{"cover*", "volume*"}
+
+var imageExtensions = map[string]bool{
+    ".jpg": true, ".jpeg": true, ".png": true, ".gif": true,
+    ".webp": true, ".avif": true, ".bmp": true, ".jxl": true,
+}
+
+// orderCover moves the cover of sourceDir to the front of files, so readers
+// that show the first page as the thumbnail pick it up. pattern is a glob
+// matched against the archive-relative path or the file name, ignoring case;
+// empty looks for a cover* or volume* image and otherwise keeps the first page.
+func orderCover(files []string, sourceDir, pattern string) []string {
+    if pattern == CoverNone {
+        return files
+    }
+
+    patterns := coverPatterns
+    if pattern != "" {
+        patterns = []string{pattern}
+    }
+
+    for _, p := range patterns {
+        p = strings.ToLower(p)
+        for i, f := range files {
+            if pattern == "" && !imageExtensions[strings.ToLower(filepath.Ext(f))] {
+                continue
+            }
+            if !coverMatch(p, f, sourceDir) {
+                continue
+            }
+            if i == 0 {
+                return files
+            }
+            ordered := make([]string, 0, len(files))
+            ordered = append(ordered, f)
+            ordered = append(ordered, files[:i]...)
+            return append(ordered, files[i+1:]...)
+        }
+    }
+    return files
+}
+
+func coverMatch(pattern, file, sourceDir string) bool {
+    rel, err := filepath.Rel(sourceDir, file)
+    if err != nil {
+        return false
+    }
+    rel = strings.ToLower(filepath.ToSlash(rel))
+    if ok, _ := path.Match(pattern, rel); ok {
+        return true
+    }
+    ok, _ := path.Match(pattern, path.Base(rel))
+    return ok
+}
@@ -0,0 +1,63 @@
+package processor
+
+import (
+    "archive/zip"
+    "crypto/sha256"
+    "encoding/hex"
+    "io"
+    "net/http"
+    "strings"
+)
+
+// Page is an image inside an archive, identified by its content
+type Page struct {
+    Name string
+    Hash string // sha256 of the raw image bytes
+}
+
+// PageHashes hashes every image in a CBZ and counts the entries that aren't
+// images. Images are recognised by content, like smart mode does, so names
+// and extensions don't matter.
+func PageHashes(cbzPath string) (pages []Page, other int, err error) {
+    reader, err := zip.OpenReader(cbzPath)
+    if err != nil {
+        return nil, 0, err
+    }
+    defer reader.Close()
+
+    for _, f := range reader.File {
+        if f.FileInfo().IsDir() {
+            continue
+        }
+
+        rc, err := f.Open()
+        if err != nil {
+            return nil, 0, err
+        }
+
+        // Sniff the head, then hash it together with the rest
+        head := make([]byte, 512)
+        n, err := io.ReadFull(rc, head)
+        if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+            rc.Close()
+            return nil, 0, err
+        }
+        head = head[:n]
+
+        if !strings.HasPrefix(http.DetectContentType(head), "image/") {
+            rc.Close()
+            other++
+            continue
+        }
+
+        h := sha256.New()
+        h.Write(head)
+        _, err = io.Copy(h, rc)
+        rc.Close()
+        if err != nil {
+            return nil, 0, err
+        }
+        pages = append(pages, Page{Name: storedName(f), Hash: hex.EncodeToString(h.Sum(nil))})
+    }
+    return pages, other, nil
+}
@@ -0,0 +1,61 @@
+package processor
+
+import (
+    "convert_cbz/internal/types"
+    "time"
+)
+
+// emitItem sends an item-level event if anyone is listening
+func emitItem(opts *types.Options, typ types.EventType, workerID int, item types.WorkItem, file string, err error) {
+    if opts.OnEvent == nil {
+        return
+    }
+
+    e := types.Event{
+        Type:       typ,
+        Time:       time.Now(),
+        Worker:     workerID,
+        FolderName: item.FolderName,
+        SourcePath: item.SourcePath,
+        OutputPath: item.OutputPath,
+        File:       file,
+    }
+    if err != nil {
+        e.Error = err.Error()
+    }
+    opts.OnEvent(e)
+}
+
+// emitStats sends the current counters if anyone is listening
+func emitStats(opts *types.Options, stats *types.ConversionStats) {
+    if opts.OnEvent == nil {
+        return
+    }
+
+    snap := stats.Snapshot()
+    opts.OnEvent(types.Event{
+        Type:  types.EventStatsUpdated,
+        Time:  time.Now(),
+        Stats: &snap,
+    })
+}
+
+// itemDone passes the result recorded for item on to opts.OnResult and the
+// post-hook, if it has one: an item left for the next run has no outcome to
+// tell about yet
+func itemDone(workerID int, item types.WorkItem, opts *types.Options, stats *types.ConversionStats, log *itemLogger, recorded *int) {
+    stats.Mutex.Lock()
+    if *recorded < 0 {
+        stats.Mutex.Unlock()
+        return
+    }
+    res := stats.Results[*recorded]
+    stats.Mutex.Unlock()
+
+    if opts.OnResult != nil {
+        opts.OnResult(res)
+    }
+    if opts.PostHook != "" {
+        postHook(workerID, item, res, opts, log)
+    }
+}
@@ -0,0 +1,356 @@
+package processor
+
+import (
+    "archive/zip"
+    "context"
+    "convert_cbz/internal/types"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path"
+    "path/filepath"
+    "strings"
+    "time"
+    "unicode/utf8"
+
+    "golang.org/x/text/encoding/charmap"
+)
+
+// extractNaming is what extractArchive names the files it writes after
+type extractNaming uint8
+
+const (
+    namesStored    extractNaming = iota // The entry names, as stored
+    namesOriginal                       // The names the source folder had, without the generated entries
+    namesRenumbered                     // Images 0001.jpg, 0002.png, ... in archive order, without the generated entries
+)
+
+// extractArchive unpacks cbzPath into dir, for repack to treat like any
+// source folder or for -extract. Folders wrapping every entry are dropped,
+// and so are the resource forks macOS leaves in __MACOSX; smart mode filters
+// the rest. naming decides what the files are called. A PDF gets the images
+// of its pages extracted instead, see extractPDF. An encrypted archive
+// is opened with the first of passwords that fits, the number of which is
+// returned, zero when it needs none. started gets the number of files to
+// extract and added the name and size of every one extracted, both may be
+// nil. Cancelling ctx abandons it.
+func extractArchive(ctx context.Context, cbzPath, dir string, passwords []string, naming extractNaming, started func(total int), added func(name string, size int64)) (int, error) {
+    if strings.EqualFold(filepath.Ext(cbzPath), ".pdf") {
+        return 0, extractPDF(ctx, cbzPath, dir, started, added)
+    }
+
+    reader, err := zip.OpenReader(cbzPath)
+    if errors.Is(err, zip.ErrFormat) {
+        // Most .cbr files are RAR archives, some are ZIPs renamed
+        return 0, errors.New("failed to open archive: not a ZIP file, RAR archives can't be extracted")
+    }
+    if err != nil {
+        return 0, fmt.Errorf("failed to open archive: %w", err)
+    }
+    defer reader.Close()
+
+    // ComicInfo.xml and the like are made again when the folder is converted
+    generated := map[string]bool{}
+    if naming != namesStored {
+        generated = generatedEntries(reader.Comment)
+    }
+    var files []*zip.File
+    var names []string
+    for _, f := range reader.File {
+        if f.FileInfo().IsDir() || generated[f.Name] {
+            continue
+        }
+        name, err := extractName(f)
+        if err != nil {
+            return 0, err
+        }
+        if first, _, _ := strings.Cut(name, "/"); first == "__MACOSX" {
+            continue
+        }
+        files = append(files, f)
+        names = append(names, name)
+    }
+
+    used, err := findPassword(files, passwords)
+    if err != nil {
+        return 0, err
+    }
+    password := ""
+    if used > 0 {
+        password = passwords[used-1]
+    }
+
+    prefix := wrapperPrefix(names)
+    dests := make([]string, len(names))
+    for i, name := range names {
+        dests[i] = strings.TrimPrefix(name, prefix)
+    }
+    var links []PageLink
+    switch naming {
+    case namesOriginal:
+        if generated[ManifestName] {
+            manifest, err := readManifest(&reader.Reader)
+            if err != nil {
+                return 0, err
+            }
+            if manifest == nil {
+                return 0, fmt.Errorf("%s is missing", ManifestName)
+            }
+            originals := make(map[string]string, len(manifest.Pages))
+            for _, p := range manifest.Pages {
+                originals[p.Name] = p.Original
+            }
+            for i, name := range names {
+                if original, ok := originals[name]; ok {
+                    dests[i] = original
+                }
+            }
+            links = manifest.Links
+        }
+    case namesRenumbered:
+        renamed := renamePages(dests)
+        for i, dest := range dests {
+            if name, ok := renamed[dest]; ok {
+                dests[i] = name
+            }
+        }
+    }
+
+    if started != nil {
+        started(len(files))
+    }
+    written := make(map[string]int, len(files))
+    for i, f := range files {
+        if ctx.Err() != nil {
+            return 0, errAborted
+        }
+        if !filepath.IsLocal(filepath.FromSlash(dests[i])) {
+            return 0, fmt.Errorf("%s names %q, which is outside the archive", ManifestName, dests[i])
+        }
+        dest := filepath.Join(dir, filepath.FromSlash(dests[i]))
+        if err := extractFile(f, password, dest); err != nil {
+            return 0, fmt.Errorf("failed to extract %s: %w", names[i], err)
+        }
+        written[names[i]] = i
+        if added != nil {
+            added(dests[i], int64(f.UncompressedSize64))
+        }
+    }
+
+    // Files that were hard links to another one are stored once, they get
+    // linked again, or extracted twice where the filesystem can't link
+    for _, l := range links {
+        i, ok := written[l.Target]
+        if !ok || !filepath.IsLocal(filepath.FromSlash(l.Original)) {
+            return 0, fmt.Errorf("%s links %s to %s, which isn't in the archive", ManifestName, l.Original, l.Target)
+        }
+        target := filepath.Join(dir, filepath.FromSlash(dests[i]))
+        dest := filepath.Join(dir, filepath.FromSlash(l.Original))
+        if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+            return 0, err
+        }
+        if os.Link(target, dest) != nil {
+            if err := extractFile(files[i], password, dest); err != nil {
+                return 0, fmt.Errorf("failed to extract %s: %w", l.Original, err)
+            }
+        }
+    }
+    return used, nil
+}
+
+// extractName is the stored name of f as a relative slash path. Names that
+// would land outside the folder extracted to are refused.
+func extractName(f *zip.File) (string, error) {
+    name := storedName(f)
+    if f.NonUTF8 && !utf8.ValidString(name) {
+        if decoded, err := charmap.CodePage437.NewDecoder().String(name); err == nil {
+            name = decoded
+        }
+    }
+    clean := path.Clean(strings.ReplaceAll(name, `\`, "/"))
+    if !filepath.IsLocal(filepath.FromSlash(clean)) {
+        return "", fmt.Errorf("entry %q points outside the archive", name)
+    }
+    return clean, nil
+}
+
+// wrapperPrefix is the folders every one of names is in, "Chapter 1/" for
+// an archive of Chapter 1/001.jpg, Chapter 1/002.jpg
+func wrapperPrefix(names []string) string {
+    prefix := ""
+    for len(names) > 0 {
+        first, _, ok := strings.Cut(strings.TrimPrefix(names[0], prefix), "/")
+        if !ok {
+            return prefix
+        }
+        next := prefix + first + "/"
+        for _, name := range names {
+            if !strings.HasPrefix(name, next) {
+                return prefix
+            }
+        }
+        prefix = next
+    }
+    return prefix
+}
+
+func extractFile(f *zip.File, password, dest string) error {
+    if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+        return err
+    }
+    rc, err := openEntry(f, password)
+    if err != nil {
+        return err
+    }
+    defer rc.Close()
+
+    // Two entries with the same name can't both be kept
+    out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+    if errors.Is(err, os.ErrExist) {
+        return errors.New("the archive holds it twice")
+    }
+    if err != nil {
+        return err
+    }
+    _, err = io.Copy(out, rc)
+    if closeErr := out.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        return err
+    }
+    if !f.Modified.IsZero() {
+        os.Chtimes(dest, f.Modified, f.Modified)
+    }
+    return nil
+}
+
+// extractItem unpacks the archive of item into the folder at its OutputPath
+// and records the outcome like processWorkItem does for a conversion
+func extractItem(ctx context.Context, workerID int, item types.WorkItem, opts *types.Options, stats *types.ConversionStats, log *itemLogger, started time.Time, recorded *int) {
+    _, statErr := os.Stat(item.OutputPath)
+    exists := statErr == nil
+    if exists && opts.Overwrite != types.OverwriteAlways {
+        log.write(finalLog(workerID, item, "warn", "Folder already exists, skipping: "+filepath.Base(item.OutputPath), started))
+        stats.Mutex.Lock()
+        stats.Skipped++
+        *recorded = len(stats.Results)
+        stats.Results = append(stats.Results, newResult(opts, item, types.StatusSkipped, nil, 0))
+        stats.Mutex.Unlock()
+        emitItem(opts, types.EventItemSkipped, workerID, item, "", nil)
+        return
+    }
+
+    files := 0
+    var size int64
+    password, err := extractFolder(ctx, item, opts, exists, func(total int) {
+        startProgress(stats, workerID, item, total)
+    }, func(name string, n int64) {
+        files++
+        size += n
+        addProgress(stats, workerID)
+        emitItem(opts, types.EventFileAdded, workerID, item, name, nil)
+    })
+    endProgress(stats, workerID)
+
+    if errors.Is(err, errAborted) {
+        log.write(finalLog(workerID, item, "warn", "Aborted, partial output removed: "+filepath.Base(item.OutputPath), started))
+        deferItems(stats, item)
+        return
+    }
+    if err != nil {
+        r := finalLog(workerID, item, "error", "Extraction failed", started)
+        r.Error = err.Error()
+        log.write(r)
+        stats.Mutex.Lock()
+        stats.Errors++
+        *recorded = len(stats.Results)
+        stats.Results = append(stats.Results, newResult(opts, item, types.StatusFailed, err, 0))
+        stats.Mutex.Unlock()
+        emitItem(opts, types.EventItemFailed, workerID, item, "", err)
+        return
+    }
+
+    if password > 0 {
+        log.write(itemLog(workerID, item, "info", fmt.Sprintf("Decrypted with password %d of the list", password)))
+    }
+
+    // The archive is the source, what was written is the folder
+    res := newResult(opts, item, types.StatusConverted, nil, 0)
+    res.Pages = files
+    res.Bytes = size
+    res.Password = password
+    if info, err := os.Stat(item.Archive); err == nil {
+        res.SourceBytes = info.Size()
+    }
+    stats.Mutex.Lock()
+    stats.Success++
+    stats.SourceBytes += res.SourceBytes
+    *recorded = len(stats.Results)
+    stats.Results = append(stats.Results, res)
+    stats.Mutex.Unlock()
+
+    if exists {
+        log.write(finalLog(workerID, item, "ok", "Replaced: "+filepath.Base(item.OutputPath), started))
+    } else {
+        log.write(finalLog(workerID, item, "ok", "Extracted: "+filepath.Base(item.OutputPath), started))
+    }
+    emitItem(opts, types.EventItemFinished, workerID, item, "", nil)
+}
+
+// extractFolder unpacks next to the destination and renames into place, so a
+// failure never leaves half a folder behind or breaks the one being
+// replaced. That one is removed once the new folder is in place, or kept
+// under its backup name with opts.KeepReplaced. The number of the password
+// that opened the archive is returned, as by extractArchive.
+func extractFolder(ctx context.Context, item types.WorkItem, opts *types.Options, exists bool, started func(int), added func(string, int64)) (int, error) {
+    dest := item.OutputPath
+    if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+        return 0, fmt.Errorf("failed to create folder: %w", err)
+    }
+    tmp, err := os.MkdirTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".*.tmp")
+    if err != nil {
+        return 0, fmt.Errorf("failed to create folder: %w", err)
+    }
+    placed := false
+    defer func() {
+        if !placed {
+            os.RemoveAll(tmp)
+        }
+    }()
+
+    naming := namesOriginal
+    if opts.RenamePages {
+        naming = namesRenumbered
+    }
+    password, err := extractArchive(ctx, item.Archive, tmp, opts.Passwords, naming, started, added)
+    if err != nil {
+        return 0, err
+    }
+    // MkdirTemp makes folders only the owner can open
+    if err := os.Chmod(tmp, 0755); err != nil {
+        return 0, fmt.Errorf("failed to finish folder: %w", err)
+    }
+
+    old := tmp + ".old"
+    if opts.KeepReplaced {
+        old = types.BackupPath(dest, opts.RunID)
+    }
+    if exists {
+        if err := retryRename(dest, old); err != nil {
+            return 0, fmt.Errorf("failed to move the existing folder aside: %w", explainNetError(err))
+        }
+    }
+    if err := retryRename(tmp, dest); err != nil {
+        if exists {
+            os.Rename(old, dest)
+        }
+        return 0, fmt.Errorf("failed to move folder into place: %w", explainNetError(err))
+    }
+    placed = true
+    if exists && !opts.KeepReplaced {
+        os.RemoveAll(old)
+    }
+    return password, nil
+}
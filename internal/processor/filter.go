@@ -1,8 +1,13 @@
 package processor
 
 import (
+    "convert_cbz/internal/ignore"
+    "convert_cbz/internal/util"
+    "encoding/binary"
+    "errors"
     "fmt"
     "io"
+    "maps"
     "net/http"
     "os"
     "path/filepath"
@@ -14,25 +19,48 @@ import (
 )
 
 // getSmartFilteredFiles intelligently filters files for SMART mode
-func getSmartFilteredFiles(dir string) ([]string, int, error) {
+// and returns the files to include plus the ones left out and the unusual
+// ones skipped, relative to dir. Subdirectories excludeDirs matches aren't
+// walked at all, and neither are the files and folders the .cbzignore files
+// in rules and found on the way ignore. Files with one of excludeExt are left
+// out, ones with one of includeExt kept without a look at their content.
+func getSmartFilteredFiles(dir string, excludeDirs []string, rules *ignore.Rules, includeExt, excludeExt []string) ([]string, []string, []string, error) {
     var includedFiles []string
     var excludedFiles []string
+    var unusualFiles []string
 
     err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
         if err != nil {
             return err
         }
 
+        fileName := d.Name()
+        rel, _ := filepath.Rel(dir, path)
+        rel = filepath.ToSlash(rel)
+
         // Skip directories
         if d.IsDir() {
+            return skipDir(excludeDirs, rules, path, dir, rel)
+        }
+        if fileName == ignore.FileName || rules.Match(path, false) {
             return nil
         }
 
-        fileName := d.Name()
+        // Reading a fifo would block forever, sniff nothing but regular files
+        if kind, err := unusualKind(path, d); err != nil {
+            return err
+        } else if kind != "" {
+            unusualFiles = append(unusualFiles, rel+" ("+kind+")")
+            return nil
+        }
 
         // Check if file should be excluded (system files, VCS, etc.)
-        if shouldExcludeFile(fileName) {
-            excludedFiles = append(excludedFiles, fileName)
+        if hasExt(fileName, excludeExt) || shouldExcludeFile(fileName) {
+            excludedFiles = append(excludedFiles, rel)
+            return nil
+        }
+        if hasExt(fileName, includeExt) {
+            includedFiles = append(includedFiles, path)
             return nil
         }
 
@@ -45,45 +73,127 @@ func getSmartFilteredFiles(dir string) ([]string, int, error) {
         } else if isUseful {
             includedFiles = append(includedFiles, path)
         } else {
-            excludedFiles = append(excludedFiles, fileName)
+            excludedFiles = append(excludedFiles, rel)
         }
 
         return nil
     })
 
     if err != nil {
-        return nil, 0, err
+        return nil, nil, nil, err
     }
 
     // Sort files for consistent ordering
     sort.Strings(includedFiles)
-    return includedFiles, len(excludedFiles), nil
+    sort.Strings(excludedFiles)
+    sort.Strings(unusualFiles)
+    return includedFiles, excludedFiles, unusualFiles, nil
 }
 
-// getAllFiles gets all files in directory for DUMB mode (no filtering)
-func getAllFiles(dir string) ([]string, error) {
+// getAllFiles gets all files in directory for DUMB mode (no filtering), and
+// the ones left out and the unusual ones skipped relative to dir. Even dumb
+// mode leaves out the subdirectories excludeDirs matches, what .cbzignore
+// files ignore and the files with one of excludeExt.
+func getAllFiles(dir string, excludeDirs []string, rules *ignore.Rules, excludeExt []string) ([]string, []string, []string, error) {
     var allFiles []string
+    var excludedFiles []string
+    var unusualFiles []string
 
     err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
         if err != nil {
             return err
         }
 
-        // Include all files, skip only directories
-        if !d.IsDir() {
-            allFiles = append(allFiles, path)
+        // Include all files, skip only directories and what can't be archived
+        if d.IsDir() {
+            rel, _ := filepath.Rel(dir, path)
+            return skipDir(excludeDirs, rules, path, dir, filepath.ToSlash(rel))
+        }
+        if d.Name() == ignore.FileName || rules.Match(path, false) {
+            return nil
+        }
+        kind, err := unusualKind(path, d)
+        if err != nil {
+            return err
+        }
+        if kind != "" {
+            rel, _ := filepath.Rel(dir, path)
+            unusualFiles = append(unusualFiles, filepath.ToSlash(rel)+" ("+kind+")")
+            return nil
         }
+        if hasExt(d.Name(), excludeExt) {
+            rel, _ := filepath.Rel(dir, path)
+            excludedFiles = append(excludedFiles, filepath.ToSlash(rel))
+            return nil
+        }
+        allFiles = append(allFiles, path)
 
         return nil
     })
 
     if err != nil {
-        return nil, err
+        return nil, nil, nil, err
     }
 
     // Sort files for consistent ordering
     sort.Strings(allFiles)
-    return allFiles, nil
+    sort.Strings(excludedFiles)
+    sort.Strings(unusualFiles)
+    return allFiles, excludedFiles, unusualFiles, nil
+}
+
+// skipDir is what a walk of root does with the directory at path: the whole
+// tree is skipped when one of excludeDirs matches rel, its path below root,
+// or rules ignore it. Otherwise its .cbzignore is added to rules.
+func skipDir(excludeDirs []string, rules *ignore.Rules, path, root, rel string) error {
+    if path != root {
+        for _, pattern := range excludeDirs {
+            if util.MatchPath(pattern, rel) {
+                return filepath.SkipDir
+            }
+        }
+        if rules.Match(path, true) {
+            return filepath.SkipDir
+        }
+    }
+    return rules.Load(path)
+}
+
+// unusualKind names what path is when it isn't something worth archiving:
+// fifos, sockets and device nodes, which can't be read like a file or never
+// stop being read, symlinks that lead nowhere or to a directory, and empty
+// files. The empty string means path is an ordinary file.
+func unusualKind(path string, d os.DirEntry) (string, error) {
+    var info os.FileInfo
+    var err error
+    if d.Type()&os.ModeSymlink != 0 {
+        info, err = os.Stat(path)
+        if errors.Is(err, os.ErrNotExist) {
+            return "broken symlink", nil
+        }
+    } else {
+        info, err = d.Info()
+    }
+    if err != nil {
+        return "", err
+    }
+
+    mode := info.Mode()
+    switch {
+    case mode&os.ModeNamedPipe != 0:
+        return "fifo", nil
+    case mode&os.ModeSocket != 0:
+        return "socket", nil
+    case mode&os.ModeDevice != 0:
+        return "device node", nil
+    case mode.IsDir():
+        return "symlink to a directory", nil
+    case !mode.IsRegular():
+        return "irregular file", nil
+    case info.Size() == 0:
+        return "empty file", nil
+    }
+    return "", nil
 }
 
 // shouldExcludeFile checks for obvious system/VCS files to exclude
@@ -127,27 +237,117 @@ func shouldExcludeFile(fileName string) bool {
     return false
 }
 
+// Text files that might contain metadata
+var textExtensions = map[string]bool{
+    ".txt": true, ".md": true, ".nfo": true, ".info": true,
+    ".readme": true, ".description": true, ".notes": true,
+}
+
+// Video files that might be supplementary content
+var videoExtensions = map[string]bool{
+    ".mp4": true, ".avi": true, ".mkv": true, ".mov": true,
+    ".wmv": true, ".flv": true, ".webm": true, ".m4v": true,
+}
+
+// extensionGroups are the names -include-ext and -exclude-ext take for all
+// the extensions smart mode knows of a kind
+var extensionGroups = map[string]map[string]bool{
+    "images": imageExtensions,
+    "videos": videoExtensions,
+    "text":   textExtensions,
+}
+
+// isTextFile reports whether name is one of the text files smart mode keeps
+func isTextFile(name string) bool {
+    return textExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// ParseExtensions turns the values of -include-ext or -exclude-ext into
+// lower case extensions with their dot. Each value is a comma separated
+// list of extensions, with or without the dot, or of the groups images,
+// videos and text.
+func ParseExtensions(values []string) ([]string, error) {
+    var exts []string
+    for _, value := range values {
+        for ext := range strings.SplitSeq(value, ",") {
+            ext = strings.ToLower(strings.TrimSpace(ext))
+            if group, ok := extensionGroups[ext]; ok {
+                exts = append(exts, slices.Sorted(maps.Keys(group))...)
+                continue
+            }
+            ext = "." + strings.TrimPrefix(ext, ".")
+            if ext == "." || strings.HasSuffix(ext, ".") || strings.ContainsAny(ext, `/\*?[ `) {
+                return nil, fmt.Errorf("%q isn't an extension or one of images, videos and text", value)
+            }
+            exts = append(exts, ext)
+        }
+    }
+    return exts, nil
+}
+
+// hasExt reports whether the extension of name is one of exts, ignoring case.
+// Extensions like .tar.gz match the end of the name.
+func hasExt(name string, exts []string) bool {
+    name = strings.ToLower(name)
+    for _, ext := range exts {
+        if strings.HasSuffix(name, ext) {
+            return true
+        }
+    }
+    return false
+}
+
+// isImageFile reports whether the file at path starts like an image readers
+// show: JPEG, PNG, GIF, WebP, BMP, AVIF or JPEG XL. Its name doesn't matter.
+func isImageFile(path string) (bool, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return false, err
+    }
+    defer file.Close()
+
+    head := make([]byte, 32)
+    n, err := io.ReadFull(file, head)
+    if err != nil && err != io.ErrUnexpectedEOF {
+        return false, err
+    }
+    return isImageData(head[:n]), nil
+}
+
+// isImageData reports whether head, the first bytes of a file, holds the
+// signature of an image format
+func isImageData(head []byte) bool {
+    has := func(offset int, magic string) bool {
+        return len(head) >= offset+len(magic) && string(head[offset:offset+len(magic)]) == magic
+    }
+    switch {
+    case has(0, "\xff\xd8\xff"), has(0, "\x89PNG\r\n\x1a\n"), has(0, "GIF87a"), has(0, "GIF89a"):
+        return true
+    case has(0, "RIFF") && has(8, "WEBP"):
+        return true
+    case has(4, "ftypavif"), has(4, "ftypavis"):
+        return true
+    case has(0, "\xff\x0a"), has(0, "\x00\x00\x00\x0cJXL \r\n\x87\n"):
+        return true
+    case has(0, "BM") && len(head) >= 18:
+        // Two letters are a weak signature, the size of the header after them isn't
+        switch binary.LittleEndian.Uint32(head[14:18]) {
+        case 12, 40, 52, 56, 108, 124:
+            return true
+        }
+    }
+    return false
+}
+
 // isUsefulFile determines if a file is useful content for comic archives
 func isUsefulFile(filePath string) (bool, error) {
     // First check by extension for quick decisions
     ext := strings.ToLower(filepath.Ext(filePath))
 
-    // Text files that might contain metadata
-    textExtensions := map[string]bool{
-        ".txt": true, ".md": true, ".nfo": true, ".info": true,
-        ".readme": true, ".description": true, ".notes": true,
-    }
-
     if textExtensions[ext] {
         return true, nil
     }
 
-    // Video files that might be supplementary content
-    videoExtensions := map[string]bool{
-        ".mp4": true, ".avi": true, ".mkv": true, ".mov": true,
-        ".wmv": true, ".flv": true, ".webm": true, ".m4v": true,
-    }
-
     if videoExtensions[ext] {
         return true, nil
     }
@@ -0,0 +1,197 @@
+package processor
+
+import (
+    "archive/zip"
+    "convert_cbz/internal/types"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+)
+
+// fingerprintKey marks our fingerprint inside the zip archive comment
+const fingerprintKey = "convert-cbz:fingerprint="
+
+// fpEntry is a file as the fingerprint sees it, either on disk or inside an archive
+type fpEntry struct {
+    name    string // Archive-relative, slash separated
+    size    int64
+    modTime time.Time
+    open    func() (io.ReadCloser, error)
+}
+
+// fingerprint identifies the selected source files of a folder. Meta mode
+// hashes names, sizes and modification times, content mode hashes the bytes.
+// The result is prefixed with the mode so the two never compare equal.
+func fingerprint(files []string, sourceDir string, mode types.FingerprintMode) (string, error) {
+    entries := make([]fpEntry, 0, len(files))
+    for _, filePath := range files {
+        rel, err := filepath.Rel(sourceDir, filePath)
+        if err != nil {
+            return "", err
+        }
+        info, err := os.Stat(filePath)
+        if err != nil {
+            return "", err
+        }
+        entries = append(entries, fpEntry{
+            name:    filepath.ToSlash(rel),
+            size:    info.Size(),
+            modTime: info.ModTime(),
+            open:    func() (io.ReadCloser, error) { return os.Open(filePath) },
+        })
+    }
+    return fingerprintEntries(entries, mode)
+}
+
+// fingerprintEntries is the canonical form shared by folders and archives:
+// entries sorted by name, each contributing its name and then its metadata or
+// the sha256 of its content
+func fingerprintEntries(entries []fpEntry, mode types.FingerprintMode) (string, error) {
+    sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+    h := sha256.New()
+    for _, e := range entries {
+        fmt.Fprintf(h, "%s\x00", e.name)
+
+        switch mode {
+        case types.FingerprintContent:
+            r, err := e.open()
+            if err != nil {
+                return "", err
+            }
+            fh := sha256.New()
+            _, err = io.Copy(fh, r)
+            r.Close()
+            if err != nil {
+                return "", err
+            }
+            h.Write(fh.Sum(nil))
+
+        default:
+            fmt.Fprintf(h, "%d\x00%d\x00", e.size, e.modTime.UnixNano())
+        }
+    }
+    return mode.String() + ":sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// HashFolder fingerprints the files of dir the converter would archive, so the
+// result equals the fingerprint stored in the archive built from it
+func HashFolder(dir string, dumbMode bool, mode types.FingerprintMode) (string, error) {
+    files, _, err := selectFiles(dir, dumbMode, &types.Options{})
+    if err != nil {
+        return "", err
+    }
+    return fingerprint(files, dir, mode)
+}
+
+// HashArchive fingerprints the entries of a CBZ. Only content hashes are
+// comparable with folders, archives don't keep modification times precisely.
+func HashArchive(cbzPath string, mode types.FingerprintMode) (string, error) {
+    if mode != types.FingerprintContent {
+        return "", fmt.Errorf("archives can only be hashed by content")
+    }
+
+    reader, err := zip.OpenReader(cbzPath)
+    if err != nil {
+        return "", err
+    }
+    defer reader.Close()
+
+    // Generated metadata isn't part of the source folder, renamed entries
+    // count under the names they had there and hard links as the copies they were
+    generated := generatedEntries(reader.Comment)
+    manifest := &Manifest{}
+    if generated[ManifestName] {
+        if manifest, err = readManifest(&reader.Reader); err != nil {
+            return "", err
+        }
+        if manifest == nil {
+            return "", fmt.Errorf("%s is missing", ManifestName)
+        }
+    }
+    originals := make(map[string]string, len(manifest.Pages))
+    for _, p := range manifest.Pages {
+        originals[p.Name] = p.Original
+    }
+
+    var entries []fpEntry
+    byName := make(map[string]fpEntry)
+    for _, f := range reader.File {
+        if f.FileInfo().IsDir() || generated[f.Name] {
+            continue
+        }
+        name := storedName(f)
+        if original, ok := originals[name]; ok {
+            name = original
+        }
+        e := fpEntry{
+            name:    name,
+            size:    int64(f.UncompressedSize64),
+            modTime: f.Modified,
+            open:    f.Open,
+        }
+        entries = append(entries, e)
+        byName[storedName(f)] = e
+    }
+    for _, l := range manifest.Links {
+        target, ok := byName[l.Target]
+        if !ok {
+            return "", fmt.Errorf("%s links to %s, which isn't in the archive", l.Original, l.Target)
+        }
+        target.name = l.Original
+        entries = append(entries, target)
+    }
+    return fingerprintEntries(entries, mode)
+}
+
+// generatedKey lists the entries the converter made up itself, like
+// ComicInfo.xml, so they can be told apart from source files
+const generatedKey = "convert-cbz:generated="
+
+// archiveComment is the archive comment that records fp and the generated entries
+func archiveComment(fp string, generated []extraEntry) string {
+    comment := fingerprintKey + fp
+    if len(generated) > 0 {
+        names := make([]string, len(generated))
+        for i, e := range generated {
+            names[i] = e.name
+        }
+        comment += "\n" + generatedKey + strings.Join(names, ",")
+    }
+    return comment
+}
+
+// generatedEntries returns the entry names a zip comment marks as generated
+func generatedEntries(comment string) map[string]bool {
+    names := make(map[string]bool)
+    for line := range strings.SplitSeq(ownComment(comment), "\n") {
+        if list, ok := strings.CutPrefix(strings.TrimSpace(line), generatedKey); ok {
+            for name := range strings.SplitSeq(list, ",") {
+                names[name] = true
+            }
+        }
+    }
+    return names
+}
+
+// ReadFingerprint returns the fingerprint stored in an archive, or "" if it has none
+func ReadFingerprint(cbzPath string) (string, error) {
+    reader, err := zip.OpenReader(cbzPath)
+    if err != nil {
+        return "", err
+    }
+    defer reader.Close()
+
+    for line := range strings.SplitSeq(ownComment(reader.Comment), "\n") {
+        if fp, ok := strings.CutPrefix(strings.TrimSpace(line), fingerprintKey); ok {
+            return fp, nil
+        }
+    }
+    return "", nil
+}
@@ -0,0 +1,82 @@
+package processor
+
+import (
+    "context"
+    "convert_cbz/internal/types"
+    "fmt"
+    "os"
+    "os/exec"
+    "runtime"
+    "strconv"
+    "strings"
+)
+
+// hookOutputLimit is how much of what a failing hook printed ends up in its error
+const hookOutputLimit = 2048
+
+// runHook runs command through the shell for item, with the item and, after
+// it, its outcome res in the environment:
+//
+//  CBZ_HOOK          pre or post
+//  CBZ_HOOK_FOLDER   name of the folder
+//  CBZ_HOOK_SOURCE   folder the archive is made from, or the archive unpacked
+//  CBZ_HOOK_OUTPUT   archive written, or folder unpacked to
+//  CBZ_HOOK_STATUS   converted, skipped or failed, post only
+//  CBZ_HOOK_ERROR    why it failed, post only
+//  CBZ_HOOK_BYTES    size of the archive, post only
+//
+// The pre-hook sees the folder that is read, an unpacked archive input too,
+// the post-hook the input as the report names it.
+func runHook(ctx context.Context, command, stage string, item types.WorkItem, res *types.ItemResult) error {
+    var cmd *exec.Cmd
+    if runtime.GOOS == "windows" {
+        cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+    } else {
+        cmd = exec.CommandContext(ctx, "sh", "-c", command)
+    }
+
+    source := item.SourcePath
+    if res != nil {
+        source = res.SourcePath
+    }
+    env := []string{
+        "CBZ_HOOK=" + stage,
+        "CBZ_HOOK_FOLDER=" + item.FolderName,
+        "CBZ_HOOK_SOURCE=" + source,
+        "CBZ_HOOK_OUTPUT=" + item.OutputPath,
+    }
+    if res != nil {
+        env = append(env,
+            "CBZ_HOOK_STATUS="+string(res.Status),
+            "CBZ_HOOK_ERROR="+res.Error,
+            "CBZ_HOOK_BYTES="+strconv.FormatInt(res.Bytes, 10),
+        )
+    }
+    cmd.Env = append(os.Environ(), env...)
+
+    out, err := cmd.CombinedOutput()
+    if err != nil {
+        msg := strings.TrimSpace(string(out))
+        if len(msg) > hookOutputLimit {
+            msg = "..." + msg[len(msg)-hookOutputLimit:]
+        }
+        if msg != "" {
+            return fmt.Errorf("%s-hook: %w: %s", stage, err, msg)
+        }
+        return fmt.Errorf("%s-hook: %w", stage, err)
+    }
+    return nil
+}
+
+// postHook runs opts.PostHook with the result of item
+func postHook(workerID int, item types.WorkItem, res types.ItemResult, opts *types.Options, log *itemLogger) {
+    ctx := opts.Abort
+    if ctx == nil {
+        ctx = context.Background()
+    }
+    if err := runHook(ctx, opts.PostHook, "post", item, &res); err != nil {
+        r := itemLog(workerID, item, "warn", "Hook failed")
+        r.Error = err.Error()
+        log.write(r)
+    }
+}
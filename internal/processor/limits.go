@@ -0,0 +1,96 @@
+package processor
+
+import (
+    "fmt"
+    "path"
+    "strconv"
+    "strings"
+    "unicode/utf8"
+)
+
+// fitEntry makes name fit the limits some readers have on entry names:
+// folders nested deeper than depth are folded into the deepest one allowed,
+// "a/b/c/001.jpg" becomes "a - b - c/001.jpg" at depth 1, then the longest
+// segments are shortened a character at a time until the whole name is at
+// most length bytes. The extension is always kept. Zero disables either limit.
+func fitEntry(name string, length, depth int) (string, error) {
+    segments := strings.Split(name, "/")
+    if depth > 0 && len(segments)-1 > depth {
+        dirs := segments[:len(segments)-1]
+        folded := strings.Join(dirs[depth-1:], " - ")
+        segments = append(append(dirs[:depth-1:depth-1], folded), segments[len(segments)-1])
+    }
+    if folded := strings.Join(segments, "/"); length <= 0 || len(folded) <= length {
+        return folded, nil
+    }
+
+    last := len(segments) - 1
+    ext := entryExt(segments[last])
+    segments[last] = strings.TrimSuffix(segments[last], ext)
+
+    size := func() int {
+        n := len(segments) - 1 + len(ext)
+        for _, seg := range segments {
+            n += len(seg)
+        }
+        return n
+    }
+    for size() > length {
+        longest := -1
+        for i, seg := range segments {
+            if utf8.RuneCountInString(seg) > 1 && (longest < 0 || len(seg) > len(segments[longest])) {
+                longest = i
+            }
+        }
+        if longest < 0 {
+            return "", fmt.Errorf("entry %s can't be shortened to %d bytes", name, length)
+        }
+        _, n := utf8.DecodeLastRuneInString(segments[longest])
+        segments[longest] = segments[longest][:len(segments[longest])-n]
+    }
+
+    // A cut can leave a trailing dot or space, which Windows drops
+    for i, seg := range segments {
+        if trimmed := strings.TrimRight(seg, ". "); trimmed != "" {
+            segments[i] = trimmed
+        }
+    }
+    segments[last] += ext
+    return strings.Join(segments, "/"), nil
+}
+
+// uniqueFitted is uniqueEntry for names that have to stay within the limits
+// of fitEntry, the file name is shortened to make room for the suffix while
+// its folder stays the one the other entries of the folder got
+func uniqueFitted(name string, owners map[string]bool, length, depth int) (string, error) {
+    fitted, err := fitEntry(name, length, depth)
+    if err != nil {
+        return "", err
+    }
+    dir, file := path.Split(fitted)
+    ext := entryExt(file)
+    stem := strings.TrimSuffix(file, ext)
+    for i := 2; ; i++ {
+        suffix := "~" + strconv.Itoa(i)
+        for length > 0 && len(dir)+len(stem)+len(suffix)+len(ext) > length {
+            if utf8.RuneCountInString(stem) <= 1 {
+                return "", fmt.Errorf("entry %s can't be shortened to %d bytes", name, length)
+            }
+            _, n := utf8.DecodeLastRuneInString(stem)
+            stem = stem[:len(stem)-n]
+        }
+        candidate := dir + stem + suffix + ext
+        if !owners[entryKey(candidate)] {
+            return candidate, nil
+        }
+    }
+}
+
+// entryExt is the extension fitEntry keeps, none for a dot somewhere in a
+// long name
+func entryExt(name string) string {
+    if ext := path.Ext(name); len(ext) <= 8 {
+        return ext
+    }
+    return ""
+}
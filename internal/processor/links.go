@@ -0,0 +1,48 @@
+package processor
+
+import (
+    "os"
+)
+
+// dedupeLinks drops the files that are hard links to a file earlier in files,
+// so the bytes are archived once. It returns the files to store and, for every
+// dropped one, the file it links to.
+func dedupeLinks(files []string) ([]string, map[string]string, error) {
+    // Only files of the same size can be the same file, os.SameFile is
+    // portable but needs a pair to compare
+    bySize := make(map[int64][]int)
+    infos := make([]os.FileInfo, len(files))
+    for i, f := range files {
+        info, err := os.Stat(f)
+        if err != nil {
+            return nil, nil, err
+        }
+        infos[i] = info
+        bySize[info.Size()] = append(bySize[info.Size()], i)
+    }
+
+    links := make(map[string]string)
+    for _, group := range bySize {
+        for j, i := range group {
+            if _, dropped := links[files[i]]; dropped {
+                continue
+            }
+            for _, k := range group[j+1:] {
+                if _, dropped := links[files[k]]; !dropped && os.SameFile(infos[i], infos[k]) {
+                    links[files[k]] = files[i]
+                }
+            }
+        }
+    }
+    if len(links) == 0 {
+        return files, nil, nil
+    }
+
+    kept := make([]string, 0, len(files)-len(links))
+    for _, f := range files {
+        if _, dropped := links[f]; !dropped {
+            kept = append(kept, f)
+        }
+    }
+    return kept, links, nil
+}
@@ -0,0 +1,89 @@
+package processor
+
+import (
+    "convert_cbz/internal/types"
+    "encoding/json"
+    "time"
+)
+
+// writeLog appends r to the run log in the configured format, and streams it
+// to opts.LogOutput if set. Lines are written whole, never interleaved.
+func writeLog(opts *types.Options, buf *types.SafeWriter, r types.LogRecord) {
+    line := formatLog(opts, r)
+
+    buf.Mutex.Lock()
+    defer buf.Mutex.Unlock()
+    buf.Buffer.Write(line)
+    if opts.LogOutput != nil {
+        opts.LogOutput.Write(line)
+    }
+}
+
+// formatLog stamps r and renders it as one line in the configured format
+func formatLog(opts *types.Options, r types.LogRecord) []byte {
+    r.Time = time.Now()
+
+    var line []byte
+    if opts.LogFormat == types.LogJSON {
+        line, _ = json.Marshal(r)
+    } else {
+        line = []byte(r.Text())
+    }
+    return append(line, '\n')
+}
+
+// itemLogger holds back the lines of one work item and writes them as a single
+// block once the item is done, so workers finishing at the same time don't
+// shuffle their lines together. With opts.Verbose they are streamed as they
+// happen as well, the log file still gets the block.
+type itemLogger struct {
+    opts  *types.Options
+    buf   *types.SafeWriter
+    lines []byte
+}
+
+func newItemLogger(opts *types.Options, buf *types.SafeWriter) *itemLogger {
+    return &itemLogger{opts: opts, buf: buf}
+}
+
+func (l *itemLogger) write(r types.LogRecord) {
+    line := formatLog(l.opts, r)
+    l.lines = append(l.lines, line...)
+    if l.opts.Verbose && l.opts.LogOutput != nil {
+        l.buf.Mutex.Lock()
+        l.opts.LogOutput.Write(line)
+        l.buf.Mutex.Unlock()
+    }
+}
+
+// flush writes the held back lines, it must be called once the item is done
+func (l *itemLogger) flush() {
+    if len(l.lines) == 0 {
+        return
+    }
+    l.buf.Mutex.Lock()
+    defer l.buf.Mutex.Unlock()
+    l.buf.Buffer.Write(l.lines)
+    if !l.opts.Verbose && l.opts.LogOutput != nil {
+        l.opts.LogOutput.Write(l.lines)
+    }
+    l.lines = nil
+}
+
+// itemLog fills in the worker and item fields of a record
+func itemLog(workerID int, item types.WorkItem, level, msg string) types.LogRecord {
+    return types.LogRecord{
+        Level:  level,
+        Msg:    msg,
+        Worker: workerID,
+        Folder: item.FolderName,
+        Output: item.OutputPath,
+    }
+}
+
+// finalLog is itemLog for the record that ends an item, it carries the duration
+func finalLog(workerID int, item types.WorkItem, level, msg string, started time.Time) types.LogRecord {
+    r := itemLog(workerID, item, level, msg)
+    r.Duration = time.Since(started).Seconds()
+    return r
+}
@@ -0,0 +1,187 @@
+package processor
+
+import (
+    "context"
+    "convert_cbz/internal/comicinfo"
+    "convert_cbz/internal/types"
+    "convert_cbz/internal/util"
+    "fmt"
+    "os"
+    "path"
+    "path/filepath"
+    "strings"
+)
+
+// selectItemFiles is selectFiles for the folder of item, or for each of its
+// parts in turn when it merges several, or for the files of its chapter when
+// -split-by-pattern split the folder. Their counts add up, excluded files
+// are named relative to the folder that holds all parts. The ComicInfo.xml of
+// every part is left out, metadataEntries combines them into one. Of a folder
+// split by size, only the files of the part are returned.
+func selectItemFiles(item types.WorkItem, opts *types.Options) ([]string, archiveResult, error) {
+    files, result, err := selectWholeItemFiles(item, opts)
+    if err != nil || item.PageRange == [2]int{} {
+        return files, result, err
+    }
+    return pageRange(files, result, item.PageRange)
+}
+
+// selectWholeItemFiles is selectItemFiles before a size split picks its part
+func selectWholeItemFiles(item types.WorkItem, opts *types.Options) ([]string, archiveResult, error) {
+    if item.SplitPattern != "" {
+        re, err := util.CompileSplit(item.SplitPattern)
+        if err != nil {
+            return nil, archiveResult{}, err
+        }
+        return selectFilesWhere(item.SourcePath, item.DumbMode, opts, func(name string) bool {
+            return util.SplitKey(re, name) == item.Chapter
+        })
+    }
+    if len(item.Parts) == 0 {
+        return selectFiles(item.SourcePath, item.DumbMode, opts)
+    }
+
+    var files []string
+    var total archiveResult
+    for _, part := range item.Parts {
+        partFiles, result, err := selectFiles(part, item.DumbMode, opts)
+        prefix := relSlash(item.SourcePath, part) + "/"
+        for _, f := range result.ExcludedFiles {
+            total.ExcludedFiles = append(total.ExcludedFiles, prefix+f)
+        }
+        for _, f := range result.UnusualFiles {
+            total.UnusualFiles = append(total.UnusualFiles, prefix+f)
+        }
+        total.Included += result.Included
+        total.Excluded += result.Excluded
+        total.Flagged = total.Flagged || result.Flagged
+        if err != nil {
+            return nil, total, fmt.Errorf("%s: %w", filepath.Base(part), err)
+        }
+        for _, f := range partFiles {
+            if filepath.Dir(f) == filepath.Clean(part) && strings.EqualFold(filepath.Base(f), comicinfo.FileName) {
+                continue
+            }
+            files = append(files, f)
+        }
+    }
+    if opts.MaxEntries > 0 && len(files) > opts.MaxEntries {
+        return nil, total, fmt.Errorf("merged folders have %d files, more than -max-entries %d", len(files), opts.MaxEntries)
+    }
+    return files, total, nil
+}
+
+// partNames stores the files of every part of item in a folder named after
+// it at the root of the archive, returning the ones that aren't already
+// stored there. Parts of the same name get a ~2, ~3, ... suffix.
+func partNames(files []string, item types.WorkItem) map[string]string {
+    names := make(map[string]string)
+    owners := make(map[string]bool)
+    for _, part := range item.Parts {
+        dir := filepath.Base(part)
+        if owners[entryKey(dir)] {
+            dir = uniqueEntry(dir, owners)
+        }
+        owners[entryKey(dir)] = true
+
+        for _, f := range files {
+            rel, err := filepath.Rel(part, f)
+            if err != nil || !filepath.IsLocal(rel) {
+                continue
+            }
+            if name := path.Join(dir, filepath.ToSlash(rel)); name != relSlash(item.SourcePath, f) {
+                names[f] = name
+            }
+        }
+    }
+    if len(names) == 0 {
+        return nil
+    }
+    return names
+}
+
+// relSlash is the slash separated path of target below base
+func relSlash(base, target string) string {
+    rel, err := filepath.Rel(base, target)
+    if err != nil {
+        return filepath.Base(target)
+    }
+    return filepath.ToSlash(rel)
+}
+
+// extractParts unpacks the archives join combines into the parts of item,
+// returning the number of the password that opened the first encrypted one
+func extractParts(ctx context.Context, item types.WorkItem, passwords []string) (int, error) {
+    first := 0
+    for i, archive := range item.PartArchives {
+        used, err := extractArchive(ctx, archive, item.Parts[i], passwords, namesStored, nil, nil)
+        if err != nil {
+            return 0, fmt.Errorf("%s: %w", filepath.Base(archive), err)
+        }
+        if first == 0 {
+            first = used
+        }
+    }
+    return first, nil
+}
+
+// mergedComicInfo is the ComicInfo.xml of a merged item: what the ones of
+// its parts agree on, with the gaps filled by a generated one for ComicInfo.
+// The name of a merged archive is usually the volume, never a chapter.
+func mergedComicInfo(item types.WorkItem, opts *types.Options) ([]extraEntry, error) {
+    infos := make([]*comicinfo.ComicInfo, 0, len(item.Parts))
+    for _, part := range item.Parts {
+        ci, err := readPartComicInfo(part)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", filepath.Base(part), err)
+        }
+        infos = append(infos, ci)
+    }
+    merged := comicinfo.Combine(infos)
+
+    if opts.ComicInfo {
+        generated, err := comicInfoOf(item, opts)
+        if err != nil {
+            return nil, err
+        }
+        // "Vol. 01" reads as volume 0 chapter 1 to the title pattern
+        generated.Number = ""
+        if item.Volume == "" {
+            generated.Volume = comicinfo.ParseVolume(item.FolderName)
+        }
+        if merged == nil {
+            merged = generated
+        } else {
+            merged.Fill(generated)
+        }
+    }
+    if merged == nil {
+        return nil, nil
+    }
+
+    data, err := merged.Marshal()
+    if err != nil {
+        return nil, err
+    }
+    return []extraEntry{{name: comicinfo.FileName, data: data}}, nil
+}
+
+// readPartComicInfo reads the ComicInfo.xml at the root of part, nil if it
+// has none
+func readPartComicInfo(part string) (*comicinfo.ComicInfo, error) {
+    entries, err := os.ReadDir(part)
+    if err != nil {
+        return nil, err
+    }
+    for _, e := range entries {
+        if !e.Type().IsRegular() || !strings.EqualFold(e.Name(), comicinfo.FileName) {
+            continue
+        }
+        data, err := os.ReadFile(filepath.Join(part, e.Name()))
+        if err != nil {
+            return nil, err
+        }
+        return comicinfo.Unmarshal(data)
+    }
+    return nil, nil
+}
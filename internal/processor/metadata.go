@@ -0,0 +1,122 @@
+package processor
+
+import (
+    "archive/zip"
+    "convert_cbz/internal/comicinfo"
+    "convert_cbz/internal/types"
+    "io"
+    "path/filepath"
+    "strings"
+)
+
+// metadataEntries generates the metadata files for an archive. A ComicInfo.xml
+// already in the folder always wins over a generated one.
+func metadataEntries(item types.WorkItem, files []string, opts *types.Options) ([]extraEntry, error) {
+    if len(item.Parts) > 0 {
+        return mergedComicInfo(item, opts)
+    }
+    if !opts.ComicInfo || hasRootFile(files, item.SourcePath, comicinfo.FileName) {
+        return nil, nil
+    }
+
+    data, err := GenerateComicInfo(item, opts)
+    if err != nil {
+        return nil, err
+    }
+    return []extraEntry{{name: comicinfo.FileName, data: data}}, nil
+}
+
+// GenerateComicInfo builds the ComicInfo.xml of item from its folder name,
+// the series map and the classification in opts
+func GenerateComicInfo(item types.WorkItem, opts *types.Options) ([]byte, error) {
+    ci, err := comicInfoOf(item, opts)
+    if err != nil {
+        return nil, err
+    }
+    return ci.Marshal()
+}
+
+// comicInfoOf is the document GenerateComicInfo renders
+func comicInfoOf(item types.WorkItem, opts *types.Options) (*comicinfo.ComicInfo, error) {
+    titles := opts.Titles
+    if titles == nil {
+        var err error
+        if titles, err = comicinfo.NewTitleParser(""); err != nil {
+            return nil, err
+        }
+    }
+
+    ch := titles.Parse(item.FolderName)
+    ci := comicinfo.ComicInfo{
+        Title:           ch.Title,
+        Number:          ch.Number,
+        Volume:          ch.Volume,
+        ScanInformation: ch.Group,
+    }
+    if item.Volume != "" {
+        ci.Volume = item.Volume
+    } else if ci.Number == "" && ci.Volume == "" {
+        ci.Volume = comicinfo.MarkedVolume(item.FolderName)
+    }
+
+    class := opts.Classification
+    series, info, ok := lookupSeries(item, opts)
+    if ok {
+        info.Apply(&ci, series)
+        class = class.Merge(info.Classification)
+    } else if item.Series != "" {
+        // A layout knows for sure which folder is the series
+        ci.Series = item.Series
+    }
+    class.Apply(&ci)
+
+    return &ci, nil
+}
+
+// lookupSeries finds the series map entry of item. Chapters live in their
+// series folder, unless the library layout says otherwise.
+func lookupSeries(item types.WorkItem, opts *types.Options) (string, comicinfo.SeriesInfo, bool) {
+    series := item.Series
+    if series == "" {
+        series = filepath.Base(filepath.Dir(item.SourcePath))
+    }
+    info, ok := opts.Series.Lookup(series)
+    return series, info, ok
+}
+
+// hasRootFile reports whether files holds name directly inside sourceDir, ignoring case
+func hasRootFile(files []string, sourceDir, name string) bool {
+    for _, f := range files {
+        if filepath.Dir(f) == filepath.Clean(sourceDir) && strings.EqualFold(filepath.Base(f), name) {
+            return true
+        }
+    }
+    return false
+}
+
+// ReadComicInfo returns the ComicInfo.xml at the root of an archive, or nil
+// if it has none
+func ReadComicInfo(cbzPath string) (*comicinfo.ComicInfo, error) {
+    reader, err := zip.OpenReader(cbzPath)
+    if err != nil {
+        return nil, err
+    }
+    defer reader.Close()
+
+    for _, f := range reader.File {
+        if !strings.EqualFold(f.Name, comicinfo.FileName) {
+            continue
+        }
+        rc, err := f.Open()
+        if err != nil {
+            return nil, err
+        }
+        data, err := io.ReadAll(rc)
+        rc.Close()
+        if err != nil {
+            return nil, err
+        }
+        return comicinfo.Unmarshal(data)
+    }
+    return nil, nil
+}
@@ -0,0 +1,154 @@
+package processor
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+// Renames on SMB and NFS shares fail in ways local disks never do: the
+// server is briefly busy, a handle went stale when the share reconnected, or
+// it can't rename over an existing file, or at all
+const (
+    renameRetries = 4
+    renameBackoff = 250 * time.Millisecond
+)
+
+// moveIntoPlace renames src to dest, which it replaces. Transient failures
+// are retried with growing pauses. A share that refuses to rename over dest
+// gets dest moved aside first, and one that can't rename at all gets src
+// copied across, see copyIntoPlace.
+func moveIntoPlace(src, dest string) error {
+    err := retryRename(src, dest)
+    if err == nil || !renameRefused(err) {
+        return explainNetError(err)
+    }
+
+    // Renaming to a free name is often allowed when replacing isn't
+    if _, statErr := os.Stat(dest); statErr == nil {
+        if aside, nameErr := freeName(dest, ".old"); nameErr == nil && os.Rename(dest, aside) == nil {
+            if err = retryRename(src, dest); err == nil {
+                os.Remove(aside)
+                return nil
+            }
+            os.Rename(aside, dest)
+        }
+    }
+    if !renameUnsupported(err) {
+        return explainNetError(err)
+    }
+    if err := copyIntoPlace(src, dest); err != nil {
+        return explainNetError(err)
+    }
+    os.Remove(src)
+    return nil
+}
+
+// MoveFile moves src to dest the way finished archives are moved into place,
+// copying it across when dest is on another filesystem
+func MoveFile(src, dest string) error {
+    return moveIntoPlace(src, dest)
+}
+
+// retryRename is os.Rename, tried again while it fails in a way that passes
+func retryRename(src, dest string) error {
+    var err error
+    for attempt := 0; ; attempt++ {
+        if err = os.Rename(src, dest); err == nil || !transientNetError(err) || attempt == renameRetries {
+            return err
+        }
+        time.Sleep(renameBackoff << attempt)
+    }
+}
+
+// copyIntoPlace copies src to a temp file next to dest and renames that
+// over dest. A share that refuses even that gets the finished copy written
+// over dest, the one step that isn't atomic. dest is never removed: a copy
+// that fails leaves it as it was, or names the complete copy to recover.
+func copyIntoPlace(src, dest string) error {
+    tmp, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".*.tmp")
+    if err != nil {
+        return fmt.Errorf("failed to copy into place after the rename was refused: %w", err)
+    }
+    // CreateTemp makes files only the owner can read
+    err = tmp.Chmod(0644)
+    if err == nil {
+        err = copyTo(tmp, src)
+    }
+    if closeErr := tmp.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(tmp.Name())
+        return fmt.Errorf("failed to copy into place after the rename was refused: %w", err)
+    }
+
+    if err := retryRename(tmp.Name(), dest); err == nil {
+        return nil
+    }
+    out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+    if err == nil {
+        err = copyTo(out, tmp.Name())
+        if closeErr := out.Close(); err == nil {
+            err = closeErr
+        }
+    }
+    if err != nil {
+        return fmt.Errorf("failed to copy over %s after the rename was refused, the complete copy is %s: %w", dest, tmp.Name(), err)
+    }
+    os.Remove(tmp.Name())
+    return nil
+}
+
+// copyTo writes the contents of src to out and syncs it
+func copyTo(out *os.File, src string) error {
+    in, err := os.Open(src)
+    if err != nil {
+        return err
+    }
+    defer in.Close()
+    if _, err := io.Copy(out, in); err != nil {
+        return err
+    }
+    return out.Sync()
+}
+
+// freeName returns a name next to path that nothing has, unique the way the
+// temp names of a run are
+func freeName(path, suffix string) (string, error) {
+    f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*"+suffix+".tmp")
+    if err != nil {
+        return "", err
+    }
+    f.Close()
+    return f.Name(), os.Remove(f.Name())
+}
+
+// renameRefused reports whether err is a rename the filesystem won't do,
+// rather than one that failed
+func renameRefused(err error) bool {
+    return errors.Is(err, fs.ErrPermission) || errors.Is(err, fs.ErrExist) || renameUnsupported(err)
+}
+
+// staleError is a stale file handle, explained
+type staleError struct{ err error }
+
+func (e *staleError) Error() string {
+    return e.err.Error() + " (the network share reconnected or the file was changed on the server, the next run retries it)"
+}
+
+func (e *staleError) Unwrap() error { return e.err }
+
+// explainNetError adds what a stale file handle means to err, it names no
+// file and is usually mistaken for a broken disk
+func explainNetError(err error) error {
+    var stale *staleError
+    if staleHandle(err) && !errors.As(err, &stale) {
+        return &staleError{err}
+    }
+    return err
+}
@@ -0,0 +1,12 @@
+//go:build !unix && !windows
+
+package processor
+
+// Other systems give no errors to tell a share apart by, a refused rename is
+// only recognized by fs.ErrPermission and fs.ErrExist
+
+func transientNetError(err error) bool { return false }
+
+func renameUnsupported(err error) bool { return false }
+
+func staleHandle(err error) bool { return false }
@@ -0,0 +1,34 @@
+//go:build unix
+
+package processor
+
+import (
+    "errors"
+    "syscall"
+)
+
+// transientNetError reports whether err is one a share gives while it is
+// busy or reconnecting, and that trying again can get past
+func transientNetError(err error) bool {
+    return isErrno(err, syscall.EBUSY, syscall.EAGAIN, syscall.EINTR, syscall.ETIMEDOUT, syscall.ESTALE, syscall.EIO)
+}
+
+// renameUnsupported reports whether err says the filesystem can't rename
+// there at all
+func renameUnsupported(err error) bool {
+    return isErrno(err, syscall.EXDEV, syscall.ENOTSUP, syscall.ENOSYS)
+}
+
+// staleHandle reports whether err is a file handle the share dropped
+func staleHandle(err error) bool {
+    return errors.Is(err, syscall.ESTALE)
+}
+
+func isErrno(err error, errnos ...syscall.Errno) bool {
+    for _, errno := range errnos {
+        if errors.Is(err, errno) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,48 @@
+//go:build windows
+
+package processor
+
+import (
+    "errors"
+    "syscall"
+)
+
+// The Win32 errors shares give, the E constants of syscall are made up on
+// Windows and never come back from a rename
+const (
+    errorInvalidFunction  syscall.Errno = 1
+    errorNotSameDevice    syscall.Errno = 17
+    errorSharingViolation syscall.Errno = 32
+    errorLockViolation    syscall.Errno = 33
+    errorNotSupported     syscall.Errno = 50
+    errorUnexpNetErr      syscall.Errno = 59
+    errorSemTimeout       syscall.Errno = 121
+)
+
+// transientNetError reports whether err is one a share gives while it is
+// busy or reconnecting, and that trying again can get past. Another program
+// holding the file open, a virus scanner most of the time, passes too.
+func transientNetError(err error) bool {
+    return isErrno(err, errorSharingViolation, errorLockViolation, errorUnexpNetErr, errorSemTimeout, syscall.ERROR_NETNAME_DELETED)
+}
+
+// renameUnsupported reports whether err says the filesystem can't rename
+// there at all
+func renameUnsupported(err error) bool {
+    return isErrno(err, errorNotSameDevice, errorNotSupported, errorInvalidFunction)
+}
+
+// staleHandle reports whether err is a share that went away under an open
+// file, what a stale handle is on Windows
+func staleHandle(err error) bool {
+    return errors.Is(err, syscall.ERROR_NETNAME_DELETED)
+}
+
+func isErrno(err error, errnos ...syscall.Errno) bool {
+    for _, errno := range errnos {
+        if errors.Is(err, errno) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,59 @@
+package processor
+
+import (
+    "archive/zip"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// archiveUpToDate compares the fingerprint stored in an existing archive with
+// the current one. Archives from before fingerprinting (or built with another
+// fingerprint mode) fall back to comparing entry names and sizes.
+func archiveUpToDate(cbzPath, sourceDir string, files []string, fp string) (bool, error) {
+    stored, err := ReadFingerprint(cbzPath)
+    if err != nil {
+        return false, err
+    }
+    if mode, _, _ := strings.Cut(stored, ":"); stored != "" && strings.HasPrefix(fp, mode+":") {
+        return stored == fp, nil
+    }
+    return archiveMatches(cbzPath, sourceDir, files)
+}
+
+// archiveMatches reports whether the archive at cbzPath holds exactly the
+// given source files, compared by entry name and size
+func archiveMatches(cbzPath, sourceDir string, files []string) (bool, error) {
+    reader, err := zip.OpenReader(cbzPath)
+    if err != nil {
+        return false, err
+    }
+    defer reader.Close()
+
+    generated := generatedEntries(reader.Comment)
+    entries := make(map[string]uint64, len(reader.File))
+    for _, f := range reader.File {
+        if !f.FileInfo().IsDir() && !generated[f.Name] {
+            entries[storedName(f)] = f.UncompressedSize64
+        }
+    }
+    if len(entries) != len(files) {
+        return false, nil
+    }
+
+    for _, filePath := range files {
+        rel, err := filepath.Rel(sourceDir, filePath)
+        if err != nil {
+            return false, err
+        }
+        info, err := os.Stat(filePath)
+        if err != nil {
+            return false, err
+        }
+        size, ok := entries[filepath.ToSlash(rel)]
+        if !ok || size != uint64(info.Size()) {
+            return false, nil
+        }
+    }
+    return true, nil
+}
@@ -0,0 +1,205 @@
+package processor
+
+import (
+    "archive/zip"
+    "convert_cbz/internal/types"
+    "convert_cbz/internal/util"
+    "encoding/json"
+    "fmt"
+    "io"
+    "path"
+    "path/filepath"
+    "strconv"
+    "strings"
+
+    "golang.org/x/text/unicode/norm"
+)
+
+// ManifestName is the generated entry that maps renamed entries back to the
+// names they had in the source folder
+const ManifestName = "convert-cbz-pages.json"
+
+// PageName is one renamed entry in the manifest
+type PageName struct {
+    Name     string `json:"name"`
+    Original string `json:"original"` // Path inside the source folder, slash separated
+}
+
+// PageLink is a file of the source folder that was a hard link to another
+// one, which is only stored once
+type PageLink struct {
+    Original string `json:"original"` // Path inside the source folder, slash separated
+    Target   string `json:"target"`   // Entry holding its bytes
+}
+
+// Manifest is the content of ManifestName
+type Manifest struct {
+    Pages []PageName `json:"pages"`
+    Links []PageLink `json:"links,omitempty"`
+}
+
+// entryNames returns the entry names of the files that aren't stored under
+// their path in the source folder of item, nil if there are none: pages
+// renamed by RenamePages or a flat merge, the folders of merged parts, names
+// Windows can't extract with an EntrySanitizer, names in another Unicode form
+// than Normalize asks for, names too long or deep for MaxEntryLength and
+// MaxEntryDepth, and names that would be the same file as an earlier one once
+// extracted.
+func entryNames(files []string, item types.WorkItem, opts *types.Options) (map[string]string, error) {
+    sourceDir := item.SourcePath
+    var names map[string]string
+    switch {
+    case opts.RenamePages, len(item.Parts) > 0 && opts.Merge == types.MergeFlat:
+        names = renamePages(files)
+    case len(item.Parts) > 0:
+        names = partNames(files, item)
+    }
+    rename := func(f, name string) {
+        if names == nil {
+            names = make(map[string]string)
+        }
+        names[f] = name
+    }
+
+    owners := make(map[string]bool, len(files))
+    for _, f := range files {
+        name, err := entryName(f, sourceDir, names)
+        if err != nil {
+            return nil, err
+        }
+        if opts.EntrySanitizer != nil || opts.Normalize != types.NormNone {
+            if safe := util.Normalize(opts.EntrySanitizer.Path(name), opts.Normalize); safe != name {
+                rename(f, safe)
+                name = safe
+            }
+        }
+        limited := opts.MaxEntryLength > 0 || opts.MaxEntryDepth > 0
+        if limited {
+            fitted, err := fitEntry(name, opts.MaxEntryLength, opts.MaxEntryDepth)
+            if err != nil {
+                return nil, err
+            }
+            if fitted != name {
+                rename(f, fitted)
+                name = fitted
+            }
+        }
+        // Page.jpg and page.jpg, or é composed and decomposed, are one file on
+        // macOS and Windows. Files come in archive order, so the first keeps
+        // its name every time.
+        if owners[entryKey(name)] {
+            if limited {
+                if name, err = uniqueFitted(name, owners, opts.MaxEntryLength, opts.MaxEntryDepth); err != nil {
+                    return nil, err
+                }
+            } else {
+                name = uniqueEntry(name, owners)
+            }
+            rename(f, name)
+        }
+        owners[entryKey(name)] = true
+    }
+    return names, nil
+}
+
+// entryKey is what entry names are compared by for collisions: case folded
+// and in NFC, the way case-insensitive filesystems see them
+func entryKey(name string) string {
+    return strings.ToLower(norm.NFC.String(name))
+}
+
+// uniqueEntry gives name a ~2, ~3, ... suffix until its key isn't in owners
+func uniqueEntry(name string, owners map[string]bool) string {
+    ext := path.Ext(name)
+    base := strings.TrimSuffix(name, ext)
+    for i := 2; ; i++ {
+        candidate := base + "~" + strconv.Itoa(i) + ext
+        if !owners[entryKey(candidate)] {
+            return candidate
+        }
+    }
+}
+
+// renamePages names the images among files 0001.jpg, 0002.png, ... in the
+// order they are archived, flattening subdirectories. Other files keep their
+// names. The width grows past four digits for longer folders.
+func renamePages(files []string) map[string]string {
+    pages := 0
+    for _, f := range files {
+        if imageExtensions[strings.ToLower(filepath.Ext(f))] {
+            pages++
+        }
+    }
+    width := max(4, len(strconv.Itoa(pages)))
+
+    names := make(map[string]string, pages)
+    for _, f := range files {
+        if ext := strings.ToLower(filepath.Ext(f)); imageExtensions[ext] {
+            names[f] = fmt.Sprintf("%0*d%s", width, len(names)+1, ext)
+        }
+    }
+    return names
+}
+
+// manifestEntry records the source name of every renamed entry, in archive
+// order, and the entry each dropped hard link points to
+func manifestEntry(allFiles, files []string, sourceDir string, names, links map[string]string) (extraEntry, error) {
+    manifest := Manifest{Pages: []PageName{}}
+    for _, f := range allFiles {
+        target, ok := links[f]
+        if !ok {
+            continue
+        }
+        rel, err := filepath.Rel(sourceDir, f)
+        if err != nil {
+            return extraEntry{}, err
+        }
+        name, err := entryName(target, sourceDir, names)
+        if err != nil {
+            return extraEntry{}, err
+        }
+        manifest.Links = append(manifest.Links, PageLink{Original: filepath.ToSlash(rel), Target: name})
+    }
+    for _, f := range files {
+        name, ok := names[f]
+        if !ok {
+            continue
+        }
+        rel, err := filepath.Rel(sourceDir, f)
+        if err != nil {
+            return extraEntry{}, err
+        }
+        manifest.Pages = append(manifest.Pages, PageName{Name: name, Original: filepath.ToSlash(rel)})
+    }
+
+    data, err := json.MarshalIndent(manifest, "", "  ")
+    if err != nil {
+        return extraEntry{}, err
+    }
+    return extraEntry{name: ManifestName, data: data}, nil
+}
+
+// readManifest returns the manifest of an archive, or nil if it has none
+func readManifest(reader *zip.Reader) (*Manifest, error) {
+    for _, f := range reader.File {
+        if f.Name != ManifestName {
+            continue
+        }
+        rc, err := f.Open()
+        if err != nil {
+            return nil, err
+        }
+        data, err := io.ReadAll(rc)
+        rc.Close()
+        if err != nil {
+            return nil, err
+        }
+
+        var manifest Manifest
+        if err := json.Unmarshal(data, &manifest); err != nil {
+            return nil, fmt.Errorf("corrupt %s: %w", ManifestName, err)
+        }
+        return &manifest, nil
+    }
+    return nil, nil
+}
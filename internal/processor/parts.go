@@ -0,0 +1,47 @@
+package processor
+
+import (
+    "convert_cbz/internal/types"
+    "fmt"
+    "os"
+    "path/filepath"
+)
+
+// entryOverhead is about what a zip entry takes besides its data and name,
+// its local header, data descriptor and central directory record
+const entryOverhead = 30 + 16 + 46
+
+// PageSizes returns what every file selected for item takes up in its
+// archive, in page order, stored uncompressed. -max-size and -max-pages
+// split folders by it.
+func PageSizes(item types.WorkItem, opts *types.Options) ([]int64, error) {
+    files, _, err := selectItemFiles(item, opts)
+    if err != nil {
+        return nil, err
+    }
+
+    sizes := make([]int64, len(files))
+    for i, f := range files {
+        info, err := os.Stat(f)
+        if err != nil {
+            return nil, err
+        }
+        name := f
+        if rel, err := filepath.Rel(item.SourcePath, f); err == nil {
+            name = rel
+        }
+        // The name is stored twice, in the local header and the directory
+        sizes[i] = info.Size() + entryOverhead + 2*int64(len(name))
+    }
+    return sizes, nil
+}
+
+// pageRange narrows a selection to the files of one part of a split folder
+func pageRange(files []string, result archiveResult, r [2]int) ([]string, archiveResult, error) {
+    if r[1] > len(files) || r[0] >= r[1] {
+        return nil, result, fmt.Errorf("folder has %d files, fewer than when it was split into parts", len(files))
+    }
+    files = files[r[0]:r[1]]
+    result.Included = len(files)
+    return files, result, nil
+}
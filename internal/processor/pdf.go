@@ -0,0 +1,652 @@
+package processor
+
+import (
+    "bytes"
+    "compress/zlib"
+    "context"
+    "convert_cbz/internal/util"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "hash/crc32"
+    "io"
+    "maps"
+    "os"
+    "path/filepath"
+    "regexp"
+    "slices"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// PDFs of comics are a page per scan, each page drawing one image. Only what
+// it takes to find those images and write them out as files is parsed: the
+// objects, the object streams newer PDFs pack them into, and the page tree.
+
+// pdfName is a /Name, without the slash
+type pdfName string
+
+// pdfRef is an indirect reference to an object, 12 0 R
+type pdfRef int
+
+type pdfDict map[string]any
+
+var errPDFSyntax = errors.New("malformed PDF")
+
+// A damaged or hostile PDF can claim anything, these bound what it can make
+// the converter allocate: the bytes a stream inflates to and the pixels of an
+// image, both far beyond any scan
+const (
+    maxPDFStream = 1 << 30
+    maxPDFPixels = 1 << 27
+)
+
+// pdfObjectStart finds "12 0 obj", the number of the object in the first group
+var pdfObjectStart = regexp.MustCompile(`(?:^|\s)(\d+)\s+\d+\s+obj\b`)
+
+// pdfFile is a PDF read whole, with where each of its objects is
+type pdfFile struct {
+    data    []byte
+    offsets map[int]int    // Object number to where its value starts in data
+    packed  map[int][]byte // Objects kept in object streams, as text
+}
+
+// extractPDF writes the images the pages of the PDF at pdfPath draw into dir
+// as 0001.jpg, 0002.png, ... in page order, each once. JPEG images are
+// written as they are stored, the compressed pixels most other images are
+// become PNGs without losing anything. Encrypted PDFs and images in other
+// encodings, like JBIG2, fail. started and added are as for extractArchive.
+func extractPDF(ctx context.Context, pdfPath, dir string, started func(total int), added func(name string, size int64)) error {
+    f, err := openPDF(pdfPath)
+    if err != nil {
+        return err
+    }
+    images, err := f.pageImages()
+    if err != nil {
+        return err
+    }
+    if len(images) == 0 {
+        return errors.New("no page images found in the PDF")
+    }
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return err
+    }
+
+    if started != nil {
+        started(len(images))
+    }
+    width := max(4, len(strconv.Itoa(len(images))))
+    for i, num := range images {
+        if ctx.Err() != nil {
+            return errAborted
+        }
+        data, ext, err := f.image(num)
+        if err != nil {
+            return fmt.Errorf("failed to extract the image of page %d: %w", i+1, err)
+        }
+        name := fmt.Sprintf("%0*d%s", width, i+1, ext)
+        if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+            return err
+        }
+        if added != nil {
+            added(name, int64(len(data)))
+        }
+    }
+    return nil
+}
+
+func openPDF(path string) (*pdfFile, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open PDF: %w", err)
+    }
+    if !bytes.Contains(data[:min(len(data), 1024)], []byte("%PDF-")) {
+        return nil, errors.New("failed to open PDF: not a PDF file")
+    }
+    if bytes.Contains(data, []byte("/Encrypt")) {
+        return nil, errors.New("PDF is encrypted, only ZIP passwords are supported")
+    }
+
+    // Later objects replace earlier ones of the same number, the way
+    // incremental updates append them
+    f := &pdfFile{data: data, offsets: make(map[int]int), packed: make(map[int][]byte)}
+    for _, m := range pdfObjectStart.FindAllSubmatchIndex(data, -1) {
+        if num, err := strconv.Atoi(string(data[m[2]:m[3]])); err == nil {
+            f.offsets[num] = m[1]
+        }
+    }
+    for _, num := range slices.Sorted(maps.Keys(f.offsets)) {
+        v, _, err := f.object(num)
+        if dict, ok := v.(pdfDict); err == nil && ok && dict["Type"] == pdfName("ObjStm") {
+            if err := f.unpack(num, dict); err != nil {
+                return nil, fmt.Errorf("failed to read object stream %d: %w", num, err)
+            }
+        }
+    }
+    return f, nil
+}
+
+// unpack adds the objects of object stream num to packed. Ones also stored
+// on their own are left to that copy.
+func (f *pdfFile) unpack(num int, dict pdfDict) error {
+    _, data, err := f.stream(num)
+    if err != nil {
+        return err
+    }
+    if data, err = f.decode(dict, data); err != nil {
+        return err
+    }
+    n, _ := f.resolve(dict["N"]).(int)
+    first, _ := f.resolve(dict["First"]).(int)
+    // Every object takes at least "1 0 " in the header
+    if n < 0 || first < 0 || first > len(data) || n > (first+1)/4 {
+        return errPDFSyntax
+    }
+
+    header := &pdfParser{b: data[:first]}
+    nums := make([]int, n)
+    starts := make([]int, n)
+    for i := range n {
+        objNum, err := header.value(0)
+        if err != nil {
+            return err
+        }
+        offset, err := header.value(0)
+        if err != nil {
+            return err
+        }
+        nums[i], _ = objNum.(int)
+        starts[i], _ = offset.(int)
+    }
+    for i := range n {
+        start, end := first+starts[i], len(data)
+        if i+1 < n {
+            end = first + starts[i+1]
+        }
+        if start < first || start > end || end > len(data) {
+            return errPDFSyntax
+        }
+        if _, direct := f.offsets[nums[i]]; !direct {
+            f.packed[nums[i]] = data[start:end]
+        }
+    }
+    return nil
+}
+
+// object parses object num, returning the parser left after its value, where
+// the data of a stream follows
+func (f *pdfFile) object(num int) (any, *pdfParser, error) {
+    p := &pdfParser{b: f.data}
+    if off, ok := f.offsets[num]; ok {
+        p.pos = off
+    } else if text, ok := f.packed[num]; ok {
+        p.b = text
+    } else {
+        return nil, nil, fmt.Errorf("PDF object %d is missing", num)
+    }
+    v, err := p.value(0)
+    return v, p, err
+}
+
+// resolve follows v to the object it refers to, nil if that is missing
+func (f *pdfFile) resolve(v any) any {
+    for range 8 {
+        ref, ok := v.(pdfRef)
+        if !ok {
+            return v
+        }
+        v, _, _ = f.object(int(ref))
+    }
+    return nil
+}
+
+// stream returns the dictionary and the raw data of stream object num
+func (f *pdfFile) stream(num int) (pdfDict, []byte, error) {
+    v, p, err := f.object(num)
+    if err != nil {
+        return nil, nil, err
+    }
+    dict, ok := v.(pdfDict)
+    p.skipSpace()
+    if !ok || !bytes.HasPrefix(p.b[p.pos:], []byte("stream")) {
+        return nil, nil, fmt.Errorf("PDF object %d isn't a stream", num)
+    }
+    p.pos += len("stream")
+    if p.pos < len(p.b) && p.b[p.pos] == '\r' {
+        p.pos++
+    }
+    if p.pos < len(p.b) && p.b[p.pos] == '\n' {
+        p.pos++
+    }
+    start := p.pos
+    if n, ok := f.resolve(dict["Length"]).(int); ok && n >= 0 && start+n <= len(p.b) {
+        return dict, p.b[start : start+n], nil
+    }
+
+    // Without a usable length the data runs up to endstream
+    end := bytes.Index(p.b[start:], []byte("endstream"))
+    if end < 0 {
+        return nil, nil, fmt.Errorf("PDF object %d has no end", num)
+    }
+    return dict, bytes.TrimRight(p.b[start:start+end], "\r\n"), nil
+}
+
+// filters are the names of the filters of a stream, in the order they apply
+func (f *pdfFile) filters(dict pdfDict) []pdfName {
+    switch v := f.resolve(dict["Filter"]).(type) {
+    case pdfName:
+        return []pdfName{v}
+    case []any:
+        var names []pdfName
+        for _, n := range v {
+            if name, ok := f.resolve(n).(pdfName); ok {
+                names = append(names, name)
+            }
+        }
+        return names
+    }
+    return nil
+}
+
+// decode undoes the filters of a stream that isn't an image, which in the
+// objects looked at is never anything but flate
+func (f *pdfFile) decode(dict pdfDict, data []byte) ([]byte, error) {
+    for _, filter := range f.filters(dict) {
+        if filter != "FlateDecode" {
+            return nil, fmt.Errorf("unsupported filter %s", filter)
+        }
+        var err error
+        if data, err = inflate(data); err != nil {
+            return nil, err
+        }
+    }
+    return data, nil
+}
+
+func inflate(data []byte) ([]byte, error) {
+    zr, err := zlib.NewReader(bytes.NewReader(data))
+    if err != nil {
+        return nil, err
+    }
+    defer zr.Close()
+    data, err = io.ReadAll(io.LimitReader(zr, maxPDFStream+1))
+    if err == nil && len(data) > maxPDFStream {
+        return nil, errors.New("stream inflates to more than 1GB")
+    }
+    return data, err
+}
+
+// pageImages returns the image objects the pages draw, in page order and
+// each only once. The images of a page, usually just one, are taken in
+// natural order of their resource names.
+func (f *pdfFile) pageImages() ([]int, error) {
+    i := bytes.LastIndex(f.data, []byte("/Root"))
+    if i < 0 {
+        return nil, errors.New("PDF has no catalog")
+    }
+    p := &pdfParser{b: f.data, pos: i + len("/Root")}
+    root, err := p.value(0)
+    if err != nil {
+        return nil, err
+    }
+    catalog, ok := f.resolve(root).(pdfDict)
+    if !ok {
+        return nil, errors.New("PDF has no catalog")
+    }
+
+    var images []int
+    seen := make(map[int]bool)
+    err = f.walkPages(catalog["Pages"], nil, 0, func(num int) {
+        if !seen[num] {
+            seen[num] = true
+            images = append(images, num)
+        }
+    })
+    return images, err
+}
+
+// walkPages calls add with the images of every page below node. Resources
+// are inherited from the nodes above a page.
+func (f *pdfFile) walkPages(node, resources any, depth int, add func(int)) error {
+    dict, ok := f.resolve(node).(pdfDict)
+    if !ok || depth > 64 {
+        return errors.New("PDF has a broken page tree")
+    }
+    if r, ok := dict["Resources"]; ok {
+        resources = r
+    }
+    if kids, ok := f.resolve(dict["Kids"]).([]any); ok {
+        for _, kid := range kids {
+            if err := f.walkPages(kid, resources, depth+1, add); err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+    f.addImages(resources, 0, add)
+    return nil
+}
+
+// addImages calls add with the images of resources, and those of the forms
+// in it, which draw images of their own. Masks aren't pages.
+func (f *pdfFile) addImages(resources any, depth int, add func(int)) {
+    res, _ := f.resolve(resources).(pdfDict)
+    xobjects, _ := f.resolve(res["XObject"]).(pdfDict)
+    names := slices.Collect(maps.Keys(xobjects))
+    sort.Slice(names, func(i, j int) bool { return util.NaturalLess(names[i], names[j]) })
+    for _, name := range names {
+        ref, ok := xobjects[name].(pdfRef)
+        if !ok {
+            continue
+        }
+        v, _, err := f.object(int(ref))
+        dict, ok := v.(pdfDict)
+        if err != nil || !ok {
+            continue
+        }
+        switch dict["Subtype"] {
+        case pdfName("Image"):
+            if mask, _ := dict["ImageMask"].(bool); !mask {
+                add(int(ref))
+            }
+        case pdfName("Form"):
+            if depth < 8 {
+                f.addImages(dict["Resources"], depth+1, add)
+            }
+        }
+    }
+}
+
+// image returns image object num as the bytes of a file, and its extension
+func (f *pdfFile) image(num int) ([]byte, string, error) {
+    dict, data, err := f.stream(num)
+    if err != nil {
+        return nil, "", err
+    }
+    filters := f.filters(dict)
+    jpeg := len(filters) > 0 && filters[len(filters)-1] == "DCTDecode"
+    if jpeg {
+        filters = filters[:len(filters)-1]
+    }
+    for _, filter := range filters {
+        if filter != "FlateDecode" {
+            return nil, "", fmt.Errorf("images encoded with %s can't be extracted", filter)
+        }
+        if data, err = inflate(data); err != nil {
+            return nil, "", err
+        }
+    }
+    if jpeg {
+        return data, ".jpg", nil
+    }
+
+    // The parameters of the flate filter, the last and usually only one
+    parms := f.resolve(dict["DecodeParms"])
+    if list, ok := parms.([]any); ok && len(list) > 0 {
+        parms = f.resolve(list[len(list)-1])
+    }
+    predictor := 1
+    if p, ok := parms.(pdfDict); ok && len(filters) > 0 {
+        if n, ok := f.resolve(p["Predictor"]).(int); ok {
+            predictor = n
+        }
+    }
+    png, err := f.png(dict, data, predictor)
+    return png, ".png", err
+}
+
+// png turns the pixels of an image into a PNG file. PNG predictors filter
+// rows exactly like PNG does, those are stored as they are.
+func (f *pdfFile) png(dict pdfDict, pixels []byte, predictor int) ([]byte, error) {
+    width, _ := f.resolve(dict["Width"]).(int)
+    height, _ := f.resolve(dict["Height"]).(int)
+    bpc, _ := f.resolve(dict["BitsPerComponent"]).(int)
+    colorType, channels, ok := f.pngColor(dict["ColorSpace"])
+    if !ok {
+        return nil, errors.New("only gray and RGB images can be extracted")
+    }
+    if width <= 0 || height <= 0 {
+        return nil, errPDFSyntax
+    }
+    if width > maxPDFPixels/height {
+        return nil, fmt.Errorf("image of %dx%d pixels is too large to extract", width, height)
+    }
+    if bpc != 8 && bpc != 16 && (colorType != 0 || (bpc != 1 && bpc != 2 && bpc != 4)) {
+        return nil, fmt.Errorf("images with %d bits per component can't be extracted", bpc)
+    }
+
+    stride := (width*channels*bpc + 7) / 8
+    var rows []byte
+    switch {
+    case predictor >= 10:
+        if len(pixels) < (stride+1)*height {
+            return nil, errors.New("image is truncated")
+        }
+        rows = pixels[:(stride+1)*height]
+    case predictor <= 1:
+        if len(pixels) < stride*height {
+            return nil, errors.New("image is truncated")
+        }
+        rows = make([]byte, 0, (stride+1)*height)
+        for y := range height {
+            rows = append(rows, 0)
+            rows = append(rows, pixels[y*stride:(y+1)*stride]...)
+        }
+    default:
+        return nil, fmt.Errorf("images with predictor %d can't be extracted", predictor)
+    }
+
+    var idat bytes.Buffer
+    zw := zlib.NewWriter(&idat)
+    zw.Write(rows)
+    if err := zw.Close(); err != nil {
+        return nil, err
+    }
+    ihdr := make([]byte, 13)
+    binary.BigEndian.PutUint32(ihdr[0:], uint32(width))
+    binary.BigEndian.PutUint32(ihdr[4:], uint32(height))
+    ihdr[8], ihdr[9] = byte(bpc), colorType
+
+    var buf bytes.Buffer
+    buf.WriteString("\x89PNG\r\n\x1a\n")
+    pngChunk(&buf, "IHDR", ihdr)
+    pngChunk(&buf, "IDAT", idat.Bytes())
+    pngChunk(&buf, "IEND", nil)
+    return buf.Bytes(), nil
+}
+
+// pngColor is the PNG color type and number of channels of a color space
+func (f *pdfFile) pngColor(cs any) (byte, int, bool) {
+    switch v := f.resolve(cs).(type) {
+    case pdfName:
+        switch v {
+        case "DeviceGray", "CalGray", "G":
+            return 0, 1, true
+        case "DeviceRGB", "CalRGB", "RGB":
+            return 2, 3, true
+        }
+    case []any:
+        if len(v) == 0 {
+            break
+        }
+        if name, _ := v[0].(pdfName); name == "CalGray" || name == "CalRGB" {
+            return f.pngColor(name)
+        }
+        if v[0] == pdfName("ICCBased") && len(v) == 2 {
+            profile, _ := f.resolve(v[1]).(pdfDict)
+            switch f.resolve(profile["N"]) {
+            case 1:
+                return 0, 1, true
+            case 3:
+                return 2, 3, true
+            }
+        }
+    }
+    return 0, 0, false
+}
+
+func pngChunk(buf *bytes.Buffer, kind string, data []byte) {
+    binary.Write(buf, binary.BigEndian, uint32(len(data)))
+    crc := crc32.NewIEEE()
+    crc.Write([]byte(kind))
+    crc.Write(data)
+    buf.WriteString(kind)
+    buf.Write(data)
+    binary.Write(buf, binary.BigEndian, crc.Sum32())
+}
+
+// pdfParser reads PDF values from b, starting at pos
+type pdfParser struct {
+    b   []byte
+    pos int
+}
+
+func isPDFSpace(c byte) bool {
+    return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPDFDelim(c byte) bool {
+    return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// skipSpace skips whitespace and comments
+func (p *pdfParser) skipSpace() {
+    for p.pos < len(p.b) {
+        switch c := p.b[p.pos]; {
+        case c == '%':
+            for p.pos < len(p.b) && p.b[p.pos] != '\n' && p.b[p.pos] != '\r' {
+                p.pos++
+            }
+        case isPDFSpace(c):
+            p.pos++
+        default:
+            return
+        }
+    }
+}
+
+// token reads a run of regular characters, a number or keyword
+func (p *pdfParser) token() string {
+    start := p.pos
+    for p.pos < len(p.b) && !isPDFSpace(p.b[p.pos]) && !isPDFDelim(p.b[p.pos]) {
+        p.pos++
+    }
+    return string(p.b[start:p.pos])
+}
+
+// value reads the next value: a pdfDict, []any, pdfName, pdfRef, int,
+// float64, bool, string or nil. Strings are kept undecoded, none of them
+// matter here.
+func (p *pdfParser) value(depth int) (any, error) {
+    p.skipSpace()
+    if p.pos >= len(p.b) || depth > 64 {
+        return nil, errPDFSyntax
+    }
+    c := p.b[p.pos]
+    switch {
+    case c == '/':
+        p.pos++
+        return pdfName(p.token()), nil
+
+    case c == '<' && p.pos+1 < len(p.b) && p.b[p.pos+1] == '<':
+        p.pos += 2
+        dict := pdfDict{}
+        for {
+            p.skipSpace()
+            if bytes.HasPrefix(p.b[p.pos:], []byte(">>")) {
+                p.pos += 2
+                return dict, nil
+            }
+            key, err := p.value(depth + 1)
+            if err != nil {
+                return nil, err
+            }
+            name, ok := key.(pdfName)
+            if !ok {
+                return nil, errPDFSyntax
+            }
+            v, err := p.value(depth + 1)
+            if err != nil {
+                return nil, err
+            }
+            dict[string(name)] = v
+        }
+
+    case c == '<':
+        end := bytes.IndexByte(p.b[p.pos:], '>')
+        if end < 0 {
+            return nil, errPDFSyntax
+        }
+        s := string(p.b[p.pos+1 : p.pos+end])
+        p.pos += end + 1
+        return s, nil
+
+    case c == '(':
+        start, nesting := p.pos+1, 0
+        for p.pos++; p.pos < len(p.b); p.pos++ {
+            switch p.b[p.pos] {
+            case '\\':
+                p.pos++
+            case '(':
+                nesting++
+            case ')':
+                if nesting == 0 {
+                    p.pos++
+                    return string(p.b[start : p.pos-1]), nil
+                }
+                nesting--
+            }
+        }
+        return nil, errPDFSyntax
+
+    case c == '[':
+        p.pos++
+        list := []any{}
+        for {
+            p.skipSpace()
+            if p.pos < len(p.b) && p.b[p.pos] == ']' {
+                p.pos++
+                return list, nil
+            }
+            v, err := p.value(depth + 1)
+            if err != nil {
+                return nil, err
+            }
+            list = append(list, v)
+        }
+
+    case isPDFDelim(c):
+        return nil, errPDFSyntax
+    }
+
+    tok := p.token()
+    switch tok {
+    case "true":
+        return true, nil
+    case "false":
+        return false, nil
+    case "null":
+        return nil, nil
+    }
+    n, err := strconv.Atoi(tok)
+    if err != nil {
+        if f, err := strconv.ParseFloat(tok, 64); err == nil {
+            return f, nil
+        }
+        return nil, errPDFSyntax
+    }
+
+    // 12 0 R refers to object 12
+    mark := p.pos
+    p.skipSpace()
+    if _, err := strconv.Atoi(p.token()); err == nil {
+        p.skipSpace()
+        if p.pos < len(p.b) && p.b[p.pos] == 'R' && (p.pos+1 == len(p.b) || isPDFSpace(p.b[p.pos+1]) || isPDFDelim(p.b[p.pos+1])) {
+            p.pos++
+            return pdfRef(n), nil
+        }
+    }
+    p.pos = mark
+    return n, nil
+}
@@ -0,0 +1,203 @@
+package processor
+
+import (
+    "bytes"
+    "compress/zlib"
+    "context"
+    "fmt"
+    "image/png"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// jpegPage stands in for a scan, the PDF stores it as it is
+const jpegPage = "\xff\xd8\xff\xe0\x00\x10JFIF\x00scan\xff\xd9"
+
+func TestExtractPDF(t *testing.T) {
+    catalog := "<< /Type /Catalog /Pages 2 0 R >>"
+    pages := "<< /Type /Pages /Kids [3 0 R] /Count 1 >>"
+    page := "<< /Type /Page /Parent 2 0 R /Resources << /XObject << /Im1 4 0 R >> >> >>"
+    gray := pdfStream("/Type /XObject /Subtype /Image /Width 2 /Height 2 /ColorSpace /DeviceGray /BitsPerComponent 8", "\x00\x40\x80\xff")
+    full := buildPDF(catalog, pages, page, gray)
+
+    tests := []struct {
+        name  string
+        pdf   []byte
+        files map[string]string // Name to contents, "" when only its existence is checked
+        err   string
+    }{
+        {
+            name:  "jpeg",
+            pdf:   buildPDF(catalog, pages, page, pdfStream("/Subtype /Image /Width 1 /Height 1 /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /DCTDecode", jpegPage)),
+            files: map[string]string{"0001.jpg": jpegPage},
+        },
+        {
+            name:  "gray pixels",
+            pdf:   full,
+            files: map[string]string{"0001.png": ""},
+        },
+        {
+            name: "flate with png predictor",
+            pdf: buildPDF(catalog, pages, page, pdfStream("/Subtype /Image /Width 2 /Height 1 /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode /DecodeParms << /Predictor 15 /Colors 3 /Columns 2 >>",
+                deflate("\x00\xff\x00\x00\x00\xff\x00"))),
+            files: map[string]string{"0001.png": ""},
+        },
+        {
+            name:  "xref offsets wrong",
+            pdf:   bytes.Replace(full, []byte("0000000009 00000 n"), []byte("0000099999 00000 n"), 1),
+            files: map[string]string{"0001.png": ""},
+        },
+        {
+            name: "truncated xref",
+            pdf:  full[:bytes.Index(full, []byte("xref"))+20],
+            err:  "no catalog",
+        },
+        {
+            name: "truncated stream",
+            pdf:  buildPDF(catalog, pages, page, "<< /Subtype /Image /Width 2 /Height 2 /ColorSpace /DeviceGray /BitsPerComponent 8 /Length 9999 >>\nstream\n\x00\x40"),
+            err:  "has no end",
+        },
+        {
+            name: "encrypted",
+            pdf:  bytes.Replace(full, []byte("/Root 1 0 R"), []byte("/Root 1 0 R /Encrypt 9 0 R"), 1),
+            err:  "encrypted",
+        },
+        {
+            name: "object stream",
+            pdf: buildPDF("<< /Type /Catalog /Pages 3 0 R >>",
+                objectStream(map[int]string{3: "<< /Type /Pages /Kids [4 0 R] /Count 1 >>", 4: "<< /Type /Page /Resources << /XObject << /Im1 5 0 R >> >> >>"}),
+                "", "", gray),
+            files: map[string]string{"0001.png": ""},
+        },
+        {
+            name: "object stream count past its header",
+            pdf:  buildPDF(catalog, pages, page, gray, pdfStream("/Type /ObjStm /N 100000000 /First 8", "6 0 7 2 1 2")),
+            err:  "object stream 5",
+        },
+        {
+            name: "object stream offset past its data",
+            pdf:  buildPDF(catalog, pages, page, gray, pdfStream("/Type /ObjStm /N 2 /First 12", "6 0 7 9999 1 2")),
+            err:  "object stream 5",
+        },
+        {
+            name: "oversized image",
+            pdf:  buildPDF(catalog, pages, page, pdfStream("/Subtype /Image /Width 100000 /Height 100000 /ColorSpace /DeviceGray /BitsPerComponent 8", "\x00")),
+            err:  "too large",
+        },
+        {
+            name: "negative size",
+            pdf:  buildPDF(catalog, pages, page, pdfStream("/Subtype /Image /Width -2 /Height 2 /ColorSpace /DeviceGray /BitsPerComponent 8", "\x00")),
+            err:  "malformed",
+        },
+        {
+            name: "truncated pixels",
+            pdf:  buildPDF(catalog, pages, page, pdfStream("/Subtype /Image /Width 4 /Height 4 /ColorSpace /DeviceGray /BitsPerComponent 8", "\x00\x00\x00")),
+            err:  "truncated",
+        },
+        {
+            name: "page tree loop",
+            pdf:  buildPDF(catalog, "<< /Type /Pages /Kids [2 0 R] /Count 1 >>"),
+            err:  "broken page tree",
+        },
+        {
+            name: "no images",
+            pdf:  buildPDF(catalog, pages, "<< /Type /Page /Parent 2 0 R >>"),
+            err:  "no page images",
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            dir := t.TempDir()
+            path := filepath.Join(dir, "book.pdf")
+            if err := os.WriteFile(path, tt.pdf, 0644); err != nil {
+                t.Fatal(err)
+            }
+            out := filepath.Join(dir, "pages")
+            err := extractPDF(context.Background(), path, out, nil, nil)
+            if tt.err != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.err) {
+                    t.Fatalf("err = %v, want one about %q", err, tt.err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            entries, _ := os.ReadDir(out)
+            if len(entries) != len(tt.files) {
+                t.Fatalf("extracted %d files, want %d", len(entries), len(tt.files))
+            }
+            for name, want := range tt.files {
+                got, err := os.ReadFile(filepath.Join(out, name))
+                if err != nil {
+                    t.Fatal(err)
+                }
+                if want != "" && string(got) != want {
+                    t.Fatalf("%s holds %q, want %q", name, got, want)
+                }
+                if filepath.Ext(name) == ".png" {
+                    if _, err := png.Decode(bytes.NewReader(got)); err != nil {
+                        t.Fatalf("%s isn't a valid PNG: %v", name, err)
+                    }
+                }
+            }
+        })
+    }
+}
+
+// buildPDF lays out objects as 1 0 obj, 2 0 obj, ... with an xref table and
+// a trailer pointing /Root at the first. An empty object is left out, its
+// number free for an object stream to hold.
+func buildPDF(objects ...string) []byte {
+    var b bytes.Buffer
+    b.WriteString("%PDF-1.5\n")
+    offsets := make([]int, len(objects))
+    for i, o := range objects {
+        if o == "" {
+            continue
+        }
+        offsets[i] = b.Len()
+        fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, o)
+    }
+    xref := b.Len()
+    fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+    for _, off := range offsets {
+        if off == 0 {
+            b.WriteString("0000000000 00000 f \n")
+            continue
+        }
+        fmt.Fprintf(&b, "%010d 00000 n \n", off)
+    }
+    fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+    return b.Bytes()
+}
+
+// pdfStream is a stream object with the entries of dict and data
+func pdfStream(dict, data string) string {
+    return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+// objectStream packs objects into a flate compressed object stream
+func objectStream(objects map[int]string) string {
+    var header, body strings.Builder
+    for num := range len(objects) + 10 {
+        text, ok := objects[num]
+        if !ok {
+            continue
+        }
+        fmt.Fprintf(&header, "%d %d ", num, body.Len())
+        body.WriteString(text + "\n")
+    }
+    data := header.String() + body.String()
+    return pdfStream(fmt.Sprintf("/Type /ObjStm /N %d /First %d /Filter /FlateDecode", len(objects), header.Len()), deflate(data))
+}
+
+func deflate(data string) string {
+    var b bytes.Buffer
+    zw := zlib.NewWriter(&b)
+    zw.Write([]byte(data))
+    zw.Close()
+    return b.String()
+}
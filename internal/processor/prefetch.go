@@ -0,0 +1,73 @@
+package processor
+
+import (
+    "convert_cbz/internal/types"
+    "os"
+    "sync"
+)
+
+// job is a work item on its way to a worker, possibly with its file
+// selection already done by the prefetch stage
+type job struct {
+    item       types.WorkItem
+    prefetched bool
+    files      []string
+    result     archiveResult
+    err        error
+}
+
+// startPrefetch moves items from queue to workChan. With prefetching enabled,
+// opts.Prefetch goroutines walk and sniff folders while the workers are busy
+// compressing, so a slow directory walk never leaves a worker idle.
+// workChan is closed once queue is drained.
+func startPrefetch(queue <-chan types.WorkItem, workChan chan<- job, gate *throttle, opts *types.Options) {
+    var wg sync.WaitGroup
+    for range max(opts.Prefetch, 1) {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for item := range queue {
+                // What a pre-hook changes would be read too early
+                if opts.Prefetch > 0 && opts.PreHook == "" {
+                    workChan <- prefetch(item, opts)
+                } else {
+                    workChan <- job{item: item}
+                }
+            }
+        }()
+    }
+
+    go func() {
+        wg.Wait()
+        close(workChan)
+        gate.dispatchDone()
+    }()
+}
+
+func prefetch(item types.WorkItem, opts *types.Options) (j job) {
+    j = job{item: item}
+
+    // The worker fails the item with it like with any selection error
+    defer func() {
+        if err := recovered(recover()); err != nil {
+            j.err = err
+            j.prefetched = true
+        }
+    }()
+
+    // Archives are only unpacked by the worker converting them
+    if item.Archive != "" || len(item.PartArchives) > 0 {
+        return j
+    }
+
+    // Skips are decided by a stat, don't walk folders that won't be converted
+    if opts.Overwrite == types.OverwriteSkip && !opts.Update {
+        if _, err := os.Stat(item.OutputPath); err == nil {
+            return j
+        }
+    }
+
+    j.files, j.result, j.err = selectItemFiles(item, opts)
+    j.prefetched = true
+    return j
+}
@@ -2,158 +2,894 @@ package processor
 
 import (
     "archive/zip"
+    "context"
+    "convert_cbz/internal/ignore"
     "convert_cbz/internal/types"
     "convert_cbz/internal/util"
+    "errors"
     "fmt"
     "os"
+    "path"
     "path/filepath"
+    "slices"
+    "sort"
+    "strings"
     "sync"
     "time"
 
     "github.com/jelius-sama/logger"
 )
 
-func ProcessConcurrently(workItems []types.WorkItem, numThreads int, stats *types.ConversionStats) *types.SafeWriter {
+// ProcessConcurrently converts workItems. Cancelling ctx stops new items from
+// being started, those are recorded in stats.Deferred; items already being
+// written finish unless opts.Abort is cancelled too.
+func ProcessConcurrently(ctx context.Context, workItems []types.WorkItem, opts *types.Options, stats *types.ConversionStats) *types.SafeWriter {
+    numThreads := opts.Threads
+
+    // Items flow dispatcher → queue → prefetch stage → workChan → workers.
     // Create work channel with buffer to prevent blocking
-    workChan := make(chan types.WorkItem, numThreads)
+    queue := make(chan types.WorkItem)
+    workChan := make(chan job, numThreads)
     buf := &types.SafeWriter{}
 
-    spinner := util.NewSpinner(stats, len(workItems))
-    // Print 4 blank lines so first render has space to overwrite and to make it less cluttered
-    fmt.Print("\n\n\n\n")
-    spinner.Start()
+    var spinner *util.Spinner
+    var status *util.StatusLine
+    if !opts.Quiet {
+        if progressMode(opts) == types.ProgressBar {
+            spinner = util.NewSpinner(stats, len(workItems))
+            // Print 4 blank lines so first render has space to overwrite and to make it less cluttered
+            fmt.Print("\n\n\n\n")
+            spinner.Start()
+        } else if opts.LogOutput == nil {
+            // Redirected output gets the log lines themselves, a bar would only
+            // leave escape codes in it
+            plain := *opts
+            plain.LogOutput = os.Stdout
+            opts = &plain
+            status = util.NewStatusLine(stats, len(workItems))
+            status.Start()
+        }
+    }
+
+    statusDone := make(chan struct{})
+    defer close(statusDone)
+    if opts.Status != nil || spinner != nil {
+        go reportStatus(opts, stats, len(workItems), spinner, status != nil, statusDone)
+    }
 
     // Create wait group to track completion
     var wg sync.WaitGroup
 
+    // Without low-power mode the throttle never drops below numThreads
+    gate := newThrottle(numThreads)
+    defer gate.stop()
+    if opts.LowPower {
+        go gate.monitor(numThreads, opts, buf)
+    }
+
+    // Hitting the error limit stops dispatching like a cancellation, and
+    // workers leave what was prefetched for the next run too
+    ctx, stop := context.WithCancel(ctx)
+    defer stop()
+
     // Start worker goroutines
     for i := range numThreads {
         wg.Add(1)
-        go worker(i+1, workChan, &wg, stats, buf)
+        go worker(ctx, stop, i+1, workChan, &wg, gate, opts, stats, buf)
+    }
+
+    // A spent time budget stops dispatching just like a cancellation
+    if opts.MaxDuration > 0 {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, opts.MaxDuration)
+        defer cancel()
     }
 
-    // Send work items to channel
+    startPrefetch(queue, workChan, gate, opts)
+
+    // Send work items to channel until ctx is done
     go func() {
-        defer close(workChan)
-        for _, item := range workItems {
-            workChan <- item
+        defer close(queue)
+        for i, item := range workItems {
+            // Check first, select picks randomly when both cases are ready
+            if ctx.Err() != nil {
+                deferItems(stats, workItems[i:]...)
+                return
+            }
+
+            select {
+            case queue <- item:
+            case <-ctx.Done():
+                deferItems(stats, workItems[i:]...)
+                return
+            }
         }
     }()
 
     // Wait for all workers to complete
     wg.Wait()
-    spinner.Stop()
+    if spinner != nil {
+        spinner.Stop()
+    }
+    if status != nil {
+        status.Stop()
+    }
 
     // flush buffer to disk in one shot
     // This might create problems in devices with low memory
-    // TODO: Introduce a flag to disable logging.
     // We might also want to consider a flag that writes directly to a file on disk,
     // although that could introduce slowdowns as disk is slower than memory.
-    if err := os.MkdirAll("/tmp/convert-cbz", 0755); err != nil {
-        logger.Error(fmt.Sprintf("Failed to write log file: %v", err))
-    } else {
-        logFilePath := fmt.Sprintf("/tmp/convert-cbz/%s.log", time.Now().Format("2006-01-02-1504"))
-        if err := os.WriteFile(logFilePath, buf.Buffer.Bytes(), 0644); err != nil {
-            logger.Error(fmt.Sprintf("Failed to write log file: %v", err))
-        } else {
-            fmt.Println("\033[90m  log written → " + logFilePath + "\033[0m")
-        }
+    if opts.LogFile != types.NoLogFile {
+        writeLogFile(opts, buf.Buffer.Bytes())
     }
     return buf
 }
 
-func worker(id int, workChan <-chan types.WorkItem, wg *sync.WaitGroup, stats *types.ConversionStats, buf *types.SafeWriter) {
+// writeLogFile writes the log of the run where opts.LogFile says
+func writeLogFile(opts *types.Options, log []byte) {
+    logFilePath := opts.LogFile
+    if logFilePath == "" {
+        name := opts.RunID
+        if name == "" {
+            name = fmt.Sprintf("%s-%d", time.Now().Format("2006-01-02-1504"), os.Getpid())
+        }
+        logFilePath = fmt.Sprintf("/tmp/convert-cbz/%s.log", name)
+    }
+    err := os.MkdirAll(filepath.Dir(logFilePath), 0755)
+    if err == nil {
+        err = os.WriteFile(logFilePath, log, 0644)
+    }
+    if err != nil {
+        logger.Error(fmt.Sprintf("Failed to write log file: %v", err))
+    } else if !opts.Quiet {
+        fmt.Println("\033[90m  log written → " + logFilePath + "\033[0m")
+    }
+}
+
+// progressMode resolves ProgressAuto against whether stdout is a terminal
+func progressMode(opts *types.Options) types.ProgressMode {
+    if opts.Progress != types.ProgressAuto {
+        return opts.Progress
+    }
+    if util.IsTerminal(os.Stdout) {
+        return types.ProgressBar
+    }
+    return types.ProgressPlain
+}
+
+func worker(ctx context.Context, stop context.CancelFunc, id int, workChan <-chan job, wg *sync.WaitGroup, gate *throttle, opts *types.Options, stats *types.ConversionStats, buf *types.SafeWriter) {
     defer wg.Done()
 
-    for item := range workChan {
+    for {
+        gate.wait(id, workChan)
+        j, ok := <-workChan
+        if !ok {
+            return
+        }
+
+        // Prefetched but not started yet, leave it for the next run
+        if ctx.Err() != nil {
+            deferItems(stats, j.item)
+            continue
+        }
+
         // Process single conversion job
-        processWorkItem(id, item, stats, buf)
+        processWorkItem(id, j, opts, stats, buf)
+        if errorLimitReached(opts, stats) {
+            writeLog(opts, buf, types.LogRecord{Level: "error", Msg: fmt.Sprintf("%d items failed, stopping", opts.MaxErrors)})
+            stop()
+        }
 
         // Small delay to prevent overwhelming the system
         time.Sleep(5 * time.Millisecond)
     }
 }
 
-func processWorkItem(workerID int, item types.WorkItem, stats *types.ConversionStats, buf *types.SafeWriter) {
-    prefix := fmt.Sprintf("[WORKER %d]", workerID)
-    fmt.Fprintf(buf, "[INFO] %s Processing: %s\n", prefix, item.FolderName)
+func processWorkItem(workerID int, j job, opts *types.Options, stats *types.ConversionStats, buf *types.SafeWriter) {
+    item := j.item
+    started := time.Now()
+    log := newItemLogger(opts, buf)
+    defer log.flush()
+    log.write(itemLog(workerID, item, "info", "Processing: "+item.FolderName))
+    emitItem(opts, types.EventItemStarted, workerID, item, "", nil)
 
-    // Check if output already exists
-    if _, err := os.Stat(item.OutputPath); err == nil {
-        fmt.Fprintf(buf, "[WARN] %s CBZ already exists, skipping: %s\n", prefix, filepath.Base(item.OutputPath))
+    // Every outcome changes the counters, let listeners know once we're done
+    defer emitStats(opts, stats)
+
+    // Listeners and the post-hook hear of every recorded outcome, a crash included
+    recorded := -1 // Index of the result of item once it has one
+    if opts.OnResult != nil || opts.PostHook != "" {
+        defer itemDone(workerID, item, opts, stats, log, &recorded)
+    }
+
+    // A panic fails only this item, with the stack in the log and report
+    defer func() {
+        err := recovered(recover())
+        if err == nil {
+            return
+        }
+        endProgress(stats, workerID)
+        r := finalLog(workerID, item, "error", "Conversion crashed", started)
+        r.Error = err.Error()
+        r.Stack = panicStack(err)
+        log.write(r)
+        stats.Mutex.Lock()
+        if recorded >= 0 {
+            // Crashed after the outcome was recorded, the archive is in place
+            res := &stats.Results[recorded]
+            if res.Error == "" {
+                res.Error = err.Error()
+            }
+            res.Stack = r.Stack
+        } else {
+            stats.Errors++
+            res := newResult(opts, item, types.StatusFailed, err, 0)
+            res.Stack = r.Stack
+            stats.Results = append(stats.Results, res)
+        }
+        stats.Mutex.Unlock()
+        emitItem(opts, types.EventItemFailed, workerID, item, "", err)
+    }()
+
+    skipBecause := func(msg string) {
+        log.write(finalLog(workerID, item, "warn", msg, started))
         stats.Mutex.Lock()
         stats.Skipped++
+        recorded = len(stats.Results)
+        stats.Results = append(stats.Results, newResult(opts, item, types.StatusSkipped, nil, 0))
         stats.Mutex.Unlock()
+        emitItem(opts, types.EventItemSkipped, workerID, item, "", nil)
+    }
+    skip := func(reason string) {
+        skipBecause(fmt.Sprintf("CBZ %s, skipping: %s", reason, filepath.Base(item.OutputPath)))
+    }
+
+    abort := opts.Abort
+    if abort == nil {
+        abort = context.Background()
+    }
+
+    // Extracting goes the other way, the archive is the source
+    if opts.Extract {
+        extractItem(abort, workerID, item, opts, stats, log, started, &recorded)
         return
     }
 
+    // Check if output already exists
+    _, statErr := os.Stat(item.OutputPath)
+    exists := statErr == nil
+    if exists && opts.Overwrite == types.OverwriteSkip && !opts.Update {
+        skip("already exists")
+        return
+    }
+    // A finished master is only replaced when asked to in so many words
+    sealed := exists && isSealed(item.OutputPath)
+    if sealed && !opts.Force {
+        skip("is read-only, -force replaces it")
+        return
+    }
+
+    // Select the files to archive, unless the prefetch stage already did
+    files, result, err := j.files, j.result, j.err
+    password := 0 // Number of the one that opened an encrypted archive
+    if item.Archive != "" {
+        defer os.RemoveAll(item.SourcePath)
+        password, err = extractArchive(abort, item.Archive, item.SourcePath, opts.Passwords, namesStored, nil, nil)
+    }
+    if len(item.PartArchives) > 0 {
+        defer os.RemoveAll(item.SourcePath)
+        password, err = extractParts(abort, item, opts.Passwords)
+    }
+    if password > 0 {
+        log.write(itemLog(workerID, item, "info", fmt.Sprintf("Decrypted with password %d of the list", password)))
+    }
+    if err == nil && opts.PreHook != "" {
+        err = runHook(abort, opts.PreHook, "pre", item, nil)
+    }
+    if err == nil && !j.prefetched {
+        files, result, err = selectItemFiles(item, opts)
+    }
+
+    // Empty stubs and folders of nothing but metadata aren't worth an archive
+    if opts.MinImages > 0 && (err == nil || errors.Is(err, errNoFiles)) {
+        if images := countImages(files); images < opts.MinImages {
+            skipBecause(fmt.Sprintf("Only %d images, fewer than -min-images %d, skipping: %s", images, opts.MinImages, item.FolderName))
+            return
+        }
+    }
+
+    if err == nil && len(result.UnusualFiles) > 0 {
+        log.write(itemLog(workerID, item, "warn", fmt.Sprintf("Skipped %d unusual files: %s", len(result.UnusualFiles), strings.Join(result.UnusualFiles, ", "))))
+    }
+
+    // Fingerprint the selection, it is stored in the archive comment
+    var fp string
+    if err == nil {
+        fp, err = fingerprint(files, item.SourcePath, opts.Fingerprint)
+    }
+
+    // Only rebuild or update archives whose source changed since they were built
+    if err == nil && exists && (opts.Overwrite == types.OverwriteIfDifferent || opts.Update && opts.Overwrite != types.OverwriteAlways) {
+        same, cmpErr := archiveUpToDate(item.OutputPath, item.SourcePath, files, fp)
+        if cmpErr != nil {
+            r := itemLog(workerID, item, "warn", "Could not read existing CBZ, rebuilding")
+            r.Error = cmpErr.Error()
+            log.write(r)
+        } else if same {
+            skip("is up to date")
+            return
+        }
+    }
+
     // Convert folder to CBZ
-    nonImageCount, err := convertToCBZ(item.SourcePath, item.OutputPath, item.DumbMode)
+    var names map[string]string // Entry names of renamed files
+    var upd *archiveUpdate      // Existing archive new files are added to
+    stored := files             // What goes into the archive, without dropped hard links
+    entries := 0                // Stored files and generated ones
+    if err == nil {
+        var extras []extraEntry
+        var links map[string]string
+        extras, err = metadataEntries(item, files, opts)
+        if err == nil && opts.TextFiles != types.TextKeep {
+            // The fingerprint still covers the text files, their text is in extras
+            stored, extras, err = foldTextFiles(item, files, extras, opts.TextFiles)
+        }
+        if err == nil && opts.DedupeLinks {
+            // The fingerprint still covers every file, links are recorded in the manifest
+            stored, links, err = dedupeLinks(stored)
+        }
+        if err == nil {
+            names, err = entryNames(stored, item, opts)
+        }
+        if err == nil && (names != nil || links != nil) {
+            var manifest extraEntry
+            if manifest, err = manifestEntry(files, stored, item.SourcePath, names, links); err == nil {
+                extras = append(extras, manifest)
+            }
+        }
+        if err == nil && len(links) > 0 {
+            log.write(itemLog(workerID, item, "info", fmt.Sprintf("Stored %d hard-linked duplicates once", len(links))))
+        }
+        comment := archiveComment(fp, extras)
+        if err == nil && opts.ComicBookInfo {
+            comment, err = withComicBookInfo(comment, item, files, extras, opts)
+        }
+        if err == nil && exists && opts.Update && opts.Overwrite != types.OverwriteAlways {
+            var why string
+            var upErr error
+            if upd, why, upErr = planUpdate(item.OutputPath, item.SourcePath, stored, names, opts.Reproducible); upErr != nil {
+                r := itemLog(workerID, item, "warn", "Could not read existing CBZ, rebuilding")
+                r.Error = upErr.Error()
+                log.write(r)
+            } else if upd == nil {
+                log.write(itemLog(workerID, item, "info", "Rebuilding, "+why))
+            }
+            defer upd.close()
+        }
+        if err == nil {
+            entries = len(stored) + len(extras)
+            startProgress(stats, workerID, item, entries)
+            place := placeArchive(opts, item)
+            if upd != nil {
+                // The entries are read from the old archive until it is replaced
+                replace := place
+                place = func(tmpPath string) error {
+                    upd.close()
+                    return replace(tmpPath)
+                }
+            }
+            if sealed {
+                replace := place
+                place = func(tmpPath string) error {
+                    if err := unsealArchive(item.OutputPath); err != nil {
+                        return fmt.Errorf("failed to make read-only archive writable: %w", err)
+                    }
+                    return replace(tmpPath)
+                }
+            }
+            // Nothing is deleted or sealed on the word of an archive that was never read back
+            if opts.Verify || opts.DeleteSource || opts.TrashSource || opts.WORM {
+                place = verifiedPlace(place, stored, len(extras))
+            }
+            err = writeArchive(abort, stored, extras, item.SourcePath, names, upd.reuse(), newEntryHeaders(opts.CP437Fallback, opts.Reproducible), item.OutputPath, comment, func(file string) {
+                addProgress(stats, workerID)
+                emitItem(opts, types.EventFileAdded, workerID, item, file, nil)
+            }, place)
+            endProgress(stats, workerID)
+        }
+    }
+    nonImageCount := result.Excluded
+    if errors.Is(err, errAborted) {
+        // Not a failure of the folder, the next run picks it up again
+        log.write(finalLog(workerID, item, "warn", "Aborted, partial output removed: "+filepath.Base(item.OutputPath), started))
+        deferItems(stats, item)
+        return
+    }
     if err != nil {
-        fmt.Fprintf(buf, "[ERROR] %s Conversion failed: %v\n", prefix, err)
+        err = explainNetError(err)
+        r := finalLog(workerID, item, "error", "Conversion failed", started)
+        r.Error = err.Error()
+        r.Stack = panicStack(err)
+        log.write(r)
         stats.Mutex.Lock()
         stats.Errors++
+        res := newResult(opts, item, types.StatusFailed, err, result.Excluded)
+        res.ExcludedFiles = result.ExcludedFiles
+        res.Stack = r.Stack
+        recorded = len(stats.Results)
+        stats.Results = append(stats.Results, res)
         stats.Mutex.Unlock()
+        emitItem(opts, types.EventItemFailed, workerID, item, "", err)
         return
     }
 
+    res := newResult(opts, item, types.StatusConverted, nil, nonImageCount)
+    res.Pages = result.Included
+    res.ExcludedFiles = result.ExcludedFiles
+    res.SourceBytes = totalSize(files)
+    res.Fingerprint = fp
+    res.Password = password
+    if info, err := os.Stat(item.OutputPath); err == nil {
+        res.Bytes = info.Size()
+    }
+
     // Update statistics
     stats.Mutex.Lock()
     stats.Success++
     stats.NonImageFiles += nonImageCount
+    stats.SourceBytes += res.SourceBytes
+    recorded = len(stats.Results)
+    stats.Results = append(stats.Results, res)
     stats.Mutex.Unlock()
 
-    fmt.Fprintf(buf, "[OK] %s Created: %s\n", prefix, filepath.Base(item.OutputPath))
+    if opts.WORM {
+        if err := sealArchive(item.OutputPath, opts.Immutable); err != nil {
+            r := itemLog(workerID, item, "warn", "Could not seal archive")
+            r.Error = err.Error()
+            log.write(r)
+        }
+    }
+
+    // The archive is fine without it, a missing sidecar is only worth a warning
+    if opts.Sidecar {
+        if err := writeSidecar(opts, item, stored, names, fp); err != nil {
+            r := itemLog(workerID, item, "warn", "Could not write sidecar")
+            r.Error = err.Error()
+            log.write(r)
+        }
+    }
+    if opts.OPF || opts.Calibre != nil {
+        addToCalibre(workerID, item, opts, fp, log)
+    }
+
+    switch {
+    case upd != nil:
+        log.write(finalLog(workerID, item, "ok", fmt.Sprintf("Updated: %s (%d new files)", filepath.Base(item.OutputPath), upd.added), started))
+    case exists:
+        log.write(finalLog(workerID, item, "ok", "Replaced: "+filepath.Base(item.OutputPath), started))
+    default:
+        log.write(finalLog(workerID, item, "ok", "Created: "+filepath.Base(item.OutputPath), started))
+    }
+    emitItem(opts, types.EventItemFinished, workerID, item, "", nil)
+
+    if (opts.TrashSource || opts.DeleteSource) && item.SharesSource() {
+        // A resumed run skips the checks that keep these apart up front
+        log.write(itemLog(workerID, item, "warn", "Kept source, it holds the pages of other archives too: "+item.SourcePath))
+    } else if opts.TrashSource {
+        if dest, err := trashSource(opts, item); err != nil {
+            r := itemLog(workerID, item, "warn", "Could not move source to the trash")
+            r.Error = err.Error()
+            log.write(r)
+        } else if dest != "" {
+            log.write(itemLog(workerID, item, "info", "Moved source to "+dest))
+        } else {
+            log.write(itemLog(workerID, item, "info", "Moved source to the Recycle Bin: "+item.SourcePath))
+        }
+    } else if opts.DeleteSource {
+        if kept, err := deleteSource(opts, item, files); err != nil {
+            r := itemLog(workerID, item, "warn", "Could not delete source")
+            r.Error = err.Error()
+            log.write(r)
+        } else if len(kept) > 0 {
+            log.write(itemLog(workerID, item, "warn", fmt.Sprintf("Deleted source, kept %d files the archive doesn't hold: %s", len(kept), strings.Join(kept, ", "))))
+        } else {
+            log.write(itemLog(workerID, item, "info", "Deleted source: "+item.SourcePath))
+        }
+    }
+
+    // zip.Writer switches to ZIP64 on its own, not every reader follows
+    if reason := zip64Reason(item.OutputPath, stored, entries); reason != "" {
+        log.write(itemLog(workerID, item, "warn", fmt.Sprintf("Written as ZIP64 (%s), older readers may not open it", reason)))
+    }
 
     // Report non-image files if found
-    if nonImageCount > 0 {
-        fmt.Fprintf(buf, "[WARN] %s Found %d non-image files (excluded from CBZ)\n", prefix, nonImageCount)
+    if nonImageCount > 0 && opts.ImagesOnly {
+        log.write(itemLog(workerID, item, "warn", fmt.Sprintf("Left out %d files that aren't images: %s", nonImageCount, strings.Join(result.ExcludedFiles, ", "))))
+    } else if nonImageCount > 0 {
+        log.write(itemLog(workerID, item, "warn", fmt.Sprintf("Found %d non-image files (excluded from CBZ)", nonImageCount)))
     }
+
+    if result.Flagged {
+        log.write(itemLog(workerID, item, "warn", fmt.Sprintf("FLAGGED %s: smart mode excluded %d of %d files (%.0f%%), check the filter didn't drop real pages",
+            item.FolderName, result.Excluded, result.Scanned(), result.ExcludedPct())))
+        stats.Mutex.Lock()
+        stats.Flagged = append(stats.Flagged, types.FlaggedItem{
+            FolderName: item.FolderName,
+            Excluded:   result.Excluded,
+            Scanned:    result.Scanned(),
+        })
+        stats.Mutex.Unlock()
+    }
+}
+
+// totalSize adds up the sizes of files, skipping any that vanished
+func totalSize(files []string) int64 {
+    var total int64
+    for _, f := range files {
+        if info, err := os.Stat(f); err == nil {
+            total += info.Size()
+        }
+    }
+    return total
+}
+
+// startProgress marks the archive of item as being written by workerID
+func startProgress(stats *types.ConversionStats, workerID int, item types.WorkItem, total int) {
+    stats.Mutex.Lock()
+    defer stats.Mutex.Unlock()
+    if stats.Active == nil {
+        stats.Active = make(map[int]types.ItemProgress)
+    }
+    stats.Active[workerID] = types.ItemProgress{Worker: workerID, FolderName: item.FolderName, Total: total}
+}
+
+// addProgress counts one more file written by workerID
+func addProgress(stats *types.ConversionStats, workerID int) {
+    stats.Mutex.Lock()
+    defer stats.Mutex.Unlock()
+    if p, ok := stats.Active[workerID]; ok {
+        p.Added++
+        stats.Active[workerID] = p
+    }
+}
+
+func endProgress(stats *types.ConversionStats, workerID int) {
+    stats.Mutex.Lock()
+    delete(stats.Active, workerID)
+    stats.Mutex.Unlock()
+}
+
+// errorLimitReached reports whether opts.MaxErrors items have failed, the
+// first time it does only
+func errorLimitReached(opts *types.Options, stats *types.ConversionStats) bool {
+    if opts.MaxErrors <= 0 {
+        return false
+    }
+    stats.Mutex.Lock()
+    defer stats.Mutex.Unlock()
+    if stats.ErrorLimit || stats.Errors < opts.MaxErrors {
+        return false
+    }
+    stats.ErrorLimit = true
+    return true
+}
+
+// deferItems records items that were never converted so a checkpoint can pick them up
+func deferItems(stats *types.ConversionStats, items ...types.WorkItem) {
+    stats.Mutex.Lock()
+    stats.Deferred = append(stats.Deferred, items...)
+    stats.Mutex.Unlock()
+}
+
+func newResult(opts *types.Options, item types.WorkItem, status types.ItemStatus, err error, excluded int) types.ItemResult {
+    r := types.ItemResult{
+        FolderName: item.FolderName,
+        SourcePath: item.SourcePath,
+        OutputPath: item.OutputPath,
+        Status:     status,
+        Excluded:   excluded,
+    }
+    if item.Archive != "" {
+        r.SourcePath = item.Archive
+    } else if len(item.PartArchives) > 0 {
+        // The parts are unpacked into scratch folders, the archives are what
+        // the user knows
+        r.SourcePath = filepath.Dir(item.PartArchives[0])
+    }
+    if err != nil {
+        r.Error = err.Error()
+    }
+    if _, info, ok := lookupSeries(item, opts); ok {
+        r.SeriesStatus = info.Status
+    }
+    return r
+}
+
+// archiveResult summarises the file selection that went into an archive
+type archiveResult struct {
+    Included int
+    Excluded int
+    Flagged  bool // Excluded share went over the configured threshold
+
+    ExcludedFiles []string // Relative to the source folder
+    UnusualFiles  []string // Skipped fifos, sockets, device nodes and empty files, with what they are
+}
+
+func (r archiveResult) Scanned() int {
+    return r.Included + r.Excluded
+}
+
+func (r archiveResult) ExcludedPct() float64 {
+    if r.Scanned() == 0 {
+        return 0
+    }
+    return float64(r.Excluded) / float64(r.Scanned()) * 100
+}
+
+// FlaggedItems selects the files of every item the way a run would, with
+// opts.Threads at once, and returns the ones smart mode flags by their index
+// in items. Nothing is archived; items that fail or still have to be
+// unpacked are left for the run to report.
+func FlaggedItems(items []types.WorkItem, opts *types.Options) map[int]types.FlaggedItem {
+    check := *opts
+    check.Strict = false
+    flagged := make(map[int]types.FlaggedItem)
+    var mu sync.Mutex
+    var wg sync.WaitGroup
+    next := make(chan int)
+    for range max(check.Threads, 1) {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := range next {
+                item := items[i]
+                if item.Archive != "" || len(item.PartArchives) > 0 {
+                    continue
+                }
+                _, result, err := selectItemFiles(item, &check)
+                if err != nil || !result.Flagged {
+                    continue
+                }
+                mu.Lock()
+                flagged[i] = types.FlaggedItem{FolderName: item.FolderName, Excluded: result.Excluded, Scanned: result.Scanned()}
+                mu.Unlock()
+            }
+        }()
+    }
+    for i := range items {
+        next <- i
+    }
+    close(next)
+    wg.Wait()
+    return flagged
 }
 
-func convertToCBZ(sourceDir, cbzPath string, dumbMode bool) (int, error) {
+// selectFiles picks the files of sourceDir that go into the archive
+func selectFiles(sourceDir string, dumbMode bool, opts *types.Options) ([]string, archiveResult, error) {
+    return selectFilesWhere(sourceDir, dumbMode, opts, nil)
+}
+
+// selectFilesWhere is selectFiles for the files of sourceDir whose name keep
+// accepts, the others are left out as if they weren't there. A nil keep
+// accepts them all.
+func selectFilesWhere(sourceDir string, dumbMode bool, opts *types.Options, keep func(name string) bool) ([]string, archiveResult, error) {
     var includeFiles []string
-    var excludedCount int
+    var excludedFiles []string
+    var unusualFiles []string
 
+    rules, err := ignore.ForFolder(sourceDir, opts.Roots)
+    if err != nil {
+        return nil, archiveResult{}, fmt.Errorf("failed to read %s: %w", ignore.FileName, err)
+    }
     if dumbMode {
         // DUMB MODE: Include all files without any filtering
-        files, err := getAllFiles(sourceDir)
+        files, excluded, unusual, err := getAllFiles(sourceDir, opts.ExcludeDirs, rules, opts.ExcludeExt)
         if err != nil {
-            return 0, fmt.Errorf("failed to scan directory: %w", err)
+            return nil, archiveResult{}, fmt.Errorf("failed to scan directory: %w", err)
         }
-        includeFiles = files
-        excludedCount = 0
+        includeFiles, excludedFiles, unusualFiles = files, excluded, unusual
     } else {
         // SMART MODE: Intelligently filter files
-        var err error
-        includeFiles, excludedCount, err = getSmartFilteredFiles(sourceDir)
+        includeFiles, excludedFiles, unusualFiles, err = getSmartFilteredFiles(sourceDir, opts.ExcludeDirs, rules, opts.IncludeExt, opts.ExcludeExt)
         if err != nil {
-            return 0, fmt.Errorf("failed to analyze directory: %w", err)
+            return nil, archiveResult{}, fmt.Errorf("failed to analyze directory: %w", err)
+        }
+    }
+
+    if keep != nil {
+        includeFiles = slices.DeleteFunc(includeFiles, func(f string) bool { return !keep(filepath.Base(f)) })
+        excludedFiles = slices.DeleteFunc(excludedFiles, func(f string) bool { return !keep(path.Base(f)) })
+        unusualFiles = slices.DeleteFunc(unusualFiles, func(f string) bool {
+            name, _, _ := strings.Cut(path.Base(f), " (")
+            return !keep(name)
+        })
+    }
+
+    // Readers show whatever an archive holds as a page, broken or not. Text
+    // folded into the ComicInfo.xml notes never becomes one.
+    if opts.ImagesOnly {
+        var images []string
+        for _, f := range includeFiles {
+            image, err := isImageFile(f)
+            if err != nil {
+                return nil, archiveResult{}, fmt.Errorf("failed to read %s: %w", filepath.Base(f), err)
+            }
+            if image || (opts.TextFiles == types.TextNotes && isTextFile(f)) {
+                images = append(images, f)
+                continue
+            }
+            rel, _ := filepath.Rel(sourceDir, f)
+            excludedFiles = append(excludedFiles, filepath.ToSlash(rel))
+        }
+        includeFiles = images
+        sort.Strings(excludedFiles)
+    }
+
+    // Walks come back in byte order, strict readers show pages in archive order
+    if opts.Sort == types.SortNatural {
+        sort.SliceStable(includeFiles, func(i, j int) bool { return util.NaturalLess(includeFiles[i], includeFiles[j]) })
+    }
+
+    result := archiveResult{Included: len(includeFiles), Excluded: len(excludedFiles), ExcludedFiles: excludedFiles, UnusualFiles: unusualFiles}
+
+    // Even dumb mode can't archive a fifo, strict mode wants to hear about them
+    if len(unusualFiles) > 0 && opts.Strict {
+        return nil, result, fmt.Errorf("unusual files in folder: %s", strings.Join(unusualFiles, ", "))
+    }
+
+    // Excluding most of a folder usually means the heuristics misread it
+    if !dumbMode && opts.ExcludeThreshold > 0 && result.ExcludedPct() > opts.ExcludeThreshold {
+        if opts.Strict {
+            return nil, result, fmt.Errorf("smart mode excluded %.0f%% of files (threshold %.0f%%)", result.ExcludedPct(), opts.ExcludeThreshold)
         }
+        result.Flagged = true
+    }
+
+    // Tens of thousands of pages are a whole library, not a chapter. Pointed one
+    // level too high, the folder would take hours to archive into one file.
+    if opts.MaxEntries > 0 && len(includeFiles) > opts.MaxEntries {
+        return nil, result, fmt.Errorf("folder has %d files, more than -max-entries %d, is the input one level too high?", len(includeFiles), opts.MaxEntries)
     }
 
     if len(includeFiles) == 0 {
-        return 0, fmt.Errorf("no files found to archive")
+        return nil, result, errNoFiles
     }
+    return orderCover(includeFiles, sourceDir, opts.Cover), result, nil
+}
+
+// errNoFiles is returned by selectFiles for a folder with nothing to archive
+var errNoFiles = errors.New("no files found to archive")
+
+// countImages is the number of files named like images
+func countImages(files []string) int {
+    n := 0
+    for _, f := range files {
+        if imageExtensions[strings.ToLower(filepath.Ext(f))] {
+            n++
+        }
+    }
+    return n
+}
+
+// errAborted is returned by writeArchive when its context is cancelled midway
+var errAborted = errors.New("aborted")
 
-    // Create CBZ file (which is just a ZIP with .cbz extension)
-    cbzFile, err := os.Create(cbzPath)
+// writeArchive archives files from sourceDir into cbzPath with the given zip
+// comment, calling added with the archive-relative name of every file once it
+// has been written. extras are added after the source files. Files whose
+// entry name is in reuse are copied from there instead of read from disk.
+// Cancelling ctx abandons the archive. place moves the finished temp file to
+// cbzPath.
+func writeArchive(ctx context.Context, files []string, extras []extraEntry, sourceDir string, names map[string]string, reuse map[string]*zip.File, headers *entryHeaders, cbzPath, comment string, added func(string), place func(tmpPath string) error) (err error) {
+    // Build next to the destination and rename into place, so a failure never
+    // leaves a half-written archive behind or destroys the one being replaced.
+    // The random temp name keeps concurrent runs from writing the same file.
+    // Name templates can put archives in subdirectories of the output
+    if err := os.MkdirAll(filepath.Dir(cbzPath), 0755); err != nil {
+        return fmt.Errorf("failed to create CBZ file: %w", err)
+    }
+    cbzFile, err := os.CreateTemp(filepath.Dir(cbzPath), "."+filepath.Base(cbzPath)+".*.tmp")
     if err != nil {
-        return 0, fmt.Errorf("failed to create CBZ file: %w", err)
+        return fmt.Errorf("failed to create CBZ file: %w", err)
     }
-    defer cbzFile.Close()
+    tmpPath := cbzFile.Name()
+    placed := false
+    defer func() {
+        // Also when a panic unwinds through here, err isn't set then
+        if !placed {
+            cbzFile.Close()
+            os.Remove(tmpPath)
+        }
+    }()
 
     // Create ZIP writer with compression
     zipWriter := zip.NewWriter(cbzFile)
-    defer zipWriter.Close()
 
     // Add all selected files to the ZIP archive
-    for _, filePath := range includeFiles {
-        if err := addFileToZip(zipWriter, filePath, sourceDir); err != nil {
-            return 0, fmt.Errorf("failed to add file to archive: %w", err)
+    for _, filePath := range files {
+        name, err := entryName(filePath, sourceDir, names)
+        if err != nil {
+            return fmt.Errorf("failed to add file to archive: %w", err)
         }
+        if f, ok := reuse[name]; ok {
+            if ctx.Err() != nil {
+                return errAborted
+            }
+            if err := zipWriter.Copy(f); err != nil {
+                return fmt.Errorf("failed to copy %s from the existing archive: %w", name, err)
+            }
+        } else if err := addFileToZip(ctx, zipWriter, headers, filePath, name); err != nil {
+            if ctx.Err() != nil {
+                return errAborted
+            }
+            return fmt.Errorf("failed to add file to archive: %w", err)
+        }
+        added(name)
     }
 
-    return excludedCount, nil
+    for _, e := range extras {
+        if err := addBytesToZip(zipWriter, headers, e); err != nil {
+            return fmt.Errorf("failed to add %s to archive: %w", e.name, err)
+        }
+        added(e.name)
+    }
+
+    if err := zipWriter.SetComment(comment); err != nil {
+        return fmt.Errorf("failed to set archive comment: %w", err)
+    }
+
+    // Closing writes the central directory, an error here means a broken archive
+    if err := zipWriter.Close(); err != nil {
+        return fmt.Errorf("failed to finish archive: %w", err)
+    }
+    // CreateTemp makes files only the owner can read
+    if err := cbzFile.Chmod(0644); err != nil {
+        return fmt.Errorf("failed to finish archive: %w", err)
+    }
+    if err := cbzFile.Close(); err != nil {
+        return fmt.Errorf("failed to finish archive: %w", err)
+    }
+    if err := place(tmpPath); err != nil {
+        var bad *verifyError
+        if errors.As(err, &bad) {
+            return err
+        }
+        return fmt.Errorf("failed to move archive into place: %w", err)
+    }
+    placed = true
+    return nil
+}
+
+// placeArchive returns how the finished archive of item is moved into place:
+// journaled first if the run keeps a journal, and with the archive it
+// replaces kept aside if asked to
+func placeArchive(opts *types.Options, item types.WorkItem) func(string) error {
+    return placeFile(opts, item, item.OutputPath)
+}
+
+// placeFile is placeArchive for any file written for item, like its sidecar
+func placeFile(opts *types.Options, item types.WorkItem, dest string) func(string) error {
+    return func(tmpPath string) error {
+        if opts.Journal == nil {
+            return moveIntoPlace(tmpPath, dest)
+        }
+
+        step := types.JournalStep{Kind: types.StepCreate, From: dest, Source: item.SourcePath}
+        if _, err := os.Stat(dest); err == nil {
+            step.Kind = types.StepRewrite
+            if opts.KeepReplaced {
+                step.Backup = types.BackupPath(dest, opts.RunID)
+            }
+        }
+        if err := opts.Journal(step); err != nil {
+            return fmt.Errorf("failed to write journal: %w", err)
+        }
+        if step.Backup != "" {
+            if err := retryRename(dest, step.Backup); err != nil {
+                return explainNetError(err)
+            }
+        }
+        return moveIntoPlace(tmpPath, dest)
+    }
 }
 
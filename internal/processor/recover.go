@@ -0,0 +1,35 @@
+package processor
+
+import (
+    "fmt"
+    "runtime/debug"
+)
+
+// panicError is a panic recovered while working on one item, a bug in a
+// decoder or in here shouldn't take the rest of the run down with it
+type panicError struct {
+    value any
+    stack []byte // Of the goroutine that panicked
+}
+
+func (e *panicError) Error() string {
+    return fmt.Sprintf("panic: %v", e.value)
+}
+
+// recovered turns the value of recover() into a panicError, nil when nothing
+// panicked. Use it as recovered(recover()) in the deferred function, recover
+// does nothing anywhere else.
+func recovered(v any) error {
+    if v == nil {
+        return nil
+    }
+    return &panicError{value: v, stack: debug.Stack()}
+}
+
+// panicStack is the stack captured with err, empty unless it is a panic
+func panicStack(err error) string {
+    if p, ok := err.(*panicError); ok {
+        return string(p.stack)
+    }
+    return ""
+}
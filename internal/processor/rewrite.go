@@ -0,0 +1,146 @@
+package processor
+
+import (
+    "archive/zip"
+    "convert_cbz/internal/comicinfo"
+    "convert_cbz/internal/types"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// ArchiveInfo is what InspectArchive finds out about an existing archive
+type ArchiveInfo struct {
+    HasComicInfo bool
+    WrongMethod  bool // Some entry isn't stored the way the compression mode asks for
+}
+
+// InspectArchive checks an archive against the current conventions
+func InspectArchive(cbzPath string) (ArchiveInfo, error) {
+    reader, err := zip.OpenReader(cbzPath)
+    if err != nil {
+        return ArchiveInfo{}, err
+    }
+    defer reader.Close()
+
+    var info ArchiveInfo
+    want := wantedMethod()
+    for _, f := range reader.File {
+        if strings.EqualFold(f.Name, comicinfo.FileName) {
+            info.HasComicInfo = true
+        }
+        if !f.FileInfo().IsDir() && f.Method != want {
+            info.WrongMethod = true
+        }
+    }
+    return info, nil
+}
+
+// wantedMethod is the zip method setMethod gives entries
+func wantedMethod() uint16 {
+    if getCompression() == types.CMNone {
+        return zip.Store
+    }
+    return zip.Deflate
+}
+
+// Rewrite is what RewriteArchive changes about an archive
+type Rewrite struct {
+    Recompress bool   // Write every entry with the configured compression mode
+    ComicInfo  []byte // Added as a generated ComicInfo.xml when set
+}
+
+// RewriteArchive writes a changed copy of cbzPath to a temp file next to it
+// and returns the temp file's path. Entries that keep their method are copied
+// without being decompressed.
+func RewriteArchive(cbzPath string, rw Rewrite) (tmpPath string, err error) {
+    reader, err := zip.OpenReader(cbzPath)
+    if err != nil {
+        return "", err
+    }
+    defer reader.Close()
+
+    out, err := os.CreateTemp(filepath.Dir(cbzPath), "."+filepath.Base(cbzPath)+".*.tmp")
+    if err != nil {
+        return "", fmt.Errorf("failed to create CBZ file: %w", err)
+    }
+    tmpPath = out.Name()
+    defer func() {
+        if err != nil {
+            out.Close()
+            os.Remove(tmpPath)
+        }
+    }()
+
+    zipWriter := zip.NewWriter(out)
+    for _, f := range reader.File {
+        if err := copyEntry(zipWriter, f, rw.Recompress); err != nil {
+            return "", fmt.Errorf("failed to copy %s: %w", f.Name, err)
+        }
+    }
+
+    comment := reader.Comment
+    if rw.ComicInfo != nil {
+        if err := addBytesToZip(zipWriter, newEntryHeaders(false, false), extraEntry{name: comicinfo.FileName, data: rw.ComicInfo}); err != nil {
+            return "", fmt.Errorf("failed to add %s to archive: %w", comicinfo.FileName, err)
+        }
+        comment = markGenerated(comment, comicinfo.FileName)
+    }
+    if err := zipWriter.SetComment(comment); err != nil {
+        return "", fmt.Errorf("failed to set archive comment: %w", err)
+    }
+
+    if err := zipWriter.Close(); err != nil {
+        return "", fmt.Errorf("failed to finish archive: %w", err)
+    }
+    if err := out.Chmod(0644); err != nil {
+        return "", fmt.Errorf("failed to finish archive: %w", err)
+    }
+    if err := out.Close(); err != nil {
+        return "", fmt.Errorf("failed to finish archive: %w", err)
+    }
+    return tmpPath, nil
+}
+
+func copyEntry(zipWriter *zip.Writer, f *zip.File, recompress bool) error {
+    if !recompress || f.FileInfo().IsDir() || f.Method == wantedMethod() {
+        return zipWriter.Copy(f)
+    }
+
+    header := &zip.FileHeader{Name: f.Name, Modified: f.Modified, Comment: f.Comment}
+    header.NonUTF8 = f.NonUTF8
+    header.Flags = f.Flags & utf8Flag
+    header.Extra = unicodePathFields(f.Extra)
+    header.SetMode(f.Mode())
+    setMethod(zipWriter, header)
+
+    rc, err := f.Open()
+    if err != nil {
+        return err
+    }
+    defer rc.Close()
+    writer, err := zipWriter.CreateHeader(header)
+    if err != nil {
+        return err
+    }
+    _, err = io.Copy(writer, rc)
+    return err
+}
+
+// markGenerated adds name to the generated entries listed in comment
+func markGenerated(comment, name string) string {
+    own := ownComment(comment)
+    lines := strings.Split(own, "\n")
+    for i, line := range lines {
+        if list, ok := strings.CutPrefix(strings.TrimSpace(line), generatedKey); ok {
+            lines[i] = generatedKey + list + "," + name
+            return withOwnComment(comment, strings.Join(lines, "\n"))
+        }
+    }
+    if own == "" {
+        return withOwnComment(comment, generatedKey+name)
+    }
+    return withOwnComment(comment, own+"\n"+generatedKey+name)
+}
@@ -0,0 +1,157 @@
+package processor
+
+import (
+    "bytes"
+    "convert_cbz/internal/types"
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/hex"
+    "encoding/json"
+    "image"
+    _ "image/gif"
+    _ "image/jpeg"
+    _ "image/png"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// Sidecar describes the pages of an archive, written next to it so tools can
+// work with them without opening and decoding the archive
+type Sidecar struct {
+    Fingerprint string        `json:"fingerprint"` // As in the archive comment
+    Pages       []SidecarPage `json:"pages"`
+}
+
+// SidecarPage is one image of the archive, in reading order. Width, height
+// and format are left out for images whose header can't be read.
+type SidecarPage struct {
+    Name     string `json:"name"`
+    Original string `json:"original,omitempty"` // Source name, with -rename-pages
+    Size     int64  `json:"size"`
+    Width    int    `json:"width,omitempty"`
+    Height   int    `json:"height,omitempty"`
+    Format   string `json:"format,omitempty"`
+    Hash     string `json:"hash"` // sha256 of the raw image bytes, as equal compares them
+}
+
+// writeSidecar writes the sidecar of item's archive, built from the files it
+// was just built from, and places it like the archive itself
+func writeSidecar(opts *types.Options, item types.WorkItem, files []string, names map[string]string, fp string) error {
+    sc := Sidecar{Fingerprint: fp, Pages: []SidecarPage{}}
+    for _, f := range files {
+        if !imageExtensions[strings.ToLower(filepath.Ext(f))] {
+            continue
+        }
+        page, err := describePage(f)
+        if err != nil {
+            return err
+        }
+        if page.Name, err = entryName(f, item.SourcePath, names); err != nil {
+            return err
+        }
+        if _, renamed := names[f]; renamed {
+            rel, _ := filepath.Rel(item.SourcePath, f)
+            page.Original = filepath.ToSlash(rel)
+        }
+        sc.Pages = append(sc.Pages, page)
+    }
+
+    data, err := json.MarshalIndent(sc, "", "  ")
+    if err != nil {
+        return err
+    }
+
+    return writeBeside(opts, item, item.OutputPath+types.SidecarExt, append(data, '\n'))
+}
+
+// writeBeside writes data to dest next to item's archive, placed and
+// journaled like the archive itself
+func writeBeside(opts *types.Options, item types.WorkItem, dest string, data []byte) error {
+    tmp, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".*.tmp")
+    if err != nil {
+        return err
+    }
+    _, err = tmp.Write(data)
+    if err == nil {
+        // CreateTemp makes files only the owner can read
+        err = tmp.Chmod(0644)
+    }
+    if closeErr := tmp.Close(); err == nil {
+        err = closeErr
+    }
+    if err == nil {
+        err = placeFile(opts, item, dest)(tmp.Name())
+    }
+    if err != nil {
+        os.Remove(tmp.Name())
+    }
+    return err
+}
+
+// describePage hashes an image and reads its dimensions from the header
+func describePage(path string) (SidecarPage, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return SidecarPage{}, err
+    }
+    defer f.Close()
+
+    info, err := f.Stat()
+    if err != nil {
+        return SidecarPage{}, err
+    }
+    page := SidecarPage{Size: info.Size()}
+
+    h := sha256.New()
+    head := make([]byte, 32)
+    n, err := io.ReadFull(f, head)
+    if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+        return SidecarPage{}, err
+    }
+    head = head[:n]
+    h.Write(head)
+    rest := io.TeeReader(f, h)
+
+    // The standard library has no WebP decoder, its header is simple enough
+    if w, ht, ok := webpSize(head); ok {
+        page.Width, page.Height, page.Format = w, ht, "webp"
+    } else if cfg, format, err := image.DecodeConfig(io.MultiReader(bytes.NewReader(head), rest)); err == nil {
+        page.Width, page.Height, page.Format = cfg.Width, cfg.Height, format
+    }
+
+    // Whatever the header decoder didn't read still has to be hashed
+    if _, err := io.Copy(io.Discard, rest); err != nil {
+        return SidecarPage{}, err
+    }
+    page.Hash = hex.EncodeToString(h.Sum(nil))
+    return page, nil
+}
+
+// webpSize reads the canvas size from the first 30 bytes of a WebP file
+func webpSize(head []byte) (int, int, bool) {
+    if len(head) < 30 || string(head[0:4]) != "RIFF" || string(head[8:12]) != "WEBP" {
+        return 0, 0, false
+    }
+    le24 := func(b []byte) int { return int(b[0]) | int(b[1])<<8 | int(b[2])<<16 }
+
+    switch string(head[12:16]) {
+    case "VP8X":
+        return le24(head[24:27]) + 1, le24(head[27:30]) + 1, true
+    case "VP8 ":
+        // Lossy: frame tag, start code, then 14 bit width and height
+        if head[23] != 0x9d || head[24] != 0x01 || head[25] != 0x2a {
+            return 0, 0, false
+        }
+        return int(binary.LittleEndian.Uint16(head[26:28]) & 0x3fff), int(binary.LittleEndian.Uint16(head[28:30]) & 0x3fff), true
+    case "VP8L":
+        // Lossless: signature byte, then width-1 and height-1 in 14 bits each
+        if head[20] != 0x2f {
+            return 0, 0, false
+        }
+        bits := binary.LittleEndian.Uint32(head[21:25])
+        return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, true
+    }
+    return 0, 0, false
+}
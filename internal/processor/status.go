@@ -0,0 +1,58 @@
+package processor
+
+import (
+    "bufio"
+    "convert_cbz/internal/types"
+    "convert_cbz/internal/util"
+    "fmt"
+    "os"
+    "time"
+)
+
+// reportStatus prints a status report every time opts.Status fires until
+// done is closed. While the bar is on a terminal, Enter asks for one too.
+func reportStatus(opts *types.Options, stats *types.ConversionStats, total int, spinner *util.Spinner, plain bool, done <-chan struct{}) {
+    start := time.Now()
+
+    var enter chan struct{}
+    if spinner != nil && util.IsTerminal(os.Stdin) {
+        enter = make(chan struct{})
+        // Blocked on the terminal until the process exits, nothing reads
+        // it after a run
+        go func() {
+            reader := bufio.NewReader(os.Stdin)
+            for {
+                if _, err := reader.ReadString('\n'); err != nil {
+                    return
+                }
+                select {
+                case enter <- struct{}{}:
+                case <-done:
+                    return
+                }
+            }
+        }()
+    }
+
+    for {
+        echoed := false
+        select {
+        case <-done:
+            return
+        case <-opts.Status:
+        case <-enter:
+            echoed = true
+        }
+
+        text := util.StatusReport(stats, total, time.Since(start))
+        switch {
+        case spinner != nil:
+            spinner.Report(text, echoed)
+        case plain:
+            fmt.Println(text)
+        default:
+            // stdout is the JSON stream or belongs to a server
+            fmt.Fprintln(os.Stderr, text)
+        }
+    }
+}
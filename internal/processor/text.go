@@ -0,0 +1,96 @@
+package processor
+
+import (
+    "bytes"
+    "convert_cbz/internal/comicinfo"
+    "convert_cbz/internal/types"
+    "os"
+    "path/filepath"
+    "slices"
+    "strings"
+)
+
+// infoName is the file -text-files merge combines the text files of a folder into
+const infoName = "info.txt"
+
+// foldTextFiles takes the text files out of files as mode asks, returning the
+// files still stored and extras with their text added: as a single info.txt,
+// or in the Notes of the ComicInfo.xml. That is the generated one, the
+// folder's own one rewritten, or one holding only the notes.
+func foldTextFiles(item types.WorkItem, files []string, extras []extraEntry, mode types.TextMode) ([]string, []extraEntry, error) {
+    var texts, stored []string
+    for _, f := range files {
+        if isTextFile(f) {
+            texts = append(texts, f)
+        } else {
+            stored = append(stored, f)
+        }
+    }
+    if mode == types.TextKeep || len(texts) == 0 {
+        return files, extras, nil
+    }
+
+    text, err := joinTexts(item.SourcePath, texts)
+    if err != nil {
+        return nil, nil, err
+    }
+    if mode == types.TextMerge {
+        return stored, append(extras, extraEntry{name: infoName, data: []byte(text + "\n")}), nil
+    }
+
+    ci := &comicinfo.ComicInfo{}
+    generated := slices.IndexFunc(extras, func(e extraEntry) bool { return e.name == comicinfo.FileName })
+    own := slices.IndexFunc(stored, func(f string) bool {
+        return filepath.Dir(f) == filepath.Clean(item.SourcePath) && strings.EqualFold(filepath.Base(f), comicinfo.FileName)
+    })
+    switch {
+    case generated >= 0:
+        ci, err = comicinfo.Unmarshal(extras[generated].data)
+    case own >= 0:
+        var data []byte
+        if data, err = os.ReadFile(stored[own]); err == nil {
+            ci, err = comicinfo.Unmarshal(data)
+        }
+        stored = slices.Delete(stored, own, own+1)
+    }
+    if err != nil {
+        return nil, nil, err
+    }
+
+    if ci.Notes != "" {
+        ci.Notes += "\n\n"
+    }
+    ci.Notes += text
+    data, err := ci.Marshal()
+    if err != nil {
+        return nil, nil, err
+    }
+    if generated >= 0 {
+        extras[generated].data = data
+        return stored, extras, nil
+    }
+    return stored, append(extras, extraEntry{name: comicinfo.FileName, data: data}), nil
+}
+
+// joinTexts reads texts one after the other, each under its name relative
+// to sourceDir when there are several
+func joinTexts(sourceDir string, texts []string) (string, error) {
+    var parts []string
+    for _, f := range texts {
+        data, err := os.ReadFile(f)
+        if err != nil {
+            return "", err
+        }
+        data = bytes.TrimPrefix(data, []byte("\uFEFF"))
+        text := strings.TrimSpace(strings.ReplaceAll(string(data), "\r\n", "\n"))
+        if len(texts) > 1 {
+            rel, err := filepath.Rel(sourceDir, f)
+            if err != nil {
+                return "", err
+            }
+            text = "== " + filepath.ToSlash(rel) + " ==\n" + text
+        }
+        parts = append(parts, text)
+    }
+    return strings.Join(parts, "\n\n"), nil
+}
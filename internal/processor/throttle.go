@@ -0,0 +1,100 @@
+package processor
+
+import (
+    "convert_cbz/internal/power"
+    "convert_cbz/internal/types"
+    "fmt"
+    "sync/atomic"
+    "time"
+)
+
+const (
+    powerPollInterval = 10 * time.Second
+    warmTemperature   = 75.0 // °C, halve the workers again
+    hotTemperature    = 85.0 // °C, drop to a single worker
+)
+
+// throttle lets only the first `allowed` workers pick up new items. Workers
+// above the limit finish what they're doing and then wait.
+type throttle struct {
+    allowed    atomic.Int32
+    dispatched chan struct{} // Closed once every item has been queued
+    done       chan struct{}
+}
+
+func newThrottle(threads int) *throttle {
+    t := &throttle{
+        dispatched: make(chan struct{}),
+        done:       make(chan struct{}),
+    }
+    t.allowed.Store(int32(threads))
+    return t
+}
+
+// wait blocks worker id until it is allowed to take more work, or until
+// there is nothing left for it to take
+func (t *throttle) wait(id int, workChan <-chan job) {
+    for int32(id) > t.allowed.Load() {
+        // Let paused workers see the closed channel, or the run never ends
+        select {
+        case <-t.dispatched:
+            if len(workChan) == 0 {
+                return
+            }
+        default:
+        }
+
+        select {
+        case <-t.done:
+            return
+        case <-time.After(500 * time.Millisecond):
+        }
+    }
+}
+
+func (t *throttle) dispatchDone() {
+    close(t.dispatched)
+}
+
+func (t *throttle) stop() {
+    close(t.done)
+}
+
+// monitor adjusts the allowed worker count from the power status until stopped
+func (t *throttle) monitor(threads int, opts *types.Options, buf *types.SafeWriter) {
+    apply := func() {
+        st := power.Read()
+        allowed := threads
+        reason := "on mains power"
+        if st.OnBattery {
+            allowed = max(threads/2, 1)
+            reason = "on battery"
+        }
+        if st.Temperature >= hotTemperature {
+            allowed = 1
+            reason = fmt.Sprintf("CPU at %.0f°C", st.Temperature)
+        } else if st.Temperature >= warmTemperature {
+            allowed = max(allowed/2, 1)
+            reason = fmt.Sprintf("CPU at %.0f°C", st.Temperature)
+        }
+
+        if old := t.allowed.Swap(int32(allowed)); old != int32(allowed) {
+            writeLog(opts, buf, types.LogRecord{
+                Level: "info",
+                Msg:   fmt.Sprintf("Low-power: %s, using %d of %d workers", reason, allowed, threads),
+            })
+        }
+    }
+
+    apply()
+    ticker := time.NewTicker(powerPollInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-t.done:
+            return
+        case <-ticker.C:
+            apply()
+        }
+    }
+}
@@ -0,0 +1,143 @@
+package processor
+
+import (
+    "archive/zip"
+    "encoding/binary"
+    "hash/crc32"
+    "path"
+    "strconv"
+    "strings"
+    "unicode/utf8"
+
+    "golang.org/x/text/encoding/charmap"
+    "golang.org/x/text/unicode/norm"
+)
+
+// Info-ZIP Unicode Path extra field: the UTF-8 name of an entry, for readers
+// that don't trust (or don't know) the UTF-8 flag
+const (
+    unicodePathID      = 0x7075
+    unicodePathVersion = 1
+    utf8Flag           = 0x800
+)
+
+// setName encodes the name of an entry. Names that aren't plain ASCII always
+// get the UTF-8 flag and a Unicode Path extra field. With cp437 the header
+// name itself is a CP437 transliteration, which is what readers that predate
+// the flag show, and the real name lives only in the extra field.
+func (eh *entryHeaders) setName(header *zip.FileHeader, name string) {
+    header.Name = name
+    if isASCII(name) {
+        eh.used[name] = true
+        return
+    }
+    // There's no telling which encoding a name that isn't UTF-8 is in, so
+    // store the bytes as they are and let the reader guess
+    if !utf8.ValidString(name) {
+        header.NonUTF8 = true
+        eh.used[name] = true
+        return
+    }
+
+    if eh.cp437 {
+        header.Name = eh.unique(cp437Name(name))
+        header.NonUTF8 = true
+    } else {
+        header.NonUTF8 = false
+        header.Flags |= utf8Flag
+    }
+    header.Extra = append(header.Extra, unicodePathExtra(header.Name, name)...)
+}
+
+// unique keeps transliterations from colliding, "ページ.png" and "ガイド.png"
+// both come out as "___.png"
+func (eh *entryHeaders) unique(name string) string {
+    if !eh.used[name] {
+        eh.used[name] = true
+        return name
+    }
+    ext := path.Ext(name)
+    base := strings.TrimSuffix(name, ext)
+    for i := 2; ; i++ {
+        candidate := base + "~" + strconv.Itoa(i) + ext
+        if !eh.used[candidate] {
+            eh.used[candidate] = true
+            return candidate
+        }
+    }
+}
+
+func unicodePathExtra(headerName, name string) []byte {
+    field := make([]byte, 4, 9+len(name))
+    binary.LittleEndian.PutUint16(field[0:], unicodePathID)
+    binary.LittleEndian.PutUint16(field[2:], uint16(5+len(name)))
+    field = append(field, unicodePathVersion)
+    field = binary.LittleEndian.AppendUint32(field, crc32.ChecksumIEEE([]byte(headerName)))
+    return append(field, name...)
+}
+
+// unicodePathFields returns just the Unicode Path fields of extra, so a
+// rewritten entry keeps its name without doubling the fields zip.Writer adds
+func unicodePathFields(extra []byte) []byte {
+    var kept []byte
+    for len(extra) >= 4 {
+        id := binary.LittleEndian.Uint16(extra)
+        size := int(binary.LittleEndian.Uint16(extra[2:]))
+        if len(extra) < 4+size {
+            break
+        }
+        if id == unicodePathID {
+            kept = append(kept, extra[:4+size]...)
+        }
+        extra = extra[4+size:]
+    }
+    return kept
+}
+
+// storedName is the real name of an archive entry: the Unicode Path field if
+// there is one that still matches the header name, the header name otherwise
+func storedName(f *zip.File) string {
+    extra := unicodePathFields(f.Extra)
+    for len(extra) >= 4 {
+        size := int(binary.LittleEndian.Uint16(extra[2:]))
+        data := extra[4 : 4+size]
+        extra = extra[4+size:]
+        if len(data) < 5 || data[0] != unicodePathVersion {
+            continue
+        }
+        if binary.LittleEndian.Uint32(data[1:]) == crc32.ChecksumIEEE([]byte(f.Name)) {
+            return string(data[5:])
+        }
+    }
+    return f.Name
+}
+
+// cp437Name transliterates name to bytes CP437 can show: characters CP437
+// has are kept, accented ones it lacks lose their accent and everything else
+// becomes an underscore
+func cp437Name(name string) string {
+    var b strings.Builder
+    for _, r := range name {
+        if c, ok := charmap.CodePage437.EncodeRune(r); ok {
+            b.WriteByte(c)
+            continue
+        }
+        // "ō" decomposes to "o" and a combining macron
+        base, _ := utf8.DecodeRuneInString(norm.NFD.String(string(r)))
+        if c, ok := charmap.CodePage437.EncodeRune(base); ok && base != r {
+            b.WriteByte(c)
+            continue
+        }
+        b.WriteByte('_')
+    }
+    return b.String()
+}
+
+func isASCII(s string) bool {
+    for i := 0; i < len(s); i++ {
+        if s[i] >= utf8.RuneSelf {
+            return false
+        }
+    }
+    return true
+}
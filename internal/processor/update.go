@@ -0,0 +1,86 @@
+package processor
+
+import (
+    "archive/zip"
+    "fmt"
+    "os"
+)
+
+// archiveUpdate is an existing archive that -update adds new files to: its
+// entries are copied into the new archive as they are, without being read
+// from disk or compressed again
+type archiveUpdate struct {
+    reader  *zip.ReadCloser
+    entries map[string]*zip.File // By entry name
+    added   int                  // Files the archive doesn't hold yet
+}
+
+// planUpdate works out whether the archive at cbzPath can be updated with the
+// files it doesn't hold yet. Every source entry in it has to match a file
+// that is stored under the same name, by size and modification time, or it
+// returns nil and why the archive has to be rebuilt instead. Generated
+// entries are always made again.
+func planUpdate(cbzPath, sourceDir string, files []string, names map[string]string, reproducible bool) (*archiveUpdate, string, error) {
+    reader, err := zip.OpenReader(cbzPath)
+    if err != nil {
+        return nil, "", err
+    }
+    u := &archiveUpdate{reader: reader, entries: make(map[string]*zip.File)}
+
+    stored := make(map[string]*zip.File)
+    generated := generatedEntries(reader.Comment)
+    for _, f := range reader.File {
+        if !f.FileInfo().IsDir() && !generated[f.Name] {
+            stored[storedName(f)] = f
+        }
+    }
+
+    for _, file := range files {
+        name, err := entryName(file, sourceDir, names)
+        if err != nil {
+            u.close()
+            return nil, "", err
+        }
+        f, ok := stored[name]
+        if !ok {
+            u.added++
+            continue
+        }
+        info, err := os.Stat(file)
+        if err != nil {
+            u.close()
+            return nil, "", err
+        }
+        // Zip times are only kept to the second, reproducible ones not at all
+        changed := f.UncompressedSize64 != uint64(info.Size())
+        if !reproducible {
+            changed = changed || f.Modified.Unix() != info.ModTime().Unix()
+        }
+        if changed {
+            u.close()
+            return nil, fmt.Sprintf("%s changed", name), nil
+        }
+        u.entries[name] = f
+    }
+    if removed := len(stored) - len(u.entries); removed > 0 {
+        u.close()
+        return nil, fmt.Sprintf("%d files are gone from the source", removed), nil
+    }
+    return u, "", nil
+}
+
+// reuse is the entries to copy, nil for no update
+func (u *archiveUpdate) reuse() map[string]*zip.File {
+    if u == nil {
+        return nil
+    }
+    return u.entries
+}
+
+// close releases the old archive, it must be closed before it is replaced
+func (u *archiveUpdate) close() {
+    if u != nil && u.reader != nil {
+        u.reader.Close()
+        u.reader = nil
+    }
+}
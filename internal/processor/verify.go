@@ -0,0 +1,116 @@
+package processor
+
+import (
+    "archive/zip"
+    "fmt"
+    "hash/crc32"
+    "io"
+    "os"
+)
+
+// entrySum identifies the content of an entry without trusting its name,
+// pages may have been renamed or sanitized on the way in
+type entrySum struct {
+    crc  uint32
+    size uint64
+}
+
+// verifyError is an archive that was written but didn't read back right
+type verifyError struct {
+    err error
+}
+
+func (e *verifyError) Error() string {
+    return "archive failed verification: " + e.err.Error()
+}
+
+func (e *verifyError) Unwrap() error {
+    return e.err
+}
+
+// verifiedPlace checks the finished archive before place moves it over the
+// destination, so a bad write fails the item without replacing a good archive
+func verifiedPlace(place func(string) error, files []string, extras int) func(string) error {
+    return func(tmpPath string) error {
+        if err := syncFile(tmpPath); err != nil {
+            return &verifyError{err}
+        }
+        if err := verifyArchive(tmpPath, files, extras); err != nil {
+            return &verifyError{err}
+        }
+        return place(tmpPath)
+    }
+}
+
+// syncFile flushes path to the drive, write errors of a failing device often
+// only show up here
+func syncFile(path string) error {
+    f, err := os.OpenFile(path, os.O_RDWR, 0)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    return f.Sync()
+}
+
+// verifyArchive re-opens the finished archive at cbzPath, reads every entry
+// back so archive/zip checks its CRC, and makes sure each of files went in
+// with its size and checksum. extras is the number of generated entries.
+func verifyArchive(cbzPath string, files []string, extras int) error {
+    want := make(map[entrySum]int, len(files))
+    for _, f := range files {
+        sum, err := fileSum(f)
+        if err != nil {
+            return err
+        }
+        want[sum]++
+    }
+
+    reader, err := zip.OpenReader(cbzPath)
+    if err != nil {
+        return err
+    }
+    defer reader.Close()
+
+    if len(reader.File) != len(files)+extras {
+        return fmt.Errorf("archive has %d entries, expected %d", len(reader.File), len(files)+extras)
+    }
+    for _, f := range reader.File {
+        rc, err := f.Open()
+        if err != nil {
+            return fmt.Errorf("%s: %w", storedName(f), err)
+        }
+        n, err := io.Copy(io.Discard, rc)
+        rc.Close()
+        if err != nil {
+            return fmt.Errorf("%s: %w", storedName(f), err)
+        }
+        if sum := (entrySum{crc: f.CRC32, size: uint64(n)}); want[sum] > 0 {
+            want[sum]--
+        }
+    }
+
+    missing := 0
+    for _, n := range want {
+        missing += n
+    }
+    if missing > 0 {
+        return fmt.Errorf("%d source files are missing from the archive or differ", missing)
+    }
+    return nil
+}
+
+func fileSum(path string) (entrySum, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return entrySum{}, err
+    }
+    defer f.Close()
+
+    h := crc32.NewIEEE()
+    n, err := io.Copy(h, f)
+    if err != nil {
+        return entrySum{}, err
+    }
+    return entrySum{crc: h.Sum32(), size: uint64(n)}, nil
+}
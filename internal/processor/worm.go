@@ -0,0 +1,36 @@
+package processor
+
+import (
+    "os"
+)
+
+// sealArchive makes a finished archive read-only, and with immutable also
+// immutable where the OS and filesystem support it, so not even its owner
+// can replace or delete it without undoing that first
+func sealArchive(path string, immutable bool) error {
+    if err := os.Chmod(path, 0444); err != nil {
+        return err
+    }
+    if immutable {
+        return setImmutable(path, true)
+    }
+    return nil
+}
+
+// unsealArchive undoes sealArchive before -force replaces the archive
+func unsealArchive(path string) error {
+    if err := setImmutable(path, false); err != nil && isImmutable(path) {
+        return err
+    }
+    return os.Chmod(path, 0644)
+}
+
+// isSealed reports whether path is an archive sealArchive or the user made
+// read-only
+func isSealed(path string) bool {
+    info, err := os.Stat(path)
+    if err != nil {
+        return false
+    }
+    return info.Mode().Perm()&0222 == 0 || isImmutable(path)
+}
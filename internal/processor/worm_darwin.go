@@ -0,0 +1,33 @@
+//go:build darwin
+
+package processor
+
+import (
+    "fmt"
+    "os"
+    "os/exec"
+    "strings"
+    "syscall"
+)
+
+// setImmutable sets or clears the user immutable flag, the one Finder shows
+// as Locked
+func setImmutable(path string, on bool) error {
+    flag := "nouchg"
+    if on {
+        flag = "uchg"
+    }
+    if out, err := exec.Command("chflags", flag, path).CombinedOutput(); err != nil {
+        return fmt.Errorf("chflags %s: %s", flag, strings.TrimSpace(string(out)))
+    }
+    return nil
+}
+
+func isImmutable(path string) bool {
+    info, err := os.Stat(path)
+    if err != nil {
+        return false
+    }
+    st, ok := info.Sys().(*syscall.Stat_t)
+    return ok && st.Flags&0x2 != 0 // UF_IMMUTABLE
+}
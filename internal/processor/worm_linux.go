@@ -0,0 +1,32 @@
+//go:build linux
+
+package processor
+
+import (
+    "fmt"
+    "os/exec"
+    "strings"
+)
+
+// setImmutable sets or clears the immutable attribute with chattr, which
+// takes root or CAP_LINUX_IMMUTABLE and a filesystem that has it, like ext4,
+// XFS or Btrfs
+func setImmutable(path string, on bool) error {
+    flag := "-i"
+    if on {
+        flag = "+i"
+    }
+    if out, err := exec.Command("chattr", flag, "--", path).CombinedOutput(); err != nil {
+        return fmt.Errorf("chattr %s: %s", flag, strings.TrimSpace(string(out)))
+    }
+    return nil
+}
+
+func isImmutable(path string) bool {
+    out, err := exec.Command("lsattr", "-d", "--", path).Output()
+    if err != nil {
+        return false
+    }
+    attrs, _, _ := strings.Cut(string(out), " ")
+    return strings.Contains(attrs, "i")
+}
@@ -0,0 +1,17 @@
+//go:build !linux && !darwin
+
+package processor
+
+import "errors"
+
+// Windows only knows the read-only attribute, which sealArchive already sets
+func setImmutable(path string, on bool) error {
+    if !on {
+        return nil
+    }
+    return errors.New("immutable files aren't supported on this OS")
+}
+
+func isImmutable(path string) bool {
+    return false
+}
@@ -0,0 +1,196 @@
+package processor
+
+import (
+    "archive/zip"
+    "compress/flate"
+    "errors"
+    "fmt"
+    "hash"
+    "hash/crc32"
+    "io"
+    "os"
+    "strings"
+)
+
+// Entries encrypted with the traditional PKWARE scheme, the ZipCrypto most
+// tools still write by default, have bit 0 of their flags set. WinZip's AES
+// marks them as well, with method 99 in place of the real one.
+const (
+    flagEncrypted = 0x1
+    flagDataDesc  = 0x8
+    methodAES     = 99
+)
+
+// errWrongPassword is returned when the header of an entry doesn't check out
+// with the password tried
+var errWrongPassword = errors.New("wrong password")
+
+// encrypted reports whether f can only be read with a password
+func encrypted(f *zip.File) bool {
+    return f.Flags&flagEncrypted != 0
+}
+
+// findPassword returns the number of the first of passwords that opens the
+// encrypted entries of files, checked by reading the smallest one whole.
+// Zero means none of them need one.
+func findPassword(files []*zip.File, passwords []string) (int, error) {
+    var probe *zip.File
+    for _, f := range files {
+        if encrypted(f) && (probe == nil || f.CompressedSize64 < probe.CompressedSize64) {
+            probe = f
+        }
+    }
+    if probe == nil {
+        return 0, nil
+    }
+    if probe.Method == methodAES {
+        return 0, errors.New("archive is AES encrypted, only ZipCrypto can be decrypted")
+    }
+    if len(passwords) == 0 {
+        return 0, errors.New("archive is encrypted, -passwords lists the ones to try")
+    }
+    for i, password := range passwords {
+        rc, err := openEncrypted(probe, password)
+        if err == nil {
+            _, err = io.Copy(io.Discard, rc)
+            rc.Close()
+        }
+        if err == nil {
+            return i + 1, nil
+        }
+    }
+    return 0, errors.New("archive is encrypted and no password of the list opens it")
+}
+
+// LoadPasswords reads a password list, one password per line. Blank lines
+// are skipped, anything else is taken as it is, spaces included.
+func LoadPasswords(path string) ([]string, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    var passwords []string
+    for _, line := range strings.Split(string(data), "\n") {
+        if line = strings.TrimSuffix(line, "\r"); line != "" {
+            passwords = append(passwords, line)
+        }
+    }
+    if len(passwords) == 0 {
+        return nil, fmt.Errorf("%s holds no passwords", path)
+    }
+    return passwords, nil
+}
+
+// openEntry opens f for reading, decrypting it with password if it is encrypted
+func openEntry(f *zip.File, password string) (io.ReadCloser, error) {
+    if !encrypted(f) {
+        return f.Open()
+    }
+    return openEncrypted(f, password)
+}
+
+// openEncrypted decrypts and decompresses f, failing with zip.ErrChecksum at
+// the end when the password only got past the header by chance
+func openEncrypted(f *zip.File, password string) (io.ReadCloser, error) {
+    if f.Method == methodAES {
+        return nil, errors.New("AES encrypted entries can't be decrypted")
+    }
+    raw, err := f.OpenRaw()
+    if err != nil {
+        return nil, err
+    }
+    keys := newZipKeys(password)
+    header := make([]byte, 12)
+    if _, err := io.ReadFull(raw, header); err != nil {
+        return nil, err
+    }
+    keys.decrypt(header)
+
+    // The last byte of the header repeats the high byte of the CRC, or of
+    // the modification time when the CRC followed the data
+    check := byte(f.CRC32 >> 24)
+    if f.Flags&flagDataDesc != 0 {
+        check = byte(f.ModifiedTime >> 8)
+    }
+    if header[11] != check {
+        return nil, errWrongPassword
+    }
+
+    var r io.Reader = &decryptReader{r: raw, keys: keys}
+    var closer io.Closer
+    switch f.Method {
+    case zip.Store:
+    case zip.Deflate:
+        fr := flate.NewReader(r)
+        r, closer = fr, fr
+    default:
+        return nil, fmt.Errorf("unsupported compression method %d", f.Method)
+    }
+    return &checkedReader{r: r, closer: closer, hash: crc32.NewIEEE(), want: f.CRC32}, nil
+}
+
+// zipKeys is the state of the traditional PKWARE cipher
+type zipKeys [3]uint32
+
+func newZipKeys(password string) *zipKeys {
+    k := &zipKeys{0x12345678, 0x23456789, 0x34567890}
+    for i := 0; i < len(password); i++ {
+        k.update(password[i])
+    }
+    return k
+}
+
+func (k *zipKeys) update(b byte) {
+    k[0] = crc32Update(k[0], b)
+    k[1] = (k[1]+k[0]&0xff)*134775813 + 1
+    k[2] = crc32Update(k[2], byte(k[1]>>24))
+}
+
+func (k *zipKeys) decrypt(buf []byte) {
+    for i, c := range buf {
+        t := uint16(k[2] | 2)
+        buf[i] = c ^ byte(t*(t^1)>>8)
+        k.update(buf[i])
+    }
+}
+
+func crc32Update(crc uint32, b byte) uint32 {
+    return crc32.IEEETable[byte(crc)^b] ^ crc>>8
+}
+
+// decryptReader decrypts what is read from r
+type decryptReader struct {
+    r    io.Reader
+    keys *zipKeys
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+    n, err := d.r.Read(p)
+    d.keys.decrypt(p[:n])
+    return n, err
+}
+
+// checkedReader fails the read that reaches the end when what was read
+// doesn't match the CRC of the entry
+type checkedReader struct {
+    r      io.Reader
+    closer io.Closer
+    hash   hash.Hash32
+    want   uint32
+}
+
+func (c *checkedReader) Read(p []byte) (int, error) {
+    n, err := c.r.Read(p)
+    c.hash.Write(p[:n])
+    if err == io.EOF && c.hash.Sum32() != c.want {
+        return n, zip.ErrChecksum
+    }
+    return n, err
+}
+
+func (c *checkedReader) Close() error {
+    if c.closer != nil {
+        return c.closer.Close()
+    }
+    return nil
+}
@@ -0,0 +1,147 @@
+package processor
+
+import (
+    "archive/zip"
+    "bytes"
+    "compress/flate"
+    "errors"
+    "hash/crc32"
+    "io"
+    "testing"
+)
+
+// page is what the entries of the fixtures hold. testdata/zipcrypto-*.zip
+// were written by Info-ZIP, "zip -P secret" and "zip -0 -P secret", which
+// puts the CRC in a data descriptor and checks the header against the time.
+const page = "page one of the chapter, page one of the chapter\n"
+
+func TestOpenEncrypted(t *testing.T) {
+    tests := []struct {
+        name     string
+        archive  func(t *testing.T) *zip.File
+        password string
+        want     string
+        err      error
+    }{
+        {"info-zip deflate", fixture("testdata/zipcrypto-deflate.zip"), "secret", page, nil},
+        {"info-zip store", fixture("testdata/zipcrypto-store.zip"), "secret", page, nil},
+        {"info-zip wrong password", fixture("testdata/zipcrypto-deflate.zip"), "wrong", "", errWrongPassword},
+        {"crc check byte", sealed(page, zip.Deflate, false, false), "secret", page, nil},
+        {"crc check byte store", sealed(page, zip.Store, false, false), "secret", page, nil},
+        {"crc check byte wrong password", sealed(page, zip.Deflate, false, false), "wrong", "", errWrongPassword},
+        {"descriptor check byte", sealed(page, zip.Deflate, true, false), "secret", page, nil},
+        {"descriptor check byte wrong password", sealed(page, zip.Deflate, true, false), "wrong", "", errWrongPassword},
+        {"header passes, data doesn't", sealed(page, zip.Store, false, true), "secret", "", zip.ErrChecksum},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            rc, err := openEncrypted(tt.archive(t), tt.password)
+            var got []byte
+            if err == nil {
+                got, err = io.ReadAll(rc)
+                rc.Close()
+            }
+            if !errors.Is(err, tt.err) {
+                t.Fatalf("err = %v, want %v", err, tt.err)
+            }
+            if err == nil && string(got) != tt.want {
+                t.Fatalf("read %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestFindPassword(t *testing.T) {
+    f := fixture("testdata/zipcrypto-deflate.zip")(t)
+    tests := []struct {
+        name      string
+        passwords []string
+        want      int
+        fails     bool
+    }{
+        {"first", []string{"secret", "other"}, 1, false},
+        {"later", []string{"other", "Secret", "secret"}, 3, false},
+        {"none", []string{"other", "Secret"}, 0, true},
+        {"no list", nil, 0, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := findPassword([]*zip.File{f}, tt.passwords)
+            if (err != nil) != tt.fails || got != tt.want {
+                t.Fatalf("findPassword = %d, %v, want %d (fails %v)", got, err, tt.want, tt.fails)
+            }
+        })
+    }
+}
+
+// fixture opens the only entry of a ZIP in testdata
+func fixture(path string) func(t *testing.T) *zip.File {
+    return func(t *testing.T) *zip.File {
+        r, err := zip.OpenReader(path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        t.Cleanup(func() { r.Close() })
+        return r.File[0]
+    }
+}
+
+// sealed encrypts content with the password "secret" the way ZipCrypto
+// writers do, with the check byte of the header taken from the time when
+// descriptor is set and from the CRC otherwise. corrupt flips a bit of the
+// data after the header, which then still checks out.
+func sealed(content string, method uint16, descriptor, corrupt bool) func(t *testing.T) *zip.File {
+    return func(t *testing.T) *zip.File {
+        var compressed bytes.Buffer
+        if method == zip.Deflate {
+            fw, _ := flate.NewWriter(&compressed, flate.BestCompression)
+            fw.Write([]byte(content))
+            fw.Close()
+        } else {
+            compressed.WriteString(content)
+        }
+
+        fh := &zip.FileHeader{
+            Name:               "page.txt",
+            Method:             method,
+            Flags:              flagEncrypted,
+            CRC32:              crc32.ChecksumIEEE([]byte(content)),
+            CompressedSize64:   uint64(compressed.Len() + 12),
+            UncompressedSize64: uint64(len(content)),
+            ModifiedTime:       0x6ca5,
+            ModifiedDate:       0x58a1,
+        }
+        header := []byte("random bytes")
+        header[11] = byte(fh.CRC32 >> 24)
+        if descriptor {
+            fh.Flags |= flagDataDesc
+            header[11] = byte(fh.ModifiedTime >> 8)
+        }
+        data := append(header, compressed.Bytes()...)
+        if corrupt {
+            data[len(data)/2] ^= 0x10
+        }
+        keys := newZipKeys("secret")
+        for i, c := range data {
+            k := uint16(keys[2] | 2)
+            data[i] = c ^ byte(k*(k^1)>>8)
+            keys.update(c)
+        }
+
+        var buf bytes.Buffer
+        zw := zip.NewWriter(&buf)
+        w, err := zw.CreateRaw(fh)
+        if err != nil {
+            t.Fatal(err)
+        }
+        w.Write(data)
+        if err := zw.Close(); err != nil {
+            t.Fatal(err)
+        }
+        r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+        if err != nil {
+            t.Fatal(err)
+        }
+        return r.File[0]
+    }
+}
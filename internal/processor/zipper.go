@@ -3,11 +3,15 @@ package processor
 import (
     "archive/zip"
     "compress/flate"
+    "context"
     "convert_cbz/internal/types"
+    "fmt"
     "io"
+    "math"
     "os"
     "path/filepath"
     "sync"
+    "time"
 )
 
 var (
@@ -18,22 +22,76 @@ var (
 // Once program starts there's no way to change compression mode so just cache it
 func getCompression() types.CompressionMode {
     compressionOnce.Do(func() {
-        compression = types.ToCompressionMode(os.Getenv(types.CKey.String()))
+        // The library never sets it, that is no reason to warn
+        compression = types.CMNone
+        if mode, ok := os.LookupEnv(types.CKey.String()); ok {
+            compression = types.ToCompressionMode(mode)
+        }
     })
     return compression
 }
 
-func addFileToZip(zipWriter *zip.Writer, filePath, baseDir string) error {
-    // Calculate relative path for the ZIP entry
-    // This preserves the directory structure within the archive
+// ctxReader stops a copy midway once its context is cancelled, so aborting
+// doesn't have to wait for a large page to be compressed
+type ctxReader struct {
+    ctx context.Context
+    r   io.Reader
+}
+
+func (cr ctxReader) Read(p []byte) (int, error) {
+    if err := cr.ctx.Err(); err != nil {
+        return 0, err
+    }
+    return cr.r.Read(p)
+}
+
+// reproducibleDate is the MS-DOS date of 1980-01-01, the earliest a zip
+// header can hold, and the time every entry of a reproducible archive gets
+const reproducibleDate = 1<<5 | 1
+
+// entryHeaders fills in the headers of one archive's entries, see setName
+// for how names are encoded. Reproducible archives get the same time and
+// permissions on every entry and no extended timestamp field, so the bytes
+// only depend on the source's names and content.
+type entryHeaders struct {
+    cp437        bool
+    reproducible bool
+    used         map[string]bool
+}
+
+func newEntryHeaders(cp437, reproducible bool) *entryHeaders {
+    return &entryHeaders{cp437: cp437, reproducible: reproducible, used: make(map[string]bool)}
+}
+
+// stamp sets the modification time and mode of an entry
+func (eh *entryHeaders) stamp(header *zip.FileHeader, modified time.Time, mode os.FileMode) {
+    if !eh.reproducible {
+        header.Modified = modified
+        header.SetMode(mode)
+        return
+    }
+    // A zero Modified makes zip.Writer use the MS-DOS fields as they are
+    header.Modified = time.Time{}
+    header.ModifiedDate, header.ModifiedTime = reproducibleDate, 0
+    header.SetMode(0644)
+}
+
+// entryName is the name filePath is stored under: its path relative to
+// baseDir, which preserves the directory structure, unless names renames it
+func entryName(filePath, baseDir string, names map[string]string) (string, error) {
+    if name, ok := names[filePath]; ok {
+        return name, nil
+    }
     relPath, err := filepath.Rel(baseDir, filePath)
     if err != nil {
-        return err
+        return "", err
     }
 
     // Convert to forward slashes for ZIP standard compliance
-    relPath = filepath.ToSlash(relPath)
+    return filepath.ToSlash(relPath), nil
+}
 
+func addFileToZip(ctx context.Context, zipWriter *zip.Writer, headers *entryHeaders, filePath, name string) error {
     // Open source file
     sourceFile, err := os.Open(filePath)
     if err != nil {
@@ -52,12 +110,26 @@ func addFileToZip(zipWriter *zip.Writer, filePath, baseDir string) error {
     if err != nil {
         return err
     }
+    headers.stamp(header, fileInfo.ModTime(), fileInfo.Mode())
 
     // Set compression method and file path
-    header.Name = relPath
-    compression := getCompression()
+    headers.setName(header, name)
+    setMethod(zipWriter, header)
+
+    // Create ZIP entry
+    writer, err := zipWriter.CreateHeader(header)
+    if err != nil {
+        return err
+    }
 
-    switch compression {
+    // Copy file content to ZIP entry
+    _, err = io.Copy(writer, ctxReader{ctx: ctx, r: sourceFile})
+    return err
+}
+
+// setMethod picks the entry method for the configured compression mode
+func setMethod(zipWriter *zip.Writer, header *zip.FileHeader) {
+    switch getCompression() {
     case types.CMDefault:
         header.Method = zip.Deflate
         zipWriter.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
@@ -79,15 +151,43 @@ func addFileToZip(zipWriter *zip.Writer, filePath, baseDir string) error {
     default:
         header.Method = zip.Store
     }
+}
+
+// extraEntry is a file generated in memory rather than read from the source folder
+type extraEntry struct {
+    name string
+    data []byte
+}
+
+func addBytesToZip(zipWriter *zip.Writer, headers *entryHeaders, e extraEntry) error {
+    header := &zip.FileHeader{}
+    headers.stamp(header, time.Now(), 0644)
+    headers.setName(header, e.name)
+    setMethod(zipWriter, header)
 
-    // Create ZIP entry
     writer, err := zipWriter.CreateHeader(header)
     if err != nil {
         return err
     }
-
-    // Copy file content to ZIP entry
-    _, err = io.Copy(writer, sourceFile)
+    _, err = writer.Write(e.data)
     return err
 }
 
+// zip64Reason says why zip.Writer had to switch the archive at cbzPath to
+// ZIP64, or returns "" if it didn't. It does once an archive has 65535
+// entries or more, or it or one of its files reaches 4 GiB. Readers from
+// before ZIP64, and some e-readers still, can't open those.
+func zip64Reason(cbzPath string, files []string, entries int) string {
+    if entries >= math.MaxUint16 {
+        return fmt.Sprintf("%d entries", entries)
+    }
+    if info, err := os.Stat(cbzPath); err == nil && info.Size() >= math.MaxUint32 {
+        return fmt.Sprintf("%.1f GiB", float64(info.Size())/(1<<30))
+    }
+    for _, f := range files {
+        if info, err := os.Stat(f); err == nil && info.Size() >= math.MaxUint32 {
+            return filepath.Base(f) + " is 4 GiB or larger"
+        }
+    }
+    return ""
+}
@@ -0,0 +1,69 @@
+package report
+
+import (
+    "convert_cbz/internal/types"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Row is one folder of the report
+type Row struct {
+    types.ItemResult
+    Ratio float64 `json:"ratio"` // Archive size over source size, 0 when nothing was written
+}
+
+// Write saves the per-folder results of a run to path, as CSV or JSON
+// depending on the extension
+func Write(path string, results []types.ItemResult) error {
+    rows := make([]Row, len(results))
+    for i, r := range results {
+        rows[i] = Row{ItemResult: r, Ratio: r.Ratio()}
+    }
+    sort.Slice(rows, func(i, j int) bool { return rows[i].SourcePath < rows[j].SourcePath })
+
+    var data []byte
+    switch ext := strings.ToLower(filepath.Ext(path)); ext {
+    case ".json":
+        var err error
+        if data, err = json.MarshalIndent(rows, "", "  "); err != nil {
+            return err
+        }
+        data = append(data, '\n')
+    case ".csv":
+        data = csvReport(rows)
+    default:
+        return fmt.Errorf("unknown report format %q, use a .csv or .json file", ext)
+    }
+    return os.WriteFile(path, data, 0644)
+}
+
+func csvReport(rows []Row) []byte {
+    var sb strings.Builder
+    w := csv.NewWriter(&sb)
+    w.Write([]string{"folder", "source", "output", "status", "pages", "source_bytes", "bytes", "ratio", "excluded", "excluded_files", "series_status", "password", "error"})
+    for _, r := range rows {
+        w.Write([]string{
+            r.FolderName,
+            r.SourcePath,
+            r.OutputPath,
+            string(r.Status),
+            strconv.Itoa(r.Pages),
+            strconv.FormatInt(r.SourceBytes, 10),
+            strconv.FormatInt(r.Bytes, 10),
+            strconv.FormatFloat(r.Ratio, 'f', 3, 64),
+            strconv.Itoa(r.Excluded),
+            strings.Join(r.ExcludedFiles, "; "),
+            r.SeriesStatus,
+            strconv.Itoa(r.Password),
+            r.Error,
+        })
+    }
+    w.Flush()
+    return []byte(sb.String())
+}
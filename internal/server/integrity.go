@@ -0,0 +1,182 @@
+package server
+
+import (
+    "convert_cbz/internal/processor"
+    "errors"
+    "fmt"
+    "io/fs"
+    "net/http"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/jelius-sama/logger"
+)
+
+// idlePoll is how often a paused integrity pass looks whether the jobs are done
+const idlePoll = 5 * time.Second
+
+// Damaged is an archive the integrity watch found no longer reads back as written
+type Damaged struct {
+    Path  string    `json:"path"` // Relative to the output directory
+    Error string    `json:"error"`
+    Found time.Time `json:"found"`
+}
+
+// Integrity is the state of the integrity watch served by GET /api/integrity
+type Integrity struct {
+    Enabled  bool       `json:"enabled"`
+    Passes   int        `json:"passes"` // Passes started since the server started
+    Paused   bool       `json:"paused"` // Waiting for the jobs to finish
+    Started  *time.Time `json:"started,omitempty"`
+    Finished *time.Time `json:"finished,omitempty"` // End of the last complete pass
+    Next     *time.Time `json:"next,omitempty"`
+    Checked  int        `json:"checked"` // Archives checked so far in the current or last pass
+    Total    int        `json:"total"`
+    Damaged  []Damaged  `json:"damaged"`
+}
+
+// watchIntegrity rechecks every archive in the output directory once per
+// VerifyEvery, for as long as the server runs
+func (s *Server) watchIntegrity() {
+    for {
+        s.integrityPass()
+        next := time.Now().Add(s.VerifyEvery)
+        s.mu.Lock()
+        s.integrity.Next = &next
+        s.mu.Unlock()
+        time.Sleep(s.VerifyEvery)
+    }
+}
+
+// integrityPass rechecks every archive once, reading no faster than
+// VerifyRate and not at all while a job is queued or running
+func (s *Server) integrityPass() {
+    archives, err := outputArchives(s.OutputDir)
+    if err != nil {
+        logger.Warning(fmt.Sprintf("Integrity: failed to list %s: %v", s.OutputDir, err))
+    }
+
+    start := time.Now()
+    s.mu.Lock()
+    s.integrity.Passes++
+    s.integrity.Started = &start
+    s.integrity.Next = nil
+    s.integrity.Checked = 0
+    s.integrity.Total = len(archives)
+    s.mu.Unlock()
+
+    damaged := 0
+    for _, path := range archives {
+        s.waitIdle()
+        err := processor.RecheckArchive(path, s.pace)
+        rel := relToOutput(s.OutputDir, path)
+        if errors.Is(err, fs.ErrNotExist) {
+            // Replaced or removed since the pass listed it
+            err = nil
+        }
+
+        s.mu.Lock()
+        s.integrity.Checked++
+        s.integrity.Damaged = flagDamaged(s.integrity.Damaged, rel, err)
+        s.mu.Unlock()
+        if err != nil {
+            damaged++
+            logger.Error(fmt.Sprintf("Integrity: %s: %v", rel, err))
+        }
+    }
+
+    finished := time.Now()
+    s.mu.Lock()
+    s.integrity.Finished = &finished
+    s.mu.Unlock()
+    logger.Info(fmt.Sprintf("Integrity: checked %d archives in %s, %d damaged", len(archives), finished.Sub(start).Round(time.Second), damaged))
+}
+
+// pace sleeps long enough that reading n bytes keeps to VerifyRate, and
+// first waits for the jobs if one came in
+func (s *Server) pace(n int) error {
+    s.waitIdle()
+    if s.VerifyRate > 0 {
+        time.Sleep(time.Duration(float64(n) / float64(s.VerifyRate) * float64(time.Second)))
+    }
+    return nil
+}
+
+// waitIdle returns once no job is queued or running
+func (s *Server) waitIdle() {
+    for s.busy() {
+        time.Sleep(idlePoll)
+    }
+    s.mu.Lock()
+    s.integrity.Paused = false
+    s.mu.Unlock()
+}
+
+// busy reports whether a job is queued or running, and marks the integrity
+// pass paused if one is
+func (s *Server) busy() bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for _, job := range s.jobs {
+        if job.State == JobQueued || job.State == JobRunning {
+            s.integrity.Paused = true
+            return true
+        }
+    }
+    return false
+}
+
+func (s *Server) handleIntegrity(w http.ResponseWriter, r *http.Request) {
+    s.mu.Lock()
+    st := s.integrity
+    st.Enabled = s.VerifyEvery > 0
+    st.Damaged = append([]Damaged{}, s.integrity.Damaged...)
+    s.mu.Unlock()
+    writeJSON(w, http.StatusOK, st)
+}
+
+// outputArchives lists the archives below dir, leaving out the hidden
+// temporary files archives are written to
+func outputArchives(dir string) ([]string, error) {
+    var archives []string
+    err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        if !d.IsDir() && strings.EqualFold(filepath.Ext(d.Name()), ".cbz") && !strings.HasPrefix(d.Name(), ".") {
+            archives = append(archives, path)
+        }
+        return nil
+    })
+    sort.Strings(archives)
+    return archives, err
+}
+
+// flagDamaged updates the entry for rel in list with the outcome of its
+// recheck: dropped once it reads back fine, such as after being converted
+// again, and kept from when it was first found otherwise
+func flagDamaged(list []Damaged, rel string, err error) []Damaged {
+    for i, d := range list {
+        if d.Path != rel {
+            continue
+        }
+        if err == nil {
+            return append(list[:i], list[i+1:]...)
+        }
+        list[i].Error = err.Error()
+        return list
+    }
+    if err != nil {
+        list = append(list, Damaged{Path: rel, Error: err.Error(), Found: time.Now()})
+    }
+    return list
+}
+
+func relToOutput(root, path string) string {
+    if rel, err := filepath.Rel(root, path); err == nil {
+        return rel
+    }
+    return path
+}
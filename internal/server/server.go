@@ -0,0 +1,480 @@
+package server
+
+import (
+    "context"
+    "convert_cbz/internal/collector"
+    "convert_cbz/internal/history"
+    "convert_cbz/internal/pathnorm"
+    "convert_cbz/internal/processor"
+    "convert_cbz/internal/types"
+    "crypto/subtle"
+    _ "embed"
+    "encoding/json"
+    "fmt"
+    "mime"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/jelius-sama/logger"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// JobState describes where a job is in its lifecycle
+type JobState string
+
+const (
+    JobQueued   JobState = "queued"
+    JobRunning  JobState = "running"
+    JobFinished JobState = "finished"
+    JobFailed   JobState = "failed"
+)
+
+// JobRequest is the body accepted by POST /api/jobs
+type JobRequest struct {
+    Inputs    []string `json:"inputs"`
+    Recursive bool     `json:"recursive"`
+    Dumb      bool     `json:"dumb"`
+    Cover     string   `json:"cover,omitempty"` // Replaces the server's -cover for this job
+}
+
+// Job is a single submitted batch of input paths
+type Job struct {
+    ID        int
+    Request   JobRequest
+    State     JobState
+    Submitted time.Time
+    Started   time.Time
+    Finished  time.Time
+    Error     string
+    Failures  []string
+    RunID     string // History and journal ID, for rollback
+
+    stats *types.ConversionStats
+}
+
+// JobStatus is the JSON view of a job served to the dashboard
+type JobStatus struct {
+    ID        int        `json:"id"`
+    Inputs    []string   `json:"inputs"`
+    Recursive bool       `json:"recursive"`
+    Dumb      bool       `json:"dumb"`
+    State     JobState   `json:"state"`
+    Submitted time.Time  `json:"submitted"`
+    Started   *time.Time `json:"started,omitempty"`
+    Finished  *time.Time `json:"finished,omitempty"`
+    Total     int        `json:"total"`
+    Success   int        `json:"success"`
+    Errors    int        `json:"errors"`
+    Skipped   int        `json:"skipped"`
+    Failures  []string   `json:"failures"`
+    Error     string     `json:"error,omitempty"`
+    RunID     string     `json:"run_id,omitempty"`
+
+    // Archives being written right now, with their files added so far
+    Active []types.ItemProgress `json:"active"`
+}
+
+// Overview aggregates stats across the jobs the server still keeps
+type Overview struct {
+    Queued   int `json:"queued"`
+    Running  int `json:"running"`
+    Finished int `json:"finished"`
+    Failed   int `json:"failed"`
+    Folders  int `json:"folders"`
+    Success  int `json:"success"`
+    Errors   int `json:"errors"`
+    Skipped  int `json:"skipped"`
+    Damaged  int `json:"damaged"` // Archives the integrity watch found damaged
+}
+
+// Server queues conversion jobs and runs them one at a time into OutputDir
+type Server struct {
+    OutputDir string
+    Options   types.Options // Template copied into every job
+
+    // Archives in OutputDir are read back once per VerifyEvery, at no more
+    // than VerifyRate bytes a second and only while no job is queued or
+    // running. Zero VerifyEvery turns the integrity watch off.
+    VerifyEvery time.Duration
+    VerifyRate  int64
+
+    // Token, when set, has to be sent as "Authorization: Bearer <token>" with
+    // every API request. AllowRoots, when set, are the only folders jobs may
+    // take their inputs from.
+    Token      string
+    AllowRoots []string
+
+    mu        sync.Mutex
+    jobs      []*Job
+    nextID    int
+    queue     chan *Job
+    integrity Integrity
+}
+
+func New(outputDir string, opts types.Options) *Server {
+    opts.Quiet = true
+    return &Server{
+        OutputDir: outputDir,
+        Options:   opts,
+        nextID:    1,
+        queue:     make(chan *Job, 128),
+    }
+}
+
+// keepFinished is how many finished and failed jobs are kept for the
+// dashboard, older ones are forgotten; their runs stay in the history
+const keepFinished = 200
+
+// Run starts the job runner and serves the API and dashboard on addr
+func (s *Server) Run(addr string) error {
+    go s.runner()
+    if s.VerifyEvery > 0 {
+        go s.watchIntegrity()
+    }
+    logger.Info(fmt.Sprintf("Dashboard listening on http://%s", displayAddr(addr)))
+    return http.ListenAndServe(addr, s.Handler())
+}
+
+func (s *Server) Handler() http.Handler {
+    mux := http.NewServeMux()
+    mux.HandleFunc("GET /{$}", s.handleDashboard)
+    mux.HandleFunc("GET /api/jobs", s.authorized(s.handleListJobs))
+    mux.HandleFunc("POST /api/jobs", s.authorized(s.handleSubmitJob))
+    mux.HandleFunc("GET /api/jobs/{id}", s.authorized(s.handleGetJob))
+    mux.HandleFunc("GET /api/stats", s.authorized(s.handleStats))
+    mux.HandleFunc("GET /api/integrity", s.authorized(s.handleIntegrity))
+    return mux
+}
+
+// authorized lets requests through to h only with the server's token, if it has one
+func (s *Server) authorized(h http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if s.Token != "" {
+            got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+            if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(s.Token)) != 1 {
+                writeError(w, http.StatusUnauthorized, "missing or wrong token")
+                return
+            }
+        }
+        h(w, r)
+    }
+}
+
+// allowed reports whether path is inside one of AllowRoots, after following
+// symlinks so a link can't lead out of them
+func (s *Server) allowed(path string) bool {
+    if len(s.AllowRoots) == 0 {
+        return true
+    }
+    path = resolve(path)
+    for _, root := range s.AllowRoots {
+        rel, err := filepath.Rel(pathnorm.Key(resolve(root)), pathnorm.Key(path))
+        if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+            return true
+        }
+    }
+    return false
+}
+
+// resolve returns path absolute with its symlinks followed, as far as it exists
+func resolve(path string) string {
+    if abs, err := filepath.Abs(path); err == nil {
+        path = abs
+    }
+    if real, err := filepath.EvalSymlinks(path); err == nil {
+        return real
+    }
+    if _, err := os.Lstat(path); err != nil && filepath.Dir(path) != path {
+        return filepath.Join(resolve(filepath.Dir(path)), filepath.Base(path))
+    }
+    return path
+}
+
+// Submit queues a job and returns it, or an error if the queue is full
+func (s *Server) Submit(req JobRequest) (*Job, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    job := &Job{
+        ID:        s.nextID,
+        Request:   req,
+        State:     JobQueued,
+        Submitted: time.Now(),
+    }
+
+    // Never block a request handler on a full queue
+    select {
+    case s.queue <- job:
+    default:
+        return nil, fmt.Errorf("job queue is full")
+    }
+
+    s.nextID++
+    s.jobs = append(s.jobs, job)
+    return job, nil
+}
+
+func (s *Server) runner() {
+    for job := range s.queue {
+        s.run(job)
+    }
+}
+
+func (s *Server) run(job *Job) {
+    s.mu.Lock()
+    job.State = JobRunning
+    job.Started = time.Now()
+    s.mu.Unlock()
+
+    logger.Info(fmt.Sprintf("Job %d: starting (%d inputs)", job.ID, len(job.Request.Inputs)))
+
+    var workItems []types.WorkItem
+    var err error
+    if job.Request.Recursive {
+        workItems, err = collector.CollectRecursive(job.Request.Inputs, s.OutputDir, job.Request.Dumb)
+    } else {
+        workItems, err = collector.CollectDirect(job.Request.Inputs, s.OutputDir, job.Request.Dumb)
+    }
+
+    if err == nil && len(workItems) == 0 {
+        err = fmt.Errorf("no folders found to process")
+    }
+    if err != nil {
+        s.mu.Lock()
+        job.State = JobFailed
+        job.Error = err.Error()
+        job.Finished = time.Now()
+        s.prune()
+        s.mu.Unlock()
+        logger.Error(fmt.Sprintf("Job %d: %v", job.ID, err))
+        return
+    }
+
+    stats := &types.ConversionStats{Total: len(workItems)}
+    // Failures show up on the dashboard as they happen, not only once the job is done
+    run := history.NewRun(job.Started, s.OutputDir, job.Request.Inputs)
+    s.mu.Lock()
+    job.stats = stats
+    job.RunID = run.ID
+    s.mu.Unlock()
+
+    journal := history.NewJournal(run.ID, s.OutputDir)
+    opts := s.Options
+    opts.RunID = run.ID
+    opts.Journal = journal.Record
+    if job.Request.Cover != "" {
+        opts.Cover = job.Request.Cover
+    }
+    opts.OnEvent = func(e types.Event) {
+        if e.Type != types.EventItemFailed {
+            return
+        }
+        s.mu.Lock()
+        job.Failures = append(job.Failures, e.FolderName+": "+e.Error)
+        s.mu.Unlock()
+    }
+    processor.ProcessConcurrently(context.Background(), workItems, &opts, stats)
+    if err := journal.Finish(); err != nil {
+        logger.Warning(fmt.Sprintf("Job %d: failed to write journal: %v", job.ID, err))
+    }
+
+    run.Finished = time.Now()
+    run.Record(stats.Results)
+    if err := history.Save(run); err != nil {
+        logger.Warning(fmt.Sprintf("Job %d: failed to record run history: %v", job.ID, err))
+    }
+
+    s.mu.Lock()
+    job.State = JobFinished
+    job.Finished = time.Now()
+    s.prune()
+    s.mu.Unlock()
+
+    stats.Mutex.Lock()
+    logger.Info(fmt.Sprintf("Job %d: finished (%d ok, %d skipped, %d errors)", job.ID, stats.Success, stats.Skipped, stats.Errors))
+    stats.Mutex.Unlock()
+}
+
+// prune forgets the oldest finished and failed jobs beyond keepFinished, it
+// must be called with s.mu held
+func (s *Server) prune() {
+    done := 0
+    for _, job := range s.jobs {
+        if job.State == JobFinished || job.State == JobFailed {
+            done++
+        }
+    }
+    kept := s.jobs[:0]
+    for _, job := range s.jobs {
+        if done > keepFinished && (job.State == JobFinished || job.State == JobFailed) {
+            done--
+            continue
+        }
+        kept = append(kept, job)
+    }
+    clear(s.jobs[len(kept):])
+    s.jobs = kept
+}
+
+// status must be called with s.mu held
+func (s *Server) status(job *Job) JobStatus {
+    st := JobStatus{
+        ID:        job.ID,
+        Inputs:    job.Request.Inputs,
+        Recursive: job.Request.Recursive,
+        Dumb:      job.Request.Dumb,
+        State:     job.State,
+        Submitted: job.Submitted,
+        Failures:  job.Failures,
+        Error:     job.Error,
+        RunID:     job.RunID,
+    }
+    if !job.Started.IsZero() {
+        st.Started = &job.Started
+    }
+    if !job.Finished.IsZero() {
+        st.Finished = &job.Finished
+    }
+    if st.Failures == nil {
+        st.Failures = []string{}
+    }
+    st.Active = []types.ItemProgress{}
+    if job.stats != nil {
+        st.Active = job.stats.Progress()
+        job.stats.Mutex.Lock()
+        st.Total = job.stats.Total
+        st.Success = job.stats.Success
+        st.Errors = job.stats.Errors
+        st.Skipped = job.stats.Skipped
+        job.stats.Mutex.Unlock()
+    }
+    return st
+}
+
+func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    w.Write(dashboardHTML)
+}
+
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+    s.mu.Lock()
+    list := make([]JobStatus, 0, len(s.jobs))
+    // Newest first, that's what people look for
+    for i := len(s.jobs) - 1; i >= 0; i-- {
+        list = append(list, s.status(s.jobs[i]))
+    }
+    s.mu.Unlock()
+    writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, http.StatusBadRequest, "invalid job id")
+        return
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for _, job := range s.jobs {
+        if job.ID == id {
+            writeJSON(w, http.StatusOK, s.status(job))
+            return
+        }
+    }
+    writeError(w, http.StatusNotFound, "job not found")
+}
+
+func (s *Server) handleSubmitJob(w http.ResponseWriter, r *http.Request) {
+    // A page in the browser can post a form or text to the server, but not JSON
+    if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+        writeError(w, http.StatusUnsupportedMediaType, "request body must be application/json")
+        return
+    }
+
+    var req JobRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+        return
+    }
+
+    var inputs []string
+    for _, in := range req.Inputs {
+        if in = strings.TrimSpace(in); in == "" {
+            continue
+        }
+        if !s.allowed(in) {
+            writeError(w, http.StatusForbidden, fmt.Sprintf("%s is outside the folders the server converts from", in))
+            return
+        }
+        inputs = append(inputs, in)
+    }
+    if len(inputs) == 0 {
+        writeError(w, http.StatusBadRequest, "at least one input path is required")
+        return
+    }
+    req.Inputs = inputs
+
+    job, err := s.Submit(req)
+    if err != nil {
+        writeError(w, http.StatusServiceUnavailable, err.Error())
+        return
+    }
+
+    s.mu.Lock()
+    st := s.status(job)
+    s.mu.Unlock()
+    writeJSON(w, http.StatusCreated, st)
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+    var ov Overview
+    s.mu.Lock()
+    for _, job := range s.jobs {
+        st := s.status(job)
+        switch st.State {
+        case JobQueued:
+            ov.Queued++
+        case JobRunning:
+            ov.Running++
+        case JobFinished:
+            ov.Finished++
+        case JobFailed:
+            ov.Failed++
+        }
+        ov.Folders += st.Total
+        ov.Success += st.Success
+        ov.Errors += st.Errors
+        ov.Skipped += st.Skipped
+    }
+    ov.Damaged = len(s.integrity.Damaged)
+    s.mu.Unlock()
+    writeJSON(w, http.StatusOK, ov)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(code)
+    if err := json.NewEncoder(w).Encode(v); err != nil {
+        logger.Error(fmt.Sprintf("Failed to encode response: %v", err))
+    }
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+    writeJSON(w, code, map[string]string{"error": msg})
+}
+
+// displayAddr turns ":8080" into something clickable
+func displayAddr(addr string) string {
+    if strings.HasPrefix(addr, ":") {
+        return "localhost" + addr
+    }
+    return addr
+}
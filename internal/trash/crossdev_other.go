@@ -0,0 +1,8 @@
+//go:build !unix && !windows
+
+package trash
+
+// crossDevice can't tell a rename between filesystems apart here
+func crossDevice(err error) bool {
+    return false
+}
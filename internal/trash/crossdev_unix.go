@@ -0,0 +1,13 @@
+//go:build unix
+
+package trash
+
+import (
+    "errors"
+    "syscall"
+)
+
+// crossDevice reports whether err is a rename between filesystems
+func crossDevice(err error) bool {
+    return errors.Is(err, syscall.EXDEV)
+}
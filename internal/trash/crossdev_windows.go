@@ -0,0 +1,17 @@
+//go:build windows
+
+package trash
+
+import (
+    "errors"
+    "syscall"
+)
+
+// errorNotSameDevice is what MoveFileEx gives for another drive, syscall
+// doesn't name it
+const errorNotSameDevice syscall.Errno = 17
+
+// crossDevice reports whether err is a rename between filesystems
+func crossDevice(err error) bool {
+    return errors.Is(err, errorNotSameDevice)
+}
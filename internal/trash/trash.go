@@ -0,0 +1,66 @@
+package trash
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "strconv"
+)
+
+// Move sends path to the trash of the OS: the XDG trash on Linux and the
+// BSDs, ~/.Trash on macOS and the Recycle Bin on Windows. It returns where
+// path ended up, or "" when the OS doesn't say (the Recycle Bin).
+func Move(path string) (string, error) {
+    path, err := filepath.Abs(path)
+    if err != nil {
+        return "", err
+    }
+    return move(path)
+}
+
+// MoveTo moves path into dir instead, under its own name or, when that is
+// taken, with a number added
+func MoveTo(path, dir string) (string, error) {
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return "", err
+    }
+    for i := 1; ; i++ {
+        dest := filepath.Join(dir, numbered(filepath.Base(path), i))
+        if _, err := os.Lstat(dest); err == nil {
+            continue
+        }
+        return dest, rename(path, dest)
+    }
+}
+
+// Restore moves what Move or MoveTo put at trashed back to path
+func Restore(trashed, path string) error {
+    if _, err := os.Lstat(path); err == nil {
+        return fmt.Errorf("%s exists again", path)
+    }
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return err
+    }
+    if err := os.Rename(trashed, path); err != nil {
+        return err
+    }
+    forget(trashed)
+    return nil
+}
+
+// numbered is name for the first try, "name 2", "name 3", ... after that
+func numbered(name string, i int) string {
+    if i == 1 {
+        return name
+    }
+    return name + " " + strconv.Itoa(i)
+}
+
+// rename explains the one failure users can do something about
+func rename(from, to string) error {
+    err := os.Rename(from, to)
+    if crossDevice(err) {
+        return fmt.Errorf("%s is on another filesystem than %s, use a -trash-dir on the same one", filepath.Dir(to), from)
+    }
+    return err
+}
@@ -0,0 +1,83 @@
+//go:build !windows && !darwin
+
+package trash
+
+import (
+    "fmt"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// move follows the freedesktop.org trash specification for the home trash:
+// the folder goes to Trash/files, with a .trashinfo in Trash/info recording
+// where it came from, so file managers can put it back
+func move(path string) (string, error) {
+    dir, err := homeTrash()
+    if err != nil {
+        return "", err
+    }
+    files, info := filepath.Join(dir, "files"), filepath.Join(dir, "info")
+    for _, d := range []string{files, info} {
+        if err := os.MkdirAll(d, 0700); err != nil {
+            return "", err
+        }
+    }
+
+    content := fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n", escapePath(path), time.Now().Format("2006-01-02T15:04:05"))
+    for i := 1; ; i++ {
+        name := numbered(filepath.Base(path), i)
+        // Creating the info file first claims the name
+        f, err := os.OpenFile(filepath.Join(info, name+".trashinfo"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+        if os.IsExist(err) {
+            continue
+        }
+        if err != nil {
+            return "", err
+        }
+        _, err = f.WriteString(content)
+        if closeErr := f.Close(); err == nil {
+            err = closeErr
+        }
+        dest := filepath.Join(files, name)
+        if err == nil {
+            err = rename(path, dest)
+        }
+        if err != nil {
+            os.Remove(f.Name())
+            return "", err
+        }
+        return dest, nil
+    }
+}
+
+// forget removes the .trashinfo of a restored folder
+func forget(trashed string) {
+    files := filepath.Dir(trashed)
+    if filepath.Base(files) != "files" {
+        return
+    }
+    os.Remove(filepath.Join(filepath.Dir(files), "info", filepath.Base(trashed)+".trashinfo"))
+}
+
+func homeTrash() (string, error) {
+    if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+        return filepath.Join(dir, "Trash"), nil
+    }
+    home, err := os.UserHomeDir()
+    if err != nil {
+        return "", err
+    }
+    return filepath.Join(home, ".local", "share", "Trash"), nil
+}
+
+// escapePath percent-encodes path the way the spec wants, keeping the slashes
+func escapePath(path string) string {
+    parts := strings.Split(path, "/")
+    for i, p := range parts {
+        parts[i] = url.PathEscape(p)
+    }
+    return strings.Join(parts, "/")
+}
@@ -2,8 +2,18 @@ package types
 
 import (
     "bytes"
+    "context"
+    "convert_cbz/internal/calibre"
+    "convert_cbz/internal/comicinfo"
+    "convert_cbz/internal/naming"
+    "fmt"
+    "io"
+    "path/filepath"
+    "sort"
+    "strconv"
     "strings"
     "sync"
+    "time"
 
     "github.com/jelius-sama/logger"
 )
@@ -16,6 +26,186 @@ type ConversionStats struct {
     Errors        int
     Skipped       int
     NonImageFiles int
+    Flagged       []FlaggedItem
+    Results       []ItemResult
+    Deferred      []WorkItem // Never dispatched because the run stopped early
+    SourceBytes   int64      // Size of the sources converted so far, for throughput
+    ErrorLimit    bool       // Stopped early because Options.MaxErrors items failed
+
+    // Active is the archive each worker is writing, by worker ID
+    Active map[int]ItemProgress
+}
+
+// ItemProgress is how far along the archive of one work item is
+type ItemProgress struct {
+    Worker     int    `json:"worker"`
+    FolderName string `json:"folder"`
+    Added      int    `json:"added"` // Files written to the archive so far
+    Total      int    `json:"total"` // Files the archive will hold
+}
+
+// Progress lists the archives being written, ordered by worker. The caller
+// must not hold the mutex.
+func (s *ConversionStats) Progress() []ItemProgress {
+    s.Mutex.Lock()
+    defer s.Mutex.Unlock()
+    list := make([]ItemProgress, 0, len(s.Active))
+    for _, p := range s.Active {
+        list = append(list, p)
+    }
+    sort.Slice(list, func(i, j int) bool { return list[i].Worker < list[j].Worker })
+    return list
+}
+
+// StatsSnapshot is a point-in-time copy of ConversionStats that is safe to pass around
+type StatsSnapshot struct {
+    Total         int `json:"total"`
+    Success       int `json:"success"`
+    Errors        int `json:"errors"`
+    Skipped       int `json:"skipped"`
+    NonImageFiles int `json:"non_image_files"`
+}
+
+// Snapshot copies the counters, the caller must not hold the mutex
+func (s *ConversionStats) Snapshot() StatsSnapshot {
+    s.Mutex.Lock()
+    defer s.Mutex.Unlock()
+    return StatsSnapshot{
+        Total:         s.Total,
+        Success:       s.Success,
+        Errors:        s.Errors,
+        Skipped:       s.Skipped,
+        NonImageFiles: s.NonImageFiles,
+    }
+}
+
+// EventType identifies what an Event reports
+type EventType string
+
+const (
+    EventItemStarted  EventType = "item_started"
+    EventFileAdded    EventType = "file_added"
+    EventItemFinished EventType = "item_finished"
+    EventItemSkipped  EventType = "item_skipped"
+    EventItemFailed   EventType = "item_failed"
+    EventStatsUpdated EventType = "stats_updated"
+)
+
+// Event is a structured progress notification emitted while processing.
+// Item fields are empty for EventStatsUpdated, Stats is only set for it.
+type Event struct {
+    Type       EventType      `json:"type"`
+    Time       time.Time      `json:"time"`
+    Worker     int            `json:"worker,omitempty"`
+    FolderName string         `json:"folder,omitempty"`
+    SourcePath string         `json:"source,omitempty"`
+    OutputPath string         `json:"output,omitempty"`
+    File       string         `json:"file,omitempty"`
+    Error      string         `json:"error,omitempty"`
+    Stats      *StatsSnapshot `json:"stats,omitempty"`
+}
+
+// StepKind is what a journal step did to an output library
+type StepKind string
+
+const (
+    StepCreate  StepKind = "create"  // Archive written at From where there was none
+    StepRewrite StepKind = "rewrite" // Archive at From replaced, the original kept at Backup if set
+    StepMove    StepKind = "move"    // Archive moved from From to To
+    StepDelete  StepKind = "delete"  // Archive at From deleted, kept at Backup if set
+
+    StepDeleteSource StepKind = "delete-source" // Source folder From deleted, To is all that's left of it
+    StepTrashSource  StepKind = "trash-source"  // Source folder From moved to the trash at To, if known
+)
+
+// JournalStep is one change to an output library, recorded before it is made
+type JournalStep struct {
+    Kind   StepKind `json:"kind"`
+    From   string   `json:"from"`
+    To     string   `json:"to,omitempty"`
+    Backup string   `json:"backup,omitempty"`
+    Source string   `json:"source,omitempty"` // Folder the archive was built from, when known
+}
+
+// SidecarExt is appended to an archive path to name its sidecar, which moves
+// along with the archive
+const SidecarExt = ".json"
+
+// OPFPath is where -opf puts the Calibre metadata of archive, the archive's
+// name with .opf instead of .cbz
+func OPFPath(archive string) string {
+    return strings.TrimSuffix(archive, filepath.Ext(archive)) + ".opf"
+}
+
+// Companions are the files written next to archive that move along with it,
+// whether or not they exist
+func Companions(archive string) []string {
+    return []string{archive + SidecarExt, OPFPath(archive)}
+}
+
+// BackupPath is where the original of path is kept while run id replaces or deletes it,
+// hidden so library scans and readers don't pick it up
+func BackupPath(path, id string) string {
+    return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+"."+id+".bak")
+}
+
+// ItemStatus is the outcome of a single work item
+type ItemStatus string
+
+const (
+    StatusConverted ItemStatus = "converted"
+    StatusSkipped   ItemStatus = "skipped"
+    StatusFailed    ItemStatus = "failed"
+    StatusDeferred  ItemStatus = "deferred" // Not started before the run was stopped
+    StatusRenamed   ItemStatus = "renamed"  // Archive moved to a new name by rename
+    StatusImported  ItemStatus = "imported" // Archive found in the library by history import
+    StatusRetired   ItemStatus = "retired"  // Archive moved out of the library by -keep-latest
+)
+
+// ItemResult records what happened to a single work item
+type ItemResult struct {
+    FolderName string     `json:"folder"`
+    SourcePath string     `json:"source"`
+    OutputPath string     `json:"output"`
+    Status     ItemStatus `json:"status"`
+    Pages      int        `json:"pages"`    // Files written into the archive
+    Bytes      int64      `json:"bytes"`    // Size of the archive written
+    Excluded   int        `json:"excluded"` // Files smart mode left out
+    Error      string     `json:"error,omitempty"`
+
+    SourceBytes   int64    `json:"source_bytes"`             // Size of the files archived
+    ExcludedFiles []string `json:"excluded_files,omitempty"` // Relative to the source folder
+    SeriesStatus  string   `json:"series_status,omitempty"`  // Publishing status from the series map
+    Fingerprint   string   `json:"fingerprint,omitempty"`    // Of the source files, as stored in the archive comment
+    Password      int      `json:"password,omitempty"`       // Number in the password list of the one that opened the archive
+    Stack         string   `json:"stack,omitempty"`          // Where the conversion panicked
+}
+
+// Ratio is the archive size relative to its source files, 0 when unknown
+func (r ItemResult) Ratio() float64 {
+    if r.SourceBytes == 0 || r.Bytes == 0 {
+        return 0
+    }
+    return float64(r.Bytes) / float64(r.SourceBytes)
+}
+
+// RunSummary is the machine-readable outcome of a run, printed by -json
+type RunSummary struct {
+    RunID     string         `json:"run_id"`
+    Started   time.Time      `json:"started"`
+    Finished  time.Time      `json:"finished"`
+    Duration  float64        `json:"duration"` // Seconds
+    OutputDir string         `json:"output_dir"`
+    Stats     StatsSnapshot  `json:"stats"`
+    Items     []ItemResult   `json:"items"`
+    Deferred  []ItemResult   `json:"deferred"` // Never started, left for -resume
+}
+
+// FlaggedItem is a folder where smart filtering dropped suspiciously many files
+type FlaggedItem struct {
+    FolderName string
+    Excluded   int
+    Scanned    int
 }
 
 // WorkItem represents a single conversion job
@@ -24,6 +214,222 @@ type WorkItem struct {
     SourcePath string
     OutputPath string
     DumbMode   bool
+
+    // Set by a library layout that knows them, otherwise the series is the
+    // folder the source is in and the volume comes from the folder name
+    Series string
+    Volume string
+
+    // NameSuffix is added to the archive name if a name template gives it
+    // the name of another item, set on chapters found more than once
+    NameSuffix string
+
+    // Archive is the CBZ repack unpacks into SourcePath before converting
+    // it, SourcePath is removed again afterwards. With Options.Extract it
+    // is unpacked into OutputPath and that is all.
+    Archive string
+
+    // Parts are the folders -merge combines into this one archive, in the
+    // order they are stored. SourcePath is then the folder that holds them.
+    // PartArchives are the CBZs join unpacks into the Parts first, one each,
+    // SourcePath is removed again afterwards.
+    Parts        []string
+    PartArchives []string
+
+    // SplitPattern is the -split-by-pattern expression that found Chapter in
+    // the names of some of the files of SourcePath, only those go into this
+    // archive
+    SplitPattern string
+    Chapter      string
+
+    // PageRange is the part of the files selected for this archive that
+    // -max-pages or -max-size left in it, from the first index up to the
+    // second, when they split the folder over several archives. Zero takes
+    // all of them.
+    PageRange [2]int
+}
+
+// SharesSource reports whether SourcePath holds more than this archive was
+// made from: the folder merged parts sit in, or one split over several
+// archives. Deleting or trashing it would take the others' pages with it.
+func (w WorkItem) SharesSource() bool {
+    return len(w.Parts) > 0 || w.Chapter != "" || w.PageRange != [2]int{}
+}
+
+// NoLogFile as Options.LogFile keeps the log of a run in memory only
+const NoLogFile = "-"
+
+// Options holds run-wide settings shared by every work item
+type Options struct {
+    Threads int
+    Quiet   bool // No spinner or terminal output, used when running as a server job
+
+    // Progress picks the live progress bar or plain log lines for terminal output
+    Progress ProgressMode
+
+    // Status asks for a snapshot of the run every time it fires, printed
+    // above the bar, among the plain log lines or on stderr next to JSON
+    Status <-chan struct{}
+
+    // ExcludeThreshold flags items where smart mode excluded more than this
+    // percentage of files, zero disables the check
+    ExcludeThreshold float64
+    Strict           bool // Fail flagged items and ones with unusual files instead of only warning
+    MaxEntries       int  // Fail folders with more files than this before archiving them, zero disables
+    MinImages        int  // Skip folders with fewer images than this, zero disables
+
+    // ExcludeDirs are patterns for subdirectories of a source folder that are
+    // never walked, matched against their path below it by util.MatchPath
+    ExcludeDirs []string
+
+    // IncludeExt and ExcludeExt are lower case extensions with their dot.
+    // Files with one of ExcludeExt are never archived, in dumb mode too, and
+    // smart mode keeps files with one of IncludeExt without sniffing them.
+    IncludeExt []string
+    ExcludeExt []string
+
+    // ImagesOnly archives nothing but files whose first bytes are those of
+    // an image, whatever their name, after the other filters
+    ImagesOnly bool
+
+    // Roots are the inputs the folders were found in. The .cbzignore files
+    // from the one a folder is in down to it apply to the folder as well as
+    // its own.
+    Roots []string
+
+    Overwrite   OverwriteMode
+    Fingerprint FingerprintMode // How sources are identified for if-different
+
+    // Update adds the files an existing archive doesn't hold yet to it,
+    // copying its entries over instead of skipping it, and rebuilds it when
+    // files it holds changed. Overwrite always still rebuilds every archive.
+    Update bool
+
+    // Extract unpacks the Archive of every item into a folder at OutputPath
+    // instead, the reverse of a conversion
+    Extract bool
+
+    // Passwords are tried in turn on every encrypted archive that is
+    // unpacked, by -extract, repack and join. The one that opened it is
+    // recorded as its number in the list.
+    Passwords []string
+
+    Sort        SortMode    // Order of the entries in every archive
+    RenamePages bool        // Store pages as 0001.jpg, 0002.jpg, ... with a manifest of the originals
+    Merge       MergeLayout // How the parts of a merged item are stored
+    Sidecar     bool        // Write <archive>.json with every page's name, size, dimensions and hash
+    DedupeLinks bool        // Store hard-linked files once, the others are recorded in the manifest
+    TextFiles   TextMode    // What becomes of the .txt, .nfo, ... files of a folder
+
+    // EntrySanitizer rewrites entry names Windows can't extract, nil keeps them
+    EntrySanitizer *naming.Sanitizer
+    Normalize      NormMode // Unicode form entry names are stored in
+    CP437Fallback  bool     // Header names transliterated to CP437, the real ones in Unicode Path fields
+    Reproducible   bool     // Fixed entry times and modes, rebuilding the same source gives the same bytes
+
+    // MaxEntryLength and MaxEntryDepth cap entry names in bytes and the
+    // folders they are nested in, for readers that fail on longer ones.
+    // Deeper folders are folded together and long names shortened, zero
+    // disables either.
+    MaxEntryLength int
+    MaxEntryDepth  int
+
+    // Journal, when set, is told about every archive before it is created or
+    // replaced, an error fails the item instead. KeepReplaced keeps the
+    // archives being replaced next to them, named .<name>.<run id>.bak.
+    Journal      func(JournalStep) error
+    KeepReplaced bool
+
+    // Verify reads every archive back before it is moved into place, failing
+    // the item when an entry is corrupt or a source file missing.
+    // DeleteSource removes the files of a folder once its archive has been
+    // verified, leaving whatever the archive doesn't hold.
+    // TrashSource moves the whole folder to the trash instead, or into
+    // TrashDir when that is set.
+    Verify       bool
+    DeleteSource bool
+    TrashSource  bool
+    TrashDir     string
+
+    // WORM makes every archive read-only once it has been verified,
+    // Immutable also immutable where the OS supports it. Read-only archives
+    // are never replaced or updated unless Force is set, whoever sealed them.
+    WORM      bool
+    Immutable bool
+    Force     bool
+
+    // Cover is a glob for the file placed first in every archive, empty picks
+    // a cover* or volume* image and "none" keeps name order
+    Cover string
+
+    // PreHook runs before the files of every folder are read, so it can
+    // change them, and PostHook once the item converted, was skipped or
+    // failed. Both are shell commands, told about the item in CBZ_HOOK_*
+    // variables. A failing pre-hook fails the item, a failing post-hook is
+    // only a warning.
+    PreHook  string
+    PostHook string
+
+    // Prefetch is how many upcoming folders are walked and sniffed ahead of
+    // the workers, zero disables read-ahead
+    Prefetch int
+
+    // LowPower cuts concurrency while on battery or running hot
+    LowPower bool
+
+    // MaxDuration stops dispatching new items once it has elapsed, zero means no limit
+    MaxDuration time.Duration
+
+    // MaxErrors stops dispatching new items once this many have failed, an
+    // early systemic problem like a full disk fails everything after it.
+    // Zero means no limit.
+    MaxErrors int
+
+    // RunID names the log file, so simultaneous runs never write the same one
+    RunID string
+
+    // Abort, once cancelled, stops the archives being written and removes their
+    // partial output. Nil lets in-flight archives always finish.
+    Abort context.Context
+
+    // ComicInfo adds a generated ComicInfo.xml to archives whose folder has
+    // none, Titles reads chapter titles and numbers from folder names for it
+    // and Series names the series by the folder they are in. Classification
+    // is the genre, tags and age rating of every archive, unless the series
+    // map sets its own.
+    ComicInfo      bool
+    Titles         *comicinfo.TitleParser
+    Series         *comicinfo.SeriesMap
+    Classification comicinfo.Classification
+
+    // OPF writes the Calibre metadata of every archive converted next to it,
+    // Calibre adds it to that library with calibredb. Both use the
+    // ComicInfo.xml in the archive, or the one -comicinfo would generate.
+    OPF     bool
+    Calibre *calibre.Library
+
+    // ComicBookInfo makes the archive comment a ComicBookInfo object with the
+    // details of the ComicInfo.xml, for readers that don't know the latter
+    ComicBookInfo bool
+
+    // LogFormat is how per-item log lines are written, LogOutput additionally
+    // receives them (nil keeps them in the log file only): each item's lines in
+    // one block once it is done, or with Verbose every line as it happens
+    LogFormat LogFormat
+    LogOutput io.Writer
+    Verbose   bool
+
+    // LogFile is where the log of the run is written once it is done, empty
+    // for /tmp/convert-cbz/<run ID>.log and NoLogFile for nowhere
+    LogFile string
+
+    // OnEvent receives progress events. It is called from worker goroutines
+    // concurrently and must not block for long.
+    OnEvent func(Event)
+
+    // OnResult receives the result of every item once it is recorded, from
+    // the worker that processed it. Items left for the next run have none.
+    OnResult func(ItemResult)
 }
 
 // StringSliceFlag allows multiple string flags
@@ -38,6 +444,44 @@ func (s *StringSliceFlag) Set(value string) error {
     return nil
 }
 
+// ByteSize is a size flag like 300MB or 1.5GB, units are powers of 1024
+type ByteSize int64
+
+var byteUnits = []struct {
+    suffix string
+    size   int64
+}{
+    {"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+    {"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+    {"B", 1},
+}
+
+func (b *ByteSize) String() string {
+    for _, u := range byteUnits[:4] {
+        if int64(*b) >= u.size && int64(*b)%u.size == 0 {
+            return strconv.FormatInt(int64(*b)/u.size, 10) + u.suffix
+        }
+    }
+    return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *ByteSize) Set(value string) error {
+    s := strings.ToUpper(strings.TrimSpace(value))
+    unit := int64(1)
+    for _, u := range byteUnits {
+        if rest, ok := strings.CutSuffix(s, u.suffix); ok {
+            s, unit = strings.TrimSpace(rest), u.size
+            break
+        }
+    }
+    n, err := strconv.ParseFloat(s, 64)
+    if err != nil || n < 0 {
+        return fmt.Errorf("%q is not a size like 300MB", value)
+    }
+    *b = ByteSize(n * float64(unit))
+    return nil
+}
+
 type SafeWriter struct {
     Mutex  sync.Mutex
     Buffer bytes.Buffer
@@ -59,8 +503,8 @@ const (
     CKey
 )
 
-func (cm CompressionMode) Set(value string) error {
-    cm = ToCompressionMode(value)
+func (cm *CompressionMode) Set(value string) error {
+    *cm = ToCompressionMode(value)
     return nil
 }
 
@@ -100,3 +544,615 @@ func (cm CompressionMode) String() string {
     }
 }
 
+// OverwriteMode decides what happens when an output archive already exists
+type OverwriteMode uint8
+
+const (
+    OverwriteSkip OverwriteMode = iota
+    OverwriteAlways
+    OverwriteIfDifferent
+)
+
+func (om *OverwriteMode) Set(value string) error {
+    *om = ToOverwriteMode(value)
+    return nil
+}
+
+func ToOverwriteMode(om string) OverwriteMode {
+    switch om {
+    case OverwriteSkip.String():
+        return OverwriteSkip
+    case OverwriteAlways.String():
+        return OverwriteAlways
+    case OverwriteIfDifferent.String():
+        return OverwriteIfDifferent
+    default:
+        logger.Warning("Undefined overwrite mode used, defaulting to \"skip\".")
+        return OverwriteSkip
+    }
+}
+
+func (om OverwriteMode) String() string {
+    switch om {
+    case OverwriteSkip:
+        return "skip"
+    case OverwriteAlways:
+        return "always"
+    case OverwriteIfDifferent:
+        return "if-different"
+    default:
+        logger.Warning("Undefined overwrite mode used, defaulting to \"skip\".")
+        return "skip"
+    }
+}
+
+// FingerprintMode selects how a source folder is identified for change detection
+type FingerprintMode uint8
+
+const (
+    FingerprintMeta FingerprintMode = iota
+    FingerprintContent
+)
+
+func (fm *FingerprintMode) Set(value string) error {
+    *fm = ToFingerprintMode(value)
+    return nil
+}
+
+func ToFingerprintMode(fm string) FingerprintMode {
+    switch fm {
+    case FingerprintMeta.String():
+        return FingerprintMeta
+    case FingerprintContent.String():
+        return FingerprintContent
+    default:
+        logger.Warning("Undefined fingerprint mode used, defaulting to \"meta\".")
+        return FingerprintMeta
+    }
+}
+
+func (fm FingerprintMode) String() string {
+    switch fm {
+    case FingerprintMeta:
+        return "meta"
+    case FingerprintContent:
+        return "content"
+    default:
+        logger.Warning("Undefined fingerprint mode used, defaulting to \"meta\".")
+        return "meta"
+    }
+}
+
+// CaseMode decides whether paths are compared case-insensitively
+type CaseMode uint8
+
+const (
+    CaseAuto CaseMode = iota
+    CaseSensitive
+    CaseInsensitive
+)
+
+func (cm *CaseMode) Set(value string) error {
+    *cm = ToCaseMode(value)
+    return nil
+}
+
+func ToCaseMode(cm string) CaseMode {
+    switch cm {
+    case CaseAuto.String():
+        return CaseAuto
+    case CaseSensitive.String():
+        return CaseSensitive
+    case CaseInsensitive.String():
+        return CaseInsensitive
+    default:
+        logger.Warning("Undefined case mode used, defaulting to \"auto\".")
+        return CaseAuto
+    }
+}
+
+func (cm CaseMode) String() string {
+    switch cm {
+    case CaseAuto:
+        return "auto"
+    case CaseSensitive:
+        return "sensitive"
+    case CaseInsensitive:
+        return "insensitive"
+    default:
+        logger.Warning("Undefined case mode used, defaulting to \"auto\".")
+        return "auto"
+    }
+}
+
+// SortMode is the order files are added to an archive in
+type SortMode uint8
+
+const (
+    SortNatural SortMode = iota // Numbers compare by value, page2 before page10
+    SortLexical                 // Plain byte order, page10 before page2
+)
+
+func (sm *SortMode) Set(value string) error {
+    *sm = ToSortMode(value)
+    return nil
+}
+
+func ToSortMode(sm string) SortMode {
+    switch sm {
+    case SortNatural.String():
+        return SortNatural
+    case SortLexical.String():
+        return SortLexical
+    default:
+        logger.Warning("Undefined sort mode used, defaulting to \"natural\".")
+        return SortNatural
+    }
+}
+
+func (sm SortMode) String() string {
+    switch sm {
+    case SortNatural:
+        return "natural"
+    case SortLexical:
+        return "lexical"
+    default:
+        logger.Warning("Undefined sort mode used, defaulting to \"natural\".")
+        return "natural"
+    }
+}
+
+// NormMode is the Unicode normalization form names are written in
+type NormMode uint8
+
+const (
+    NormNone NormMode = iota // Names are kept byte for byte
+    NormNFC                  // Composed, what Linux tools and most servers expect
+    NormNFD                  // Decomposed, what older macOS filesystems produce
+)
+
+func (nm *NormMode) Set(value string) error {
+    *nm = ToNormMode(value)
+    return nil
+}
+
+func ToNormMode(nm string) NormMode {
+    switch nm {
+    case NormNone.String():
+        return NormNone
+    case NormNFC.String():
+        return NormNFC
+    case NormNFD.String():
+        return NormNFD
+    default:
+        logger.Warning("Undefined normalization form used, defaulting to \"none\".")
+        return NormNone
+    }
+}
+
+func (nm NormMode) String() string {
+    switch nm {
+    case NormNone:
+        return "none"
+    case NormNFC:
+        return "nfc"
+    case NormNFD:
+        return "nfd"
+    default:
+        logger.Warning("Undefined normalization form used, defaulting to \"none\".")
+        return "none"
+    }
+}
+
+// LibraryLayout is how the folders under a library input are organised
+type LibraryLayout uint8
+
+const (
+    LayoutFlat                LibraryLayout = iota // Inputs are converted as -recursive and -input say
+    LayoutSeriesVolumeChapter                      // Library/Series/Volume/Chapter, one archive per chapter
+)
+
+func (ll *LibraryLayout) Set(value string) error {
+    *ll = ToLibraryLayout(value)
+    return nil
+}
+
+func ToLibraryLayout(ll string) LibraryLayout {
+    switch ll {
+    case LayoutFlat.String():
+        return LayoutFlat
+    case LayoutSeriesVolumeChapter.String():
+        return LayoutSeriesVolumeChapter
+    default:
+        logger.Warning("Undefined library layout used, defaulting to \"flat\".")
+        return LayoutFlat
+    }
+}
+
+func (ll LibraryLayout) String() string {
+    switch ll {
+    case LayoutFlat:
+        return "flat"
+    case LayoutSeriesVolumeChapter:
+        return "series/volume/chapter"
+    default:
+        logger.Warning("Undefined library layout used, defaulting to \"flat\".")
+        return "flat"
+    }
+}
+
+// NestedMode is how recursive inputs more than one level deep are converted,
+// like Series/Volume/Chapter trees
+type NestedMode uint8
+
+const (
+    NestedNone         NestedMode = iota // Every subfolder of an input is one archive, whatever it holds
+    NestedFlatten                        // One archive per leaf folder, named after its path below the input
+    NestedMergeVolumes                   // One archive per folder of leaf folders, which stay subfolders
+    NestedMirror                         // One archive per leaf folder, in the folders it is in below the input
+)
+
+func (nm *NestedMode) Set(value string) error {
+    *nm = ToNestedMode(value)
+    return nil
+}
+
+func ToNestedMode(nm string) NestedMode {
+    switch nm {
+    case NestedNone.String():
+        return NestedNone
+    case NestedFlatten.String():
+        return NestedFlatten
+    case NestedMergeVolumes.String():
+        return NestedMergeVolumes
+    case NestedMirror.String():
+        return NestedMirror
+    default:
+        logger.Warning("Undefined nested mode used, defaulting to \"none\".")
+        return NestedNone
+    }
+}
+
+func (nm NestedMode) String() string {
+    switch nm {
+    case NestedNone:
+        return "none"
+    case NestedFlatten:
+        return "flatten"
+    case NestedMergeVolumes:
+        return "merge-volumes"
+    case NestedMirror:
+        return "mirror"
+    default:
+        logger.Warning("Undefined nested mode used, defaulting to \"none\".")
+        return "none"
+    }
+}
+
+// MergeLayout is how the folders merged into one archive are stored in it
+type MergeLayout uint8
+
+const (
+    MergeFolders MergeLayout = iota // A subdirectory per folder, its pages as they are
+    MergeFlat                       // Every page at the root, numbered across all folders
+)
+
+func (ml *MergeLayout) Set(value string) error {
+    *ml = ToMergeLayout(value)
+    return nil
+}
+
+func ToMergeLayout(ml string) MergeLayout {
+    switch ml {
+    case MergeFolders.String():
+        return MergeFolders
+    case MergeFlat.String():
+        return MergeFlat
+    default:
+        logger.Warning("Undefined merge layout used, defaulting to \"folders\".")
+        return MergeFolders
+    }
+}
+
+func (ml MergeLayout) String() string {
+    switch ml {
+    case MergeFolders:
+        return "folders"
+    case MergeFlat:
+        return "flat"
+    default:
+        logger.Warning("Undefined merge layout used, defaulting to \"folders\".")
+        return "folders"
+    }
+}
+
+// MergeOrder is the order folders are merged in
+type MergeOrder uint8
+
+const (
+    MergeNatural MergeOrder = iota // By folder name, Chapter 2 before Chapter 10
+    MergeInput                     // As the inputs were given and collected
+)
+
+func (mo *MergeOrder) Set(value string) error {
+    *mo = ToMergeOrder(value)
+    return nil
+}
+
+func ToMergeOrder(mo string) MergeOrder {
+    switch mo {
+    case MergeNatural.String():
+        return MergeNatural
+    case MergeInput.String():
+        return MergeInput
+    default:
+        logger.Warning("Undefined merge order used, defaulting to \"natural\".")
+        return MergeNatural
+    }
+}
+
+func (mo MergeOrder) String() string {
+    switch mo {
+    case MergeNatural:
+        return "natural"
+    case MergeInput:
+        return "input"
+    default:
+        logger.Warning("Undefined merge order used, defaulting to \"natural\".")
+        return "natural"
+    }
+}
+
+// TextMode is what becomes of the text files going into an archive, the
+// readme, .nfo and release notes some readers list among the pages
+type TextMode uint8
+
+const (
+    TextKeep  TextMode = iota // Stored as they are
+    TextMerge                 // Combined into a single info.txt at the root
+    TextNotes                 // Written into the Notes of the ComicInfo.xml
+)
+
+func (tm *TextMode) Set(value string) error {
+    *tm = ToTextMode(value)
+    return nil
+}
+
+func ToTextMode(tm string) TextMode {
+    switch tm {
+    case TextKeep.String():
+        return TextKeep
+    case TextMerge.String():
+        return TextMerge
+    case TextNotes.String():
+        return TextNotes
+    default:
+        logger.Warning("Undefined text file mode used, defaulting to \"keep\".")
+        return TextKeep
+    }
+}
+
+func (tm TextMode) String() string {
+    switch tm {
+    case TextKeep:
+        return "keep"
+    case TextMerge:
+        return "merge"
+    case TextNotes:
+        return "notes"
+    default:
+        logger.Warning("Undefined text file mode used, defaulting to \"keep\".")
+        return "keep"
+    }
+}
+
+// ProgressMode decides how a run shows its progress on stdout
+type ProgressMode uint8
+
+const (
+    ProgressAuto  ProgressMode = iota // Bar on a terminal, plain lines otherwise
+    ProgressBar                       // Live progress bar with throughput and ETA
+    ProgressPlain                     // Per-folder log lines and a periodic status line
+)
+
+func (pm *ProgressMode) Set(value string) error {
+    *pm = ToProgressMode(value)
+    return nil
+}
+
+func ToProgressMode(pm string) ProgressMode {
+    switch pm {
+    case ProgressAuto.String():
+        return ProgressAuto
+    case ProgressBar.String():
+        return ProgressBar
+    case ProgressPlain.String():
+        return ProgressPlain
+    default:
+        logger.Warning("Undefined progress mode used, defaulting to \"auto\".")
+        return ProgressAuto
+    }
+}
+
+func (pm ProgressMode) String() string {
+    switch pm {
+    case ProgressAuto:
+        return "auto"
+    case ProgressBar:
+        return "bar"
+    case ProgressPlain:
+        return "plain"
+    default:
+        logger.Warning("Undefined progress mode used, defaulting to \"auto\".")
+        return "auto"
+    }
+}
+
+// LogRecord is one line of the per-item log
+type LogRecord struct {
+    Time     time.Time      `json:"time"`
+    Level    string         `json:"level"` // info, ok, warn or error
+    Msg      string         `json:"msg"`
+    Worker   int            `json:"worker,omitempty"`
+    Folder   string         `json:"folder,omitempty"`
+    Output   string         `json:"output,omitempty"`
+    Duration float64        `json:"duration,omitempty"` // Seconds the item took, on its final record
+    Error    string         `json:"error,omitempty"`
+    Stack    string         `json:"stack,omitempty"` // Of a recovered panic
+    Stats    *StatsSnapshot `json:"stats,omitempty"`
+}
+
+// Text formats the record the way the plain text log always looked
+func (r LogRecord) Text() string {
+    labels := map[string]string{"info": "INFO", "ok": "OK", "warn": "WARN", "error": "ERROR"}
+    line := "[" + labels[r.Level] + "] "
+    if r.Worker > 0 {
+        line += fmt.Sprintf("[WORKER %d] ", r.Worker)
+    }
+    line += r.Msg
+    if r.Error != "" {
+        line += ": " + r.Error
+    }
+    if r.Stack != "" {
+        line += "\n    " + strings.ReplaceAll(strings.TrimRight(r.Stack, "\n"), "\n", "\n    ")
+    }
+    return line
+}
+
+// LogFormat selects between plain text and JSON log lines
+type LogFormat uint8
+
+const (
+    LogText LogFormat = iota
+    LogJSON
+)
+
+func (lf *LogFormat) Set(value string) error {
+    *lf = ToLogFormat(value)
+    return nil
+}
+
+func ToLogFormat(lf string) LogFormat {
+    switch lf {
+    case LogText.String():
+        return LogText
+    case LogJSON.String():
+        return LogJSON
+    default:
+        logger.Warning("Undefined log format used, defaulting to \"text\".")
+        return LogText
+    }
+}
+
+func (lf LogFormat) String() string {
+    switch lf {
+    case LogText:
+        return "text"
+    case LogJSON:
+        return "json"
+    default:
+        logger.Warning("Undefined log format used, defaulting to \"text\".")
+        return "text"
+    }
+}
+
+// DuplicatePolicy decides what happens when several folders hold the same
+// chapter: the same series, volume and number
+type DuplicatePolicy uint8
+
+const (
+    DuplicatesKeep   DuplicatePolicy = iota // Convert them all, as named
+    DuplicatesAsk                           // Ask which to keep when run from a terminal
+    DuplicatesLarger                        // Keep the folder with the most bytes
+    DuplicatesNewer                         // Keep the most recently modified folder
+    DuplicatesSuffix                        // Keep them all, numbering names that would collide
+)
+
+func (dp *DuplicatePolicy) Set(value string) error {
+    *dp = ToDuplicatePolicy(value)
+    return nil
+}
+
+func ToDuplicatePolicy(dp string) DuplicatePolicy {
+    switch dp {
+    case DuplicatesKeep.String():
+        return DuplicatesKeep
+    case DuplicatesAsk.String():
+        return DuplicatesAsk
+    case DuplicatesLarger.String():
+        return DuplicatesLarger
+    case DuplicatesNewer.String():
+        return DuplicatesNewer
+    case DuplicatesSuffix.String():
+        return DuplicatesSuffix
+    default:
+        logger.Warning("Undefined duplicate policy used, defaulting to \"keep\".")
+        return DuplicatesKeep
+    }
+}
+
+func (dp DuplicatePolicy) String() string {
+    switch dp {
+    case DuplicatesKeep:
+        return "keep"
+    case DuplicatesAsk:
+        return "ask"
+    case DuplicatesLarger:
+        return "larger"
+    case DuplicatesNewer:
+        return "newer"
+    case DuplicatesSuffix:
+        return "suffix"
+    default:
+        logger.Warning("Undefined duplicate policy used, defaulting to \"keep\".")
+        return "keep"
+    }
+}
+
+// CollisionPolicy decides what happens when several inputs would be written
+// to the same output
+type CollisionPolicy uint8
+
+const (
+    CollisionSkip   CollisionPolicy = iota // The first one wins, the others are skipped with a warning
+    CollisionError                         // Nothing is written, the run stops naming them
+    CollisionSuffix                        // The others get their parent folder or " (2)", " (3)", ... added to their names
+    CollisionMerge                         // The folders go into one archive together, conversions only
+)
+
+func (cp *CollisionPolicy) Set(value string) error {
+    *cp = ToCollisionPolicy(value)
+    return nil
+}
+
+func ToCollisionPolicy(cp string) CollisionPolicy {
+    switch cp {
+    case CollisionSkip.String():
+        return CollisionSkip
+    case CollisionError.String():
+        return CollisionError
+    case CollisionSuffix.String():
+        return CollisionSuffix
+    case CollisionMerge.String():
+        return CollisionMerge
+    default:
+        logger.Warning("Undefined collision policy used, defaulting to \"skip\".")
+        return CollisionSkip
+    }
+}
+
+func (cp CollisionPolicy) String() string {
+    switch cp {
+    case CollisionSkip:
+        return "skip"
+    case CollisionError:
+        return "error"
+    case CollisionSuffix:
+        return "suffix"
+    case CollisionMerge:
+        return "merge"
+    default:
+        logger.Warning("Undefined collision policy used, defaulting to \"skip\".")
+        return "skip"
+    }
+}
@@ -0,0 +1,131 @@
+package util
+
+import (
+    "errors"
+    "io/fs"
+    "path"
+    "path/filepath"
+    "slices"
+    "sort"
+    "strings"
+)
+
+// MatchPath reports whether the slash separated relative path name matches
+// pattern. Segments match like path.Match, and a "**" segment matches any
+// number of them, none included, so "raw/**" matches raw and everything
+// below it. A pattern without a slash matches the last segment at any depth.
+// Both are compared case-insensitively.
+func MatchPath(pattern, name string) bool {
+    pattern, name = strings.ToLower(pattern), strings.ToLower(name)
+    if !strings.Contains(pattern, "/") {
+        ok, _ := path.Match(pattern, path.Base(name))
+        return ok
+    }
+    return matchSegments(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+    for len(pattern) > 0 {
+        if pattern[0] == "**" {
+            for i := 0; i <= len(name); i++ {
+                if matchSegments(pattern[1:], name[i:]) {
+                    return true
+                }
+            }
+            return false
+        }
+        if len(name) == 0 {
+            return false
+        }
+        if ok, _ := path.Match(pattern[0], name[0]); !ok {
+            return false
+        }
+        pattern, name = pattern[1:], name[1:]
+    }
+    return len(name) == 0
+}
+
+// ValidPattern checks pattern for the syntax errors MatchPath would ignore
+func ValidPattern(pattern string) error {
+    for seg := range strings.SplitSeq(pattern, "/") {
+        if _, err := path.Match(seg, ""); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// HasMeta reports whether pattern holds any of the characters path.Match
+// treats specially
+func HasMeta(pattern string) bool {
+    return strings.ContainsAny(pattern, `*?[`)
+}
+
+// Glob returns the paths matching pattern, a path whose segments match like
+// MatchPath's, "**" included, in natural order. A trailing slash matches
+// folders only. Hidden files and folders, starting with a dot, are left out.
+func Glob(pattern string) ([]string, error) {
+    if err := ValidPattern(filepath.ToSlash(pattern)); err != nil {
+        return nil, err
+    }
+    segments := strings.Split(filepath.ToSlash(pattern), "/")
+    dirsOnly := segments[len(segments)-1] == ""
+    if dirsOnly {
+        segments = segments[:len(segments)-1]
+    }
+
+    // The segments up to the first one with a wildcard are a plain folder
+    first := slices.IndexFunc(segments, HasMeta)
+    if first < 0 {
+        return []string{pattern}, nil
+    }
+    base := strings.Join(segments[:first], "/")
+    if base == "" && first > 0 {
+        base = "/"
+    } else if base == "" {
+        base = "."
+    }
+    rest := segments[first:]
+    deep := slices.Contains(rest, "**")
+
+    var matches []string
+    err := filepath.WalkDir(filepath.FromSlash(base), func(p string, d fs.DirEntry, err error) error {
+        if err != nil {
+            if p == filepath.FromSlash(base) {
+                return err
+            }
+            return nil
+        }
+        rel, _ := filepath.Rel(filepath.FromSlash(base), p)
+        if rel == "." {
+            return nil
+        }
+        if strings.HasPrefix(d.Name(), ".") {
+            if d.IsDir() {
+                return filepath.SkipDir
+            }
+            return nil
+        }
+        rel = filepath.ToSlash(rel)
+        if (d.IsDir() || !dirsOnly) && matchSegments(lowerAll(rest), strings.Split(strings.ToLower(rel), "/")) {
+            matches = append(matches, p)
+        }
+        if d.IsDir() && !deep && strings.Count(rel, "/")+1 >= len(rest) {
+            return filepath.SkipDir
+        }
+        return nil
+    })
+    if errors.Is(err, fs.ErrNotExist) {
+        err = nil
+    }
+    sort.SliceStable(matches, func(i, j int) bool { return NaturalLess(matches[i], matches[j]) })
+    return matches, err
+}
+
+func lowerAll(segments []string) []string {
+    lower := make([]string, len(segments))
+    for i, s := range segments {
+        lower[i] = strings.ToLower(s)
+    }
+    return lower
+}
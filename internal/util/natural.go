@@ -0,0 +1,64 @@
+package util
+
+// NaturalLess orders strings the way people count: runs of digits compare by
+// their value, so "page2.jpg" sorts before "page10.jpg". Everything else
+// compares byte by byte, like sort.Strings.
+func NaturalLess(a, b string) bool {
+    for a != "" && b != "" {
+        if isDigit(a[0]) && isDigit(b[0]) {
+            na, ra := digitRun(a)
+            nb, rb := digitRun(b)
+            if c := compareNumbers(na, nb); c != 0 {
+                return c < 0
+            }
+            // Same value, fewer leading zeros first keeps the order total
+            if len(na) != len(nb) {
+                return len(na) < len(nb)
+            }
+            a, b = ra, rb
+            continue
+        }
+        if a[0] != b[0] {
+            return a[0] < b[0]
+        }
+        a, b = a[1:], b[1:]
+    }
+    return len(a) < len(b)
+}
+
+func isDigit(c byte) bool {
+    return c >= '0' && c <= '9'
+}
+
+func digitRun(s string) (string, string) {
+    i := 0
+    for i < len(s) && isDigit(s[i]) {
+        i++
+    }
+    return s[:i], s[i:]
+}
+
+// compareNumbers compares two runs of digits by value, however long they are
+func compareNumbers(a, b string) int {
+    ta, tb := trimLeadingZeros(a), trimLeadingZeros(b)
+    if len(ta) != len(tb) {
+        if len(ta) < len(tb) {
+            return -1
+        }
+        return 1
+    }
+    switch {
+    case ta < tb:
+        return -1
+    case ta > tb:
+        return 1
+    }
+    return 0
+}
+
+func trimLeadingZeros(s string) string {
+    for len(s) > 1 && s[0] == '0' {
+        s = s[1:]
+    }
+    return s
+}
@@ -0,0 +1,30 @@
+package util
+
+import (
+    "convert_cbz/internal/types"
+    "path/filepath"
+
+    "golang.org/x/text/unicode/norm"
+)
+
+// Normalize puts s into the Unicode normalization form of mode
+func Normalize(s string, mode types.NormMode) string {
+    switch mode {
+    case types.NormNFC:
+        return norm.NFC.String(s)
+    case types.NormNFD:
+        return norm.NFD.String(s)
+    default:
+        return s
+    }
+}
+
+// NormalizeUnder normalizes the part of path below dir, dir itself is left
+// alone since it has to stay the directory that exists on disk
+func NormalizeUnder(dir, path string, mode types.NormMode) string {
+    rel, err := filepath.Rel(dir, path)
+    if mode == types.NormNone || err != nil {
+        return path
+    }
+    return filepath.Join(dir, Normalize(rel, mode))
+}
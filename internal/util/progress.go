@@ -15,13 +15,24 @@ type Spinner struct {
     total   int
     current atomic.Value // current item name
     done    chan struct{}
+    reports chan report
+    lines   int // Lines the last render took, to move back over them
+}
+
+// report is text to print above the bar, echoed when the Enter that asked
+// for it moved the cursor a line down
+type report struct {
+    text   string
+    echoed bool
 }
 
 func NewSpinner(stats *types.ConversionStats, total int) *Spinner {
     s := &Spinner{
-        stats: stats,
-        total: total,
-        done:  make(chan struct{}),
+        stats:   stats,
+        total:   total,
+        done:    make(chan struct{}),
+        reports: make(chan report),
+        lines:   3,
     }
     s.current.Store("")
     return s
@@ -45,6 +56,13 @@ func (s *Spinner) Start() {
                 s.render(frame, time.Since(start), true)
                 fmt.Println()
                 return
+            case r := <-s.reports:
+                // Printed where the bar was, the next render goes below it
+                up := s.lines
+                if r.echoed {
+                    up++
+                }
+                fmt.Printf("\033[%dA\033[J%s\n%s", up, r.text, strings.Repeat("\n", s.lines))
             default:
                 s.render(frame, time.Since(start), false)
                 frame = (frame + 1) % len(spinnerFrames)
@@ -54,6 +72,15 @@ func (s *Spinner) Start() {
     }()
 }
 
+// Report prints text above the bar. echoed says the cursor moved down a
+// line since the last render, from an Enter typed on the terminal.
+func (s *Spinner) Report(text string, echoed bool) {
+    select {
+    case s.reports <- report{text: text, echoed: echoed}:
+    case <-s.done:
+    }
+}
+
 func (s *Spinner) Stop() {
     close(s.done)
     time.Sleep(120 * time.Millisecond) // let final render flush
@@ -64,6 +91,7 @@ func (s *Spinner) render(frame int, elapsed time.Duration, final bool) {
     done := s.stats.Success + s.stats.Errors + s.stats.Skipped
     success := s.stats.Success
     errors := s.stats.Errors
+    bytes := s.stats.SourceBytes
     s.stats.Mutex.Unlock()
 
     sp := spinnerFrames[frame]
@@ -79,6 +107,7 @@ func (s *Spinner) render(frame int, elapsed time.Duration, final bool) {
         remaining := perItem * time.Duration(s.total-done)
         eta = fmt.Sprintf("  eta %s", FmtDuration(remaining))
     }
+    eta = "  " + Throughput(bytes, done, elapsed) + eta
 
     // Progress bar (30 chars wide)
     const barWidth = 30
@@ -91,11 +120,17 @@ func (s *Spinner) render(frame int, elapsed time.Duration, final bool) {
         counts += fmt.Sprintf("  \033[31m✗ %d failed\033[0m", errors)
     }
 
-    // Current item
-    current := s.current.Load().(string)
+    // Current item, or the largest archive being written so a huge folder
+    // visibly moves along
+    current := TruncateName(s.current.Load().(string))
+    if p, ok := largest(s.stats.Progress()); ok {
+        current = fmt.Sprintf("%s  %d/%d files", TruncateName(p.FolderName), p.Added, p.Total)
+    }
     currentLine := ""
+    lines := 3
     if !final && current != "" {
         currentLine = fmt.Sprintf("\n  \033[2m%s  %s\033[0m", sp, current)
+        lines++
     }
 
     prefix := fmt.Sprintf("\033[35m%s\033[0m", sp)
@@ -104,8 +139,9 @@ func (s *Spinner) render(frame int, elapsed time.Duration, final bool) {
         eta = fmt.Sprintf("  done in %s", FmtDuration(elapsed))
     }
 
-    // Move cursor up to overwrite previous render (3 lines)
-    fmt.Print("\033[3A\033[J")
+    // Move cursor up to overwrite previous render
+    fmt.Printf("\033[%dA\033[J", s.lines)
+    s.lines = lines
     fmt.Printf(
         "%s converting \033[35m%d/%d\033[0m folders\n  \033[35m%s\033[0m \033[90m%3.0f%%%s\033[0m\n  %s%s\n",
         prefix, done, s.total,
@@ -114,3 +150,107 @@ func (s *Spinner) render(frame int, elapsed time.Duration, final bool) {
     )
 }
 
+// Throughput formats how fast a run is going, in source megabytes and folders
+func Throughput(bytes int64, folders int, elapsed time.Duration) string {
+    secs := max(elapsed.Seconds(), 0.001)
+    return fmt.Sprintf("%.1f MB/s  %.1f folders/min", float64(bytes)/(1<<20)/secs, float64(folders)/secs*60)
+}
+
+const statusInterval = 10 * time.Second
+
+// StatusLine prints a progress line every few seconds, the stand-in for the
+// spinner when stdout is not a terminal
+type StatusLine struct {
+    stats *types.ConversionStats
+    total int
+    start time.Time
+    done  chan struct{}
+}
+
+func NewStatusLine(stats *types.ConversionStats, total int) *StatusLine {
+    return &StatusLine{
+        stats: stats,
+        total: total,
+        done:  make(chan struct{}),
+    }
+}
+
+func (s *StatusLine) Start() {
+    s.start = time.Now()
+    go func() {
+        ticker := time.NewTicker(statusInterval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-s.done:
+                return
+            case <-ticker.C:
+                fmt.Println(s.line())
+            }
+        }
+    }()
+}
+
+func (s *StatusLine) Stop() {
+    close(s.done)
+}
+
+func (s *StatusLine) line() string {
+    s.stats.Mutex.Lock()
+    done := s.stats.Success + s.stats.Errors + s.stats.Skipped
+    errors := s.stats.Errors
+    bytes := s.stats.SourceBytes
+    s.stats.Mutex.Unlock()
+
+    elapsed := time.Since(s.start)
+    line := fmt.Sprintf("[PROGRESS] %d/%d folders", done, s.total)
+    if errors > 0 {
+        line += fmt.Sprintf(", %d failed", errors)
+    }
+    line += ", " + Throughput(bytes, done, elapsed)
+    if done > 0 && done < s.total {
+        line += ", eta " + FmtDuration(elapsed/time.Duration(done)*time.Duration(s.total-done))
+    }
+    for _, p := range s.stats.Progress() {
+        line += fmt.Sprintf("; %s %d/%d files", p.FolderName, p.Added, p.Total)
+    }
+    return line
+}
+
+// StatusReport is the snapshot printed when one is asked for mid-run: the
+// counts, throughput and what every busy worker is writing
+func StatusReport(stats *types.ConversionStats, total int, elapsed time.Duration) string {
+    stats.Mutex.Lock()
+    done := stats.Success + stats.Errors + stats.Skipped
+    success := stats.Success
+    errors := stats.Errors
+    skipped := stats.Skipped
+    bytes := stats.SourceBytes
+    stats.Mutex.Unlock()
+
+    var b strings.Builder
+    fmt.Fprintf(&b, "[STATUS] %d/%d folders after %s: %d converted, %d skipped, %d failed, %d remaining", done, total, FmtDuration(elapsed), success, skipped, errors, total-done)
+    b.WriteString("\n  " + Throughput(bytes, done, elapsed))
+    if done > 0 && done < total {
+        b.WriteString(", eta " + FmtDuration(elapsed/time.Duration(done)*time.Duration(total-done)))
+    }
+    active := stats.Progress()
+    if len(active) == 0 {
+        b.WriteString("\n  No folder is being written")
+    }
+    for _, p := range active {
+        fmt.Fprintf(&b, "\n  Worker %d: %s  %d/%d files", p.Worker, p.FolderName, p.Added, p.Total)
+    }
+    return b.String()
+}
+
+// largest picks the archive with the most files among those being written
+func largest(active []types.ItemProgress) (types.ItemProgress, bool) {
+    var best types.ItemProgress
+    for _, p := range active {
+        if p.Total > best.Total {
+            best = p
+        }
+    }
+    return best, best.Total > 0
+}
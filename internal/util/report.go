@@ -16,7 +16,7 @@ type VisualLine struct {
 func (v *VisualLine) Add(s, ansi string) *VisualLine {
     v.raw.WriteString(ansi)
     v.raw.WriteString(s)
-    v.visible += len([]rune(s))
+    v.visible += StringWidth(s)
     return v
 }
 
@@ -42,27 +42,14 @@ func box(content *VisualLine, W int) string {
     return "│ " + content.String() + ansiReset + strings.Repeat(" ", pad) + " │"
 }
 
-func PrintFinalStats(stats *types.ConversionStats, buf *types.SafeWriter, elapsed time.Duration) {
+func PrintFinalStats(stats *types.ConversionStats, elapsed time.Duration) {
     stats.Mutex.Lock()
     defer stats.Mutex.Unlock()
 
-    buf.Mutex.Lock()
-    logContent := buf.Buffer.String()
-    buf.Mutex.Unlock()
-
     var failures []struct{ name, reason string }
-    for line := range strings.SplitSeq(logContent, "\n") {
-        if !strings.HasPrefix(line, "[ERROR]") {
-            continue
-        }
-        if _, after, ok := strings.Cut(line, "Conversion failed: "); ok {
-            reason := after
-            name := ""
-            parts := strings.SplitN(line, "] ", 3)
-            if len(parts) == 3 {
-                name = strings.TrimSpace(parts[1])
-            }
-            failures = append(failures, struct{ name, reason string }{name, reason})
+    for _, r := range stats.Results {
+        if r.Status == types.StatusFailed {
+            failures = append(failures, struct{ name, reason string }{r.FolderName, r.Error})
         }
     }
 
@@ -163,20 +150,41 @@ func PrintFinalStats(stats *types.ConversionStats, buf *types.SafeWriter, elapse
         fh := newLine()
         fh.Styled("✗ failed conversions", ansiRed)
         fmt.Println(box(fh, W))
+        // Reason gets whatever room the name column leaves in the box
+        nameWidth := TruncateWidth
+        reasonWidth := max(W-3-nameWidth, 14)
         for _, f := range failures {
-            name := TruncateString(f.name, 32)
-            reason := TruncateString(f.reason, 14)
+            name := TruncateName(f.name)
+            reason := f.reason
+            if TruncateWidth > 0 {
+                reason = TruncateString(reason, reasonWidth)
+            }
             fl := newLine()
             fl.Color("✗ ", ansiRed)
-            fl.Plain(fmt.Sprintf("%-32s ", name))
+            fl.Plain(PadRight(name, nameWidth) + " ")
             fl.Muted(reason)
             fmt.Println(box(fl, W))
         }
     }
 
+    // Flagged folders, smart mode dropped more than the threshold allows
+    if len(stats.Flagged) > 0 {
+        fmt.Println(mid)
+        wh := newLine()
+        wh.Styled("⚠ high exclusion rate, check these folders", ansiYellow)
+        fmt.Println(box(wh, W))
+        for _, f := range stats.Flagged {
+            wl := newLine()
+            wl.Color("⚠ ", ansiYellow)
+            wl.Plain(PadRight(TruncateName(f.FolderName), TruncateWidth) + " ")
+            wl.Muted(fmt.Sprintf("%d/%d excluded", f.Excluded, f.Scanned))
+            fmt.Println(box(wl, W))
+        }
+    }
+
     // Footer
     logStr := "git@git.jelius.dev:jelius-sama/convert_cbz.git"
-    pad := max(W-len([]rune(logStr)), 0)
+    pad := max(W-StringWidth(logStr), 0)
 
     fmt.Println(mid)
     ft := newLine()
@@ -0,0 +1,32 @@
+package util
+
+import (
+    "fmt"
+    "regexp"
+)
+
+// CompileSplit compiles a -split-by-pattern expression, which has to say
+// which part of a file name is the chapter
+func CompileSplit(pattern string) (*regexp.Regexp, error) {
+    re, err := regexp.Compile(pattern)
+    if err != nil {
+        return nil, fmt.Errorf("invalid split pattern: %w", err)
+    }
+    if re.NumSubexp() == 0 {
+        return nil, fmt.Errorf("split pattern %q has no group for the chapter, e.g. ^(c\\d+)_", pattern)
+    }
+    return re, nil
+}
+
+// SplitKey is the chapter re finds in a file name: its chapter group if it
+// has one, its first group otherwise. Empty when the name doesn't match.
+func SplitKey(re *regexp.Regexp, name string) string {
+    m := re.FindStringSubmatch(name)
+    if m == nil {
+        return ""
+    }
+    if i := re.SubexpIndex("chapter"); i > 0 {
+        return m[i]
+    }
+    return m[1]
+}
@@ -4,19 +4,39 @@ import (
     "fmt"
     "os"
     "sort"
+    "strings"
     "time"
 )
 
-func TruncateString(s string, maxLen int) string {
-    runes := []rune(s)
-    if len(runes) <= maxLen {
+// TruncateString cuts s so that it occupies at most maxWidth terminal cells,
+// counting double-width CJK characters as two cells
+func TruncateString(s string, maxWidth int) string {
+    if StringWidth(s) <= maxWidth {
         return s
     }
-    if maxLen <= 1 {
-        return string(runes[:maxLen])
+    if maxWidth <= 0 {
+        return ""
     }
-    // Use ellipsis to indicate truncation
-    return string(runes[:maxLen-1]) + "…"
+
+    // Reserve one cell for the ellipsis that indicates truncation
+    limit := maxWidth - 1
+    if maxWidth == 1 {
+        limit = 1
+    }
+    var b strings.Builder
+    w := 0
+    for _, r := range s {
+        rw := RuneWidth(r)
+        if w+rw > limit {
+            break
+        }
+        b.WriteRune(r)
+        w += rw
+    }
+    if maxWidth == 1 {
+        return b.String()
+    }
+    return b.String() + "…"
 }
 
 func GetFolders(dir string) ([]string, error) {
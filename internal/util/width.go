@@ -0,0 +1,81 @@
+package util
+
+import (
+    "os"
+    "strings"
+    "unicode"
+)
+
+// TruncateWidth is the display width (in terminal cells) that folder names are
+// cut to in progress and summary output. Zero disables truncation entirely.
+var TruncateWidth = 32
+
+// wideRanges lists the East Asian Wide/Fullwidth blocks that occupy two cells
+var wideRanges = [][2]rune{
+    {0x1100, 0x115F},   // Hangul Jamo
+    {0x2E80, 0x303E},   // CJK Radicals .. CJK Symbols and Punctuation
+    {0x3041, 0x33FF},   // Hiragana .. CJK Compatibility
+    {0x3400, 0x4DBF},   // CJK Unified Ideographs Extension A
+    {0x4E00, 0x9FFF},   // CJK Unified Ideographs
+    {0xA000, 0xA4CF},   // Yi Syllables and Radicals
+    {0xAC00, 0xD7A3},   // Hangul Syllables
+    {0xF900, 0xFAFF},   // CJK Compatibility Ideographs
+    {0xFE30, 0xFE4F},   // CJK Compatibility Forms
+    {0xFF00, 0xFF60},   // Fullwidth Forms
+    {0xFFE0, 0xFFE6},   // Fullwidth Signs
+    {0x1F300, 0x1F64F}, // Misc Symbols and Pictographs, Emoticons
+    {0x1F900, 0x1F9FF}, // Supplemental Symbols and Pictographs
+    {0x20000, 0x3FFFD}, // CJK Unified Ideographs Extension B and beyond
+}
+
+// RuneWidth returns the number of terminal cells r occupies (0, 1 or 2)
+func RuneWidth(r rune) int {
+    // Combining marks, variation selectors and zero-width joiners take no space
+    if r == 0 || unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) {
+        return 0
+    }
+    for _, rg := range wideRanges {
+        if r < rg[0] {
+            break
+        }
+        if r <= rg[1] {
+            return 2
+        }
+    }
+    return 1
+}
+
+// StringWidth returns the number of terminal cells s occupies
+func StringWidth(s string) int {
+    w := 0
+    for _, r := range s {
+        w += RuneWidth(r)
+    }
+    return w
+}
+
+// PadRight pads s with spaces so that it occupies at least width cells
+func PadRight(s string, width int) string {
+    if pad := width - StringWidth(s); pad > 0 {
+        return s + strings.Repeat(" ", pad)
+    }
+    return s
+}
+
+// TruncateName cuts s to the configured TruncateWidth, or returns it untouched
+// when truncation is disabled
+func TruncateName(s string) string {
+    if TruncateWidth <= 0 {
+        return s
+    }
+    return TruncateString(s, TruncateWidth)
+}
+
+// IsTerminal reports whether f refers to a character device (a TTY)
+func IsTerminal(f *os.File) bool {
+    info, err := f.Stat()
+    if err != nil {
+        return false
+    }
+    return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,95 @@
+// Package cbz exposes the converter as a library for GUI frontends and other
+// tools that want to drive conversions without shelling out to the binary.
+//
+// Progress is reported through Options.OnEvent rather than log output, and
+// the log of a run is only written to a file when Options.LogFile names one:
+//
+//	items, _ := cbz.CollectRecursive([]string{"./mangas"}, "./cbz", false)
+//	res := cbz.Convert(items, &cbz.Options{
+//	    Threads: 4,
+//	    OnEvent: func(e cbz.Event) {
+//	        if e.Type == cbz.EventItemFailed {
+//	            fmt.Println(e.FolderName, e.Error)
+//	        }
+//	    },
+//	})
+//	fmt.Println(res.Stats.Success, "converted")
+package cbz
+
+import (
+    "context"
+    "convert_cbz/internal/collector"
+    "convert_cbz/internal/processor"
+    "convert_cbz/internal/types"
+)
+
+type (
+    Options       = types.Options
+    WorkItem      = types.WorkItem
+    Event         = types.Event
+    EventType     = types.EventType
+    StatsSnapshot = types.StatsSnapshot
+    ItemResult    = types.ItemResult
+    ItemStatus    = types.ItemStatus
+)
+
+const (
+    EventItemStarted  = types.EventItemStarted
+    EventFileAdded    = types.EventFileAdded
+    EventItemFinished = types.EventItemFinished
+    EventItemSkipped  = types.EventItemSkipped
+    EventItemFailed   = types.EventItemFailed
+    EventStatsUpdated = types.EventStatsUpdated
+
+    StatusConverted = types.StatusConverted
+    StatusSkipped   = types.StatusSkipped
+    StatusFailed    = types.StatusFailed
+)
+
+// CollectRecursive creates one work item per subdirectory of each input path.
+// Inputs that don't exist or can't be read are skipped without a word.
+func CollectRecursive(inputPaths []string, outputDir string, dumbMode bool) ([]WorkItem, error) {
+    return collector.CollectRecursiveNotify(inputPaths, outputDir, dumbMode, nil)
+}
+
+// CollectDirect creates one work item per input path. Inputs that don't exist
+// or aren't directories are skipped without a word.
+func CollectDirect(inputPaths []string, outputDir string, dumbMode bool) ([]WorkItem, error) {
+    return collector.CollectDirectNotify(inputPaths, outputDir, dumbMode, nil)
+}
+
+// Result is the outcome of a Convert call
+type Result struct {
+    Stats    StatsSnapshot
+    Results  []ItemResult
+    Deferred []WorkItem // Never started because the context was cancelled
+}
+
+// Convert processes items and blocks until all of them are done. Terminal
+// output is always suppressed, listen on opts.OnEvent for progress instead.
+// The log of the run is kept in memory unless opts.LogFile names a file.
+func Convert(items []WorkItem, opts *Options) Result {
+    return ConvertContext(context.Background(), items, opts)
+}
+
+// ConvertContext is Convert, but stops starting new items once ctx is
+// cancelled. Items that were never started are listed in Result.Deferred.
+func ConvertContext(ctx context.Context, items []WorkItem, opts *Options) Result {
+    o := *opts
+    o.Quiet = true
+    if o.LogFile == "" {
+        o.LogFile = types.NoLogFile
+    }
+    if o.Threads < 1 {
+        o.Threads = 1
+    }
+
+    stats := &types.ConversionStats{Total: len(items)}
+    processor.ProcessConcurrently(ctx, items, &o, stats)
+
+    stats.Mutex.Lock()
+    results := append([]ItemResult(nil), stats.Results...)
+    deferred := append([]WorkItem(nil), stats.Deferred...)
+    stats.Mutex.Unlock()
+    return Result{Stats: stats.Snapshot(), Results: results, Deferred: deferred}
+}